@@ -0,0 +1,9 @@
+// Package api contains the api specifications of the service.
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 specification of the http api.
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/s02190058/billing-service/internal/app"
+	"github.com/s02190058/billing-service/internal/config"
+)
+
+var configPath = flag.String("config", "./configs/main.yml", "path to config file")
+
+// verify-journal walks the hash chains of the journal, prints the report and
+// exits with status 1 if a chain is broken.
+func main() {
+	flag.Parse()
+
+	cfg, err := config.New(*configPath)
+	if err != nil {
+		log.Fatalf("unable to read config: %v", err)
+	}
+
+	report, err := app.VerifyJournal(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("unable to verify journal: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		log.Fatalf("unable to print report: %v", err)
+	}
+
+	if !report.Valid {
+		os.Exit(1)
+	}
+}
@@ -1,41 +1,304 @@
 package app
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/s02190058/billing-service/api"
 	"github.com/s02190058/billing-service/internal/config"
 	"github.com/s02190058/billing-service/internal/service"
 	"github.com/s02190058/billing-service/internal/storage"
 	"github.com/s02190058/billing-service/internal/transport"
+	"github.com/s02190058/billing-service/pkg/grpcserver"
+	"github.com/s02190058/billing-service/pkg/health"
 	"github.com/s02190058/billing-service/pkg/httpserver"
 	"github.com/s02190058/billing-service/pkg/postgres"
+	"github.com/s02190058/billing-service/pkg/scheduler"
+	"github.com/s02190058/billing-service/pkg/tracing"
+	"github.com/s02190058/billing-service/pkg/webhook"
 	"github.com/s02190058/billing-service/pkg/zaplogger"
 )
 
 func Run(cfg *config.Config) {
 	logger := zaplogger.New(cfg.Logger.Level)
 
+	shutdownTracing, err := tracing.New(tracing.Config(cfg.Tracing))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Errorf("error occurred during tracer provider shutdown: %v", err)
+		}
+	}()
+
 	pool, err := postgres.New(logger, postgres.Config(cfg.Postgres))
 	if err != nil {
 		logger.Fatal(err)
 	}
 	defer pool.Close()
 
-	userStorage := storage.NewUserStorage(logger, pool)
-	userService := service.NewUserService(userStorage)
+	prometheus.MustRegister(postgres.NewStatsCollector(pool))
+
+	chainKey := []byte(cfg.Journal.ChainKey)
+
+	userStorage := storage.NewUserStorage(logger, pool, chainKey)
+	orderStorage := storage.NewOrderStorage(logger, pool, chainKey)
+
+	pendingTransferStorage := storage.NewPendingTransferStorage(logger, pool, chainKey)
+
+	riskStorage := storage.NewRiskStorage(logger, pool)
+	riskService := service.NewRiskService(riskStorage, userStorage, orderStorage, pendingTransferStorage)
+
+	catalogStorage := storage.NewCatalogStorage(logger, pool)
+	catalogService := service.NewCatalogService(
+		catalogStorage,
+		cfg.Catalog.RequireListed,
+		cfg.Catalog.PriceToleranceBps,
+	)
+
+	userService := service.NewUserService(userStorage, riskService)
+	orderService := service.NewOrderService(orderStorage, userStorage, riskService, catalogService)
+	pendingTransferService := service.NewPendingTransferService(
+		pendingTransferStorage,
+		userStorage,
+		riskService,
+		cfg.PendingTransfers.DefaultTimeout,
+		cfg.PendingTransfers.MaxTimeout,
+		cfg.PendingTransfers.BatchSize,
+	)
+
+	adjustmentStorage := storage.NewAdjustmentStorage(logger, pool, chainKey)
+	adjustmentService := service.NewAdjustmentService(adjustmentStorage, cfg.Admin.ApprovalThreshold)
+
+	limitStorage := storage.NewLimitStorage(logger, pool)
+	limitService := service.NewLimitService(limitStorage)
+
+	feeStorage := storage.NewFeeStorage(logger, pool)
+	feeService := service.NewFeeService(feeStorage)
+
+	bonusStorage := storage.NewBonusStorage(logger, pool)
+	bonusService := service.NewBonusService(bonusStorage, userStorage)
+
+	subscriptionStorage := storage.NewSubscriptionStorage(logger, pool)
+	subscriptionService := service.NewSubscriptionService(
+		subscriptionStorage,
+		orderStorage,
+		userStorage,
+		riskService,
+		catalogService,
+		cfg.Subscriptions.RetryInterval,
+		cfg.Subscriptions.GracePeriod,
+		cfg.Subscriptions.BatchSize,
+	)
+
+	scheduledTransferStorage := storage.NewScheduledTransferStorage(logger, pool)
+	scheduledTransferService := service.NewScheduledTransferService(
+		scheduledTransferStorage,
+		userStorage,
+		riskService,
+		cfg.ScheduledTransfers.BatchSize,
+	)
+
+	disputeStorage := storage.NewDisputeStorage(logger, pool, chainKey)
+	disputeService := service.NewDisputeService(
+		disputeStorage,
+		userStorage,
+		cfg.Disputes.Window,
+		cfg.Disputes.ResponseTime,
+		cfg.Disputes.BatchSize,
+	)
+
+	journalStorage := storage.NewJournalStorage(logger, pool)
+	journalService := service.NewJournalService(journalStorage, chainKey)
+
+	reconciliationStorage := storage.NewReconciliationStorage(logger, pool, chainKey)
+	reconciliationService := service.NewReconciliationService(reconciliationStorage, cfg.Reconciliation.AutoRepair)
+
+	settlementStorage := storage.NewSettlementStorage(logger, pool)
+	settlementService := service.NewSettlementService(
+		settlementStorage,
+		cfg.Settlements.MaxFileSize,
+		cfg.Settlements.AmountScale,
+	)
 
-	orderStorage := storage.NewOrderStorage(logger, pool)
-	orderService := service.NewOrderService(orderStorage)
+	outboxStorage := storage.NewOutboxStorage(logger, pool)
+	outboxService := service.NewOutboxService(
+		outboxStorage,
+		webhook.New(cfg.Outbox.WebhookURL, cfg.Outbox.Timeout),
+		cfg.Outbox.BatchSize,
+	)
 
-	router := transport.ConfigureRouter(logger, userService, orderService)
+	limiter, policy, err := newRateLimiter(cfg.RateLimit)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	probes := health.New(cfg.Health.CheckTimeout)
+	schemaStorage := storage.NewSchemaStorage(logger, pool)
+	probes.AddReadinessCheck("postgres", schemaStorage.Ping)
+	probes.AddReadinessCheck("schema", schemaStorage.CheckVersion)
+	probes.AddReadinessCheck("reports", orderService.CheckReportStorage)
+
+	router, err := transport.ConfigureRouter(
+		logger,
+		userService,
+		orderService,
+		feeService,
+		bonusService,
+		subscriptionService,
+		scheduledTransferService,
+		pendingTransferService,
+		disputeService,
+		limiter,
+		policy,
+		probes,
+		transport.OpenAPIOptions{
+			Spec:              api.OpenAPI,
+			ValidateRequests:  cfg.OpenAPI.ValidateRequests,
+			ValidateResponses: cfg.OpenAPI.ValidateResponses,
+		},
+	)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	server := httpserver.New(router, httpserver.Config(cfg.Server))
+	server.RegisterOnShutdown(probes.Drain)
+
+	grpcServer := grpcserver.New(
+		transport.ConfigureGRPCServer(logger, userService, orderService),
+		grpcserver.Config(cfg.GRPC),
+	)
+
+	adminRouter := transport.ConfigureAdminRouter(
+		logger,
+		adjustmentService,
+		userService,
+		userService,
+		limitService,
+		feeService,
+		bonusService,
+		riskService,
+		disputeService,
+		catalogService,
+		journalService,
+		reconciliationService,
+		settlementService,
+		cfg.Admin.OperatorHeader,
+	)
+	adminServer := httpserver.New(adminRouter, httpserver.Config{
+		Port:            cfg.Admin.Port,
+		ReadTimeout:     cfg.Server.ReadTimeout,
+		WriteTimeout:    cfg.Server.WriteTimeout,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+	})
+
+	// only the replica holding the advisory lock of a scheduler runs it; the
+	// locks of all the schedulers share one connection outside the pool
+	lockSession := postgres.NewLockSession(pool)
+	defer lockSession.Close()
+
+	subscriptionScheduler, err := scheduler.New(logger, subscriptionService.ChargeDue, scheduler.Config{
+		Name:            "subscription charges",
+		Interval:        cfg.Subscriptions.Interval,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		Elector:         postgres.NewAdvisoryLock(lockSession, "billing:subscription-charges"),
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	transferScheduler, err := scheduler.New(logger, scheduledTransferService.RunDue, scheduler.Config{
+		Name:            "scheduled transfers",
+		Interval:        cfg.ScheduledTransfers.Interval,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		Elector:         postgres.NewAdvisoryLock(lockSession, "billing:scheduled-transfers"),
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	holdScheduler, err := scheduler.New(logger, pendingTransferService.ExpireDue, scheduler.Config{
+		Name:            "pending transfer expiry",
+		Interval:        cfg.PendingTransfers.Interval,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		Elector:         postgres.NewAdvisoryLock(lockSession, "billing:pending-transfers"),
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	disputeScheduler, err := scheduler.New(logger, disputeService.ResolveOverdue, scheduler.Config{
+		Name:            "dispute deadlines",
+		Interval:        cfg.Disputes.Interval,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		Elector:         postgres.NewAdvisoryLock(lockSession, "billing:dispute-deadlines"),
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	reconciliationScheduler, err := scheduler.New(logger, reconciliationService.RunScheduled, scheduler.Config{
+		Name:            "reconciliation",
+		Interval:        cfg.Reconciliation.Interval,
+		ShutdownTimeout: cfg.Server.ShutdownTimeout,
+		Elector:         postgres.NewAdvisoryLock(lockSession, "billing:reconciliation"),
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	// without a webhook the events stay in the outbox until one is configured
+	var outboxScheduler *scheduler.Scheduler
+	if cfg.Outbox.WebhookURL != "" {
+		outboxScheduler, err = scheduler.New(logger, outboxService.Relay, scheduler.Config{
+			Name:            "outbox relay",
+			Interval:        cfg.Outbox.Interval,
+			ShutdownTimeout: cfg.Server.ShutdownTimeout,
+			Elector:         postgres.NewAdvisoryLock(lockSession, "billing:outbox-relay"),
+		})
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
 
 	logger.Infof("starting http server on port %s", cfg.Server.Port)
 	server.Start()
 
+	logger.Infof("starting grpc server on port %s", cfg.GRPC.Port)
+	grpcServer.Start()
+
+	logger.Infof("starting admin http server on port %s", cfg.Admin.Port)
+	adminServer.Start()
+
+	logger.Infof("starting subscription scheduler with interval %s", cfg.Subscriptions.Interval)
+	subscriptionScheduler.Start()
+
+	logger.Infof("starting scheduled transfer scheduler with interval %s", cfg.ScheduledTransfers.Interval)
+	transferScheduler.Start()
+
+	logger.Infof("starting pending transfer scheduler with interval %s", cfg.PendingTransfers.Interval)
+	holdScheduler.Start()
+
+	logger.Infof("starting dispute scheduler with interval %s", cfg.Disputes.Interval)
+	disputeScheduler.Start()
+
+	logger.Infof("starting reconciliation scheduler with interval %s", cfg.Reconciliation.Interval)
+	reconciliationScheduler.Start()
+
+	if outboxScheduler != nil {
+		logger.Infof("starting outbox relay with interval %s", cfg.Outbox.Interval)
+		outboxScheduler.Start()
+	} else {
+		logger.Warnf("outbox webhook url isn't set, events won't be published")
+	}
+
+	probes.MarkStarted()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
@@ -44,9 +307,47 @@ func Run(cfg *config.Config) {
 		logger.Infof("server interrupt: %v", sig)
 	case err := <-server.Notify():
 		logger.Errorf("error occurred since server started: %v", err)
+	case err := <-grpcServer.Notify():
+		logger.Errorf("error occurred since grpc server started: %v", err)
+	case err := <-adminServer.Notify():
+		logger.Errorf("error occurred since admin server started: %v", err)
+	}
+
+	if err := subscriptionScheduler.Shutdown(); err != nil {
+		logger.Errorf("error occurred during subscription scheduler shutdown: %v", err)
+	}
+
+	if err := transferScheduler.Shutdown(); err != nil {
+		logger.Errorf("error occurred during scheduled transfer scheduler shutdown: %v", err)
+	}
+
+	if err := holdScheduler.Shutdown(); err != nil {
+		logger.Errorf("error occurred during pending transfer scheduler shutdown: %v", err)
+	}
+
+	if err := disputeScheduler.Shutdown(); err != nil {
+		logger.Errorf("error occurred during dispute scheduler shutdown: %v", err)
+	}
+
+	if err := reconciliationScheduler.Shutdown(); err != nil {
+		logger.Errorf("error occurred during reconciliation scheduler shutdown: %v", err)
+	}
+
+	if outboxScheduler != nil {
+		if err := outboxScheduler.Shutdown(); err != nil {
+			logger.Errorf("error occurred during outbox relay shutdown: %v", err)
+		}
 	}
 
 	if err := server.Shutdown(); err != nil {
 		logger.Errorf("error occurred during server shutdown: %v", err)
 	}
+
+	if err := grpcServer.Shutdown(); err != nil {
+		logger.Errorf("error occurred during grpc server shutdown: %v", err)
+	}
+
+	if err := adminServer.Shutdown(); err != nil {
+		logger.Errorf("error occurred during admin server shutdown: %v", err)
+	}
 }
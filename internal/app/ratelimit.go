@@ -0,0 +1,41 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/s02190058/billing-service/internal/config"
+	"github.com/s02190058/billing-service/internal/transport"
+	"github.com/s02190058/billing-service/pkg/ratelimit"
+)
+
+func newRateLimiter(cfg config.RateLimit) (ratelimit.Limiter, transport.RateLimitPolicy, error) {
+	policy := transport.RateLimitPolicy{
+		Default: ratelimit.Rule(cfg.Default),
+		Routes:  make(map[string]ratelimit.Rule, len(cfg.Routes)),
+		Clients: make(map[string]ratelimit.Rule, len(cfg.Clients)),
+	}
+	for route, rule := range cfg.Routes {
+		policy.Routes[route] = ratelimit.Rule(rule)
+	}
+	for client, rule := range cfg.Clients {
+		policy.Clients[client] = ratelimit.Rule(rule)
+	}
+
+	if !cfg.Enabled {
+		return nil, policy, nil
+	}
+
+	switch cfg.Backend {
+	case "", "memory":
+		return ratelimit.NewMemoryLimiter(), policy, nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+		})
+		return ratelimit.NewRedisLimiter(client, cfg.RedisPrefix), policy, nil
+	default:
+		return nil, policy, fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+}
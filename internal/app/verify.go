@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+
+	"github.com/s02190058/billing-service/internal/config"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"github.com/s02190058/billing-service/internal/storage"
+	"github.com/s02190058/billing-service/pkg/postgres"
+	"github.com/s02190058/billing-service/pkg/zaplogger"
+)
+
+// VerifyJournal verifies the hash chains of the journal in the database of
+// the config without starting the servers.
+func VerifyJournal(ctx context.Context, cfg *config.Config) (model.ChainReport, error) {
+	logger := zaplogger.New(cfg.Logger.Level)
+
+	pool, err := postgres.New(logger, postgres.Config(cfg.Postgres))
+	if err != nil {
+		return model.ChainReport{}, err
+	}
+	defer pool.Close()
+
+	journalStorage := storage.NewJournalStorage(logger, pool)
+	journalService := service.NewJournalService(journalStorage, []byte(cfg.Journal.ChainKey))
+
+	return journalService.Verify(ctx)
+}
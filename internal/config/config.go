@@ -9,8 +9,23 @@ import (
 type (
 	Config struct {
 		Server
+		GRPC
+		Admin
+		Health
+		OpenAPI
 		Postgres
 		Logger
+		Tracing
+		RateLimit
+		Subscriptions
+		ScheduledTransfers
+		PendingTransfers
+		Disputes
+		Catalog
+		Reconciliation
+		Settlements
+		Outbox
+		Journal
 	}
 
 	Server struct {
@@ -18,6 +33,27 @@ type (
 		ReadTimeout     time.Duration `yaml:"read_timeout" env:"SRV_READ_TIMEOUT"`
 		WriteTimeout    time.Duration `yaml:"write_timeout" env:"SRV_WRITE_TIMEOUT"`
 		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SRV_SHUTDOWN_TIMEOUT"`
+		DrainDelay      time.Duration `yaml:"drain_delay" env:"SRV_DRAIN_DELAY"`
+	}
+
+	GRPC struct {
+		Port            string        `yaml:"port" env:"GRPC_PORT"`
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"GRPC_SHUTDOWN_TIMEOUT"`
+	}
+
+	Admin struct {
+		Port              string `yaml:"port" env:"ADMIN_PORT"`
+		OperatorHeader    string `yaml:"operator_header" env:"ADMIN_OPERATOR_HEADER"`
+		ApprovalThreshold int    `yaml:"approval_threshold" env:"ADMIN_APPROVAL_THRESHOLD"`
+	}
+
+	Health struct {
+		CheckTimeout time.Duration `yaml:"check_timeout" env:"HEALTH_CHECK_TIMEOUT"`
+	}
+
+	OpenAPI struct {
+		ValidateRequests  bool `yaml:"validate_requests" env:"OPENAPI_VALIDATE_REQUESTS"`
+		ValidateResponses bool `yaml:"validate_responses" env:"OPENAPI_VALIDATE_RESPONSES"`
 	}
 
 	Postgres struct {
@@ -35,6 +71,83 @@ type (
 	Logger struct {
 		Level string `yaml:"level" env:"LOGGER_LEVEL"`
 	}
+
+	Tracing struct {
+		Exporter     string  `yaml:"exporter" env:"TRACING_EXPORTER"`
+		OTLPEndpoint string  `yaml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+		OTLPInsecure bool    `yaml:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+		ServiceName  string  `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+		SampleRatio  float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	}
+
+	RateLimit struct {
+		Enabled       bool                     `yaml:"enabled" env:"RL_ENABLED"`
+		Backend       string                   `yaml:"backend" env:"RL_BACKEND"`
+		RedisAddr     string                   `yaml:"redis_addr" env:"RL_REDIS_ADDR"`
+		RedisPassword string                   `env:"RL_REDIS_PASSWORD"`
+		RedisPrefix   string                   `yaml:"redis_prefix" env:"RL_REDIS_PREFIX"`
+		Default       RateLimitRule            `yaml:"default"`
+		Routes        map[string]RateLimitRule `yaml:"routes"`
+		Clients       map[string]RateLimitRule `yaml:"clients"`
+	}
+
+	Subscriptions struct {
+		Interval      time.Duration `yaml:"interval" env:"SUBSCRIPTIONS_INTERVAL"`
+		RetryInterval time.Duration `yaml:"retry_interval" env:"SUBSCRIPTIONS_RETRY_INTERVAL"`
+		GracePeriod   time.Duration `yaml:"grace_period" env:"SUBSCRIPTIONS_GRACE_PERIOD"`
+		BatchSize     int           `yaml:"batch_size" env:"SUBSCRIPTIONS_BATCH_SIZE"`
+	}
+
+	ScheduledTransfers struct {
+		Interval  time.Duration `yaml:"interval" env:"SCHEDULED_TRANSFERS_INTERVAL"`
+		BatchSize int           `yaml:"batch_size" env:"SCHEDULED_TRANSFERS_BATCH_SIZE"`
+	}
+
+	PendingTransfers struct {
+		DefaultTimeout time.Duration `yaml:"default_timeout" env:"PENDING_TRANSFERS_DEFAULT_TIMEOUT"`
+		MaxTimeout     time.Duration `yaml:"max_timeout" env:"PENDING_TRANSFERS_MAX_TIMEOUT"`
+		Interval       time.Duration `yaml:"interval" env:"PENDING_TRANSFERS_INTERVAL"`
+		BatchSize      int           `yaml:"batch_size" env:"PENDING_TRANSFERS_BATCH_SIZE"`
+	}
+
+	Disputes struct {
+		Window       time.Duration `yaml:"window" env:"DISPUTES_WINDOW"`
+		ResponseTime time.Duration `yaml:"response_time" env:"DISPUTES_RESPONSE_TIME"`
+		Interval     time.Duration `yaml:"interval" env:"DISPUTES_INTERVAL"`
+		BatchSize    int           `yaml:"batch_size" env:"DISPUTES_BATCH_SIZE"`
+	}
+
+	Catalog struct {
+		RequireListed     bool `yaml:"require_listed" env:"CATALOG_REQUIRE_LISTED"`
+		PriceToleranceBps int  `yaml:"price_tolerance_bps" env:"CATALOG_PRICE_TOLERANCE_BPS"`
+	}
+
+	Reconciliation struct {
+		Interval   time.Duration `yaml:"interval" env:"RECONCILIATION_INTERVAL"`
+		AutoRepair bool          `yaml:"auto_repair" env:"RECONCILIATION_AUTO_REPAIR"`
+	}
+
+	Settlements struct {
+		MaxFileSize int64 `yaml:"max_file_size" env:"SETTLEMENTS_MAX_FILE_SIZE"`
+		AmountScale int   `yaml:"amount_scale" env:"SETTLEMENTS_AMOUNT_SCALE"`
+	}
+
+	Outbox struct {
+		WebhookURL string        `yaml:"webhook_url" env:"OUTBOX_WEBHOOK_URL"`
+		Timeout    time.Duration `yaml:"timeout" env:"OUTBOX_TIMEOUT"`
+		Interval   time.Duration `yaml:"interval" env:"OUTBOX_INTERVAL"`
+		BatchSize  int           `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE"`
+	}
+
+	Journal struct {
+		ChainKey string `env:"JOURNAL_CHAIN_KEY" env-required:"true"`
+	}
+
+	RateLimitRule struct {
+		Limit  int           `yaml:"limit"`
+		Period time.Duration `yaml:"period"`
+		Burst  int           `yaml:"burst"`
+	}
 )
 
 func New(path string) (*Config, error) {
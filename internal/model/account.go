@@ -0,0 +1,60 @@
+package model
+
+import "time"
+
+type AccountStatus string
+
+const (
+	AccountActive AccountStatus = "active"
+	AccountFrozen AccountStatus = "frozen"
+	AccountClosed AccountStatus = "closed"
+)
+
+// CanBecome reports whether an account may move from s to status. Closure
+// is final.
+func (s AccountStatus) CanBecome(status AccountStatus) bool {
+	switch s {
+	case AccountActive:
+		return status == AccountFrozen || status == AccountClosed
+	case AccountFrozen:
+		return status == AccountActive || status == AccountClosed
+	}
+
+	return false
+}
+
+// Account is a user balance together with its lifecycle state. Frozen
+// accounts can receive money but can't spend it; closed accounts reject all
+// money movements.
+type Account struct {
+	ID          int            `json:"id"`
+	Balance     int            `json:"balance"`
+	CreditLimit int            `json:"credit_limit"`
+	Available   int            `json:"available"`
+	Status      AccountStatus  `json:"status"`
+	Segment     string         `json:"segment"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+	Created     time.Time      `json:"created"`
+	Events      []AccountEvent `json:"events,omitempty"`
+}
+
+// Balance is the money on the account and the money the user can spend,
+// which includes the credit limit. Bonus is the unexpired bonus money, which
+// is kept apart from the balance.
+type Balance struct {
+	Balance     int `json:"balance"`
+	CreditLimit int `json:"credit_limit"`
+	Available   int `json:"available"`
+	Bonus       int `json:"bonus"`
+}
+
+// AccountEvent is an audit record of an account status, credit limit or
+// segment change.
+type AccountEvent struct {
+	Status      AccountStatus `json:"status"`
+	CreditLimit *int          `json:"credit_limit,omitempty"`
+	Segment     *string       `json:"segment,omitempty"`
+	Reason      string        `json:"reason"`
+	Operator    string        `json:"operator,omitempty"`
+	Created     time.Time     `json:"created"`
+}
@@ -0,0 +1,59 @@
+package model
+
+import "time"
+
+type AdjustmentStatus string
+
+const (
+	AdjustmentPending  AdjustmentStatus = "pending"
+	AdjustmentApplied  AdjustmentStatus = "applied"
+	AdjustmentRejected AdjustmentStatus = "rejected"
+	AdjustmentReversed AdjustmentStatus = "reversed"
+)
+
+type ReasonCode string
+
+const (
+	ReasonCorrection ReasonCode = "correction"
+	ReasonGoodwill   ReasonCode = "goodwill"
+	ReasonChargeback ReasonCode = "chargeback"
+	ReasonFraud      ReasonCode = "fraud"
+	ReasonOther      ReasonCode = "other"
+)
+
+func (c ReasonCode) Valid() bool {
+	switch c {
+	case ReasonCorrection,
+		ReasonGoodwill,
+		ReasonChargeback,
+		ReasonFraud,
+		ReasonOther:
+		return true
+	}
+
+	return false
+}
+
+// Adjustment is a manual credit (positive amount) or debit (negative amount)
+// made by an operator.
+type Adjustment struct {
+	ID            int               `json:"id"`
+	UserID        int               `json:"user_id"`
+	Amount        int               `json:"amount"`
+	ReasonCode    ReasonCode        `json:"reason_code"`
+	Comment       string            `json:"comment"`
+	Status        AdjustmentStatus  `json:"status"`
+	RequestedBy   string            `json:"requested_by"`
+	DecidedBy     *string           `json:"decided_by,omitempty"`
+	TransactionID *int              `json:"transaction_id,omitempty"`
+	Created       time.Time         `json:"created"`
+	Decided       *time.Time        `json:"decided,omitempty"`
+	Events        []AdjustmentEvent `json:"events,omitempty"`
+}
+
+// AdjustmentEvent is an audit record of an action on an adjustment.
+type AdjustmentEvent struct {
+	Action   string    `json:"action"`
+	Operator string    `json:"operator"`
+	Created  time.Time `json:"created"`
+}
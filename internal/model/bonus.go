@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+// BonusSource tells how a bonus was granted.
+type BonusSource string
+
+const (
+	BonusGranted  BonusSource = "grant"
+	BonusCashback BonusSource = "cashback"
+)
+
+// BonusGrant is a lot of bonus money. It can be spent on the listed services
+// only, or on any service if none are listed, until it expires.
+type BonusGrant struct {
+	ID         int         `json:"id"`
+	UserID     int         `json:"user_id"`
+	Amount     int         `json:"amount"`
+	Remaining  int         `json:"remaining"`
+	ServiceIDs []int       `json:"service_ids,omitempty"`
+	Source     BonusSource `json:"source"`
+	Reason     string      `json:"reason"`
+	Operator   string      `json:"operator,omitempty"`
+	Expires    time.Time   `json:"expires"`
+	Created    time.Time   `json:"created"`
+}
+
+// CashbackRule grants a share of confirmed service payments back as bonus.
+// Rates are in basis points. A rule with a service takes precedence over a
+// rule without one.
+type CashbackRule struct {
+	ID           int       `json:"id"`
+	ServiceID    *int      `json:"service_id,omitempty"`
+	RateBps      int       `json:"rate_bps"`
+	MaxAmount    *int      `json:"max_amount,omitempty"`
+	ValidSeconds int       `json:"valid_seconds"`
+	Enabled      bool      `json:"enabled"`
+	Created      time.Time `json:"created"`
+}
+
+// Cashback returns the bonus granted for the payment.
+func (r CashbackRule) Cashback(amount int) int {
+	cashback := percentage(amount, r.RateBps)
+	if r.MaxAmount != nil && cashback > *r.MaxAmount {
+		cashback = *r.MaxAmount
+	}
+
+	return cashback
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// DefaultCurrency is the currency of catalog services created without one.
+const DefaultCurrency = "RUB"
+
+// ServicePrice is a price of a service valid from ValidFrom until ValidTo,
+// or indefinitely if there is no ValidTo. If several prices are valid at the
+// same time, the one starting last applies.
+type ServicePrice struct {
+	ID        int        `json:"id"`
+	ServiceID int        `json:"service_id"`
+	Price     int        `json:"price"`
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+	Created   time.Time  `json:"created"`
+}
+
+// CatalogItem is a service users pay for. Its ID is the service id of the
+// orders. Inactive services can't be reserved. Prices are loaded for a
+// single item only.
+type CatalogItem struct {
+	ID           int            `json:"id"`
+	Name         string         `json:"name"`
+	Category     string         `json:"category"`
+	Currency     string         `json:"currency"`
+	Active       bool           `json:"active"`
+	CurrentPrice *int           `json:"current_price,omitempty"`
+	Prices       []ServicePrice `json:"prices,omitempty"`
+	Created      time.Time      `json:"created"`
+}
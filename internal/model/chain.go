@@ -0,0 +1,98 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Journal entries are chained twice: to the previous entry of the same user
+// and to the previous entry of the whole journal.
+const (
+	ChainGlobal = "global"
+	ChainUser   = "user"
+)
+
+// chainedContent is the part of a journal entry covered by its hashes. The
+// reversed_by link is set after the entry is written, so it is left out; the
+// reversal_of link of the reversal covers it.
+type chainedContent struct {
+	ID             int             `json:"id"`
+	UserID         int             `json:"user_id"`
+	Amount         int             `json:"amount"`
+	Type           TransactionType `json:"type"`
+	CounterpartyID *int            `json:"counterparty_id"`
+	OrderID        *int            `json:"order_id"`
+	ServiceID      *int            `json:"service_id"`
+	ExternalRef    *string         `json:"external_ref"`
+	Metadata       map[string]any  `json:"metadata"`
+	PairID         *int            `json:"pair_id"`
+	ReversalOf     *int            `json:"reversal_of"`
+	Message        string          `json:"message"`
+	Created        string          `json:"created"`
+}
+
+// ChainHash returns the HMAC-SHA256 of the entry chained to prev, the hash
+// of the previous entry of the chain, which is empty for the first entry.
+// The key is kept outside the database, so whoever can rewrite the journal
+// can't recalculate the chains after it. The entry must be read back from
+// the database, so that its metadata and creation time are the ones stored.
+func (t Transaction) ChainHash(key []byte, prev string) string {
+	// metadata decoded from jsonb can always be encoded back, and maps are
+	// encoded with sorted keys, so the content is canonical
+	content, _ := json.Marshal(chainedContent{
+		ID:             t.ID,
+		UserID:         t.UserID,
+		Amount:         t.Amount,
+		Type:           t.Type,
+		CounterpartyID: t.CounterpartyID,
+		OrderID:        t.OrderID,
+		ServiceID:      t.ServiceID,
+		ExternalRef:    t.ExternalRef,
+		Metadata:       t.Metadata,
+		PairID:         t.PairID,
+		ReversalOf:     t.ReversalOf,
+		Message:        t.Message,
+		Created:        t.Created.UTC().Format(time.RFC3339Nano),
+	})
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prev))
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ChainLink is a journal entry with its place in the chains. Seq is the
+// position in the global chain and is zero for entries which aren't chained.
+type ChainLink struct {
+	Seq        int64
+	UserHash   string
+	GlobalHash string
+	Entry      Transaction
+}
+
+// ChainHead is the last link of the global chain.
+type ChainHead struct {
+	Seq  int64
+	Hash string
+}
+
+// ChainBreak is the first link of a chain which doesn't match the content
+// of the journal.
+type ChainBreak struct {
+	Chain         string `json:"chain"`
+	UserID        *int   `json:"user_id,omitempty"`
+	Seq           int64  `json:"seq,omitempty"`
+	TransactionID *int   `json:"transaction_id,omitempty"`
+	Reason        string `json:"reason"`
+}
+
+// ChainReport is the result of the verification of the journal.
+type ChainReport struct {
+	Valid   bool        `json:"valid"`
+	Entries int64       `json:"entries"`
+	Head    string      `json:"head"`
+	Broken  *ChainBreak `json:"broken,omitempty"`
+}
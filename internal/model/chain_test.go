@@ -0,0 +1,92 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func chainEntry() Transaction {
+	ref := "pay-1"
+
+	return Transaction{
+		ID:          1,
+		UserID:      2,
+		Amount:      1000,
+		Type:        TransactionTopUp,
+		ExternalRef: &ref,
+		Metadata:    map[string]any{"source": "card", "attempt": 1},
+		Message:     "top-up",
+		Created:     time.Date(2022, 11, 20, 12, 0, 0, 123456000, time.UTC),
+	}
+}
+
+func TestTransactionChainHash(t *testing.T) {
+	key := []byte("key")
+	entry := chainEntry()
+	want := entry.ChainHash(key, "prev")
+
+	tests := []struct {
+		name  string
+		key   []byte
+		prev  string
+		entry func(t Transaction) Transaction
+		same  bool
+	}{
+		{"same entry", key, "prev", func(t Transaction) Transaction { return t }, true},
+		{
+			"creation time in another zone",
+			key,
+			"prev",
+			func(t Transaction) Transaction { t.Created = t.Created.In(time.FixedZone("MSK", 3*3600)); return t },
+			true,
+		},
+		{
+			"reversed_by is not covered",
+			key,
+			"prev",
+			func(t Transaction) Transaction { t.ReversedBy = intPtr(5); return t },
+			true,
+		},
+		{"another key", []byte("other"), "prev", func(t Transaction) Transaction { return t }, false},
+		{"no key", nil, "prev", func(t Transaction) Transaction { return t }, false},
+		{"another prev", key, "other", func(t Transaction) Transaction { return t }, false},
+		{"first entry", key, "", func(t Transaction) Transaction { return t }, false},
+		{"edited amount", key, "prev", func(t Transaction) Transaction { t.Amount = 10000; return t }, false},
+		{"edited user", key, "prev", func(t Transaction) Transaction { t.UserID = 3; return t }, false},
+		{
+			"edited metadata",
+			key,
+			"prev",
+			func(t Transaction) Transaction { t.Metadata = map[string]any{"source": "cash", "attempt": 1}; return t },
+			false,
+		},
+		{
+			"edited creation time",
+			key,
+			"prev",
+			func(t Transaction) Transaction { t.Created = t.Created.Add(time.Microsecond); return t },
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.entry(chainEntry()).ChainHash(tt.key, tt.prev)
+			if (got == want) != tt.same {
+				t.Errorf("ChainHash() = %s, same as %s: %t, want %t", got, want, got == want, tt.same)
+			}
+		})
+	}
+}
+
+func BenchmarkTransactionChainHash(b *testing.B) {
+	key := []byte("2f4d9a61c07e4b3f8e15a9d2c6b80f37")
+	entry := chainEntry()
+	prev := entry.ChainHash(key, "")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		prev = entry.ChainHash(key, prev)
+	}
+}
@@ -0,0 +1,61 @@
+package model
+
+import "time"
+
+type DisputeStatus string
+
+const (
+	DisputeOpen     DisputeStatus = "open"
+	DisputeRefunded DisputeStatus = "refunded"
+	DisputeRejected DisputeStatus = "rejected"
+)
+
+func (s DisputeStatus) Valid() bool {
+	switch s {
+	case DisputeOpen, DisputeRefunded, DisputeRejected:
+		return true
+	}
+
+	return false
+}
+
+// DisputeOutcome is the party a dispute is resolved in favor of.
+type DisputeOutcome string
+
+const (
+	OutcomeCustomer DisputeOutcome = "customer"
+	OutcomeMerchant DisputeOutcome = "merchant"
+)
+
+func (o DisputeOutcome) Valid() bool {
+	return o == OutcomeCustomer || o == OutcomeMerchant
+}
+
+// Status is the status of a dispute resolved with the outcome.
+func (o DisputeOutcome) Status() DisputeStatus {
+	if o == OutcomeCustomer {
+		return DisputeRefunded
+	}
+
+	return DisputeRejected
+}
+
+// Dispute is a customer's claim against a confirmed service payment. While
+// the dispute is open, the disputed amount is frozen from the revenue of the
+// service. A dispute resolved in favor of the customer refunds the amount to
+// the balance; one still open at the deadline is resolved so automatically.
+type Dispute struct {
+	ID            int           `json:"id"`
+	OrderID       int           `json:"order_id"`
+	UserID        int           `json:"user_id"`
+	ServiceID     int           `json:"service_id"`
+	Amount        int           `json:"amount"`
+	Reason        string        `json:"reason"`
+	Status        DisputeStatus `json:"status"`
+	Deadline      time.Time     `json:"deadline"`
+	ResolvedBy    *string       `json:"resolved_by,omitempty"`
+	Comment       *string       `json:"comment,omitempty"`
+	TransactionID *int          `json:"transaction_id,omitempty"`
+	Created       time.Time     `json:"created"`
+	Resolved      *time.Time    `json:"resolved,omitempty"`
+}
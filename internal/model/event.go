@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Event is a domain event written to the outbox in the same transaction as
+// the change it describes.
+type Event struct {
+	ID      int            `json:"id"`
+	Type    string         `json:"type"`
+	UserID  int            `json:"user_id"`
+	Payload map[string]any `json:"payload"`
+	Created time.Time      `json:"created"`
+}
@@ -0,0 +1,143 @@
+package model
+
+import "time"
+
+// RevenueAccountID is the account platform fees are booked to.
+const RevenueAccountID = 0
+
+// FeeOperation is an operation fee rules apply to. Transfer fees are charged
+// to the sender on top of the amount; payment fees are taken out of the
+// service cost.
+type FeeOperation string
+
+const (
+	FeeTransfer FeeOperation = "transfer"
+	FeePayment  FeeOperation = "payment"
+)
+
+func (o FeeOperation) Valid() bool {
+	switch o {
+	case FeeTransfer, FeePayment:
+		return true
+	}
+
+	return false
+}
+
+type FeeKind string
+
+const (
+	FeeFlat       FeeKind = "flat"
+	FeePercentage FeeKind = "percentage"
+	FeeTiered     FeeKind = "tiered"
+)
+
+func (k FeeKind) Valid() bool {
+	switch k {
+	case FeeFlat, FeePercentage, FeeTiered:
+		return true
+	}
+
+	return false
+}
+
+// FeeTier applies to amounts starting from From up to the next tier. Tiers
+// are not marginal: the tier an amount falls into is applied to the whole
+// amount.
+type FeeTier struct {
+	From    int `json:"from"`
+	Flat    int `json:"flat"`
+	RateBps int `json:"rate_bps"`
+}
+
+// FeeRule describes how the fee of an operation is calculated. Rates are in
+// basis points (1/100 of a percent). A rule with a service applies to payments
+// for that service only and takes precedence over a rule without one.
+type FeeRule struct {
+	ID        int          `json:"id"`
+	Operation FeeOperation `json:"operation"`
+	ServiceID *int         `json:"service_id,omitempty"`
+	Kind      FeeKind      `json:"kind"`
+	Flat      int          `json:"flat,omitempty"`
+	RateBps   int          `json:"rate_bps,omitempty"`
+	Tiers     []FeeTier    `json:"tiers,omitempty"`
+	MinFee    *int         `json:"min_fee,omitempty"`
+	MaxFee    *int         `json:"max_fee,omitempty"`
+	Enabled   bool         `json:"enabled"`
+	Created   time.Time    `json:"created"`
+}
+
+// Fee returns the fee for the amount. Percentages are rounded half up.
+func (r FeeRule) Fee(amount int) int {
+	var fee int
+	switch r.Kind {
+	case FeeFlat:
+		fee = r.Flat
+	case FeePercentage:
+		fee = percentage(amount, r.RateBps)
+	case FeeTiered:
+		for _, tier := range r.Tiers {
+			if amount < tier.From {
+				break
+			}
+
+			fee = tier.Flat + percentage(amount, tier.RateBps)
+		}
+	}
+
+	if r.MinFee != nil && fee < *r.MinFee {
+		fee = *r.MinFee
+	}
+	if r.MaxFee != nil && fee > *r.MaxFee {
+		fee = *r.MaxFee
+	}
+
+	return fee
+}
+
+func percentage(amount, rateBps int) int {
+	return (amount*rateBps + 5000) / 10000
+}
+
+// FeeQuote is the fee an operation would be charged. Total is the amount
+// taken from the user and Net is the amount the receiver or the service gets.
+type FeeQuote struct {
+	Operation FeeOperation `json:"operation"`
+	ServiceID *int         `json:"service_id,omitempty"`
+	Amount    int          `json:"amount"`
+	Fee       int          `json:"fee"`
+	Total     int          `json:"total"`
+	Net       int          `json:"net"`
+	RuleID    *int         `json:"rule_id,omitempty"`
+}
+
+// NewFeeQuote calculates the fee of the operation by the rule, which may be
+// nil if no rule applies. Payment fees never exceed the cost.
+func NewFeeQuote(rule *FeeRule, operation FeeOperation, serviceID *int, amount int) FeeQuote {
+	quote := FeeQuote{
+		Operation: operation,
+		ServiceID: serviceID,
+		Amount:    amount,
+	}
+	if rule != nil {
+		quote.Fee = rule.Fee(amount)
+		quote.RuleID = &rule.ID
+	}
+	if quote.Fee < 0 {
+		quote.Fee = 0
+	}
+
+	switch operation {
+	case FeePayment:
+		if quote.Fee > amount {
+			quote.Fee = amount
+		}
+		quote.Total = amount
+		quote.Net = amount - quote.Fee
+	default:
+		quote.Total = amount + quote.Fee
+		quote.Net = amount
+	}
+
+	return quote
+}
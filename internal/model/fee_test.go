@@ -0,0 +1,108 @@
+package model
+
+import "testing"
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func TestFeeRuleFee(t *testing.T) {
+	tiered := FeeRule{
+		Kind: FeeTiered,
+		Tiers: []FeeTier{
+			{From: 0, Flat: 10},
+			{From: 1000, RateBps: 100},
+			{From: 10000, Flat: 5, RateBps: 50},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		rule   FeeRule
+		amount int
+		want   int
+	}{
+		{"flat", FeeRule{Kind: FeeFlat, Flat: 30}, 1000, 30},
+		{"percentage", FeeRule{Kind: FeePercentage, RateBps: 150}, 1000, 15},
+		{"percentage rounds half up", FeeRule{Kind: FeePercentage, RateBps: 150}, 1100, 17},
+		{"percentage rounds down", FeeRule{Kind: FeePercentage, RateBps: 150}, 1099, 16},
+		{"percentage of zero", FeeRule{Kind: FeePercentage, RateBps: 150}, 0, 0},
+		{"min fee", FeeRule{Kind: FeePercentage, RateBps: 150, MinFee: intPtr(10)}, 100, 10},
+		{"max fee", FeeRule{Kind: FeePercentage, RateBps: 150, MaxFee: intPtr(500)}, 100000, 500},
+		{"first tier", tiered, 999, 10},
+		{"tier lower boundary", tiered, 1000, 10},
+		{"tier applies to the whole amount", tiered, 9999, 100},
+		{"last tier boundary", tiered, 10000, 55},
+		{"last tier", tiered, 20000, 105},
+		{"below the first tier", FeeRule{Kind: FeeTiered, Tiers: []FeeTier{{From: 100, Flat: 10}}}, 99, 0},
+		{"tiered with min fee", FeeRule{Kind: FeeTiered, Tiers: []FeeTier{{From: 100, Flat: 10}}, MinFee: intPtr(3)}, 99, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Fee(tt.amount); got != tt.want {
+				t.Errorf("Fee(%d) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFeeQuote(t *testing.T) {
+	rule := &FeeRule{ID: 1, Kind: FeePercentage, RateBps: 150}
+
+	tests := []struct {
+		name      string
+		rule      *FeeRule
+		operation FeeOperation
+		amount    int
+		want      FeeQuote
+	}{
+		{
+			name:      "transfer fee on top of the amount",
+			rule:      rule,
+			operation: FeeTransfer,
+			amount:    1000,
+			want:      FeeQuote{Operation: FeeTransfer, Amount: 1000, Fee: 15, Total: 1015, Net: 1000},
+		},
+		{
+			name:      "payment fee out of the cost",
+			rule:      rule,
+			operation: FeePayment,
+			amount:    1000,
+			want:      FeeQuote{Operation: FeePayment, Amount: 1000, Fee: 15, Total: 1000, Net: 985},
+		},
+		{
+			name:      "payment fee capped by the cost",
+			rule:      &FeeRule{ID: 1, Kind: FeeFlat, Flat: 50},
+			operation: FeePayment,
+			amount:    30,
+			want:      FeeQuote{Operation: FeePayment, Amount: 30, Fee: 30, Total: 30, Net: 0},
+		},
+		{
+			name:      "negative fee",
+			rule:      &FeeRule{ID: 1, Kind: FeeFlat, Flat: -5},
+			operation: FeeTransfer,
+			amount:    100,
+			want:      FeeQuote{Operation: FeeTransfer, Amount: 100, Fee: 0, Total: 100, Net: 100},
+		},
+		{
+			name:      "no rule",
+			operation: FeeTransfer,
+			amount:    100,
+			want:      FeeQuote{Operation: FeeTransfer, Amount: 100, Total: 100, Net: 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewFeeQuote(tt.rule, tt.operation, nil, tt.amount)
+			if (got.RuleID != nil) != (tt.rule != nil) {
+				t.Errorf("RuleID = %v, want the id of %v", got.RuleID, tt.rule)
+			}
+			got.RuleID = nil
+			if got != tt.want {
+				t.Errorf("NewFeeQuote() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// LimitOperation is an operation limit rules apply to.
+type LimitOperation string
+
+const (
+	LimitTopUp    LimitOperation = "topup"
+	LimitTransfer LimitOperation = "transfer"
+	LimitReserve  LimitOperation = "reserve"
+)
+
+func (o LimitOperation) Valid() bool {
+	switch o {
+	case LimitTopUp, LimitTransfer, LimitReserve:
+		return true
+	}
+
+	return false
+}
+
+// DefaultSegment is the segment of accounts no operator has classified.
+const DefaultSegment = "default"
+
+// LimitRule caps the total amount and/or the number of operations a user may
+// make within a rolling window. A rule without a segment applies to all users.
+type LimitRule struct {
+	ID            int            `json:"id"`
+	Operation     LimitOperation `json:"operation"`
+	Segment       *string        `json:"segment,omitempty"`
+	WindowSeconds int            `json:"window_seconds"`
+	MaxAmount     *int           `json:"max_amount,omitempty"`
+	MaxCount      *int           `json:"max_count,omitempty"`
+	Enabled       bool           `json:"enabled"`
+	Created       time.Time      `json:"created"`
+}
@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+type PendingTransferStatus string
+
+const (
+	PendingHeld     PendingTransferStatus = "held"
+	PendingCaptured PendingTransferStatus = "captured"
+	PendingVoided   PendingTransferStatus = "voided"
+	PendingExpired  PendingTransferStatus = "expired"
+)
+
+func (s PendingTransferStatus) Valid() bool {
+	switch s {
+	case PendingHeld, PendingCaptured, PendingVoided, PendingExpired:
+		return true
+	}
+
+	return false
+}
+
+// PendingTransfer is a two-phase transfer. The amount and the fee are held
+// from the sender until the transfer is captured to the receiver, or voided
+// or expired back to the sender. The transfer is booked to the journal only
+// when it is captured.
+type PendingTransfer struct {
+	ID            int                   `json:"id"`
+	SenderID      int                   `json:"sender_id"`
+	ReceiverID    int                   `json:"receiver_id"`
+	Amount        int                   `json:"amount"`
+	Fee           int                   `json:"fee"`
+	FeeRuleID     *int                  `json:"fee_rule_id,omitempty"`
+	Status        PendingTransferStatus `json:"status"`
+	ExternalRef   *string               `json:"external_ref,omitempty"`
+	Metadata      map[string]any        `json:"metadata,omitempty"`
+	TransactionID *int                  `json:"transaction_id,omitempty"`
+	Expires       time.Time             `json:"expires"`
+	Created       time.Time             `json:"created"`
+	Settled       *time.Time            `json:"settled,omitempty"`
+}
+
+// Party reports whether the user is the sender or the receiver.
+func (t PendingTransfer) Party(userID int) bool {
+	return t.SenderID == userID || t.ReceiverID == userID
+}
@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Discrepancy is a user whose balance doesn't match the journal. Money taken
+// from the balance by open reserves and held transfers isn't in the journal
+// yet, so the balance plus Open must equal the sum of the journal entries.
+type Discrepancy struct {
+	UserID        int  `json:"user_id"`
+	Balance       int  `json:"balance"`
+	Open          int  `json:"open"`
+	Journal       int  `json:"journal"`
+	Drift         int  `json:"drift"`
+	TransactionID *int `json:"transaction_id,omitempty"`
+}
+
+// Reconciliation is a run of the comparison of balances with the journal.
+// Drift is the sum of the absolute drifts of the discrepancies. If Repair is
+// set, the journal of each discrepancy is aligned with the balance by an
+// adjustment entry referenced by TransactionID.
+type Reconciliation struct {
+	ID            int           `json:"id"`
+	RequestedBy   string        `json:"requested_by"`
+	Repair        bool          `json:"repair"`
+	Users         int           `json:"users"`
+	Mismatched    int           `json:"mismatched"`
+	Drift         int           `json:"drift"`
+	Discrepancies []Discrepancy `json:"discrepancies,omitempty"`
+	Started       time.Time     `json:"started"`
+	Finished      *time.Time    `json:"finished,omitempty"`
+}
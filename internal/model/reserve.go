@@ -0,0 +1,11 @@
+package model
+
+// ReserveSource tells what a reserve pays for. Order ids are unique within a
+// source only, so the orders of the clients never collide with subscription
+// charges.
+type ReserveSource string
+
+const (
+	ReserveOrder        ReserveSource = "order"
+	ReserveSubscription ReserveSource = "subscription"
+)
@@ -0,0 +1,294 @@
+package model
+
+import (
+	"strconv"
+	"time"
+)
+
+// RiskOperation is a money movement risk rules are evaluated for.
+type RiskOperation string
+
+const (
+	RiskTopUp    RiskOperation = "topup"
+	RiskTransfer RiskOperation = "transfer"
+	RiskReserve  RiskOperation = "reserve"
+	RiskHold     RiskOperation = "hold"
+)
+
+func (o RiskOperation) Valid() bool {
+	switch o {
+	case RiskTopUp, RiskTransfer, RiskReserve, RiskHold:
+		return true
+	}
+
+	return false
+}
+
+// RiskOutcome is what happens to an operation a rule matches. Outcomes are
+// ordered by severity: when several rules match, the most severe one wins.
+type RiskOutcome string
+
+const (
+	OutcomeAllow  RiskOutcome = "allow"
+	OutcomeReview RiskOutcome = "review"
+	OutcomeDeny   RiskOutcome = "deny"
+)
+
+func (o RiskOutcome) Valid() bool {
+	switch o {
+	case OutcomeAllow, OutcomeReview, OutcomeDeny:
+		return true
+	}
+
+	return false
+}
+
+func (o RiskOutcome) severity() int {
+	switch o {
+	case OutcomeReview:
+		return 1
+	case OutcomeDeny:
+		return 2
+	}
+
+	return 0
+}
+
+// Worse reports whether o is more severe than outcome.
+func (o RiskOutcome) Worse(outcome RiskOutcome) bool {
+	return o.severity() > outcome.severity()
+}
+
+// RiskField is a fact about an operation a condition compares.
+type RiskField string
+
+const (
+	// RiskAmount is the amount of the operation.
+	RiskAmount RiskField = "amount"
+	// RiskAccountAge is the age of the account of the user in seconds.
+	RiskAccountAge RiskField = "account_age_seconds"
+	// RiskBalanceShare is the share of the money available to the user the
+	// operation takes, in percent.
+	RiskBalanceShare RiskField = "balance_share_pct"
+	// RiskUserBlocklisted is 1 if the user is on the blocklist, 0 otherwise.
+	RiskUserBlocklisted RiskField = "user_blocklisted"
+	// RiskCounterpartyBlocklisted is 1 if the receiver of a transfer is on
+	// the blocklist, 0 otherwise.
+	RiskCounterpartyBlocklisted RiskField = "counterparty_blocklisted"
+
+	// The velocity fields count and sum up the money that came to and left
+	// the account within the window of the condition.
+	RiskIncomingCount  RiskField = "incoming_count"
+	RiskIncomingAmount RiskField = "incoming_amount"
+	RiskOutgoingCount  RiskField = "outgoing_count"
+	RiskOutgoingAmount RiskField = "outgoing_amount"
+)
+
+func (f RiskField) Valid() bool {
+	switch f {
+	case RiskAmount, RiskAccountAge, RiskBalanceShare, RiskUserBlocklisted, RiskCounterpartyBlocklisted:
+		return true
+	}
+
+	return f.Windowed()
+}
+
+// Windowed reports whether the field is measured within a window.
+func (f RiskField) Windowed() bool {
+	switch f {
+	case RiskIncomingCount, RiskIncomingAmount, RiskOutgoingCount, RiskOutgoingAmount:
+		return true
+	}
+
+	return false
+}
+
+type RiskComparison string
+
+const (
+	RiskEq  RiskComparison = "eq"
+	RiskGt  RiskComparison = "gt"
+	RiskGte RiskComparison = "gte"
+	RiskLt  RiskComparison = "lt"
+	RiskLte RiskComparison = "lte"
+)
+
+func (c RiskComparison) Valid() bool {
+	switch c {
+	case RiskEq, RiskGt, RiskGte, RiskLt, RiskLte:
+		return true
+	}
+
+	return false
+}
+
+// RiskCondition is a node of the rules DSL: either a combination of other
+// conditions (all, any, not) or a comparison of a fact with a value, e.g.
+//
+//	{"all": [
+//	  {"field": "account_age_seconds", "op": "lt", "value": 86400},
+//	  {"field": "incoming_count", "window_seconds": 3600, "op": "gte", "value": 5},
+//	  {"field": "balance_share_pct", "op": "gte", "value": 90}
+//	]}
+type RiskCondition struct {
+	All    []RiskCondition `json:"all,omitempty"`
+	Any    []RiskCondition `json:"any,omitempty"`
+	Not    *RiskCondition  `json:"not,omitempty"`
+	Field  RiskField       `json:"field,omitempty"`
+	Window int             `json:"window_seconds,omitempty"`
+	Op     RiskComparison  `json:"op,omitempty"`
+	Value  int             `json:"value,omitempty"`
+}
+
+// RiskFact identifies a fact a condition needs.
+type RiskFact struct {
+	Field  RiskField
+	Window int
+}
+
+// Key is the name the fact is recorded under in decisions.
+func (f RiskFact) Key() string {
+	if !f.Field.Windowed() {
+		return string(f.Field)
+	}
+
+	return string(f.Field) + ":" + strconv.Itoa(f.Window)
+}
+
+// Facts returns the facts the condition compares.
+func (c RiskCondition) Facts() []RiskFact {
+	var facts []RiskFact
+	for _, condition := range append(c.All, c.Any...) {
+		facts = append(facts, condition.Facts()...)
+	}
+	if c.Not != nil {
+		facts = append(facts, c.Not.Facts()...)
+	}
+	if c.Field != "" {
+		facts = append(facts, RiskFact{Field: c.Field, Window: c.Window})
+	}
+
+	return facts
+}
+
+// Match evaluates the condition against the facts keyed by RiskFact.Key.
+func (c RiskCondition) Match(facts map[string]int) bool {
+	switch {
+	case c.All != nil:
+		for _, condition := range c.All {
+			if !condition.Match(facts) {
+				return false
+			}
+		}
+
+		return true
+	case c.Any != nil:
+		for _, condition := range c.Any {
+			if condition.Match(facts) {
+				return true
+			}
+		}
+
+		return false
+	case c.Not != nil:
+		return !c.Not.Match(facts)
+	}
+
+	fact := facts[RiskFact{Field: c.Field, Window: c.Window}.Key()]
+	switch c.Op {
+	case RiskEq:
+		return fact == c.Value
+	case RiskGt:
+		return fact > c.Value
+	case RiskGte:
+		return fact >= c.Value
+	case RiskLt:
+		return fact < c.Value
+	case RiskLte:
+		return fact <= c.Value
+	}
+
+	return false
+}
+
+// RiskRule gives the outcome of the operations its condition matches. A rule
+// without an operation applies to all of them.
+type RiskRule struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	Operation *RiskOperation `json:"operation,omitempty"`
+	Condition RiskCondition  `json:"condition"`
+	Outcome   RiskOutcome    `json:"outcome"`
+	Enabled   bool           `json:"enabled"`
+	Created   time.Time      `json:"created"`
+}
+
+// RiskCheck is an operation submitted to the risk stage. It holds everything
+// needed to make the operation later, once a review approves it.
+type RiskCheck struct {
+	Operation      RiskOperation  `json:"operation"`
+	UserID         int            `json:"user_id"`
+	CounterpartyID *int           `json:"counterparty_id,omitempty"`
+	OrderID        *int           `json:"order_id,omitempty"`
+	ServiceID      *int           `json:"service_id,omitempty"`
+	Amount         int            `json:"amount"`
+	TimeoutSeconds *int           `json:"timeout_seconds,omitempty"`
+	ExternalRef    *string        `json:"external_ref,omitempty"`
+	Metadata       map[string]any `json:"metadata,omitempty"`
+	// Unattended operations are made by the schedulers and can't wait for an
+	// operator: a review outcome denies them and queues no review.
+	Unattended bool `json:"unattended,omitempty"`
+}
+
+// RiskDecision is the audit record of a check some rule matched.
+type RiskDecision struct {
+	ID       int            `json:"id"`
+	Check    RiskCheck      `json:"check"`
+	Outcome  RiskOutcome    `json:"outcome"`
+	RuleIDs  []int          `json:"rule_ids"`
+	Facts    map[string]int `json:"facts"`
+	ReviewID *int           `json:"review_id,omitempty"`
+	Created  time.Time      `json:"created"`
+}
+
+type ReviewStatus string
+
+const (
+	ReviewPending   ReviewStatus = "pending"
+	ReviewExecuting ReviewStatus = "executing"
+	ReviewApproved  ReviewStatus = "approved"
+	ReviewRejected  ReviewStatus = "rejected"
+	ReviewFailed    ReviewStatus = "failed"
+)
+
+func (s ReviewStatus) Valid() bool {
+	switch s {
+	case ReviewPending, ReviewExecuting, ReviewApproved, ReviewRejected, ReviewFailed:
+		return true
+	}
+
+	return false
+}
+
+// RiskReview is an operation held for an operator to decide on. An approved
+// review is executing until its operation is made; one that can no longer be
+// made is failed with the reason.
+type RiskReview struct {
+	ID         int          `json:"id"`
+	DecisionID int          `json:"decision_id"`
+	Check      RiskCheck    `json:"check"`
+	Status     ReviewStatus `json:"status"`
+	Reviewer   *string      `json:"reviewer,omitempty"`
+	Comment    *string      `json:"comment,omitempty"`
+	Error      *string      `json:"error,omitempty"`
+	Created    time.Time    `json:"created"`
+	Decided    *time.Time   `json:"decided,omitempty"`
+}
+
+// BlockedUser is an entry of the blocklist.
+type BlockedUser struct {
+	UserID   int       `json:"user_id"`
+	Reason   string    `json:"reason"`
+	Operator string    `json:"operator"`
+	Created  time.Time `json:"created"`
+}
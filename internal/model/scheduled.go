@@ -0,0 +1,59 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+type ScheduledTransferStatus string
+
+const (
+	ScheduledActive    ScheduledTransferStatus = "active"
+	ScheduledCompleted ScheduledTransferStatus = "completed"
+	ScheduledFailed    ScheduledTransferStatus = "failed"
+	ScheduledCancelled ScheduledTransferStatus = "cancelled"
+)
+
+// ScheduledTransfer moves the amount from the user to the receiver at
+// NextRun and, if there is a period, every period after that. A transfer
+// without a period is completed or failed after its only run.
+type ScheduledTransfer struct {
+	ID         int                     `json:"id"`
+	UserID     int                     `json:"user_id"`
+	ReceiverID int                     `json:"receiver_id"`
+	Amount     int                     `json:"amount"`
+	Period     *Period                 `json:"period,omitempty"`
+	Status     ScheduledTransferStatus `json:"status"`
+	NextRun    time.Time               `json:"next_run"`
+	Created    time.Time               `json:"created"`
+	Runs       []TransferRun           `json:"runs,omitempty"`
+}
+
+type RunStatus string
+
+const (
+	RunPending   RunStatus = "pending"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+)
+
+// TransferRun is the execution of a scheduled transfer for one occurrence.
+// A failed run isn't retried; the schedule moves on to the next occurrence.
+type TransferRun struct {
+	ID                  int       `json:"id"`
+	ScheduledTransferID int       `json:"scheduled_transfer_id"`
+	ScheduledFor        time.Time `json:"scheduled_for"`
+	Status              RunStatus `json:"status"`
+	Attempts            int       `json:"attempts"`
+	TransactionID       *int      `json:"transaction_id,omitempty"`
+	Error               *string   `json:"error,omitempty"`
+	Created             time.Time `json:"created"`
+	Updated             time.Time `json:"updated"`
+}
+
+// ExternalRef is the reference the transfer of the run is booked with. It
+// identifies the occurrence, so a run interrupted after the transfer can
+// find it instead of making it again.
+func (r TransferRun) ExternalRef() string {
+	return fmt.Sprintf("scheduled-transfer-run:%d", r.ID)
+}
@@ -0,0 +1,85 @@
+package model
+
+import "time"
+
+// SettlementFormat is the format of a settlement file sent by a payment
+// provider.
+type SettlementFormat string
+
+const (
+	SettlementCSV     SettlementFormat = "csv"
+	SettlementCamt053 SettlementFormat = "camt053"
+)
+
+func (f SettlementFormat) Valid() bool {
+	return f == SettlementCSV || f == SettlementCamt053
+}
+
+// SettlementStatus is the result of matching a settlement line against the
+// top-ups of the journal.
+type SettlementStatus string
+
+const (
+	SettlementMatched    SettlementStatus = "matched"
+	SettlementUnmatched  SettlementStatus = "unmatched"
+	SettlementMismatched SettlementStatus = "mismatched"
+)
+
+func (s SettlementStatus) Valid() bool {
+	switch s {
+	case SettlementMatched, SettlementUnmatched, SettlementMismatched:
+		return true
+	}
+
+	return false
+}
+
+// SettlementLine is a credit the provider reports in a settlement file. Line
+// is the position of the line in the file, starting from 1.
+type SettlementLine struct {
+	Line      int        `json:"line"`
+	Reference string     `json:"reference"`
+	Amount    int        `json:"amount"`
+	Currency  string     `json:"currency,omitempty"`
+	Booked    *time.Time `json:"booked,omitempty"`
+}
+
+// SettlementItem is a settlement line matched against the top-ups with the
+// same external reference. A mismatched item references the top-up it was
+// compared with and explains the difference in Reason.
+type SettlementItem struct {
+	SettlementLine
+	Status        SettlementStatus `json:"status"`
+	TransactionID *int             `json:"transaction_id,omitempty"`
+	UserID        *int             `json:"user_id,omitempty"`
+	JournalAmount *int             `json:"journal_amount,omitempty"`
+	Reason        string           `json:"reason,omitempty"`
+}
+
+// SettledTopUp is a top-up of the journal a settlement line may match.
+// Settled is set if a line of an earlier settlement has matched it.
+type SettledTopUp struct {
+	ID          int
+	UserID      int
+	Amount      int
+	ExternalRef string
+	Reversed    bool
+	Settled     bool
+}
+
+// Settlement is an imported settlement file of a payment provider. Checksum
+// is the SHA-256 of the file, so a file can't be imported twice.
+type Settlement struct {
+	ID         int              `json:"id"`
+	Provider   string           `json:"provider"`
+	Format     SettlementFormat `json:"format"`
+	Checksum   string           `json:"checksum"`
+	ImportedBy string           `json:"imported_by"`
+	Lines      int              `json:"lines"`
+	Amount     int              `json:"amount"`
+	Matched    int              `json:"matched"`
+	Unmatched  int              `json:"unmatched"`
+	Mismatched int              `json:"mismatched"`
+	Items      []SettlementItem `json:"items,omitempty"`
+	Created    time.Time        `json:"created"`
+}
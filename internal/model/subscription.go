@@ -0,0 +1,103 @@
+package model
+
+import "time"
+
+// Period is how often a subscription is charged or a scheduled transfer is
+// made.
+type Period string
+
+const (
+	PeriodDay   Period = "day"
+	PeriodWeek  Period = "week"
+	PeriodMonth Period = "month"
+	PeriodYear  Period = "year"
+)
+
+func (p Period) Valid() bool {
+	switch p {
+	case PeriodDay, PeriodWeek, PeriodMonth, PeriodYear:
+		return true
+	}
+
+	return false
+}
+
+// Next returns the start of the period following the one starting at t.
+// Months and years follow the calendar, so a charge on the 31st moves to the
+// 1st of the month after a short one, as time.AddDate normalizes it.
+func (p Period) Next(t time.Time) time.Time {
+	switch p {
+	case PeriodDay:
+		return t.AddDate(0, 0, 1)
+	case PeriodWeek:
+		return t.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return t.AddDate(0, 1, 0)
+	case PeriodYear:
+		return t.AddDate(1, 0, 0)
+	}
+
+	return t
+}
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive    SubscriptionStatus = "active"
+	SubscriptionPastDue   SubscriptionStatus = "past_due"
+	SubscriptionPaused    SubscriptionStatus = "paused"
+	SubscriptionCancelled SubscriptionStatus = "cancelled"
+	SubscriptionExpired   SubscriptionStatus = "expired"
+)
+
+// CanBecome reports whether a subscription may move from s to status by a
+// request of the user. Past due subscriptions become active or expired only
+// by the scheduler; cancellation and expiry are final.
+func (s SubscriptionStatus) CanBecome(status SubscriptionStatus) bool {
+	switch s {
+	case SubscriptionActive, SubscriptionPastDue:
+		return status == SubscriptionPaused || status == SubscriptionCancelled
+	case SubscriptionPaused:
+		return status == SubscriptionActive || status == SubscriptionCancelled
+	}
+
+	return false
+}
+
+// Subscription charges the user the price for the service every period,
+// starting at NextCharge. A failed charge is retried at RetryAt until the
+// grace period after NextCharge runs out.
+type Subscription struct {
+	ID         int                  `json:"id"`
+	UserID     int                  `json:"user_id"`
+	ServiceID  int                  `json:"service_id"`
+	Price      int                  `json:"price"`
+	Period     Period               `json:"period"`
+	Status     SubscriptionStatus   `json:"status"`
+	NextCharge time.Time            `json:"next_charge"`
+	RetryAt    *time.Time           `json:"retry_at,omitempty"`
+	LastError  *string              `json:"last_error,omitempty"`
+	Created    time.Time            `json:"created"`
+	Charges    []SubscriptionCharge `json:"charges,omitempty"`
+}
+
+type ChargeStatus string
+
+const (
+	ChargePending ChargeStatus = "pending"
+	ChargePaid    ChargeStatus = "paid"
+	ChargeFailed  ChargeStatus = "failed"
+)
+
+// SubscriptionCharge is the charge for one period. It is paid with a
+// subscription reserve confirmed right away, whose order id is the charge id.
+type SubscriptionCharge struct {
+	ID             int          `json:"id"`
+	SubscriptionID int          `json:"subscription_id"`
+	PeriodStart    time.Time    `json:"period_start"`
+	Status         ChargeStatus `json:"status"`
+	Attempts       int          `json:"attempts"`
+	Error          *string      `json:"error,omitempty"`
+	Created        time.Time    `json:"created"`
+	Updated        time.Time    `json:"updated"`
+}
@@ -2,10 +2,113 @@ package model
 
 import "time"
 
+type TransactionType string
+
+const (
+	TransactionTopUp       TransactionType = "topup"
+	TransactionTransferOut TransactionType = "transfer_out"
+	TransactionTransferIn  TransactionType = "transfer_in"
+	TransactionPayment     TransactionType = "payment"
+	TransactionRefund      TransactionType = "refund"
+	TransactionAdjustment  TransactionType = "adjustment"
+	TransactionWithdrawal  TransactionType = "withdrawal"
+	TransactionReversal    TransactionType = "reversal"
+	TransactionFee         TransactionType = "fee"
+)
+
+func (t TransactionType) Valid() bool {
+	switch t {
+	case TransactionTopUp,
+		TransactionTransferOut,
+		TransactionTransferIn,
+		TransactionPayment,
+		TransactionRefund,
+		TransactionAdjustment,
+		TransactionWithdrawal,
+		TransactionReversal,
+		TransactionFee:
+		return true
+	}
+
+	return false
+}
+
 type Transaction struct {
-	ID      int       `json:"id"`
-	UserID  int       `json:"user_id"`
-	Amount  int       `json:"amount"`
-	Message string    `json:"message"`
-	Created time.Time `json:"created"`
+	ID             int             `json:"id"`
+	UserID         int             `json:"user_id"`
+	Amount         int             `json:"amount"`
+	Type           TransactionType `json:"type"`
+	CounterpartyID *int            `json:"counterparty_id,omitempty"`
+	OrderID        *int            `json:"order_id,omitempty"`
+	ServiceID      *int            `json:"service_id,omitempty"`
+	ExternalRef    *string         `json:"external_ref,omitempty"`
+	Metadata       map[string]any  `json:"metadata,omitempty"`
+	PairID         *int            `json:"pair_id,omitempty"`
+	ReversalOf     *int            `json:"reversal_of,omitempty"`
+	ReversedBy     *int            `json:"reversed_by,omitempty"`
+	Message        string          `json:"message"`
+	Created        time.Time       `json:"created"`
+}
+
+// Reversible reports whether the entry may be undone with a reversal.
+// Adjustments are undone through their own workflow and fee lines together
+// with the operation they were taken for.
+func (t Transaction) Reversible() bool {
+	switch t.Type {
+	case TransactionTopUp, TransactionTransferOut, TransactionTransferIn:
+		return true
+	}
+
+	return false
+}
+
+// Reversal describes a request to undo a journal entry. Force allows the
+// reversal to drive a balance negative and is reserved for operators.
+type Reversal struct {
+	Reason   string
+	Force    bool
+	Operator string
+}
+
+// Reference is the information a caller attaches to a money movement.
+type Reference struct {
+	ExternalRef *string
+	Metadata    map[string]any
+}
+
+const (
+	DirectionCredit = "credit"
+	DirectionDebit  = "debit"
+)
+
+// TransactionsQuery describes a page of user transactions. Nil filters are
+// not applied.
+type TransactionsQuery struct {
+	OrderField string
+	Descending bool
+	Limit      int
+	After      *TransactionsCursor
+
+	From           *time.Time
+	To             *time.Time
+	MinAmount      *int
+	MaxAmount      *int
+	Direction      string
+	Type           TransactionType
+	CounterpartyID *int
+}
+
+// TransactionsCursor points to the last transaction of the previous page.
+// It is bound to the ordering it was issued for.
+type TransactionsCursor struct {
+	OrderField string    `json:"f"`
+	Descending bool      `json:"d,omitempty"`
+	ID         int       `json:"id"`
+	Amount     int       `json:"a,omitempty"`
+	Created    time.Time `json:"c"`
+}
+
+type TransactionsPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
 }
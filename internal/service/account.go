@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrUserExists              = errors.New("user already exists")
+	ErrAccountFrozen           = errors.New("account is frozen")
+	ErrAccountClosed           = errors.New("account is closed")
+	ErrInvalidStatusTransition = errors.New("account status can't be changed")
+	ErrNonZeroBalance          = errors.New("account balance must be zero to close it")
+	ErrOpenReserves            = errors.New("account has open reserves")
+	ErrInvalidCreditLimit      = errors.New("credit limit must be non-negative")
+	ErrCreditLimitTooLow       = errors.New("credit limit doesn't cover the current overdraft")
+	ErrMissedSegment           = errors.New("segment is required")
+	ErrReservedAccount         = errors.New("account is reserved for the platform")
+)
+
+type accountStorage interface {
+	GetAccount(ctx context.Context, id int) (account model.Account, err error)
+}
+
+// checkNotReserved refuses the accounts of the platform: callers of the
+// public API can neither read them nor move their money.
+func checkNotReserved(ids ...int) error {
+	for _, id := range ids {
+		if id == model.RevenueAccountID {
+			return WithDetails(fmt.Errorf("%w: %d", ErrReservedAccount, id), Details{"user_id": id})
+		}
+	}
+
+	return nil
+}
+
+// checkCanSend fails early if money can't be taken from the account: frozen
+// and closed accounts can't spend. It reads the status outside of the
+// transaction which moves the money, so the storage checks it again there.
+func checkCanSend(ctx context.Context, accounts accountStorage, id int) error {
+	account, err := accounts.GetAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch account.Status {
+	case model.AccountFrozen:
+		return WithDetails(fmt.Errorf("%w: %d", ErrAccountFrozen, id), Details{"user_id": id})
+	case model.AccountClosed:
+		return WithDetails(fmt.Errorf("%w: %d", ErrAccountClosed, id), Details{"user_id": id})
+	}
+
+	return nil
+}
+
+// checkCanReceive fails early if money can't be put on the account: only
+// closed accounts can't receive. Like checkCanSend, it doesn't replace the
+// check of the storage.
+func checkCanReceive(ctx context.Context, accounts accountStorage, id int) error {
+	account, err := accounts.GetAccount(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if account.Status == model.AccountClosed {
+		return WithDetails(fmt.Errorf("%w: %d", ErrAccountClosed, id), Details{"user_id": id})
+	}
+
+	return nil
+}
+
+func (s UserService) CreateAccount(ctx context.Context, id int, metadata map[string]any) (account model.Account, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.CreateAccount")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(id); err != nil {
+		return account, err
+	}
+
+	return s.storage.CreateAccount(ctx, id, metadata)
+}
+
+// GetAccount returns the account together with its status history.
+func (s UserService) GetAccount(ctx context.Context, id int) (account model.Account, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetAccount")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(id); err != nil {
+		return account, err
+	}
+
+	if account, err = s.storage.GetAccount(ctx, id); err != nil {
+		return account, err
+	}
+
+	if account.Events, err = s.storage.AccountEvents(ctx, id); err != nil {
+		return account, err
+	}
+
+	return account, nil
+}
+
+// ChangeStatus freezes, unfreezes or closes the account on behalf of operator.
+func (s UserService) ChangeStatus(
+	ctx context.Context,
+	id int,
+	status model.AccountStatus,
+	reason string,
+	operator string,
+) (account model.Account, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.ChangeStatus")
+	span.SetAttributes(
+		attribute.Int("user.id", id),
+		attribute.String("status", string(status)),
+		attribute.String("operator", operator),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if reason == "" {
+		return account, ErrMissedReason
+	}
+
+	return s.storage.ChangeStatus(ctx, id, status, reason, operator)
+}
+
+// SetCreditLimit changes the amount the account may go below zero on behalf
+// of operator.
+func (s UserService) SetCreditLimit(
+	ctx context.Context,
+	id int,
+	creditLimit int,
+	reason string,
+	operator string,
+) (account model.Account, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.SetCreditLimit")
+	span.SetAttributes(
+		attribute.Int("user.id", id),
+		attribute.Int("credit_limit", creditLimit),
+		attribute.String("operator", operator),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if creditLimit < 0 {
+		return account, ErrInvalidCreditLimit
+	}
+	if reason == "" {
+		return account, ErrMissedReason
+	}
+
+	return s.storage.SetCreditLimit(ctx, id, creditLimit, reason, operator)
+}
+
+// SetSegment moves the account to the segment limit rules are scoped by on
+// behalf of operator.
+func (s UserService) SetSegment(
+	ctx context.Context,
+	id int,
+	segment string,
+	reason string,
+	operator string,
+) (account model.Account, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.SetSegment")
+	span.SetAttributes(
+		attribute.Int("user.id", id),
+		attribute.String("segment", segment),
+		attribute.String("operator", operator),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if segment == "" {
+		return account, ErrMissedSegment
+	}
+	if reason == "" {
+		return account, ErrMissedReason
+	}
+
+	return s.storage.SetSegment(ctx, id, segment, reason, operator)
+}
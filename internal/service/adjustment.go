@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidAdjustment    = errors.New("adjustment amount must not be zero")
+	ErrInvalidReasonCode    = errors.New("unknown reason code")
+	ErrMissedComment        = errors.New("comment is required")
+	ErrInvalidStatus        = errors.New("status must be 'pending', 'applied', 'rejected' or 'reversed'")
+	ErrAdjustmentNotFound   = errors.New("adjustment not found")
+	ErrAdjustmentNotPending = errors.New("adjustment has already been decided")
+	ErrSelfApproval         = errors.New("adjustment must be approved by another operator")
+	ErrAdjustmentNotApplied = errors.New("only applied adjustments can be reversed")
+)
+
+type adjustmentStorage interface {
+	Create(ctx context.Context, adjustment model.Adjustment, apply bool) (model.Adjustment, error)
+	Approve(ctx context.Context, id int, operator string) (model.Adjustment, error)
+	Reject(ctx context.Context, id int, operator string) (model.Adjustment, error)
+	Reverse(ctx context.Context, id int, reversal model.Reversal) (model.Adjustment, error)
+	Get(ctx context.Context, id int) (model.Adjustment, error)
+	List(ctx context.Context, status model.AdjustmentStatus) ([]model.Adjustment, error)
+}
+
+// AdjustmentService makes manual balance corrections. Adjustments whose
+// absolute amount reaches the approval threshold stay pending until another
+// operator approves them; a zero threshold disables approvals.
+type AdjustmentService struct {
+	storage           adjustmentStorage
+	approvalThreshold int
+}
+
+func NewAdjustmentService(storage adjustmentStorage, approvalThreshold int) AdjustmentService {
+	return AdjustmentService{
+		storage:           storage,
+		approvalThreshold: approvalThreshold,
+	}
+}
+
+func (s AdjustmentService) requiresApproval(amount int) bool {
+	if amount < 0 {
+		amount = -amount
+	}
+
+	return s.approvalThreshold > 0 && amount >= s.approvalThreshold
+}
+
+func (s AdjustmentService) Create(
+	ctx context.Context,
+	userID int,
+	amount int,
+	reasonCode model.ReasonCode,
+	comment string,
+	operator string,
+) (adjustment model.Adjustment, err error) {
+	ctx, span := tracer.Start(ctx, "AdjustmentService.Create")
+	span.SetAttributes(
+		attribute.Int("user.id", userID),
+		attribute.Int("amount", amount),
+		attribute.String("operator", operator),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if amount == 0 {
+		return adjustment, ErrInvalidAdjustment
+	}
+	if !reasonCode.Valid() {
+		return adjustment, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidReasonCode, reasonCode),
+			Details{"reason_code": reasonCode},
+		)
+	}
+	if comment == "" {
+		return adjustment, ErrMissedComment
+	}
+
+	apply := !s.requiresApproval(amount)
+	adjustment, err = s.storage.Create(ctx, model.Adjustment{
+		UserID:      userID,
+		Amount:      amount,
+		ReasonCode:  reasonCode,
+		Comment:     comment,
+		RequestedBy: operator,
+	}, apply)
+	if err != nil {
+		return adjustment, err
+	}
+
+	if apply {
+		observeAdjustment(adjustment)
+	}
+
+	return adjustment, nil
+}
+
+func (s AdjustmentService) Approve(ctx context.Context, id int, operator string) (adjustment model.Adjustment, err error) {
+	ctx, span := tracer.Start(ctx, "AdjustmentService.Approve")
+	span.SetAttributes(attribute.Int("adjustment.id", id), attribute.String("operator", operator))
+	defer func() { endSpan(span, err) }()
+
+	if adjustment, err = s.storage.Approve(ctx, id, operator); err != nil {
+		return adjustment, err
+	}
+
+	observeAdjustment(adjustment)
+
+	return adjustment, nil
+}
+
+func (s AdjustmentService) Reject(ctx context.Context, id int, operator string) (adjustment model.Adjustment, err error) {
+	ctx, span := tracer.Start(ctx, "AdjustmentService.Reject")
+	span.SetAttributes(attribute.Int("adjustment.id", id), attribute.String("operator", operator))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Reject(ctx, id, operator)
+}
+
+// Reverse undoes the applied adjustment on behalf of reversal.Operator.
+func (s AdjustmentService) Reverse(
+	ctx context.Context,
+	id int,
+	reversal model.Reversal,
+) (adjustment model.Adjustment, err error) {
+	ctx, span := tracer.Start(ctx, "AdjustmentService.Reverse")
+	span.SetAttributes(
+		attribute.Int("adjustment.id", id),
+		attribute.String("operator", reversal.Operator),
+		attribute.Bool("force", reversal.Force),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if reversal.Reason == "" {
+		return adjustment, ErrMissedReason
+	}
+
+	if adjustment, err = s.storage.Reverse(ctx, id, reversal); err != nil {
+		return adjustment, err
+	}
+
+	amount := adjustment.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	observeOperation("reversal", amount)
+
+	return adjustment, nil
+}
+
+func (s AdjustmentService) Get(ctx context.Context, id int) (adjustment model.Adjustment, err error) {
+	ctx, span := tracer.Start(ctx, "AdjustmentService.Get")
+	span.SetAttributes(attribute.Int("adjustment.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s AdjustmentService) List(ctx context.Context, status model.AdjustmentStatus) (adjustments []model.Adjustment, err error) {
+	ctx, span := tracer.Start(ctx, "AdjustmentService.List")
+	span.SetAttributes(attribute.String("status", string(status)))
+	defer func() { endSpan(span, err) }()
+
+	switch status {
+	case model.AdjustmentPending, model.AdjustmentApplied, model.AdjustmentRejected, model.AdjustmentReversed:
+	default:
+		return nil, ErrInvalidStatus
+	}
+
+	return s.storage.List(ctx, status)
+}
+
+func observeAdjustment(adjustment model.Adjustment) {
+	amount := adjustment.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+
+	observeOperation("adjustment", amount)
+}
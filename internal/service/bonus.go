@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidBonus         = errors.New("bonus amount must be positive")
+	ErrInvalidExpiry        = errors.New("expiry must be in the future")
+	ErrInvalidCashbackRule  = errors.New("cashback rule must have a positive rate and validity")
+	ErrCashbackRuleNotFound = errors.New("cashback rule not found")
+)
+
+type bonusStorage interface {
+	Grant(ctx context.Context, grant model.BonusGrant) (model.BonusGrant, error)
+	Grants(ctx context.Context, userID int) ([]model.BonusGrant, error)
+	CreateCashbackRule(ctx context.Context, rule model.CashbackRule) (model.CashbackRule, error)
+	UpdateCashbackRule(ctx context.Context, rule model.CashbackRule) (model.CashbackRule, error)
+	DeleteCashbackRule(ctx context.Context, id int) (model.CashbackRule, error)
+	GetCashbackRule(ctx context.Context, id int) (model.CashbackRule, error)
+	ListCashbackRules(ctx context.Context) ([]model.CashbackRule, error)
+}
+
+// BonusService grants bonus money and manages the cashback rules. Bonuses are
+// spent and returned by the order storage together with the reserves.
+type BonusService struct {
+	storage  bonusStorage
+	accounts accountStorage
+}
+
+func NewBonusService(storage bonusStorage, accounts accountStorage) BonusService {
+	return BonusService{
+		storage:  storage,
+		accounts: accounts,
+	}
+}
+
+// Grant credits bonus money spendable on serviceIDs (or on any service if
+// there are none) until expires on behalf of operator.
+func (s BonusService) Grant(
+	ctx context.Context,
+	userID int,
+	amount int,
+	serviceIDs []int,
+	expires time.Time,
+	reason string,
+	operator string,
+) (grant model.BonusGrant, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.Grant")
+	span.SetAttributes(
+		attribute.Int("user.id", userID),
+		attribute.Int("amount", amount),
+		attribute.String("operator", operator),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if amount <= 0 {
+		return grant, ErrInvalidBonus
+	}
+	if !expires.After(time.Now()) {
+		return grant, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidExpiry, expires.Format(time.RFC3339)),
+			Details{"expires": expires},
+		)
+	}
+	if reason == "" {
+		return grant, ErrMissedReason
+	}
+
+	if err = checkCanReceive(ctx, s.accounts, userID); err != nil {
+		return grant, err
+	}
+
+	if grant, err = s.storage.Grant(ctx, model.BonusGrant{
+		UserID:     userID,
+		Amount:     amount,
+		ServiceIDs: serviceIDs,
+		Source:     model.BonusGranted,
+		Reason:     reason,
+		Operator:   operator,
+		Expires:    expires,
+	}); err != nil {
+		return grant, err
+	}
+
+	observeOperation("bonus", amount)
+
+	return grant, nil
+}
+
+// Grants returns the spendable bonus lots of the user.
+func (s BonusService) Grants(ctx context.Context, userID int) (grants []model.BonusGrant, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.Grants")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return nil, err
+	}
+
+	if _, err = s.accounts.GetAccount(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.Grants(ctx, userID)
+}
+
+func validateCashbackRule(rule model.CashbackRule) error {
+	if rule.RateBps <= 0 || rule.ValidSeconds <= 0 || rule.MaxAmount != nil && *rule.MaxAmount <= 0 {
+		return ErrInvalidCashbackRule
+	}
+
+	return nil
+}
+
+func (s BonusService) CreateCashbackRule(ctx context.Context, rule model.CashbackRule) (created model.CashbackRule, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.CreateCashbackRule")
+	defer func() { endSpan(span, err) }()
+
+	if err = validateCashbackRule(rule); err != nil {
+		return created, err
+	}
+
+	return s.storage.CreateCashbackRule(ctx, rule)
+}
+
+func (s BonusService) UpdateCashbackRule(ctx context.Context, rule model.CashbackRule) (updated model.CashbackRule, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.UpdateCashbackRule")
+	span.SetAttributes(attribute.Int("rule.id", rule.ID))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateCashbackRule(rule); err != nil {
+		return updated, err
+	}
+
+	return s.storage.UpdateCashbackRule(ctx, rule)
+}
+
+func (s BonusService) DeleteCashbackRule(ctx context.Context, id int) (rule model.CashbackRule, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.DeleteCashbackRule")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.DeleteCashbackRule(ctx, id)
+}
+
+func (s BonusService) GetCashbackRule(ctx context.Context, id int) (rule model.CashbackRule, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.GetCashbackRule")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.GetCashbackRule(ctx, id)
+}
+
+func (s BonusService) ListCashbackRules(ctx context.Context) (rules []model.CashbackRule, err error) {
+	ctx, span := tracer.Start(ctx, "BonusService.ListCashbackRules")
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.ListCashbackRules(ctx)
+}
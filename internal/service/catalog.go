@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidCatalogItem   = errors.New("invalid catalog service")
+	ErrCatalogItemExists    = errors.New("service already exists in the catalog")
+	ErrCatalogItemNotFound  = errors.New("service not found in the catalog")
+	ErrInvalidServicePrice  = errors.New("invalid service price")
+	ErrServicePriceNotFound = errors.New("service price not found")
+	ErrServiceInactive      = errors.New("service is inactive")
+	ErrNoServicePrice       = errors.New("service has no price at the moment")
+	ErrPriceMismatch        = errors.New("cost doesn't match the price of the service")
+)
+
+type catalogStorage interface {
+	Create(ctx context.Context, item model.CatalogItem) (model.CatalogItem, error)
+	Update(ctx context.Context, item model.CatalogItem) (model.CatalogItem, error)
+	Get(ctx context.Context, id int) (model.CatalogItem, error)
+	List(ctx context.Context, category *string, active *bool) ([]model.CatalogItem, error)
+	AddPrice(
+		ctx context.Context,
+		serviceID int,
+		price int,
+		validFrom *time.Time,
+		validTo *time.Time,
+	) (model.ServicePrice, error)
+	DeletePrice(ctx context.Context, serviceID, id int) (model.ServicePrice, error)
+	CurrentPrice(ctx context.Context, serviceID int) (active bool, price *int, err error)
+}
+
+// catalogChecker validates the cost of a service before it is reserved.
+type catalogChecker interface {
+	CheckPrice(ctx context.Context, serviceID int, cost int) error
+}
+
+// CatalogService manages the services users pay for and their prices.
+type CatalogService struct {
+	storage       catalogStorage
+	requireListed bool
+	toleranceBps  int
+}
+
+// NewCatalogService returns a catalog which lets costs differ from the price
+// by toleranceBps basis points. Services missing from the catalog can be
+// reserved at any cost unless requireListed is set.
+func NewCatalogService(storage catalogStorage, requireListed bool, toleranceBps int) CatalogService {
+	return CatalogService{
+		storage:       storage,
+		requireListed: requireListed,
+		toleranceBps:  toleranceBps,
+	}
+}
+
+func invalidCatalogItem(reason string) error {
+	return WithDetails(
+		fmt.Errorf("%w: %s", ErrInvalidCatalogItem, reason),
+		Details{"reason": reason},
+	)
+}
+
+func invalidServicePrice(reason string) error {
+	return WithDetails(
+		fmt.Errorf("%w: %s", ErrInvalidServicePrice, reason),
+		Details{"reason": reason},
+	)
+}
+
+func validateCatalogItem(item model.CatalogItem) error {
+	if item.Name == "" {
+		return invalidCatalogItem("name must not be empty")
+	}
+	if len(item.Currency) != 3 {
+		return invalidCatalogItem("currency must be a three-letter code")
+	}
+	for _, c := range item.Currency {
+		if c < 'A' || c > 'Z' {
+			return invalidCatalogItem("currency must be a three-letter code")
+		}
+	}
+
+	return nil
+}
+
+func (s CatalogService) Create(ctx context.Context, item model.CatalogItem) (created model.CatalogItem, err error) {
+	ctx, span := tracer.Start(ctx, "CatalogService.Create")
+	span.SetAttributes(attribute.Int("service.id", item.ID))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateCatalogItem(item); err != nil {
+		return created, err
+	}
+
+	return s.storage.Create(ctx, item)
+}
+
+func (s CatalogService) Update(ctx context.Context, item model.CatalogItem) (updated model.CatalogItem, err error) {
+	ctx, span := tracer.Start(ctx, "CatalogService.Update")
+	span.SetAttributes(attribute.Int("service.id", item.ID))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateCatalogItem(item); err != nil {
+		return updated, err
+	}
+
+	return s.storage.Update(ctx, item)
+}
+
+func (s CatalogService) Get(ctx context.Context, id int) (item model.CatalogItem, err error) {
+	ctx, span := tracer.Start(ctx, "CatalogService.Get")
+	span.SetAttributes(attribute.Int("service.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s CatalogService) List(
+	ctx context.Context,
+	category *string,
+	active *bool,
+) (items []model.CatalogItem, err error) {
+	ctx, span := tracer.Start(ctx, "CatalogService.List")
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.List(ctx, category, active)
+}
+
+func (s CatalogService) AddPrice(
+	ctx context.Context,
+	serviceID int,
+	price int,
+	validFrom *time.Time,
+	validTo *time.Time,
+) (added model.ServicePrice, err error) {
+	ctx, span := tracer.Start(ctx, "CatalogService.AddPrice")
+	span.SetAttributes(attribute.Int("service.id", serviceID), attribute.Int("price", price))
+	defer func() { endSpan(span, err) }()
+
+	if price < 0 {
+		return added, invalidServicePrice("price must be non-negative")
+	}
+	if validFrom != nil && validTo != nil && !validTo.After(*validFrom) {
+		return added, invalidServicePrice("valid_to must be after valid_from")
+	}
+
+	return s.storage.AddPrice(ctx, serviceID, price, validFrom, validTo)
+}
+
+func (s CatalogService) DeletePrice(ctx context.Context, serviceID, id int) (price model.ServicePrice, err error) {
+	ctx, span := tracer.Start(ctx, "CatalogService.DeletePrice")
+	span.SetAttributes(attribute.Int("service.id", serviceID), attribute.Int("price.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.DeletePrice(ctx, serviceID, id)
+}
+
+// CheckPrice checks that the service is active and the cost is within the
+// tolerance of its current price.
+func (s CatalogService) CheckPrice(ctx context.Context, serviceID int, cost int) error {
+	active, price, err := s.storage.CurrentPrice(ctx, serviceID)
+	if err != nil {
+		if errors.Is(err, ErrCatalogItemNotFound) && !s.requireListed {
+			return nil
+		}
+
+		return err
+	}
+
+	if !active {
+		return WithDetails(
+			fmt.Errorf("%w: %d", ErrServiceInactive, serviceID),
+			Details{"service_id": serviceID},
+		)
+	}
+	if price == nil {
+		return WithDetails(
+			fmt.Errorf("%w: %d", ErrNoServicePrice, serviceID),
+			Details{"service_id": serviceID},
+		)
+	}
+
+	diff := cost - *price
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff*10000 > *price*s.toleranceBps {
+		return WithDetails(
+			fmt.Errorf("%w: cost %d, price %d", ErrPriceMismatch, cost, *price),
+			Details{"service_id": serviceID, "cost": cost, "price": *price},
+		)
+	}
+
+	return nil
+}
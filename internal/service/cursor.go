@@ -0,0 +1,27 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/s02190058/billing-service/internal/model"
+)
+
+func encodeCursor(cursor model.TransactionsCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*model.TransactionsCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	cursor := new(model.TransactionsCursor)
+	if err = json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
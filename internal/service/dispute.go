@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidDisputeAmount = errors.New("dispute amount must be positive and not exceed the paid amount")
+	ErrInvalidOutcome       = errors.New("outcome must be 'customer' or 'merchant'")
+	ErrInvalidDisputeStatus = errors.New("status must be 'open', 'refunded' or 'rejected'")
+	ErrNotDisputable        = errors.New("only confirmed payments can be disputed")
+	ErrDisputeWindowClosed  = errors.New("payment is too old to be disputed")
+	ErrAlreadyDisputed      = errors.New("payment has already been disputed")
+	ErrDisputeNotFound      = errors.New("dispute not found")
+	ErrDisputeNotOpen       = errors.New("dispute has already been resolved")
+)
+
+// deadlineOperator is the operator disputes resolved at their deadline are
+// recorded with.
+const deadlineOperator = "system"
+
+type disputeStorage interface {
+	Open(
+		ctx context.Context,
+		dispute model.Dispute,
+		amount *int,
+		windowSeconds int,
+		responseSeconds int,
+	) (model.Dispute, error)
+	Get(ctx context.Context, id int) (model.Dispute, error)
+	List(ctx context.Context, userID int) ([]model.Dispute, error)
+	ListByStatus(ctx context.Context, status model.DisputeStatus) ([]model.Dispute, error)
+	Overdue(ctx context.Context, limit int) ([]model.Dispute, error)
+	Resolve(
+		ctx context.Context,
+		id int,
+		outcome model.DisputeOutcome,
+		operator string,
+		comment *string,
+	) (model.Dispute, error)
+}
+
+// DisputeService manages disputes against confirmed service payments.
+// Disputes are opened through the public API and resolved by operators.
+type DisputeService struct {
+	storage      disputeStorage
+	accounts     accountStorage
+	window       time.Duration
+	responseTime time.Duration
+	batchSize    int
+}
+
+func NewDisputeService(
+	storage disputeStorage,
+	accounts accountStorage,
+	window time.Duration,
+	responseTime time.Duration,
+	batchSize int,
+) DisputeService {
+	return DisputeService{
+		storage:      storage,
+		accounts:     accounts,
+		window:       window,
+		responseTime: responseTime,
+		batchSize:    batchSize,
+	}
+}
+
+// Open disputes the payment of the order. The whole paid amount is disputed
+// if there is no amount.
+func (s DisputeService) Open(
+	ctx context.Context,
+	orderID, userID, serviceID int,
+	amount *int,
+	reason string,
+) (dispute model.Dispute, err error) {
+	ctx, span := tracer.Start(ctx, "DisputeService.Open")
+	span.SetAttributes(
+		attribute.Int("order.id", orderID),
+		attribute.Int("user.id", userID),
+		attribute.Int("service.id", serviceID),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return dispute, err
+	}
+
+	if reason == "" {
+		return dispute, ErrMissedReason
+	}
+	if amount != nil && *amount <= 0 {
+		return dispute, WithDetails(
+			fmt.Errorf("%w: %d", ErrInvalidDisputeAmount, *amount),
+			Details{"amount": *amount},
+		)
+	}
+
+	return s.storage.Open(ctx, model.Dispute{
+		OrderID:   orderID,
+		UserID:    userID,
+		ServiceID: serviceID,
+		Reason:    reason,
+	}, amount, int(s.window.Seconds()), int(s.responseTime.Seconds()))
+}
+
+func (s DisputeService) Get(ctx context.Context, id int) (dispute model.Dispute, err error) {
+	ctx, span := tracer.Start(ctx, "DisputeService.Get")
+	span.SetAttributes(attribute.Int("dispute.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s DisputeService) List(ctx context.Context, userID int) (disputes []model.Dispute, err error) {
+	ctx, span := tracer.Start(ctx, "DisputeService.List")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return nil, err
+	}
+
+	if _, err = s.accounts.GetAccount(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.List(ctx, userID)
+}
+
+// ListByStatus returns the disputes in the status, the open ones by default.
+func (s DisputeService) ListByStatus(
+	ctx context.Context,
+	status *model.DisputeStatus,
+) (disputes []model.Dispute, err error) {
+	ctx, span := tracer.Start(ctx, "DisputeService.ListByStatus")
+	defer func() { endSpan(span, err) }()
+
+	queue := model.DisputeOpen
+	if status != nil {
+		if !status.Valid() {
+			return nil, WithDetails(
+				fmt.Errorf("%w: %s", ErrInvalidDisputeStatus, *status),
+				Details{"status": *status},
+			)
+		}
+
+		queue = *status
+	}
+
+	return s.storage.ListByStatus(ctx, queue)
+}
+
+// Resolve resolves the dispute with the outcome on behalf of operator.
+func (s DisputeService) Resolve(
+	ctx context.Context,
+	id int,
+	outcome model.DisputeOutcome,
+	operator string,
+	comment *string,
+) (dispute model.Dispute, err error) {
+	ctx, span := tracer.Start(ctx, "DisputeService.Resolve")
+	span.SetAttributes(
+		attribute.Int("dispute.id", id),
+		attribute.String("outcome", string(outcome)),
+		attribute.String("operator", operator),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if !outcome.Valid() {
+		return dispute, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidOutcome, outcome),
+			Details{"outcome": outcome},
+		)
+	}
+
+	return s.resolve(ctx, id, outcome, operator, comment)
+}
+
+func (s DisputeService) resolve(
+	ctx context.Context,
+	id int,
+	outcome model.DisputeOutcome,
+	operator string,
+	comment *string,
+) (model.Dispute, error) {
+	dispute, err := s.storage.Resolve(ctx, id, outcome, operator, comment)
+	if err != nil {
+		return dispute, err
+	}
+
+	if dispute.Status == model.DisputeRefunded {
+		observeOperation("chargeback", dispute.Amount)
+	}
+
+	return dispute, nil
+}
+
+// ResolveOverdue resolves a batch of disputes the merchant hasn't answered
+// by the deadline in favor of the customer. The first error is returned
+// after the rest of the batch has been tried.
+func (s DisputeService) ResolveOverdue(ctx context.Context) error {
+	disputes, err := s.storage.Overdue(ctx, s.batchSize)
+	if err != nil {
+		return err
+	}
+
+	comment := "deadline passed"
+	for _, dispute := range disputes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		_, resolveErr := s.resolve(ctx, dispute.ID, model.OutcomeCustomer, deadlineOperator, &comment)
+		// a dispute resolved by an operator meanwhile is left as it is
+		if resolveErr != nil && !errors.Is(resolveErr, ErrDisputeNotOpen) && err == nil {
+			err = resolveErr
+		}
+	}
+
+	return err
+}
@@ -5,3 +5,28 @@ import "errors"
 var (
 	ErrInternalServerError = errors.New("internal server error")
 )
+
+// Details are machine readable facts about an error, such as the current
+// balance or the identifiers of missing records.
+type Details map[string]any
+
+// DetailedError attaches details to an error without changing its message.
+type DetailedError struct {
+	err     error
+	Details Details
+}
+
+func WithDetails(err error, details Details) error {
+	return &DetailedError{
+		err:     err,
+		Details: details,
+	}
+}
+
+func (e *DetailedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *DetailedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidFeeOperation = errors.New("operation must be 'transfer' or 'payment'")
+	ErrInvalidFeeKind      = errors.New("kind must be 'flat', 'percentage' or 'tiered'")
+	ErrInvalidFeeRule      = errors.New("invalid fee rule")
+	ErrFeeRuleNotFound     = errors.New("fee rule not found")
+)
+
+type feeStorage interface {
+	Create(ctx context.Context, rule model.FeeRule) (model.FeeRule, error)
+	Update(ctx context.Context, rule model.FeeRule) (model.FeeRule, error)
+	Delete(ctx context.Context, id int) (model.FeeRule, error)
+	Get(ctx context.Context, id int) (model.FeeRule, error)
+	List(ctx context.Context) ([]model.FeeRule, error)
+	Quote(ctx context.Context, operation model.FeeOperation, serviceID *int, amount int) (model.FeeQuote, error)
+}
+
+// FeeService manages the fee rules and previews fees. The fees themselves are
+// charged by the storage in the same transaction as the operation.
+type FeeService struct {
+	storage feeStorage
+}
+
+func NewFeeService(storage feeStorage) FeeService {
+	return FeeService{
+		storage: storage,
+	}
+}
+
+func invalidFeeRule(reason string) error {
+	return WithDetails(
+		fmt.Errorf("%w: %s", ErrInvalidFeeRule, reason),
+		Details{"reason": reason},
+	)
+}
+
+func validateFeeRule(rule model.FeeRule) error {
+	if !rule.Operation.Valid() {
+		return WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidFeeOperation, rule.Operation),
+			Details{"operation": rule.Operation},
+		)
+	}
+	if !rule.Kind.Valid() {
+		return WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidFeeKind, rule.Kind),
+			Details{"kind": rule.Kind},
+		)
+	}
+	if rule.ServiceID != nil && rule.Operation != model.FeePayment {
+		return invalidFeeRule("service_id is allowed for payments only")
+	}
+	if rule.Flat < 0 || rule.RateBps < 0 {
+		return invalidFeeRule("flat and rate_bps must be non-negative")
+	}
+	if rule.Kind == model.FeeTiered {
+		if len(rule.Tiers) == 0 {
+			return invalidFeeRule("tiered rule must have tiers")
+		}
+		for i, tier := range rule.Tiers {
+			if tier.From < 0 || tier.Flat < 0 || tier.RateBps < 0 {
+				return invalidFeeRule("tier values must be non-negative")
+			}
+			if i > 0 && tier.From <= rule.Tiers[i-1].From {
+				return invalidFeeRule("tiers must be sorted by from")
+			}
+		}
+	} else if len(rule.Tiers) != 0 {
+		return invalidFeeRule("tiers are allowed for tiered rules only")
+	}
+	if rule.MinFee != nil && *rule.MinFee < 0 || rule.MaxFee != nil && *rule.MaxFee < 0 {
+		return invalidFeeRule("min_fee and max_fee must be non-negative")
+	}
+	if rule.MinFee != nil && rule.MaxFee != nil && *rule.MinFee > *rule.MaxFee {
+		return invalidFeeRule("min_fee must not exceed max_fee")
+	}
+
+	return nil
+}
+
+func (s FeeService) Create(ctx context.Context, rule model.FeeRule) (created model.FeeRule, err error) {
+	ctx, span := tracer.Start(ctx, "FeeService.Create")
+	span.SetAttributes(attribute.String("operation", string(rule.Operation)))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateFeeRule(rule); err != nil {
+		return created, err
+	}
+
+	return s.storage.Create(ctx, rule)
+}
+
+func (s FeeService) Update(ctx context.Context, rule model.FeeRule) (updated model.FeeRule, err error) {
+	ctx, span := tracer.Start(ctx, "FeeService.Update")
+	span.SetAttributes(attribute.Int("rule.id", rule.ID), attribute.String("operation", string(rule.Operation)))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateFeeRule(rule); err != nil {
+		return updated, err
+	}
+
+	return s.storage.Update(ctx, rule)
+}
+
+func (s FeeService) Delete(ctx context.Context, id int) (rule model.FeeRule, err error) {
+	ctx, span := tracer.Start(ctx, "FeeService.Delete")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Delete(ctx, id)
+}
+
+func (s FeeService) Get(ctx context.Context, id int) (rule model.FeeRule, err error) {
+	ctx, span := tracer.Start(ctx, "FeeService.Get")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s FeeService) List(ctx context.Context) (rules []model.FeeRule, err error) {
+	ctx, span := tracer.Start(ctx, "FeeService.List")
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.List(ctx)
+}
+
+// Quote previews the fee the operation would be charged with the current
+// rules.
+func (s FeeService) Quote(
+	ctx context.Context,
+	operation model.FeeOperation,
+	serviceID *int,
+	amount int,
+) (quote model.FeeQuote, err error) {
+	ctx, span := tracer.Start(ctx, "FeeService.Quote")
+	span.SetAttributes(attribute.String("operation", string(operation)), attribute.Int("amount", amount))
+	defer func() { endSpan(span, err) }()
+
+	if !operation.Valid() {
+		return quote, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidFeeOperation, operation),
+			Details{"operation": operation},
+		)
+	}
+	if amount <= 0 {
+		return quote, ErrInvalidAmount
+	}
+
+	return s.storage.Quote(ctx, operation, serviceID, amount)
+}
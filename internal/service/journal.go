@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var journalChainValid = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "billing",
+	Name:      "journal_chain_valid",
+	Help:      "Whether the last verification of the journal hash chains passed.",
+})
+
+// errChainBroken stops the walk over the chain at the first broken link.
+var errChainBroken = errors.New("journal chain is broken")
+
+type journalStorage interface {
+	WalkChain(ctx context.Context, visit func(link model.ChainLink) error) (model.ChainHead, error)
+}
+
+// JournalService verifies the hash chains of the journal, which detect
+// journal rows changed, added or deleted around the service. The chains are
+// keyed by the key the journal was sealed with.
+type JournalService struct {
+	storage  journalStorage
+	chainKey []byte
+}
+
+func NewJournalService(storage journalStorage, chainKey []byte) JournalService {
+	return JournalService{
+		storage:  storage,
+		chainKey: chainKey,
+	}
+}
+
+// Verify recalculates the hashes of the journal and reports the first link
+// which doesn't match the stored one.
+func (s JournalService) Verify(ctx context.Context) (report model.ChainReport, err error) {
+	ctx, span := tracer.Start(ctx, "JournalService.Verify")
+	defer func() { endSpan(span, err) }()
+
+	var head string
+	userHeads := make(map[int]string)
+
+	broken := func(chain string, link model.ChainLink, reason string) error {
+		entry := link.Entry
+		report.Broken = &model.ChainBreak{
+			Chain:         chain,
+			Seq:           link.Seq,
+			TransactionID: &entry.ID,
+			Reason:        reason,
+		}
+		if chain == model.ChainUser {
+			report.Broken.UserID = &entry.UserID
+		}
+
+		return errChainBroken
+	}
+
+	chainHead, err := s.storage.WalkChain(ctx, func(link model.ChainLink) error {
+		switch {
+		case link.Seq == 0:
+			return broken(model.ChainGlobal, link, "entry is not chained")
+		case link.Seq != report.Entries+1:
+			return broken(model.ChainGlobal, link, fmt.Sprintf("entries %d to %d are missing", report.Entries+1, link.Seq-1))
+		case link.Entry.ChainHash(s.chainKey, head) != link.GlobalHash:
+			return broken(model.ChainGlobal, link, "hash doesn't match the entry")
+		case link.Entry.ChainHash(s.chainKey, userHeads[link.Entry.UserID]) != link.UserHash:
+			return broken(model.ChainUser, link, "hash doesn't match the entry")
+		}
+
+		report.Entries++
+		head = link.GlobalHash
+		userHeads[link.Entry.UserID] = link.UserHash
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errChainBroken) {
+		return report, err
+	}
+
+	// entries deleted from the end of the journal leave the head behind
+	if report.Broken == nil && (chainHead.Seq != report.Entries || chainHead.Hash != head) {
+		report.Broken = &model.ChainBreak{
+			Chain:  model.ChainGlobal,
+			Seq:    chainHead.Seq,
+			Reason: "head doesn't match the last entry",
+		}
+	}
+
+	report.Head = head
+	report.Valid = report.Broken == nil
+	span.SetAttributes(attribute.Bool("journal.valid", report.Valid), attribute.Int64("journal.entries", report.Entries))
+
+	if report.Valid {
+		journalChainValid.Set(1)
+	} else {
+		journalChainValid.Set(0)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+)
+
+type fakeJournalStorage struct {
+	links []model.ChainLink
+	head  model.ChainHead
+}
+
+func (s fakeJournalStorage) WalkChain(
+	_ context.Context,
+	visit func(link model.ChainLink) error,
+) (model.ChainHead, error) {
+	for _, link := range s.links {
+		if err := visit(link); err != nil {
+			return s.head, err
+		}
+	}
+
+	return s.head, nil
+}
+
+// sealedJournal chains n entries of two users the way the storage seals
+// them.
+func sealedJournal(key []byte, n int) fakeJournalStorage {
+	var storage fakeJournalStorage
+	userHeads := make(map[int]string)
+	created := time.Date(2022, 11, 20, 12, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= n; i++ {
+		entry := model.Transaction{
+			ID:      i,
+			UserID:  i%2 + 1,
+			Amount:  100 * i,
+			Type:    model.TransactionTopUp,
+			Message: "top-up",
+			Created: created.Add(time.Duration(i) * time.Second),
+		}
+
+		storage.head.Seq++
+		storage.head.Hash = entry.ChainHash(key, storage.head.Hash)
+		userHeads[entry.UserID] = entry.ChainHash(key, userHeads[entry.UserID])
+
+		storage.links = append(storage.links, model.ChainLink{
+			Seq:        storage.head.Seq,
+			UserHash:   userHeads[entry.UserID],
+			GlobalHash: storage.head.Hash,
+			Entry:      entry,
+		})
+	}
+
+	return storage
+}
+
+func TestJournalServiceVerify(t *testing.T) {
+	key := []byte("key")
+
+	tests := []struct {
+		name    string
+		key     []byte
+		tamper  func(s *fakeJournalStorage)
+		entries int64
+		broken  *model.ChainBreak
+	}{
+		{
+			name:    "valid",
+			key:     key,
+			tamper:  func(s *fakeJournalStorage) {},
+			entries: 5,
+		},
+		{
+			name:    "empty",
+			key:     key,
+			tamper:  func(s *fakeJournalStorage) { *s = fakeJournalStorage{} },
+			entries: 0,
+		},
+		{
+			name:    "edited amount",
+			key:     key,
+			tamper:  func(s *fakeJournalStorage) { s.links[2].Entry.Amount = 1 },
+			entries: 2,
+			broken:  &model.ChainBreak{Chain: model.ChainGlobal, Seq: 3, Reason: "hash doesn't match the entry"},
+		},
+		{
+			name: "edited row with a recalculated global hash",
+			key:  key,
+			tamper: func(s *fakeJournalStorage) {
+				link := &s.links[2]
+				link.Entry.Amount = 1
+				link.GlobalHash = link.Entry.ChainHash(key, s.links[1].GlobalHash)
+			},
+			entries: 2,
+			broken:  &model.ChainBreak{Chain: model.ChainUser, Seq: 3, Reason: "hash doesn't match the entry"},
+		},
+		{
+			name: "edited row rehashed without the key",
+			key:  key,
+			tamper: func(s *fakeJournalStorage) {
+				link := &s.links[2]
+				link.Entry.Amount = 1
+				link.GlobalHash = link.Entry.ChainHash(nil, s.links[1].GlobalHash)
+				link.UserHash = link.Entry.ChainHash(nil, s.links[0].UserHash)
+			},
+			entries: 2,
+			broken:  &model.ChainBreak{Chain: model.ChainGlobal, Seq: 3, Reason: "hash doesn't match the entry"},
+		},
+		{
+			name:    "another key",
+			key:     []byte("other"),
+			tamper:  func(s *fakeJournalStorage) {},
+			entries: 0,
+			broken:  &model.ChainBreak{Chain: model.ChainGlobal, Seq: 1, Reason: "hash doesn't match the entry"},
+		},
+		{
+			name:    "deleted entry",
+			key:     key,
+			tamper:  func(s *fakeJournalStorage) { s.links = append(s.links[:2], s.links[3:]...) },
+			entries: 2,
+			broken:  &model.ChainBreak{Chain: model.ChainGlobal, Seq: 4, Reason: "entries 3 to 3 are missing"},
+		},
+		{
+			name: "entry which isn't chained",
+			key:  key,
+			tamper: func(s *fakeJournalStorage) {
+				s.links = append([]model.ChainLink{{Entry: model.Transaction{ID: 6, UserID: 1}}}, s.links...)
+			},
+			entries: 0,
+			broken:  &model.ChainBreak{Chain: model.ChainGlobal, Reason: "entry is not chained"},
+		},
+		{
+			name:    "deleted last entry",
+			key:     key,
+			tamper:  func(s *fakeJournalStorage) { s.links = s.links[:4] },
+			entries: 4,
+			broken:  &model.ChainBreak{Chain: model.ChainGlobal, Seq: 5, Reason: "head doesn't match the last entry"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := sealedJournal(key, 5)
+			tt.tamper(&storage)
+
+			report, err := NewJournalService(storage, tt.key).Verify(context.Background())
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			if report.Entries != tt.entries {
+				t.Errorf("Verify() entries = %d, want %d", report.Entries, tt.entries)
+			}
+			if report.Valid != (tt.broken == nil) {
+				t.Errorf("Verify() valid = %t, want %t", report.Valid, tt.broken == nil)
+			}
+			if tt.broken == nil {
+				if report.Broken != nil {
+					t.Errorf("Verify() broken = %+v, want nil", report.Broken)
+				}
+				return
+			}
+			if report.Broken == nil {
+				t.Fatalf("Verify() broken = nil, want %+v", tt.broken)
+			}
+
+			got := *report.Broken
+			if got.Chain != tt.broken.Chain || got.Seq != tt.broken.Seq || got.Reason != tt.broken.Reason {
+				t.Errorf(
+					"Verify() broken = {%s %d %s}, want {%s %d %s}",
+					got.Chain, got.Seq, got.Reason,
+					tt.broken.Chain, tt.broken.Seq, tt.broken.Reason,
+				)
+			}
+		})
+	}
+}
+
+func BenchmarkJournalServiceVerify(b *testing.B) {
+	key := []byte("2f4d9a61c07e4b3f8e15a9d2c6b80f37")
+	journalService := NewJournalService(sealedJournal(key, 10000), key)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := journalService.Verify(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrLimitExceeded         = errors.New("operation exceeds the limit")
+	ErrInvalidLimitOperation = errors.New("operation must be 'topup', 'transfer' or 'reserve'")
+	ErrInvalidLimitWindow    = errors.New("window must be positive")
+	ErrInvalidLimitRule      = errors.New("rule must cap a positive amount or number of operations")
+	ErrLimitRuleNotFound     = errors.New("limit rule not found")
+)
+
+type limitStorage interface {
+	Create(ctx context.Context, rule model.LimitRule) (model.LimitRule, error)
+	Update(ctx context.Context, rule model.LimitRule) (model.LimitRule, error)
+	Delete(ctx context.Context, id int) (model.LimitRule, error)
+	Get(ctx context.Context, id int) (model.LimitRule, error)
+	List(ctx context.Context) ([]model.LimitRule, error)
+}
+
+// LimitService manages the limit rules. The rules themselves are evaluated by
+// the storage in the same transaction as the operation they limit.
+type LimitService struct {
+	storage limitStorage
+}
+
+func NewLimitService(storage limitStorage) LimitService {
+	return LimitService{
+		storage: storage,
+	}
+}
+
+func validateLimitRule(rule model.LimitRule) error {
+	if !rule.Operation.Valid() {
+		return WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidLimitOperation, rule.Operation),
+			Details{"operation": rule.Operation},
+		)
+	}
+	if rule.WindowSeconds <= 0 {
+		return WithDetails(
+			fmt.Errorf("%w: %d", ErrInvalidLimitWindow, rule.WindowSeconds),
+			Details{"window_seconds": rule.WindowSeconds},
+		)
+	}
+	if rule.MaxAmount == nil && rule.MaxCount == nil ||
+		rule.MaxAmount != nil && *rule.MaxAmount <= 0 ||
+		rule.MaxCount != nil && *rule.MaxCount <= 0 {
+		return ErrInvalidLimitRule
+	}
+	if rule.Segment != nil && *rule.Segment == "" {
+		return ErrMissedSegment
+	}
+
+	return nil
+}
+
+func (s LimitService) Create(ctx context.Context, rule model.LimitRule) (created model.LimitRule, err error) {
+	ctx, span := tracer.Start(ctx, "LimitService.Create")
+	span.SetAttributes(attribute.String("operation", string(rule.Operation)))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateLimitRule(rule); err != nil {
+		return created, err
+	}
+
+	return s.storage.Create(ctx, rule)
+}
+
+func (s LimitService) Update(ctx context.Context, rule model.LimitRule) (updated model.LimitRule, err error) {
+	ctx, span := tracer.Start(ctx, "LimitService.Update")
+	span.SetAttributes(attribute.Int("rule.id", rule.ID), attribute.String("operation", string(rule.Operation)))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateLimitRule(rule); err != nil {
+		return updated, err
+	}
+
+	return s.storage.Update(ctx, rule)
+}
+
+func (s LimitService) Delete(ctx context.Context, id int) (rule model.LimitRule, err error) {
+	ctx, span := tracer.Start(ctx, "LimitService.Delete")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Delete(ctx, id)
+}
+
+func (s LimitService) Get(ctx context.Context, id int) (rule model.LimitRule, err error) {
+	ctx, span := tracer.Start(ctx, "LimitService.Get")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s LimitService) List(ctx context.Context) (rules []model.LimitRule, err error) {
+	ctx, span := tracer.Start(ctx, "LimitService.List")
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.List(ctx)
+}
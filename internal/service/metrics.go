@@ -0,0 +1,32 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	operations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "billing",
+		Name:      "operations_total",
+		Help:      "Number of successful business operations.",
+	}, []string{"operation"})
+
+	amountMoved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "billing",
+		Name:      "amount_moved_total",
+		Help:      "Total amount of money moved by business operations.",
+	}, []string{"operation"})
+
+	reportDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "billing",
+		Name:      "report_generation_duration_seconds",
+		Help:      "Monthly report generation latency.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+func observeOperation(operation string, amount int) {
+	operations.WithLabelValues(operation).Inc()
+	amountMoved.WithLabelValues(operation).Add(float64(amount))
+}
@@ -1,11 +1,16 @@
 package service
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -15,58 +20,151 @@ var (
 	ErrRecordNotFound  = errors.New("record not found")
 )
 
+// ReportsDir is the directory monthly reports are written to.
+const ReportsDir = "/reports"
+
 type orderStorage interface {
-	Reserve(orderID, userID, serviceID int, cost int) (err error)
-	Confirm(orderID, userID, serviceID int, cost int) (err error)
-	Reject(orderID, userID, serviceID int, cost int) (err error)
-	Report(year int, month time.Month) (services [][]string, err error)
+	Reserve(ctx context.Context, orderID, userID, serviceID int, cost int) (err error)
+	Confirm(ctx context.Context, orderID, userID, serviceID int, cost int) (err error)
+	Reject(ctx context.Context, orderID, userID, serviceID int, cost int) (err error)
+	Report(ctx context.Context, year int, month time.Month) (services [][]string, err error)
 }
 
 type OrderService struct {
-	storage orderStorage
+	storage  orderStorage
+	accounts accountStorage
+	risk     riskChecker
+	catalog  catalogChecker
 }
 
-func NewOrderService(storage orderStorage) OrderService {
+func NewOrderService(
+	storage orderStorage,
+	accounts accountStorage,
+	risk riskChecker,
+	catalog catalogChecker,
+) OrderService {
 	return OrderService{
-		storage: storage,
+		storage:  storage,
+		accounts: accounts,
+		risk:     risk,
+		catalog:  catalog,
 	}
 }
 
-func (s OrderService) Reserve(orderID, userID, serviceID int, cost int) error {
+func startOrderSpan(ctx context.Context, name string, orderID, userID, serviceID int, cost int) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, name)
+	span.SetAttributes(
+		attribute.Int("order.id", orderID),
+		attribute.Int("user.id", userID),
+		attribute.Int("service.id", serviceID),
+		attribute.Int("cost", cost),
+	)
+
+	return ctx, span
+}
+
+func (s OrderService) Reserve(ctx context.Context, orderID, userID, serviceID int, cost int) (err error) {
+	ctx, span := startOrderSpan(ctx, "OrderService.Reserve", orderID, userID, serviceID, cost)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return err
+	}
+
 	if cost < 0 {
 		return ErrInvalidCost
 	}
 
-	return s.storage.Reserve(orderID, userID, serviceID, cost)
+	if err = s.catalog.CheckPrice(ctx, serviceID, cost); err != nil {
+		return err
+	}
+
+	if err = checkCanSend(ctx, s.accounts, userID); err != nil {
+		return err
+	}
+
+	err = s.risk.Check(ctx, model.RiskCheck{
+		Operation: model.RiskReserve,
+		UserID:    userID,
+		OrderID:   &orderID,
+		ServiceID: &serviceID,
+		Amount:    cost,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = s.storage.Reserve(ctx, orderID, userID, serviceID, cost); err != nil {
+		return err
+	}
+
+	observeOperation("reserve", cost)
+
+	return nil
 }
 
-func (s OrderService) Confirm(orderID, userID, serviceID int, cost int) error {
+func (s OrderService) Confirm(ctx context.Context, orderID, userID, serviceID int, cost int) (err error) {
+	ctx, span := startOrderSpan(ctx, "OrderService.Confirm", orderID, userID, serviceID, cost)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return err
+	}
+
 	if cost < 0 {
 		return ErrInvalidCost
 	}
 
-	return s.storage.Confirm(orderID, userID, serviceID, cost)
+	if err = s.storage.Confirm(ctx, orderID, userID, serviceID, cost); err != nil {
+		return err
+	}
+
+	observeOperation("confirm", cost)
+
+	return nil
 }
 
-func (s OrderService) Reject(orderID, userID, serviceID int, cost int) error {
+func (s OrderService) Reject(ctx context.Context, orderID, userID, serviceID int, cost int) (err error) {
+	ctx, span := startOrderSpan(ctx, "OrderService.Reject", orderID, userID, serviceID, cost)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return err
+	}
+
 	if cost < 0 {
 		return ErrInvalidCost
 	}
 
-	return s.storage.Reject(orderID, userID, serviceID, cost)
+	if err = s.storage.Reject(ctx, orderID, userID, serviceID, cost); err != nil {
+		return err
+	}
+
+	observeOperation("reject", cost)
+
+	return nil
 }
 
-func (s OrderService) Report(year, month int) (string, error) {
+func (s OrderService) Report(ctx context.Context, year, month int) (path string, err error) {
+	ctx, span := tracer.Start(ctx, "OrderService.Report")
+	span.SetAttributes(attribute.Int("year", year), attribute.Int("month", month))
+	defer func() { endSpan(span, err) }()
+
 	if month < 1 || month > 12 {
 		return "", ErrInvalidMonth
 	}
 
-	services, err := s.storage.Report(year, time.Month(month))
+	start := time.Now()
+	defer func() {
+		reportDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	services, err := s.storage.Report(ctx, year, time.Month(month))
 	if err != nil {
 		return "", err
 	}
 
-	reportPath := fmt.Sprintf("/reports/%d-%d.csv", year, month)
+	reportPath := fmt.Sprintf("%s/%d-%d.csv", ReportsDir, year, month)
 	report, err := os.Create(reportPath)
 	if err != nil {
 		println(err.Error())
@@ -76,7 +174,17 @@ func (s OrderService) Report(year, month int) (string, error) {
 	csvWriter := csv.NewWriter(report)
 	csvWriter.Comma = ';'
 
-	if err = csvWriter.Write([]string{"service", "total revenue"}); err != nil {
+	// columns are only ever appended, so readers of the earlier reports
+	// keep working
+	if err = csvWriter.Write([]string{
+		"service",
+		"total revenue",
+		"platform fee",
+		"subscription revenue",
+		"disputed",
+		"chargebacks",
+		"name",
+	}); err != nil {
 		return "", ErrInternalServerError
 	}
 	if err = csvWriter.WriteAll(services); err != nil {
@@ -85,3 +193,17 @@ func (s OrderService) Report(year, month int) (string, error) {
 
 	return reportPath, nil
 }
+
+// CheckReportStorage makes sure reports can be written.
+func (s OrderService) CheckReportStorage(_ context.Context) error {
+	file, err := os.CreateTemp(ReportsDir, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var publishedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "billing",
+	Name:      "outbox_published_events_total",
+	Help:      "Number of outbox events published by type.",
+}, []string{"type"})
+
+type outboxStorage interface {
+	Unpublished(ctx context.Context, limit int) ([]model.Event, error)
+	MarkPublished(ctx context.Context, ids []int) error
+}
+
+type eventPublisher interface {
+	Send(ctx context.Context, key string, payload any) error
+}
+
+// OutboxService relays the events of the outbox to the publisher. An event
+// is marked as published only after the publisher has accepted it, so it may
+// be delivered more than once; the id of the event is the idempotency key.
+type OutboxService struct {
+	storage   outboxStorage
+	publisher eventPublisher
+	batchSize int
+}
+
+func NewOutboxService(storage outboxStorage, publisher eventPublisher, batchSize int) OutboxService {
+	return OutboxService{
+		storage:   storage,
+		publisher: publisher,
+		batchSize: batchSize,
+	}
+}
+
+// Relay publishes a batch of the unpublished events in the order they were
+// written. It stops at the first event the publisher rejects, so the events
+// are retried in order on the next run.
+func (s OutboxService) Relay(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "OutboxService.Relay")
+	defer func() { endSpan(span, err) }()
+
+	events, err := s.storage.Unpublished(ctx, s.batchSize)
+	if err != nil {
+		return err
+	}
+
+	published := make([]int, 0, len(events))
+	defer func() {
+		span.SetAttributes(attribute.Int("published", len(published)))
+	}()
+
+	for _, event := range events {
+		if err = s.publisher.Send(ctx, strconv.Itoa(event.ID), event); err != nil {
+			break
+		}
+
+		published = append(published, event.ID)
+		publishedEvents.WithLabelValues(event.Type).Inc()
+	}
+
+	if len(published) > 0 {
+		if markErr := s.storage.MarkPublished(ctx, published); markErr != nil {
+			return markErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidTimeout          = errors.New("timeout is out of range")
+	ErrInvalidTransferStatus   = errors.New("status must be 'held', 'captured', 'voided' or 'expired'")
+	ErrPendingTransferNotFound = errors.New("pending transfer not found")
+	ErrPendingTransferNotHeld  = errors.New("pending transfer is not held")
+	ErrNotTransferSender       = errors.New("only the sender can capture the transfer")
+	ErrNotTransferReceiver     = errors.New("only the receiver can void the transfer")
+)
+
+type pendingTransferStorage interface {
+	Hold(ctx context.Context, transfer model.PendingTransfer, timeoutSeconds int) (model.PendingTransfer, error)
+	Get(ctx context.Context, id int) (model.PendingTransfer, error)
+	List(ctx context.Context, userID int, status *model.PendingTransferStatus) ([]model.PendingTransfer, error)
+	Capture(ctx context.Context, id int) (model.PendingTransfer, error)
+	Void(ctx context.Context, id int) (model.PendingTransfer, error)
+	Expire(ctx context.Context, limit int) (int, error)
+}
+
+// PendingTransferService manages two-phase transfers between users: the
+// money is held from the sender and later captured to the receiver or
+// returned to the sender.
+type PendingTransferService struct {
+	storage        pendingTransferStorage
+	accounts       accountStorage
+	risk           riskChecker
+	defaultTimeout time.Duration
+	maxTimeout     time.Duration
+	batchSize      int
+}
+
+func NewPendingTransferService(
+	storage pendingTransferStorage,
+	accounts accountStorage,
+	risk riskChecker,
+	defaultTimeout time.Duration,
+	maxTimeout time.Duration,
+	batchSize int,
+) PendingTransferService {
+	return PendingTransferService{
+		storage:        storage,
+		accounts:       accounts,
+		risk:           risk,
+		defaultTimeout: defaultTimeout,
+		maxTimeout:     maxTimeout,
+		batchSize:      batchSize,
+	}
+}
+
+// Hold holds the amount and the transfer fee from the sender for the
+// timeout, the default one if there is none.
+func (s PendingTransferService) Hold(
+	ctx context.Context,
+	senderID int,
+	receiverID int,
+	amount int,
+	timeoutSeconds *int,
+	ref model.Reference,
+) (transfer model.PendingTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "PendingTransferService.Hold")
+	span.SetAttributes(
+		attribute.Int("user.id", senderID),
+		attribute.Int("receiver.id", receiverID),
+		attribute.Int("amount", amount),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(senderID, receiverID); err != nil {
+		return transfer, err
+	}
+
+	if senderID == receiverID {
+		return transfer, ErrInvalidTransfer
+	}
+	if amount <= 0 {
+		return transfer, ErrInvalidAmount
+	}
+
+	timeout := int(s.defaultTimeout.Seconds())
+	if timeoutSeconds != nil {
+		timeout = *timeoutSeconds
+	}
+	if maxTimeout := int(s.maxTimeout.Seconds()); timeout < 1 || timeout > maxTimeout {
+		return transfer, WithDetails(
+			fmt.Errorf("%w: %d", ErrInvalidTimeout, timeout),
+			Details{"timeout_seconds": timeout, "max_timeout_seconds": maxTimeout},
+		)
+	}
+
+	if err = checkCanSend(ctx, s.accounts, senderID); err != nil {
+		return transfer, err
+	}
+	if err = checkCanReceive(ctx, s.accounts, receiverID); err != nil {
+		return transfer, err
+	}
+
+	err = s.risk.Check(ctx, model.RiskCheck{
+		Operation:      model.RiskHold,
+		UserID:         senderID,
+		CounterpartyID: &receiverID,
+		Amount:         amount,
+		TimeoutSeconds: &timeout,
+		ExternalRef:    ref.ExternalRef,
+		Metadata:       ref.Metadata,
+	})
+	if err != nil {
+		return transfer, err
+	}
+
+	transfer, err = s.storage.Hold(ctx, model.PendingTransfer{
+		SenderID:    senderID,
+		ReceiverID:  receiverID,
+		Amount:      amount,
+		ExternalRef: ref.ExternalRef,
+		Metadata:    ref.Metadata,
+	}, timeout)
+	if err != nil {
+		return transfer, err
+	}
+
+	observeOperation("hold", amount)
+
+	return transfer, nil
+}
+
+// Get returns the transfer if the user is a party to it. Other users can't
+// tell the transfer exists.
+func (s PendingTransferService) Get(ctx context.Context, userID, id int) (transfer model.PendingTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "PendingTransferService.Get")
+	span.SetAttributes(attribute.Int("user.id", userID), attribute.Int("transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return transfer, err
+	}
+
+	return s.get(ctx, userID, id)
+}
+
+func (s PendingTransferService) get(ctx context.Context, userID, id int) (model.PendingTransfer, error) {
+	transfer, err := s.storage.Get(ctx, id)
+	if err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if !transfer.Party(userID) {
+		return model.PendingTransfer{}, WithDetails(
+			fmt.Errorf("%w: %d", ErrPendingTransferNotFound, id),
+			Details{"transfer_id": id},
+		)
+	}
+
+	return transfer, nil
+}
+
+// List returns the transfers the user sent or receives.
+func (s PendingTransferService) List(
+	ctx context.Context,
+	userID int,
+	status *model.PendingTransferStatus,
+) (transfers []model.PendingTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "PendingTransferService.List")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return nil, err
+	}
+
+	if status != nil && !status.Valid() {
+		return nil, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidTransferStatus, *status),
+			Details{"status": *status},
+		)
+	}
+
+	if _, err = s.accounts.GetAccount(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.List(ctx, userID, status)
+}
+
+// Capture moves the held amount to the receiver on behalf of the sender.
+func (s PendingTransferService) Capture(ctx context.Context, userID, id int) (transfer model.PendingTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "PendingTransferService.Capture")
+	span.SetAttributes(attribute.Int("user.id", userID), attribute.Int("transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return transfer, err
+	}
+
+	if transfer, err = s.get(ctx, userID, id); err != nil {
+		return transfer, err
+	}
+	if transfer.SenderID != userID {
+		return model.PendingTransfer{}, WithDetails(
+			fmt.Errorf("%w: %d", ErrNotTransferSender, id),
+			Details{"transfer_id": id, "sender_id": transfer.SenderID},
+		)
+	}
+
+	if err = checkCanReceive(ctx, s.accounts, transfer.ReceiverID); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if transfer, err = s.storage.Capture(ctx, id); err != nil {
+		return transfer, err
+	}
+
+	observeOperation("capture", transfer.Amount)
+
+	return transfer, nil
+}
+
+// Void returns the held money to the sender on behalf of the receiver, who
+// declines the transfer. The sender can't take the money back once it is
+// held; it returns to them when the transfer expires.
+func (s PendingTransferService) Void(ctx context.Context, userID, id int) (transfer model.PendingTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "PendingTransferService.Void")
+	span.SetAttributes(attribute.Int("user.id", userID), attribute.Int("transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return transfer, err
+	}
+
+	if transfer, err = s.get(ctx, userID, id); err != nil {
+		return transfer, err
+	}
+	if transfer.ReceiverID != userID {
+		return model.PendingTransfer{}, WithDetails(
+			fmt.Errorf("%w: %d", ErrNotTransferReceiver, id),
+			Details{"transfer_id": id, "receiver_id": transfer.ReceiverID},
+		)
+	}
+
+	if transfer, err = s.storage.Void(ctx, id); err != nil {
+		return transfer, err
+	}
+
+	observeOperation("void", transfer.Amount)
+
+	return transfer, nil
+}
+
+// ExpireDue returns the money of a batch of expired transfers to the
+// senders.
+func (s PendingTransferService) ExpireDue(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "PendingTransferService.ExpireDue")
+	defer func() { endSpan(span, err) }()
+
+	expired, err := s.storage.Expire(ctx, s.batchSize)
+	if err != nil {
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("expired", expired))
+
+	return nil
+}
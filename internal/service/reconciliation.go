@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var ErrReconciliationNotFound = errors.New("reconciliation not found")
+
+var (
+	reconciliationMismatched = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "billing",
+		Name:      "reconciliation_mismatched_users",
+		Help:      "Number of users whose balance didn't match the journal in the last reconciliation.",
+	})
+
+	reconciliationDrift = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "billing",
+		Name:      "reconciliation_drift",
+		Help:      "Sum of the absolute differences between balances and the journal in the last reconciliation.",
+	})
+)
+
+const (
+	// reconciliationOperator is the requester of scheduled reconciliations.
+	reconciliationOperator = "system"
+	// defaultReconciliationsLimit is the number of runs listed by default.
+	defaultReconciliationsLimit = 10
+)
+
+type reconciliationStorage interface {
+	Start(ctx context.Context, requestedBy string, repair bool) (model.Reconciliation, error)
+	Discrepancies(ctx context.Context) (users int, discrepancies []model.Discrepancy, err error)
+	Repair(ctx context.Context, runID, userID int) (model.Discrepancy, error)
+	Finish(ctx context.Context, run model.Reconciliation) (model.Reconciliation, error)
+	Get(ctx context.Context, id int) (model.Reconciliation, error)
+	List(ctx context.Context, limit int) ([]model.Reconciliation, error)
+}
+
+// ReconciliationService compares the balances of users with the journal.
+type ReconciliationService struct {
+	storage    reconciliationStorage
+	autoRepair bool
+}
+
+// NewReconciliationService returns a service whose scheduled runs repair the
+// discrepancies they find if autoRepair is set.
+func NewReconciliationService(storage reconciliationStorage, autoRepair bool) ReconciliationService {
+	return ReconciliationService{
+		storage:    storage,
+		autoRepair: autoRepair,
+	}
+}
+
+// Run reconciles the balances of all users with the journal on behalf of
+// operator. Discrepancies are repaired if repair is set; a failed repair is
+// recorded without a transaction and the first error is returned with the
+// run.
+func (s ReconciliationService) Run(
+	ctx context.Context,
+	operator string,
+	repair bool,
+) (run model.Reconciliation, err error) {
+	ctx, span := tracer.Start(ctx, "ReconciliationService.Run")
+	span.SetAttributes(attribute.String("operator", operator), attribute.Bool("repair", repair))
+	defer func() { endSpan(span, err) }()
+
+	if run, err = s.storage.Start(ctx, operator, repair); err != nil {
+		return run, err
+	}
+
+	users, discrepancies, err := s.storage.Discrepancies(ctx)
+	if err != nil {
+		return run, err
+	}
+
+	var repairErr error
+	if repair {
+		for i, discrepancy := range discrepancies {
+			repaired, err := s.storage.Repair(ctx, run.ID, discrepancy.UserID)
+			if err != nil {
+				if repairErr == nil {
+					repairErr = err
+				}
+				continue
+			}
+
+			discrepancies[i] = repaired
+		}
+	}
+
+	run.Users = users
+	run.Mismatched = len(discrepancies)
+	run.Drift = 0
+	for _, discrepancy := range discrepancies {
+		if discrepancy.Drift < 0 {
+			run.Drift -= discrepancy.Drift
+		} else {
+			run.Drift += discrepancy.Drift
+		}
+	}
+	run.Discrepancies = discrepancies
+
+	if run, err = s.storage.Finish(ctx, run); err != nil {
+		return run, err
+	}
+
+	reconciliationMismatched.Set(float64(run.Mismatched))
+	reconciliationDrift.Set(float64(run.Drift))
+	span.SetAttributes(attribute.Int("reconciliation.mismatched", run.Mismatched))
+
+	return run, repairErr
+}
+
+// RunScheduled is the job of the reconciliation scheduler.
+func (s ReconciliationService) RunScheduled(ctx context.Context) error {
+	_, err := s.Run(ctx, reconciliationOperator, s.autoRepair)
+	return err
+}
+
+func (s ReconciliationService) Get(ctx context.Context, id int) (run model.Reconciliation, err error) {
+	ctx, span := tracer.Start(ctx, "ReconciliationService.Get")
+	span.SetAttributes(attribute.Int("reconciliation.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+// List returns the latest runs, defaultReconciliationsLimit by default.
+func (s ReconciliationService) List(ctx context.Context, limit *int) (runs []model.Reconciliation, err error) {
+	ctx, span := tracer.Start(ctx, "ReconciliationService.List")
+	defer func() { endSpan(span, err) }()
+
+	n := defaultReconciliationsLimit
+	if limit != nil {
+		if *limit < 1 || *limit > maxTransactionsLimit {
+			return nil, WithDetails(
+				fmt.Errorf("%w: %d", ErrInvalidLimit, *limit),
+				Details{"limit": *limit},
+			)
+		}
+		n = *limit
+	}
+
+	return s.storage.List(ctx, n)
+}
@@ -0,0 +1,497 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrRiskDenied          = errors.New("operation denied by risk rules")
+	ErrRiskReview          = errors.New("operation is held for review")
+	ErrInvalidRiskRule     = errors.New("invalid risk rule")
+	ErrRiskRuleNotFound    = errors.New("risk rule not found")
+	ErrReviewNotFound      = errors.New("review not found")
+	ErrReviewNotPending    = errors.New("review has already been decided")
+	ErrInvalidReviewStatus = errors.New("status must be 'pending', 'executing', 'approved', 'rejected' or 'failed'")
+	ErrNotBlocked          = errors.New("user is not on the blocklist")
+)
+
+var riskDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "billing",
+	Name:      "risk_decisions_total",
+	Help:      "Number of operations risk rules matched, by outcome.",
+}, []string{"operation", "outcome"})
+
+const (
+	// maxConditionDepth limits the nesting of risk conditions.
+	maxConditionDepth = 8
+	// defaultDecisionsLimit is the number of decisions listed by default.
+	defaultDecisionsLimit = 50
+)
+
+// riskChecker is the risk stage money movements pass before they are made.
+// It returns ErrRiskDenied or ErrRiskReview for operations that must not be
+// made right away.
+type riskChecker interface {
+	Check(ctx context.Context, check model.RiskCheck) error
+}
+
+type riskStorage interface {
+	CreateRule(ctx context.Context, rule model.RiskRule) (model.RiskRule, error)
+	UpdateRule(ctx context.Context, rule model.RiskRule) (model.RiskRule, error)
+	DeleteRule(ctx context.Context, id int) (model.RiskRule, error)
+	GetRule(ctx context.Context, id int) (model.RiskRule, error)
+	ListRules(ctx context.Context) ([]model.RiskRule, error)
+	Rules(ctx context.Context, operation model.RiskOperation) ([]model.RiskRule, error)
+	Facts(ctx context.Context, check model.RiskCheck, facts []model.RiskFact) (map[string]int, error)
+	RecordDecision(ctx context.Context, decision model.RiskDecision) (model.RiskDecision, error)
+	Decisions(ctx context.Context, userID *int, limit int) ([]model.RiskDecision, error)
+	Reviews(ctx context.Context, status model.ReviewStatus) ([]model.RiskReview, error)
+	GetReview(ctx context.Context, id int) (model.RiskReview, error)
+	DecideReview(
+		ctx context.Context,
+		id int,
+		status model.ReviewStatus,
+		reviewer string,
+		comment *string,
+	) (model.RiskReview, error)
+	CompleteReview(ctx context.Context, id int) (model.RiskReview, error)
+	FailReview(ctx context.Context, id int, reason string) (model.RiskReview, error)
+	ReviewExecuted(ctx context.Context, review model.RiskReview) (bool, error)
+	Block(ctx context.Context, blocked model.BlockedUser) (model.BlockedUser, error)
+	Unblock(ctx context.Context, userID int) (model.BlockedUser, error)
+	Blocklist(ctx context.Context) ([]model.BlockedUser, error)
+}
+
+type riskUserStorage interface {
+	GetAccount(ctx context.Context, id int) (account model.Account, err error)
+	TopUpBalance(ctx context.Context, id int, amount int, ref model.Reference) (balance int, err error)
+	Transfer(ctx context.Context, id, receiverID int, amount int, ref model.Reference) (balance int, err error)
+}
+
+// RiskService evaluates the risk rules, keeps the audit trail of decisions
+// and makes the operations operators approve in the review queue.
+type RiskService struct {
+	storage riskStorage
+	users   riskUserStorage
+	orders  orderStorage
+	holds   pendingTransferStorage
+}
+
+func NewRiskService(
+	storage riskStorage,
+	users riskUserStorage,
+	orders orderStorage,
+	holds pendingTransferStorage,
+) RiskService {
+	return RiskService{
+		storage: storage,
+		users:   users,
+		orders:  orders,
+		holds:   holds,
+	}
+}
+
+// Check evaluates the enabled rules of the operation. The most severe outcome
+// of the matched rules wins, and an operation no rule matches is allowed.
+// Decisions are recorded only when some rule matched.
+func (s RiskService) Check(ctx context.Context, check model.RiskCheck) (err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.Check")
+	span.SetAttributes(
+		attribute.String("operation", string(check.Operation)),
+		attribute.Int("user.id", check.UserID),
+		attribute.Int("amount", check.Amount),
+	)
+	defer func() { endSpan(span, err) }()
+
+	rules, err := s.storage.Rules(ctx, check.Operation)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	var needed []model.RiskFact
+	for _, rule := range rules {
+		needed = append(needed, rule.Condition.Facts()...)
+	}
+
+	facts, err := s.storage.Facts(ctx, check, needed)
+	if err != nil {
+		return err
+	}
+
+	outcome := model.OutcomeAllow
+	ruleIDs := make([]int, 0)
+	for _, rule := range rules {
+		if !rule.Condition.Match(facts) {
+			continue
+		}
+
+		ruleIDs = append(ruleIDs, rule.ID)
+		if rule.Outcome.Worse(outcome) {
+			outcome = rule.Outcome
+		}
+	}
+	if len(ruleIDs) == 0 {
+		return nil
+	}
+
+	decision, err := s.storage.RecordDecision(ctx, model.RiskDecision{
+		Check:   check,
+		Outcome: outcome,
+		RuleIDs: ruleIDs,
+		Facts:   facts,
+	})
+	if err != nil {
+		return err
+	}
+
+	riskDecisions.WithLabelValues(string(check.Operation), string(outcome)).Inc()
+	span.SetAttributes(attribute.String("risk.outcome", string(outcome)))
+
+	switch outcome {
+	case model.OutcomeDeny:
+		return WithDetails(
+			fmt.Errorf("%w: decision %d", ErrRiskDenied, decision.ID),
+			Details{"decision_id": decision.ID, "rule_ids": ruleIDs},
+		)
+	case model.OutcomeReview:
+		if check.Unattended {
+			return WithDetails(
+				fmt.Errorf("%w: decision %d", ErrRiskDenied, decision.ID),
+				Details{"decision_id": decision.ID, "rule_ids": ruleIDs},
+			)
+		}
+
+		return WithDetails(
+			fmt.Errorf("%w: review %d", ErrRiskReview, *decision.ReviewID),
+			Details{"decision_id": decision.ID, "review_id": *decision.ReviewID},
+		)
+	}
+
+	return nil
+}
+
+func invalidRiskRule(reason string) error {
+	return WithDetails(
+		fmt.Errorf("%w: %s", ErrInvalidRiskRule, reason),
+		Details{"reason": reason},
+	)
+}
+
+func validateRiskRule(rule model.RiskRule) error {
+	if rule.Name == "" {
+		return invalidRiskRule("name is required")
+	}
+	if rule.Operation != nil && !rule.Operation.Valid() {
+		return invalidRiskRule("operation must be 'topup', 'transfer', 'reserve' or 'hold'")
+	}
+	if !rule.Outcome.Valid() {
+		return invalidRiskRule("outcome must be 'allow', 'review' or 'deny'")
+	}
+
+	return validateRiskCondition(rule.Condition, 1)
+}
+
+// validateRiskCondition makes sure every node of the condition is either one
+// combination or one comparison.
+func validateRiskCondition(condition model.RiskCondition, depth int) error {
+	if depth > maxConditionDepth {
+		return invalidRiskRule(fmt.Sprintf("conditions can't be nested deeper than %d", maxConditionDepth))
+	}
+
+	kinds := 0
+	for _, set := range []bool{
+		condition.All != nil,
+		condition.Any != nil,
+		condition.Not != nil,
+		condition.Field != "",
+	} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return invalidRiskRule("condition must have exactly one of 'all', 'any', 'not' and 'field'")
+	}
+
+	switch {
+	case condition.All != nil || condition.Any != nil:
+		conditions := append(condition.All, condition.Any...)
+		if len(conditions) == 0 {
+			return invalidRiskRule("'all' and 'any' must not be empty")
+		}
+
+		for _, c := range conditions {
+			if err := validateRiskCondition(c, depth+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case condition.Not != nil:
+		return validateRiskCondition(*condition.Not, depth+1)
+	}
+
+	if !condition.Field.Valid() {
+		return invalidRiskRule(fmt.Sprintf("unknown field %q", condition.Field))
+	}
+	if condition.Field.Windowed() != (condition.Window > 0) {
+		return invalidRiskRule("window_seconds must be positive for velocity fields only")
+	}
+	if !condition.Op.Valid() {
+		return invalidRiskRule("op must be 'eq', 'gt', 'gte', 'lt' or 'lte'")
+	}
+
+	return nil
+}
+
+func (s RiskService) CreateRule(ctx context.Context, rule model.RiskRule) (created model.RiskRule, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.CreateRule")
+	span.SetAttributes(attribute.String("rule.name", rule.Name))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateRiskRule(rule); err != nil {
+		return created, err
+	}
+
+	return s.storage.CreateRule(ctx, rule)
+}
+
+func (s RiskService) UpdateRule(ctx context.Context, rule model.RiskRule) (updated model.RiskRule, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.UpdateRule")
+	span.SetAttributes(attribute.Int("rule.id", rule.ID), attribute.String("rule.name", rule.Name))
+	defer func() { endSpan(span, err) }()
+
+	if err = validateRiskRule(rule); err != nil {
+		return updated, err
+	}
+
+	return s.storage.UpdateRule(ctx, rule)
+}
+
+func (s RiskService) DeleteRule(ctx context.Context, id int) (rule model.RiskRule, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.DeleteRule")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.DeleteRule(ctx, id)
+}
+
+func (s RiskService) GetRule(ctx context.Context, id int) (rule model.RiskRule, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.GetRule")
+	span.SetAttributes(attribute.Int("rule.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.GetRule(ctx, id)
+}
+
+func (s RiskService) ListRules(ctx context.Context) (rules []model.RiskRule, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.ListRules")
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.ListRules(ctx)
+}
+
+// Decisions returns the latest decisions, of the user if there is one.
+func (s RiskService) Decisions(ctx context.Context, userID *int, limit *int) (decisions []model.RiskDecision, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.Decisions")
+	defer func() { endSpan(span, err) }()
+
+	n := defaultDecisionsLimit
+	if limit != nil {
+		if *limit < 1 || *limit > maxTransactionsLimit {
+			return nil, WithDetails(
+				fmt.Errorf("%w: %d", ErrInvalidLimit, *limit),
+				Details{"limit": *limit},
+			)
+		}
+
+		n = *limit
+	}
+
+	return s.storage.Decisions(ctx, userID, n)
+}
+
+// Reviews returns the review queue in the status, pending by default.
+func (s RiskService) Reviews(ctx context.Context, status *model.ReviewStatus) (reviews []model.RiskReview, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.Reviews")
+	defer func() { endSpan(span, err) }()
+
+	queue := model.ReviewPending
+	if status != nil {
+		if !status.Valid() {
+			return nil, WithDetails(
+				fmt.Errorf("%w: %s", ErrInvalidReviewStatus, *status),
+				Details{"status": *status},
+			)
+		}
+
+		queue = *status
+	}
+
+	return s.storage.Reviews(ctx, queue)
+}
+
+func (s RiskService) GetReview(ctx context.Context, id int) (review model.RiskReview, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.GetReview")
+	span.SetAttributes(attribute.Int("review.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.GetReview(ctx, id)
+}
+
+// ApproveReview makes the held operation without checking it against the
+// rules again. The review is executing until the operation is made, so an
+// approval interrupted by an internal error is retried by approving the review
+// again; the operation is made only if the interrupted attempt hasn't made
+// it. An operation that can no longer be made, e.g. because the money has gone
+// meanwhile, fails the review with the reason.
+func (s RiskService) ApproveReview(
+	ctx context.Context,
+	id int,
+	reviewer string,
+	comment *string,
+) (review model.RiskReview, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.ApproveReview")
+	span.SetAttributes(attribute.Int("review.id", id), attribute.String("operator", reviewer))
+	defer func() { endSpan(span, err) }()
+
+	executed := false
+	review, err = s.storage.DecideReview(ctx, id, model.ReviewExecuting, reviewer, comment)
+	if err != nil {
+		if !errors.Is(err, ErrReviewNotPending) {
+			return review, err
+		}
+
+		current, getErr := s.storage.GetReview(ctx, id)
+		if getErr != nil {
+			return review, getErr
+		}
+		if current.Status != model.ReviewExecuting {
+			return review, err
+		}
+
+		review = current
+		if executed, err = s.storage.ReviewExecuted(ctx, review); err != nil {
+			return review, err
+		}
+	}
+
+	if !executed {
+		if err = s.execute(ctx, review); err != nil {
+			if errors.Is(err, ErrInternalServerError) {
+				return review, err
+			}
+
+			return s.storage.FailReview(ctx, id, err.Error())
+		}
+	}
+
+	return s.storage.CompleteReview(ctx, id)
+}
+
+func (s RiskService) RejectReview(
+	ctx context.Context,
+	id int,
+	reviewer string,
+	comment *string,
+) (review model.RiskReview, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.RejectReview")
+	span.SetAttributes(attribute.Int("review.id", id), attribute.String("operator", reviewer))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.DecideReview(ctx, id, model.ReviewRejected, reviewer, comment)
+}
+
+// execute makes the operation of the review the way the service it was
+// submitted to would have made it.
+func (s RiskService) execute(ctx context.Context, review model.RiskReview) error {
+	check := review.Check
+
+	metadata := make(map[string]any, len(check.Metadata)+1)
+	for k, v := range check.Metadata {
+		metadata[k] = v
+	}
+	metadata["risk_review_id"] = review.ID
+
+	ref := model.Reference{
+		ExternalRef: check.ExternalRef,
+		Metadata:    metadata,
+	}
+
+	switch check.Operation {
+	case model.RiskTopUp:
+		if _, err := s.users.TopUpBalance(ctx, check.UserID, check.Amount, ref); err != nil {
+			return err
+		}
+	case model.RiskTransfer:
+		if _, err := s.users.Transfer(ctx, check.UserID, *check.CounterpartyID, check.Amount, ref); err != nil {
+			return err
+		}
+	case model.RiskReserve:
+		if err := s.orders.Reserve(ctx, *check.OrderID, check.UserID, *check.ServiceID, check.Amount); err != nil {
+			return err
+		}
+	case model.RiskHold:
+		if _, err := s.holds.Hold(ctx, model.PendingTransfer{
+			SenderID:    check.UserID,
+			ReceiverID:  *check.CounterpartyID,
+			Amount:      check.Amount,
+			ExternalRef: ref.ExternalRef,
+			Metadata:    ref.Metadata,
+		}, *check.TimeoutSeconds); err != nil {
+			return err
+		}
+	}
+
+	observeOperation(string(check.Operation), check.Amount)
+
+	return nil
+}
+
+// Block puts the user on the blocklist on behalf of operator.
+func (s RiskService) Block(
+	ctx context.Context,
+	userID int,
+	reason string,
+	operator string,
+) (blocked model.BlockedUser, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.Block")
+	span.SetAttributes(attribute.Int("user.id", userID), attribute.String("operator", operator))
+	defer func() { endSpan(span, err) }()
+
+	if reason == "" {
+		return blocked, ErrMissedReason
+	}
+
+	if _, err = s.users.GetAccount(ctx, userID); err != nil {
+		return blocked, err
+	}
+
+	return s.storage.Block(ctx, model.BlockedUser{
+		UserID:   userID,
+		Reason:   reason,
+		Operator: operator,
+	})
+}
+
+func (s RiskService) Unblock(ctx context.Context, userID int) (blocked model.BlockedUser, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.Unblock")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Unblock(ctx, userID)
+}
+
+func (s RiskService) Blocklist(ctx context.Context) (blocklist []model.BlockedUser, err error) {
+	ctx, span := tracer.Start(ctx, "RiskService.Blocklist")
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Blocklist(ctx)
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrScheduledTransferNotFound  = errors.New("scheduled transfer not found")
+	ErrScheduledTransferNotActive = errors.New("scheduled transfer is not active")
+)
+
+type scheduledTransferStorage interface {
+	Create(ctx context.Context, transfer model.ScheduledTransfer) (model.ScheduledTransfer, error)
+	Get(ctx context.Context, id int) (model.ScheduledTransfer, error)
+	List(ctx context.Context, userID int) ([]model.ScheduledTransfer, error)
+	Cancel(ctx context.Context, id int) (model.ScheduledTransfer, error)
+	Due(ctx context.Context, limit int) ([]model.ScheduledTransfer, error)
+	StartRun(ctx context.Context, transfer model.ScheduledTransfer) (model.TransferRun, error)
+	FindTransfer(ctx context.Context, userID int, externalRef string) (*int, error)
+	FinishRun(ctx context.Context, transfer model.ScheduledTransfer, run model.TransferRun) error
+}
+
+type transferStorage interface {
+	GetAccount(ctx context.Context, id int) (account model.Account, err error)
+	Transfer(ctx context.Context, id, receiverID int, amount int, ref model.Reference) (balance int, err error)
+}
+
+// ScheduledTransferService manages scheduled transfers and runs the due ones
+// through the same risk stage and storage as the transfers made by the users.
+type ScheduledTransferService struct {
+	storage   scheduledTransferStorage
+	transfers transferStorage
+	risk      riskChecker
+	batchSize int
+}
+
+func NewScheduledTransferService(
+	storage scheduledTransferStorage,
+	transfers transferStorage,
+	risk riskChecker,
+	batchSize int,
+) ScheduledTransferService {
+	return ScheduledTransferService{
+		storage:   storage,
+		transfers: transfers,
+		risk:      risk,
+		batchSize: batchSize,
+	}
+}
+
+// Create schedules a transfer from the user to the receiver at start,
+// repeated every period if there is one.
+func (s ScheduledTransferService) Create(
+	ctx context.Context,
+	userID int,
+	receiverID int,
+	amount int,
+	start time.Time,
+	period *model.Period,
+) (transfer model.ScheduledTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "ScheduledTransferService.Create")
+	span.SetAttributes(
+		attribute.Int("user.id", userID),
+		attribute.Int("receiver.id", receiverID),
+		attribute.Int("amount", amount),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID, receiverID); err != nil {
+		return transfer, err
+	}
+
+	if userID == receiverID {
+		return transfer, ErrInvalidTransfer
+	}
+	if amount <= 0 {
+		return transfer, ErrInvalidAmount
+	}
+	if period != nil && !period.Valid() {
+		return transfer, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidPeriod, *period),
+			Details{"period": *period},
+		)
+	}
+	if start.Before(time.Now()) {
+		return transfer, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidStart, start.Format(time.RFC3339)),
+			Details{"start": start},
+		)
+	}
+
+	if err = checkCanSend(ctx, s.transfers, userID); err != nil {
+		return transfer, err
+	}
+	if err = checkCanReceive(ctx, s.transfers, receiverID); err != nil {
+		return transfer, err
+	}
+
+	return s.storage.Create(ctx, model.ScheduledTransfer{
+		UserID:     userID,
+		ReceiverID: receiverID,
+		Amount:     amount,
+		Period:     period,
+		Status:     model.ScheduledActive,
+		NextRun:    start.UTC(),
+	})
+}
+
+func (s ScheduledTransferService) Get(ctx context.Context, id int) (transfer model.ScheduledTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "ScheduledTransferService.Get")
+	span.SetAttributes(attribute.Int("scheduled_transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s ScheduledTransferService) List(
+	ctx context.Context,
+	userID int,
+) (transfers []model.ScheduledTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "ScheduledTransferService.List")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return nil, err
+	}
+
+	if _, err = s.transfers.GetAccount(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.List(ctx, userID)
+}
+
+func (s ScheduledTransferService) Cancel(ctx context.Context, id int) (transfer model.ScheduledTransfer, err error) {
+	ctx, span := tracer.Start(ctx, "ScheduledTransferService.Cancel")
+	span.SetAttributes(attribute.Int("scheduled_transfer.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Cancel(ctx, id)
+}
+
+// RunDue runs a batch of due transfers. A transfer that can't be made is
+// recorded as a failed run; the first internal error is returned after the
+// rest of the batch has been tried.
+func (s ScheduledTransferService) RunDue(ctx context.Context) error {
+	transfers, err := s.storage.Due(ctx, s.batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, transfer := range transfers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if runErr := s.run(ctx, transfer); runErr != nil && err == nil {
+			err = runErr
+		}
+	}
+
+	return err
+}
+
+func (s ScheduledTransferService) run(ctx context.Context, transfer model.ScheduledTransfer) (err error) {
+	ctx, span := tracer.Start(ctx, "ScheduledTransferService.Run")
+	span.SetAttributes(
+		attribute.Int("scheduled_transfer.id", transfer.ID),
+		attribute.Int("user.id", transfer.UserID),
+		attribute.Int("amount", transfer.Amount),
+	)
+	defer func() { endSpan(span, err) }()
+
+	run, err := s.storage.StartRun(ctx, transfer)
+	if err != nil {
+		return err
+	}
+
+	if run.Status == model.RunPending {
+		if run, err = s.execute(ctx, transfer, run); err != nil {
+			return err
+		}
+	}
+
+	return s.storage.FinishRun(ctx, transfer, run)
+}
+
+// execute makes the transfer of the run unless an interrupted attempt has
+// already made it, and returns the run with its outcome. Only internal
+// errors are returned, so the run is attempted again.
+func (s ScheduledTransferService) execute(
+	ctx context.Context,
+	transfer model.ScheduledTransfer,
+	run model.TransferRun,
+) (model.TransferRun, error) {
+	ref := run.ExternalRef()
+	id, err := s.storage.FindTransfer(ctx, transfer.UserID, ref)
+	if err != nil {
+		return run, err
+	}
+
+	// a concurrent attempt that has booked the transfer meanwhile makes
+	// this one fail with ErrDuplicateReference; its transfer is taken then
+	if id == nil {
+		if err = s.transfer(ctx, transfer, ref); err != nil && !errors.Is(err, ErrDuplicateReference) {
+			if errors.Is(err, ErrInternalServerError) {
+				return run, err
+			}
+
+			reason := err.Error()
+			run.Status = model.RunFailed
+			run.Error = &reason
+
+			return run, nil
+		}
+
+		if id, err = s.storage.FindTransfer(ctx, transfer.UserID, ref); err != nil {
+			return run, err
+		}
+	}
+
+	run.Status = model.RunSucceeded
+	run.TransactionID = id
+	run.Error = nil
+
+	return run, nil
+}
+
+func (s ScheduledTransferService) transfer(ctx context.Context, transfer model.ScheduledTransfer, ref string) error {
+	if err := checkCanSend(ctx, s.transfers, transfer.UserID); err != nil {
+		return err
+	}
+	if err := checkCanReceive(ctx, s.transfers, transfer.ReceiverID); err != nil {
+		return err
+	}
+
+	metadata := map[string]any{"scheduled_transfer_id": transfer.ID}
+	if err := s.risk.Check(ctx, model.RiskCheck{
+		Operation:      model.RiskTransfer,
+		UserID:         transfer.UserID,
+		CounterpartyID: &transfer.ReceiverID,
+		Amount:         transfer.Amount,
+		ExternalRef:    &ref,
+		Metadata:       metadata,
+		Unattended:     true,
+	}); err != nil {
+		return err
+	}
+
+	if _, err := s.transfers.Transfer(ctx, transfer.UserID, transfer.ReceiverID, transfer.Amount, model.Reference{
+		ExternalRef: &ref,
+		Metadata:    metadata,
+	}); err != nil {
+		return err
+	}
+
+	observeOperation("transfer", transfer.Amount)
+
+	return nil
+}
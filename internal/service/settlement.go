@@ -0,0 +1,325 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrMissedProvider          = errors.New("missed payment provider")
+	ErrInvalidSettlementFormat = errors.New("settlement file format must be csv or camt053")
+	ErrInvalidSettlementFile   = errors.New("invalid settlement file")
+	ErrSettlementFileTooLarge  = errors.New("settlement file is too large")
+	ErrSettlementExists        = errors.New("settlement file already imported")
+	ErrSettlementConflict      = errors.New("top-up settled by a concurrent import")
+	ErrSettlementNotFound      = errors.New("settlement not found")
+	ErrInvalidSettlementStatus = errors.New("settlement item status must be matched, unmatched or mismatched")
+)
+
+// defaultSettlementsLimit is the number of settlements listed by default.
+const defaultSettlementsLimit = 10
+
+var settlementItems = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "billing",
+	Name:      "settlement_items_total",
+	Help:      "Number of imported settlement lines by provider and matching status.",
+}, []string{"provider", "status"})
+
+type settlementStorage interface {
+	TopUps(ctx context.Context, refs []string) ([]model.SettledTopUp, error)
+	Create(ctx context.Context, settlement model.Settlement) (model.Settlement, error)
+	Get(ctx context.Context, id int, status *model.SettlementStatus) (model.Settlement, error)
+	List(ctx context.Context, provider *string, limit int) ([]model.Settlement, error)
+}
+
+// SettlementService imports the settlement files of payment providers and
+// matches their lines against the top-ups of the journal.
+type SettlementService struct {
+	storage     settlementStorage
+	maxFileSize int64
+	amountScale int
+}
+
+// NewSettlementService returns a service which accepts files up to
+// maxFileSize bytes. Amounts of the files are converted to the units of the
+// balances, which are 10^-amountScale of the currency.
+func NewSettlementService(storage settlementStorage, maxFileSize int64, amountScale int) SettlementService {
+	return SettlementService{
+		storage:     storage,
+		maxFileSize: maxFileSize,
+		amountScale: amountScale,
+	}
+}
+
+// matchSettlement matches each line against the top-ups with its reference.
+// A top-up is matched by at most one line across all settlements; a line
+// whose top-ups differ in amount, are reversed or are already settled is
+// mismatched with the first of them.
+func matchSettlement(lines []model.SettlementLine, topUps []model.SettledTopUp) []model.SettlementItem {
+	byRef := make(map[string][]model.SettledTopUp)
+	for _, topUp := range topUps {
+		byRef[topUp.ExternalRef] = append(byRef[topUp.ExternalRef], topUp)
+	}
+	used := make(map[int]bool)
+
+	items := make([]model.SettlementItem, 0, len(lines))
+	for _, line := range lines {
+		item := model.SettlementItem{SettlementLine: line}
+
+		var match, candidate *model.SettledTopUp
+		reason := ""
+		for i := range byRef[line.Reference] {
+			topUp := &byRef[line.Reference][i]
+			switch {
+			case used[topUp.ID]:
+				continue
+			case topUp.Reversed:
+				if candidate == nil {
+					candidate, reason = topUp, "top-up is reversed"
+				}
+			case topUp.Settled:
+				if candidate == nil {
+					candidate, reason = topUp, "top-up is already settled"
+				}
+			case topUp.Amount != line.Amount:
+				if candidate == nil || candidate.Reversed || candidate.Settled {
+					candidate, reason = topUp, fmt.Sprintf("amount differs from the top-up by %d", line.Amount-topUp.Amount)
+				}
+			default:
+				match = topUp
+			}
+			if match != nil {
+				break
+			}
+		}
+
+		switch {
+		case match != nil:
+			item.Status = model.SettlementMatched
+			candidate = match
+		case candidate != nil:
+			item.Status = model.SettlementMismatched
+			item.Reason = reason
+		case len(byRef[line.Reference]) > 0:
+			item.Status = model.SettlementMismatched
+			item.Reason = "top-up is matched by another line"
+		default:
+			item.Status = model.SettlementUnmatched
+			item.Reason = "no top-up with the reference"
+		}
+		if candidate != nil {
+			used[candidate.ID] = true
+			item.TransactionID = &candidate.ID
+			item.UserID = &candidate.UserID
+			item.JournalAmount = &candidate.Amount
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// Import reads a settlement file of the provider on behalf of operator and
+// records the result of matching its lines.
+func (s SettlementService) Import(
+	ctx context.Context,
+	provider string,
+	format model.SettlementFormat,
+	file io.Reader,
+	operator string,
+) (settlement model.Settlement, err error) {
+	ctx, span := tracer.Start(ctx, "SettlementService.Import")
+	span.SetAttributes(attribute.String("provider", provider), attribute.String("format", string(format)))
+	defer func() { endSpan(span, err) }()
+
+	if provider == "" {
+		return settlement, ErrMissedProvider
+	}
+	if !format.Valid() {
+		return settlement, ErrInvalidSettlementFormat
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, s.maxFileSize+1))
+	if err != nil {
+		return settlement, invalidSettlementFile(err.Error())
+	}
+	if int64(len(data)) > s.maxFileSize {
+		return settlement, WithDetails(
+			fmt.Errorf("%w: more than %d bytes", ErrSettlementFileTooLarge, s.maxFileSize),
+			Details{"max_size": s.maxFileSize},
+		)
+	}
+
+	lines, err := parseSettlement(format, data, s.amountScale)
+	if err != nil {
+		return settlement, err
+	}
+
+	refs := make([]string, 0, len(lines))
+	for _, line := range lines {
+		refs = append(refs, line.Reference)
+	}
+	topUps, err := s.storage.TopUps(ctx, refs)
+	if err != nil {
+		return settlement, err
+	}
+
+	checksum := sha256.Sum256(data)
+	settlement = model.Settlement{
+		Provider:   provider,
+		Format:     format,
+		Checksum:   hex.EncodeToString(checksum[:]),
+		ImportedBy: operator,
+		Lines:      len(lines),
+		Items:      matchSettlement(lines, topUps),
+	}
+	for _, item := range settlement.Items {
+		settlement.Amount += item.Amount
+		switch item.Status {
+		case model.SettlementMatched:
+			settlement.Matched++
+		case model.SettlementUnmatched:
+			settlement.Unmatched++
+		case model.SettlementMismatched:
+			settlement.Mismatched++
+		}
+	}
+
+	if settlement, err = s.storage.Create(ctx, settlement); err != nil {
+		return settlement, err
+	}
+
+	settlementItems.WithLabelValues(provider, string(model.SettlementMatched)).Add(float64(settlement.Matched))
+	settlementItems.WithLabelValues(provider, string(model.SettlementUnmatched)).Add(float64(settlement.Unmatched))
+	settlementItems.WithLabelValues(provider, string(model.SettlementMismatched)).Add(float64(settlement.Mismatched))
+	span.SetAttributes(attribute.Int("settlement.id", settlement.ID), attribute.Int("settlement.lines", settlement.Lines))
+
+	return settlement, nil
+}
+
+// Get returns the settlement with its items, only those with the status if
+// it is set.
+func (s SettlementService) Get(
+	ctx context.Context,
+	id int,
+	status *model.SettlementStatus,
+) (settlement model.Settlement, err error) {
+	ctx, span := tracer.Start(ctx, "SettlementService.Get")
+	span.SetAttributes(attribute.Int("settlement.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if status != nil && !status.Valid() {
+		return settlement, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidSettlementStatus, *status),
+			Details{"status": *status},
+		)
+	}
+
+	return s.storage.Get(ctx, id, status)
+}
+
+// List returns the latest settlements without their items,
+// defaultSettlementsLimit by default.
+func (s SettlementService) List(
+	ctx context.Context,
+	provider *string,
+	limit *int,
+) (settlements []model.Settlement, err error) {
+	ctx, span := tracer.Start(ctx, "SettlementService.List")
+	defer func() { endSpan(span, err) }()
+
+	n := defaultSettlementsLimit
+	if limit != nil {
+		if *limit < 1 || *limit > maxTransactionsLimit {
+			return nil, WithDetails(
+				fmt.Errorf("%w: %d", ErrInvalidLimit, *limit),
+				Details{"limit": *limit},
+			)
+		}
+		n = *limit
+	}
+
+	return s.storage.List(ctx, provider, n)
+}
+
+// Report returns the items of the settlement as a CSV file for finance.
+func (s SettlementService) Report(
+	ctx context.Context,
+	id int,
+	status *model.SettlementStatus,
+) (report []byte, err error) {
+	ctx, span := tracer.Start(ctx, "SettlementService.Report")
+	span.SetAttributes(attribute.Int("settlement.id", id))
+	defer func() { endSpan(span, err) }()
+
+	settlement, err := s.Get(ctx, id, status)
+	if err != nil {
+		return nil, err
+	}
+
+	optional := func(v *int) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	}
+
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	csvWriter.Comma = ';'
+
+	if err = csvWriter.Write([]string{
+		"line",
+		"reference",
+		"amount",
+		"currency",
+		"booked",
+		"status",
+		"transaction",
+		"user",
+		"journal amount",
+		"reason",
+	}); err != nil {
+		return nil, ErrInternalServerError
+	}
+	for _, item := range settlement.Items {
+		booked := ""
+		if item.Booked != nil {
+			booked = item.Booked.Format("2006-01-02")
+		}
+
+		if err = csvWriter.Write([]string{
+			strconv.Itoa(item.Line),
+			item.Reference,
+			strconv.Itoa(item.Amount),
+			item.Currency,
+			booked,
+			string(item.Status),
+			optional(item.TransactionID),
+			optional(item.UserID),
+			optional(item.JournalAmount),
+			item.Reason,
+		}); err != nil {
+			return nil, ErrInternalServerError
+		}
+	}
+	csvWriter.Flush()
+	if err = csvWriter.Error(); err != nil {
+		return nil, ErrInternalServerError
+	}
+
+	return buf.Bytes(), nil
+}
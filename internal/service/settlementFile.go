@@ -0,0 +1,300 @@
+package service
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+)
+
+// camtNamespace is the prefix of the namespaces of all camt.053 versions.
+const camtNamespace = "urn:iso:std:iso:20022:tech:xsd:camt.053"
+
+// maxAmountDigits keeps amounts of settlement lines within int.
+const maxAmountDigits = 15
+
+func invalidSettlementFile(reason string) error {
+	return WithDetails(
+		fmt.Errorf("%w: %s", ErrInvalidSettlementFile, reason),
+		Details{"reason": reason},
+	)
+}
+
+func invalidSettlementLine(line int, reason string) error {
+	return WithDetails(
+		fmt.Errorf("%w: line %d: %s", ErrInvalidSettlementFile, line, reason),
+		Details{"line": line, "reason": reason},
+	)
+}
+
+// parseSettlementAmount converts a decimal amount of the currency to the
+// units balances are kept in, which are 10^-scale of the currency. Both the
+// point and the comma separate the fraction.
+func parseSettlementAmount(s string, scale int) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "+")
+	if s == "" {
+		return 0, errors.New("amount is empty")
+	}
+
+	whole, fraction := s, ""
+	if i := strings.IndexAny(s, ".,"); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	if whole == "" {
+		return 0, fmt.Errorf("amount %q is not a decimal", s)
+	}
+
+	// digits of the fraction below the units must be zeros
+	if len(fraction) > scale {
+		if strings.Trim(fraction[scale:], "0") != "" {
+			return 0, fmt.Errorf("amount %q is more precise than the balances", s)
+		}
+		fraction = fraction[:scale]
+	}
+	digits := whole + fraction + strings.Repeat("0", scale-len(fraction))
+	if len(digits) > maxAmountDigits {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+
+	amount := 0
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("amount %q is not a decimal", s)
+		}
+		amount = amount*10 + int(c-'0')
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount %q must be positive", s)
+	}
+
+	return amount, nil
+}
+
+func parseSettlementDate(s string) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{"2006-01-02", time.RFC3339, "2006-01-02T15:04:05"} {
+		if date, err := time.Parse(layout, s); err == nil {
+			return &date, nil
+		}
+	}
+
+	return nil, fmt.Errorf("date %q is neither a date nor a date-time", s)
+}
+
+// parseSettlementCSV reads a CSV file with a header naming the reference and
+// amount columns and, optionally, the currency and date ones. Columns are
+// separated by semicolons if the header has any, otherwise by commas.
+func parseSettlementCSV(data []byte, scale int) ([]model.SettlementLine, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.ContainsRune(header, ';') {
+		reader.Comma = ';'
+	}
+
+	columns, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, invalidSettlementFile("file is empty")
+		}
+		return nil, invalidSettlementFile(err.Error())
+	}
+
+	index := map[string]int{"currency": -1, "date": -1}
+	for i, column := range columns {
+		index[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range []string{"reference", "amount"} {
+		if _, ok := index[column]; !ok {
+			return nil, invalidSettlementFile(fmt.Sprintf("header has no %s column", column))
+		}
+	}
+
+	lines := make([]model.SettlementLine, 0)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, invalidSettlementFile(err.Error())
+		}
+
+		number, _ := reader.FieldPos(0)
+		line := model.SettlementLine{
+			Line:      number,
+			Reference: strings.TrimSpace(record[index["reference"]]),
+		}
+		if line.Reference == "" {
+			return nil, invalidSettlementLine(number, "reference is empty")
+		}
+		if line.Amount, err = parseSettlementAmount(record[index["amount"]], scale); err != nil {
+			return nil, invalidSettlementLine(number, err.Error())
+		}
+		if i := index["currency"]; i >= 0 {
+			line.Currency = strings.ToUpper(strings.TrimSpace(record[i]))
+		}
+		if i := index["date"]; i >= 0 {
+			if line.Booked, err = parseSettlementDate(record[i]); err != nil {
+				return nil, invalidSettlementLine(number, err.Error())
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+type camtTransaction struct {
+	EndToEndID       string     `xml:"Refs>EndToEndId"`
+	ServicerRef      string     `xml:"Refs>AcctSvcrRef"`
+	Indicator        string     `xml:"CdtDbtInd"`
+	Amount           camtAmount `xml:"Amt"`
+	TransactedAmount camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+}
+
+type camtEntry struct {
+	Ref          string            `xml:"NtryRef"`
+	Amount       camtAmount        `xml:"Amt"`
+	Indicator    string            `xml:"CdtDbtInd"`
+	Booked       camtDate          `xml:"BookgDt"`
+	ServicerRef  string            `xml:"AcctSvcrRef"`
+	Transactions []camtTransaction `xml:"NtryDtls>TxDtls"`
+}
+
+type camtDocument struct {
+	XMLName xml.Name    `xml:"Document"`
+	Entries []camtEntry `xml:"BkToCstmrStmt>Stmt>Ntry"`
+}
+
+// firstReference returns the first reference which is set, skipping the
+// NOTPROVIDED placeholder of camt.053.
+func firstReference(refs ...string) string {
+	for _, ref := range refs {
+		if ref = strings.TrimSpace(ref); ref != "" && ref != "NOTPROVIDED" {
+			return ref
+		}
+	}
+
+	return ""
+}
+
+// parseSettlementCamt053 reads the credit entries of the statements of an
+// ISO 20022 camt.053 file. A batch entry gives a line for each of its
+// transactions; lines are numbered in the order of the credits in the file.
+// The end-to-end id of a transaction is its reference, falling back to the
+// references of the servicer and of the entry.
+func parseSettlementCamt053(data []byte, scale int) ([]model.SettlementLine, error) {
+	var document camtDocument
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return nil, invalidSettlementFile(err.Error())
+	}
+	if !strings.HasPrefix(document.XMLName.Space, camtNamespace) {
+		return nil, invalidSettlementFile(fmt.Sprintf("namespace %q is not camt.053", document.XMLName.Space))
+	}
+
+	lines := make([]model.SettlementLine, 0)
+	add := func(ref string, amount camtAmount, booked *time.Time) error {
+		number := len(lines) + 1
+		line := model.SettlementLine{
+			Line:      number,
+			Reference: ref,
+			Currency:  amount.Currency,
+			Booked:    booked,
+		}
+		if line.Reference == "" {
+			return invalidSettlementLine(number, "reference is empty")
+		}
+
+		var err error
+		if line.Amount, err = parseSettlementAmount(amount.Value, scale); err != nil {
+			return invalidSettlementLine(number, err.Error())
+		}
+
+		lines = append(lines, line)
+		return nil
+	}
+
+	for _, entry := range document.Entries {
+		if entry.Indicator != "CRDT" {
+			continue
+		}
+
+		booked, err := parseSettlementDate(entry.Booked.Date + entry.Booked.DateTime)
+		if err != nil {
+			return nil, invalidSettlementLine(len(lines)+1, err.Error())
+		}
+
+		// an entry booked as a whole has no amounts of its transactions
+		batch := len(entry.Transactions) > 1
+		for _, transaction := range entry.Transactions {
+			if transaction.Amount.Value == "" && transaction.TransactedAmount.Value == "" {
+				batch = false
+			}
+		}
+		if !batch {
+			var ref string
+			if len(entry.Transactions) == 1 {
+				transaction := entry.Transactions[0]
+				ref = firstReference(transaction.EndToEndID, transaction.ServicerRef, entry.Ref, entry.ServicerRef)
+			} else {
+				ref = firstReference(entry.Ref, entry.ServicerRef)
+			}
+
+			if err = add(ref, entry.Amount, booked); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		for _, transaction := range entry.Transactions {
+			if transaction.Indicator != "" && transaction.Indicator != "CRDT" {
+				continue
+			}
+
+			amount := transaction.Amount
+			if amount.Value == "" {
+				amount = transaction.TransactedAmount
+			}
+			ref := firstReference(transaction.EndToEndID, transaction.ServicerRef)
+
+			if err = add(ref, amount, booked); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return lines, nil
+}
+
+// parseSettlement reads the credit lines of a settlement file.
+func parseSettlement(format model.SettlementFormat, data []byte, scale int) ([]model.SettlementLine, error) {
+	if format == model.SettlementCamt053 {
+		return parseSettlementCamt053(data, scale)
+	}
+
+	return parseSettlementCSV(data, scale)
+}
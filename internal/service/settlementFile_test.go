@@ -0,0 +1,308 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+)
+
+func datePtr(year int, month time.Month, day int) *time.Time {
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &date
+}
+
+// checkSettlementLines compares parsed lines, or the error of a file which
+// must be rejected with a reason containing wantErr.
+func checkSettlementLines(
+	t *testing.T,
+	lines []model.SettlementLine,
+	err error,
+	want []model.SettlementLine,
+	wantErr string,
+) {
+	t.Helper()
+
+	if wantErr != "" {
+		if !errors.Is(err, ErrInvalidSettlementFile) || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("error = %v, want %v containing %q", err, ErrInvalidSettlementFile, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines %+v, want %d", len(lines), lines, len(want))
+	}
+	for i := range want {
+		got, want := lines[i], want[i]
+		sameBooked := got.Booked == nil && want.Booked == nil ||
+			got.Booked != nil && want.Booked != nil && got.Booked.Equal(*want.Booked)
+		if got.Line != want.Line || got.Reference != want.Reference || got.Amount != want.Amount ||
+			got.Currency != want.Currency || !sameBooked {
+			t.Errorf("line %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestParseSettlementAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		scale   int
+		want    int
+		wantErr string
+	}{
+		{"whole", "10", 0, 10, ""},
+		{"point", "10.50", 2, 1050, ""},
+		{"comma", "10,5", 2, 1050, ""},
+		{"without fraction", "10", 2, 1000, ""},
+		{"plus sign and spaces", " +7 ", 0, 7, ""},
+		{"zero fraction below the units", "10.00", 0, 10, ""},
+		{"fraction below the units", "10.5", 0, 0, "more precise than the balances"},
+		{"empty", " ", 0, 0, "amount is empty"},
+		{"no whole part", ".5", 1, 0, "not a decimal"},
+		{"negative", "-5", 0, 0, "not a decimal"},
+		{"exponent", "1e3", 0, 0, "not a decimal"},
+		{"thousands separator", "1 000", 0, 0, "not a decimal"},
+		{"two separators", "1.2.3", 3, 0, "not a decimal"},
+		{"zero", "0.00", 2, 0, "must be positive"},
+		{"largest", "999999999999999", 0, 999999999999999, ""},
+		{"too large", "9999999999999.99", 3, 0, "too large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSettlementAmount(tt.s, tt.scale)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseSettlementAmount(%q, %d) error = %v, want %q", tt.s, tt.scale, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSettlementAmount(%q, %d) error = %v", tt.s, tt.scale, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSettlementAmount(%q, %d) = %d, want %d", tt.s, tt.scale, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSettlementCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		scale   int
+		want    []model.SettlementLine
+		wantErr string
+	}{
+		{
+			name:  "commas",
+			data:  "reference,amount,currency,date\npay-1,10.00,rub,2022-11-20\npay-2,20.5,RUB,2022-11-21T10:00:00Z\n",
+			scale: 2,
+			want: []model.SettlementLine{
+				{Line: 2, Reference: "pay-1", Amount: 1000, Currency: "RUB", Booked: datePtr(2022, 11, 20)},
+				{Line: 3, Reference: "pay-2", Amount: 2050, Currency: "RUB", Booked: func() *time.Time {
+					date := time.Date(2022, 11, 21, 10, 0, 0, 0, time.UTC)
+					return &date
+				}()},
+			},
+		},
+		{
+			name:    "comma of the amount separates the fraction",
+			data:    "amount;reference\n10,5;pay-1\n",
+			wantErr: "line 2: amount \"10,5\" is more precise than the balances",
+		},
+		{
+			name:  "semicolons, byte order mark and header case",
+			data:  "\xef\xbb\xbfAmount; Reference\n10,5;pay-1\n",
+			scale: 1,
+			want:  []model.SettlementLine{{Line: 2, Reference: "pay-1", Amount: 105}},
+		},
+		{
+			name: "duplicate references",
+			data: "reference,amount\npay-1,10\npay-1,10\n",
+			want: []model.SettlementLine{
+				{Line: 2, Reference: "pay-1", Amount: 10},
+				{Line: 3, Reference: "pay-1", Amount: 10},
+			},
+		},
+		{
+			name: "header only",
+			data: "reference,amount\n",
+			want: []model.SettlementLine{},
+		},
+		{name: "empty", data: "", wantErr: "file is empty"},
+		{name: "no amount column", data: "reference,sum\npay-1,10\n", wantErr: "header has no amount column"},
+		{name: "no reference column", data: "ref,amount\npay-1,10\n", wantErr: "header has no reference column"},
+		{name: "empty reference", data: "reference,amount\npay-1,10\n ,10\n", wantErr: "line 3: reference is empty"},
+		{name: "malformed amount", data: "reference,amount\npay-1,ten\n", wantErr: "line 2: amount \"ten\" is not a decimal"},
+		{name: "negative amount", data: "reference,amount\npay-1,-10\n", wantErr: "line 2: amount \"-10\" is not a decimal"},
+		{name: "malformed date", data: "reference,amount,date\npay-1,10,20.11.2022\n", wantErr: "line 2: date"},
+		{name: "missing field", data: "reference,amount\npay-1,10\npay-2\n", wantErr: "wrong number of fields"},
+		{
+			name:    "unterminated quote",
+			data:    "reference,amount\n\"pay-1,10\n",
+			wantErr: "extraneous or missing \" in quoted-field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := parseSettlementCSV([]byte(tt.data), tt.scale)
+			checkSettlementLines(t, lines, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+// camtStatement wraps entries into a camt.053 document.
+func camtStatement(entries ...string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+<BkToCstmrStmt><Stmt>` + strings.Join(entries, "") + `</Stmt></BkToCstmrStmt>
+</Document>`
+}
+
+// camtEntryXML builds an entry with the reference, amount, indicator and
+// booking date, followed by the transaction details.
+func camtEntryXML(ref, amount, indicator, booked string, transactions ...string) string {
+	details := ""
+	if len(transactions) > 0 {
+		details = "<NtryDtls>" + strings.Join(transactions, "") + "</NtryDtls>"
+	}
+
+	return `<Ntry><NtryRef>` + ref + `</NtryRef><Amt Ccy="RUB">` + amount + `</Amt>` +
+		`<CdtDbtInd>` + indicator + `</CdtDbtInd><BookgDt><Dt>` + booked + `</Dt></BookgDt>` +
+		details + `</Ntry>`
+}
+
+// camtTransactionXML builds transaction details with the end-to-end id, the
+// amount if any and the indicator if any.
+func camtTransactionXML(endToEndID, amount, indicator string) string {
+	details := `<TxDtls><Refs><EndToEndId>` + endToEndID + `</EndToEndId></Refs>`
+	if amount != "" {
+		details += `<Amt Ccy="RUB">` + amount + `</Amt>`
+	}
+	if indicator != "" {
+		details += `<CdtDbtInd>` + indicator + `</CdtDbtInd>`
+	}
+
+	return details + `</TxDtls>`
+}
+
+func TestParseSettlementCamt053(t *testing.T) {
+	booked := datePtr(2022, 11, 20)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []model.SettlementLine
+		wantErr string
+	}{
+		{
+			name: "single transaction",
+			data: camtStatement(camtEntryXML("entry-1", "10.00", "CRDT", "2022-11-20",
+				camtTransactionXML("pay-1", "", ""))),
+			want: []model.SettlementLine{{Line: 1, Reference: "pay-1", Amount: 10, Currency: "RUB", Booked: booked}},
+		},
+		{
+			name: "reference falls back to the entry",
+			data: camtStatement(camtEntryXML("entry-1", "10", "CRDT", "2022-11-20",
+				camtTransactionXML("NOTPROVIDED", "", ""))),
+			want: []model.SettlementLine{{Line: 1, Reference: "entry-1", Amount: 10, Currency: "RUB", Booked: booked}},
+		},
+		{
+			name: "debits are skipped",
+			data: camtStatement(
+				camtEntryXML("entry-1", "10", "DBIT", "2022-11-20", camtTransactionXML("pay-1", "", "")),
+				camtEntryXML("entry-2", "20", "CRDT", "2022-11-20", camtTransactionXML("pay-2", "", "")),
+			),
+			want: []model.SettlementLine{{Line: 1, Reference: "pay-2", Amount: 20, Currency: "RUB", Booked: booked}},
+		},
+		{
+			name: "batch",
+			data: camtStatement(camtEntryXML("entry-1", "35", "CRDT", "2022-11-20",
+				camtTransactionXML("pay-1", "10", "CRDT"),
+				camtTransactionXML("pay-2", "5", "DBIT"),
+				camtTransactionXML("pay-3", "30", ""),
+			)),
+			want: []model.SettlementLine{
+				{Line: 1, Reference: "pay-1", Amount: 10, Currency: "RUB", Booked: booked},
+				{Line: 2, Reference: "pay-3", Amount: 30, Currency: "RUB", Booked: booked},
+			},
+		},
+		{
+			name: "batch booked as a whole",
+			data: camtStatement(camtEntryXML("entry-1", "40", "CRDT", "2022-11-20",
+				camtTransactionXML("pay-1", "10", ""),
+				camtTransactionXML("pay-2", "", ""),
+			)),
+			want: []model.SettlementLine{{Line: 1, Reference: "entry-1", Amount: 40, Currency: "RUB", Booked: booked}},
+		},
+		{
+			name: "duplicate references",
+			data: camtStatement(
+				camtEntryXML("entry-1", "10", "CRDT", "2022-11-20", camtTransactionXML("pay-1", "", "")),
+				camtEntryXML("entry-2", "10", "CRDT", "2022-11-20", camtTransactionXML("pay-1", "", "")),
+			),
+			want: []model.SettlementLine{
+				{Line: 1, Reference: "pay-1", Amount: 10, Currency: "RUB", Booked: booked},
+				{Line: 2, Reference: "pay-1", Amount: 10, Currency: "RUB", Booked: booked},
+			},
+		},
+		{
+			name: "no entries",
+			data: camtStatement(),
+			want: []model.SettlementLine{},
+		},
+		{
+			name:    "not camt.053",
+			data:    `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.054.001.02"></Document>`,
+			wantErr: "is not camt.053",
+		},
+		{
+			name:    "malformed xml",
+			data:    `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"><BkToCstmrStmt>`,
+			wantErr: "invalid settlement file: XML syntax error",
+		},
+		{
+			name: "empty reference",
+			data: camtStatement(
+				camtEntryXML("entry-1", "10", "CRDT", "2022-11-20", camtTransactionXML("pay-1", "", "")),
+				camtEntryXML("", "10", "CRDT", "2022-11-20"),
+			),
+			wantErr: "line 2: reference is empty",
+		},
+		{
+			name:    "malformed amount",
+			data:    camtStatement(camtEntryXML("entry-1", "10.5", "CRDT", "2022-11-20")),
+			wantErr: "line 1: amount \"10.5\" is more precise than the balances",
+		},
+		{
+			name: "malformed amount of a transaction",
+			data: camtStatement(camtEntryXML("entry-1", "20", "CRDT", "2022-11-20",
+				camtTransactionXML("pay-1", "10", ""),
+				camtTransactionXML("pay-2", "ten", ""),
+			)),
+			wantErr: "line 2: amount \"ten\" is not a decimal",
+		},
+		{
+			name:    "malformed date",
+			data:    camtStatement(camtEntryXML("entry-1", "10", "CRDT", "20.11.2022")),
+			wantErr: "line 1: date",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := parseSettlementCamt053([]byte(tt.data), 0)
+			checkSettlementLines(t, lines, err, tt.want, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/s02190058/billing-service/internal/model"
+)
+
+func TestMatchSettlement(t *testing.T) {
+	type want struct {
+		status        model.SettlementStatus
+		transactionID int
+		reason        string
+	}
+
+	line := func(number int, ref string, amount int) model.SettlementLine {
+		return model.SettlementLine{Line: number, Reference: ref, Amount: amount}
+	}
+
+	tests := []struct {
+		name   string
+		lines  []model.SettlementLine
+		topUps []model.SettledTopUp
+		want   []want
+	}{
+		{
+			name:   "matched",
+			lines:  []model.SettlementLine{line(1, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1"}},
+			want:   []want{{model.SettlementMatched, 1, ""}},
+		},
+		{
+			name:   "unmatched",
+			lines:  []model.SettlementLine{line(1, "pay-9", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1"}},
+			want:   []want{{model.SettlementUnmatched, 0, "no top-up with the reference"}},
+		},
+		{
+			name:   "amount above the top-up",
+			lines:  []model.SettlementLine{line(1, "pay-1", 2000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1500, ExternalRef: "pay-1"}},
+			want:   []want{{model.SettlementMismatched, 1, "amount differs from the top-up by 500"}},
+		},
+		{
+			name:   "amount below the top-up",
+			lines:  []model.SettlementLine{line(1, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1500, ExternalRef: "pay-1"}},
+			want:   []want{{model.SettlementMismatched, 1, "amount differs from the top-up by -500"}},
+		},
+		{
+			name:   "reversed top-up",
+			lines:  []model.SettlementLine{line(1, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1", Reversed: true}},
+			want:   []want{{model.SettlementMismatched, 1, "top-up is reversed"}},
+		},
+		{
+			name:   "top-up settled by an earlier file",
+			lines:  []model.SettlementLine{line(1, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1", Settled: true}},
+			want:   []want{{model.SettlementMismatched, 1, "top-up is already settled"}},
+		},
+		{
+			name:   "duplicate reference in the file",
+			lines:  []model.SettlementLine{line(1, "pay-1", 1000), line(2, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1"}},
+			want: []want{
+				{model.SettlementMatched, 1, ""},
+				{model.SettlementMismatched, 0, "top-up is matched by another line"},
+			},
+		},
+		{
+			name:   "duplicate reference after a mismatch",
+			lines:  []model.SettlementLine{line(1, "pay-1", 2000), line(2, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1"}},
+			want: []want{
+				{model.SettlementMismatched, 1, "amount differs from the top-up by 1000"},
+				{model.SettlementMismatched, 0, "top-up is matched by another line"},
+			},
+		},
+		{
+			name:  "duplicate reference in the journal",
+			lines: []model.SettlementLine{line(1, "pay-1", 1000), line(2, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{
+				{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1"},
+				{ID: 2, UserID: 2, Amount: 1000, ExternalRef: "pay-1"},
+			},
+			want: []want{
+				{model.SettlementMatched, 1, ""},
+				{model.SettlementMatched, 2, ""},
+			},
+		},
+		{
+			name:  "equal amount is preferred",
+			lines: []model.SettlementLine{line(1, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{
+				{ID: 1, UserID: 1, Amount: 500, ExternalRef: "pay-1"},
+				{ID: 2, UserID: 2, Amount: 1000, ExternalRef: "pay-1", Settled: true},
+				{ID: 3, UserID: 3, Amount: 1000, ExternalRef: "pay-1"},
+			},
+			want: []want{{model.SettlementMatched, 3, ""}},
+		},
+		{
+			name:  "amount mismatch is reported before a reversal",
+			lines: []model.SettlementLine{line(1, "pay-1", 1000)},
+			topUps: []model.SettledTopUp{
+				{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1", Reversed: true},
+				{ID: 2, UserID: 2, Amount: 500, ExternalRef: "pay-1"},
+			},
+			want: []want{{model.SettlementMismatched, 2, "amount differs from the top-up by 500"}},
+		},
+		{
+			name:   "no lines",
+			lines:  []model.SettlementLine{},
+			topUps: []model.SettledTopUp{{ID: 1, UserID: 1, Amount: 1000, ExternalRef: "pay-1"}},
+			want:   []want{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := matchSettlement(tt.lines, tt.topUps)
+			if len(items) != len(tt.want) {
+				t.Fatalf("got %d items, want %d", len(items), len(tt.want))
+			}
+
+			for i, item := range items {
+				want := tt.want[i]
+				if item.SettlementLine != tt.lines[i] {
+					t.Errorf("item %d line = %+v, want %+v", i, item.SettlementLine, tt.lines[i])
+				}
+				if item.Status != want.status || item.Reason != want.reason {
+					t.Errorf("item %d = %s %q, want %s %q", i, item.Status, item.Reason, want.status, want.reason)
+				}
+
+				if want.transactionID == 0 {
+					if item.TransactionID != nil {
+						t.Errorf("item %d transaction = %d, want none", i, *item.TransactionID)
+					}
+					continue
+				}
+				if item.TransactionID == nil || *item.TransactionID != want.transactionID {
+					t.Errorf("item %d transaction = %v, want %d", i, item.TransactionID, want.transactionID)
+					continue
+				}
+
+				topUp := tt.topUps[want.transactionID-1]
+				if *item.UserID != topUp.UserID || *item.JournalAmount != topUp.Amount {
+					t.Errorf(
+						"item %d user and amount = %d %d, want %d %d",
+						i, *item.UserID, *item.JournalAmount, topUp.UserID, topUp.Amount,
+					)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrInvalidPrice                  = errors.New("price must be positive")
+	ErrInvalidPeriod                 = errors.New("period must be 'day', 'week', 'month' or 'year'")
+	ErrInvalidStart                  = errors.New("start must not be in the past")
+	ErrSubscriptionNotFound          = errors.New("subscription not found")
+	ErrInvalidSubscriptionTransition = errors.New("subscription can't move to the status")
+)
+
+type subscriptionStorage interface {
+	Create(ctx context.Context, subscription model.Subscription) (model.Subscription, error)
+	Get(ctx context.Context, id int) (model.Subscription, error)
+	List(ctx context.Context, userID int) ([]model.Subscription, error)
+	ChangeStatus(ctx context.Context, id int, status model.SubscriptionStatus) (model.Subscription, error)
+	Due(ctx context.Context, limit int) ([]model.Subscription, error)
+	StartCharge(ctx context.Context, subscription model.Subscription) (model.SubscriptionCharge, error)
+	CompleteCharge(ctx context.Context, subscription model.Subscription, charge model.SubscriptionCharge) error
+	FailCharge(
+		ctx context.Context,
+		subscription model.Subscription,
+		charge model.SubscriptionCharge,
+		reason string,
+		retry time.Duration,
+		grace time.Duration,
+	) error
+}
+
+// chargeStorage pays subscription charges with reserves of their own, apart
+// from the orders of the clients.
+type chargeStorage interface {
+	ReserveCharge(ctx context.Context, chargeID, userID, serviceID int, cost int) error
+	ConfirmCharge(ctx context.Context, chargeID, userID, serviceID int, cost int) error
+}
+
+// SubscriptionService manages subscriptions and charges the due ones. A
+// charge is a reserve confirmed right away, so it is booked, limited and
+// reported like any other service payment.
+type SubscriptionService struct {
+	storage       subscriptionStorage
+	charges       chargeStorage
+	accounts      accountStorage
+	risk          riskChecker
+	catalog       catalogChecker
+	retryInterval time.Duration
+	gracePeriod   time.Duration
+	batchSize     int
+}
+
+func NewSubscriptionService(
+	storage subscriptionStorage,
+	charges chargeStorage,
+	accounts accountStorage,
+	risk riskChecker,
+	catalog catalogChecker,
+	retryInterval time.Duration,
+	gracePeriod time.Duration,
+	batchSize int,
+) SubscriptionService {
+	return SubscriptionService{
+		storage:       storage,
+		charges:       charges,
+		accounts:      accounts,
+		risk:          risk,
+		catalog:       catalog,
+		retryInterval: retryInterval,
+		gracePeriod:   gracePeriod,
+		batchSize:     batchSize,
+	}
+}
+
+// Create subscribes the user to the service. The first charge is made at
+// start, or right away if there is no start.
+func (s SubscriptionService) Create(
+	ctx context.Context,
+	userID int,
+	serviceID int,
+	price int,
+	period model.Period,
+	start *time.Time,
+) (subscription model.Subscription, err error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.Create")
+	span.SetAttributes(
+		attribute.Int("user.id", userID),
+		attribute.Int("service.id", serviceID),
+		attribute.Int("price", price),
+		attribute.String("period", string(period)),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return subscription, err
+	}
+
+	if price <= 0 {
+		return subscription, WithDetails(
+			fmt.Errorf("%w: %d", ErrInvalidPrice, price),
+			Details{"price": price},
+		)
+	}
+	if !period.Valid() {
+		return subscription, WithDetails(
+			fmt.Errorf("%w: %s", ErrInvalidPeriod, period),
+			Details{"period": period},
+		)
+	}
+
+	nextCharge := time.Now().UTC()
+	if start != nil {
+		if start.Before(nextCharge) {
+			return subscription, WithDetails(
+				fmt.Errorf("%w: %s", ErrInvalidStart, start.Format(time.RFC3339)),
+				Details{"start": start},
+			)
+		}
+
+		nextCharge = start.UTC()
+	}
+
+	if err = s.catalog.CheckPrice(ctx, serviceID, price); err != nil {
+		return subscription, err
+	}
+
+	if err = checkCanSend(ctx, s.accounts, userID); err != nil {
+		return subscription, err
+	}
+
+	return s.storage.Create(ctx, model.Subscription{
+		UserID:     userID,
+		ServiceID:  serviceID,
+		Price:      price,
+		Period:     period,
+		Status:     model.SubscriptionActive,
+		NextCharge: nextCharge,
+	})
+}
+
+func (s SubscriptionService) Get(ctx context.Context, id int) (subscription model.Subscription, err error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.Get")
+	span.SetAttributes(attribute.Int("subscription.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.Get(ctx, id)
+}
+
+func (s SubscriptionService) List(ctx context.Context, userID int) (subscriptions []model.Subscription, err error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.List")
+	span.SetAttributes(attribute.Int("user.id", userID))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(userID); err != nil {
+		return nil, err
+	}
+
+	if _, err = s.accounts.GetAccount(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return s.storage.List(ctx, userID)
+}
+
+func (s SubscriptionService) Pause(ctx context.Context, id int) (subscription model.Subscription, err error) {
+	return s.changeStatus(ctx, "SubscriptionService.Pause", id, model.SubscriptionPaused)
+}
+
+func (s SubscriptionService) Resume(ctx context.Context, id int) (subscription model.Subscription, err error) {
+	return s.changeStatus(ctx, "SubscriptionService.Resume", id, model.SubscriptionActive)
+}
+
+func (s SubscriptionService) Cancel(ctx context.Context, id int) (subscription model.Subscription, err error) {
+	return s.changeStatus(ctx, "SubscriptionService.Cancel", id, model.SubscriptionCancelled)
+}
+
+func (s SubscriptionService) changeStatus(
+	ctx context.Context,
+	name string,
+	id int,
+	status model.SubscriptionStatus,
+) (subscription model.Subscription, err error) {
+	ctx, span := tracer.Start(ctx, name)
+	span.SetAttributes(attribute.Int("subscription.id", id))
+	defer func() { endSpan(span, err) }()
+
+	return s.storage.ChangeStatus(ctx, id, status)
+}
+
+// ChargeDue charges a batch of due subscriptions. A charge the user can't
+// pay is recorded and retried later; the first internal error is returned
+// after the rest of the batch has been tried.
+func (s SubscriptionService) ChargeDue(ctx context.Context) error {
+	subscriptions, err := s.storage.Due(ctx, s.batchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if chargeErr := s.charge(ctx, subscription); chargeErr != nil && err == nil {
+			err = chargeErr
+		}
+	}
+
+	return err
+}
+
+func (s SubscriptionService) charge(ctx context.Context, subscription model.Subscription) (err error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.Charge")
+	span.SetAttributes(
+		attribute.Int("subscription.id", subscription.ID),
+		attribute.Int("user.id", subscription.UserID),
+		attribute.Int("price", subscription.Price),
+	)
+	defer func() { endSpan(span, err) }()
+
+	charge, err := s.storage.StartCharge(ctx, subscription)
+	if err != nil {
+		return err
+	}
+
+	if charge.Status != model.ChargePaid {
+		if err = s.pay(ctx, subscription, charge); err != nil {
+			if errors.Is(err, ErrInternalServerError) {
+				return err
+			}
+
+			return s.storage.FailCharge(ctx, subscription, charge, err.Error(), s.retryInterval, s.gracePeriod)
+		}
+
+		observeOperation("subscription", subscription.Price)
+	}
+
+	return s.storage.CompleteCharge(ctx, subscription, charge)
+}
+
+// pay reserves and confirms the price under the id of the charge. A
+// previous attempt may have got as far as either step, so an existing
+// reserve is confirmed and a confirmed one is taken as paid.
+func (s SubscriptionService) pay(
+	ctx context.Context,
+	subscription model.Subscription,
+	charge model.SubscriptionCharge,
+) error {
+	// the price of the subscription is checked against the catalog on every
+	// charge, so a service that is withdrawn or repriced stops being charged
+	if err := s.catalog.CheckPrice(ctx, subscription.ServiceID, subscription.Price); err != nil {
+		return err
+	}
+	if err := checkCanSend(ctx, s.accounts, subscription.UserID); err != nil {
+		return err
+	}
+
+	err := s.risk.Check(ctx, model.RiskCheck{
+		Operation:  model.RiskReserve,
+		UserID:     subscription.UserID,
+		ServiceID:  &subscription.ServiceID,
+		Amount:     subscription.Price,
+		Metadata:   map[string]any{"subscription_id": subscription.ID, "charge_id": charge.ID},
+		Unattended: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.charges.ReserveCharge(ctx, charge.ID, subscription.UserID, subscription.ServiceID, subscription.Price)
+	if err != nil && !errors.Is(err, ErrAlreadyReserved) {
+		return err
+	}
+
+	err = s.charges.ConfirmCharge(ctx, charge.ID, subscription.UserID, subscription.ServiceID, subscription.Price)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -1,49 +1,139 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	"github.com/s02190058/billing-service/internal/model"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
-	ErrInsufficientFunds = errors.New("insufficient funds")
-	ErrInvalidAmount     = errors.New("amount must be positive")
-	ErrInvalidOrderField = errors.New("order field must be 'amount' or 'created'")
-	ErrInvalidTransfer   = errors.New("impossible to transfer to yourself")
-	ErrUserNotFound      = errors.New("user not found")
+	ErrInsufficientFunds   = errors.New("insufficient funds")
+	ErrInvalidAmount       = errors.New("amount must be positive")
+	ErrInvalidOrderField   = errors.New("order field must be 'amount' or 'created'")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrInvalidLimit        = errors.New("limit must be in the range from 1 to 100")
+	ErrInvalidDirection    = errors.New("direction must be 'credit' or 'debit'")
+	ErrInvalidRange        = errors.New("lower bound must not exceed upper bound")
+	ErrInvalidTransfer     = errors.New("impossible to transfer to yourself")
+	ErrInvalidType         = errors.New("unknown transaction type")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrNotReversible       = errors.New("transaction of this type can't be reversed")
+	ErrAlreadyReversed     = errors.New("transaction has already been reversed")
+	ErrMissedReason        = errors.New("reason is required")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrDuplicateReference  = errors.New("transfer with the external reference already exists")
 )
 
 type userStorage interface {
-	GetBalance(id int) (balance int, err error)
-	TopUpBalance(id int, amount int) (balance int, err error)
-	Transfer(id, receiverID int, amount int) (balance int, err error)
-	Transactions(id int, orderField string, limit, offset int) (transactions []model.Transaction, err error)
+	GetBalance(ctx context.Context, id int) (balance model.Balance, err error)
+	TopUpBalance(ctx context.Context, id int, amount int, ref model.Reference) (balance int, err error)
+	Transfer(ctx context.Context, id, receiverID int, amount int, ref model.Reference) (balance int, err error)
+	Transactions(ctx context.Context, id int, query model.TransactionsQuery) (transactions []model.Transaction, err error)
+	Reverse(ctx context.Context, id int, reversal model.Reversal) (entries []model.Transaction, err error)
+	CreateAccount(ctx context.Context, id int, metadata map[string]any) (account model.Account, err error)
+	GetAccount(ctx context.Context, id int) (account model.Account, err error)
+	AccountEvents(ctx context.Context, id int) (events []model.AccountEvent, err error)
+	ChangeStatus(
+		ctx context.Context,
+		id int,
+		status model.AccountStatus,
+		reason string,
+		operator string,
+	) (account model.Account, err error)
+	SetCreditLimit(
+		ctx context.Context,
+		id int,
+		creditLimit int,
+		reason string,
+		operator string,
+	) (account model.Account, err error)
+	SetSegment(
+		ctx context.Context,
+		id int,
+		segment string,
+		reason string,
+		operator string,
+	) (account model.Account, err error)
 }
 
 type UserService struct {
 	storage userStorage
+	risk    riskChecker
 }
 
-func NewUserService(storage userStorage) UserService {
+func NewUserService(storage userStorage, risk riskChecker) UserService {
 	return UserService{
 		storage: storage,
+		risk:    risk,
 	}
 }
 
-func (s UserService) GetBalance(id int) (int, error) {
-	return s.storage.GetBalance(id)
+func (s UserService) GetBalance(ctx context.Context, id int) (balance model.Balance, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.GetBalance")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(id); err != nil {
+		return balance, err
+	}
+
+	return s.storage.GetBalance(ctx, id)
 }
 
-func (s UserService) TopUpBalance(id int, amount int) (int, error) {
+func (s UserService) TopUpBalance(ctx context.Context, id int, amount int, ref model.Reference) (balance int, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.TopUpBalance")
+	span.SetAttributes(attribute.Int("user.id", id), attribute.Int("amount", amount))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(id); err != nil {
+		return 0, err
+	}
+
 	if amount <= 0 {
 		return 0, ErrInvalidAmount
 	}
 
-	return s.storage.TopUpBalance(id, amount)
+	if err = checkCanReceive(ctx, s.storage, id); err != nil {
+		return 0, err
+	}
+
+	err = s.risk.Check(ctx, model.RiskCheck{
+		Operation:   model.RiskTopUp,
+		UserID:      id,
+		Amount:      amount,
+		ExternalRef: ref.ExternalRef,
+		Metadata:    ref.Metadata,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	balance, err = s.storage.TopUpBalance(ctx, id, amount, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	observeOperation("topup", amount)
+
+	return balance, nil
 }
 
-func (s UserService) Transfer(id, receiverID int, amount int) (int, error) {
+func (s UserService) Transfer(ctx context.Context, id, receiverID int, amount int, ref model.Reference) (balance int, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Transfer")
+	span.SetAttributes(
+		attribute.Int("user.id", id),
+		attribute.Int("receiver.id", receiverID),
+		attribute.Int("amount", amount),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(id, receiverID); err != nil {
+		return 0, err
+	}
+
 	if id == receiverID {
 		return 0, ErrInvalidTransfer
 	}
@@ -51,13 +141,132 @@ func (s UserService) Transfer(id, receiverID int, amount int) (int, error) {
 		return 0, ErrInvalidAmount
 	}
 
-	return s.storage.Transfer(id, receiverID, amount)
+	if err = checkCanSend(ctx, s.storage, id); err != nil {
+		return 0, err
+	}
+	if err = checkCanReceive(ctx, s.storage, receiverID); err != nil {
+		return 0, err
+	}
+
+	err = s.risk.Check(ctx, model.RiskCheck{
+		Operation:      model.RiskTransfer,
+		UserID:         id,
+		CounterpartyID: &receiverID,
+		Amount:         amount,
+		ExternalRef:    ref.ExternalRef,
+		Metadata:       ref.Metadata,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	balance, err = s.storage.Transfer(ctx, id, receiverID, amount, ref)
+	if err != nil {
+		return 0, err
+	}
+
+	observeOperation("transfer", amount)
+
+	return balance, nil
 }
 
-func (s UserService) Transactions(id int, orderField string, limit, offset int) ([]model.Transaction, error) {
-	if orderField != "amount" && orderField != "created" {
-		return nil, ErrInvalidOrderField
+// Reverse undoes the journal entry with compensating entries and returns
+// them. Both sides of a transfer are reversed together.
+func (s UserService) Reverse(ctx context.Context, id int, reversal model.Reversal) (entries []model.Transaction, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Reverse")
+	span.SetAttributes(
+		attribute.Int("transaction.id", id),
+		attribute.Bool("force", reversal.Force),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if reversal.Reason == "" {
+		return nil, ErrMissedReason
 	}
 
-	return s.storage.Transactions(id, orderField, limit, offset)
+	if entries, err = s.storage.Reverse(ctx, id, reversal); err != nil {
+		return nil, err
+	}
+
+	amount := entries[0].Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	observeOperation("reversal", amount)
+
+	return entries, nil
+}
+
+const maxTransactionsLimit = 100
+
+// Transactions returns a page of user transactions. The cursor is the
+// next_cursor of the previous page or an empty string for the first page.
+func (s UserService) Transactions(
+	ctx context.Context,
+	id int,
+	query model.TransactionsQuery,
+	cursor string,
+) (page model.TransactionsPage, err error) {
+	ctx, span := tracer.Start(ctx, "UserService.Transactions")
+	span.SetAttributes(attribute.Int("user.id", id))
+	defer func() { endSpan(span, err) }()
+
+	if err = checkNotReserved(id); err != nil {
+		return page, err
+	}
+
+	if query.OrderField != "amount" && query.OrderField != "created" {
+		return page, ErrInvalidOrderField
+	}
+	if query.Limit < 1 || query.Limit > maxTransactionsLimit {
+		return page, ErrInvalidLimit
+	}
+	if query.Direction != "" && query.Direction != model.DirectionCredit && query.Direction != model.DirectionDebit {
+		return page, ErrInvalidDirection
+	}
+	if query.Type != "" && !query.Type.Valid() {
+		return page, WithDetails(fmt.Errorf("%w: %s", ErrInvalidType, query.Type), Details{"type": query.Type})
+	}
+	if query.From != nil && query.To != nil && query.From.After(*query.To) {
+		return page, WithDetails(ErrInvalidRange, Details{"parameter": "from"})
+	}
+	if query.MinAmount != nil && query.MaxAmount != nil && *query.MinAmount > *query.MaxAmount {
+		return page, WithDetails(ErrInvalidRange, Details{"parameter": "min_amount"})
+	}
+
+	if cursor != "" {
+		query.After, err = decodeCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+
+		if query.After.OrderField != query.OrderField || query.After.Descending != query.Descending {
+			return page, ErrInvalidCursor
+		}
+	}
+
+	// one more transaction is requested to find out whether there is a next page
+	limit := query.Limit
+	query.Limit++
+
+	transactions, err := s.storage.Transactions(ctx, id, query)
+	if err != nil {
+		return page, err
+	}
+
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+		last := transactions[limit-1]
+		page.NextCursor = encodeCursor(model.TransactionsCursor{
+			OrderField: query.OrderField,
+			Descending: query.Descending,
+			ID:         last.ID,
+			Amount:     last.Amount,
+			Created:    last.Created,
+		})
+	}
+
+	page.Transactions = transactions
+
+	return page, nil
 }
@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+)
+
+const accountColumns = "id, balance, credit_limit, status, segment, metadata, created"
+
+func scanAccount(row pgx.Row) (model.Account, error) {
+	var account model.Account
+	err := row.Scan(
+		&account.ID,
+		&account.Balance,
+		&account.CreditLimit,
+		&account.Status,
+		&account.Segment,
+		&account.Metadata,
+		&account.Created,
+	)
+	account.Available = account.Balance + account.CreditLimit
+
+	return account, err
+}
+
+func (s UserStorage) CreateAccount(ctx context.Context, id int, metadata map[string]any) (model.Account, error) {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "INSERT INTO users (id, balance, status, metadata) VALUES ($1, 0, $2, $3) " +
+		"RETURNING " + accountColumns
+	account, err := scanAccount(tx.QueryRow(ctx, query, id, model.AccountActive, metadata))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserExists, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	if err = s.addAccountEvent(ctx, tx, id, model.AccountEvent{
+		Status: model.AccountActive,
+		Reason: "account opened",
+	}); err != nil {
+		return model.Account{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	return account, nil
+}
+
+func (s UserStorage) GetAccount(ctx context.Context, id int) (model.Account, error) {
+	query := "SELECT " + accountColumns + " FROM users WHERE id=$1"
+	account, err := scanAccount(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	return account, nil
+}
+
+// AccountEvents returns the status history of the account, oldest first.
+func (s UserStorage) AccountEvents(ctx context.Context, id int) ([]model.AccountEvent, error) {
+	query := "SELECT status, credit_limit, segment, reason, operator, created FROM account_events " +
+		"WHERE user_id=$1 ORDER BY id"
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	events := make([]model.AccountEvent, 0)
+	for rows.Next() {
+		var event model.AccountEvent
+		if err = rows.Scan(
+			&event.Status,
+			&event.CreditLimit,
+			&event.Segment,
+			&event.Reason,
+			&event.Operator,
+			&event.Created,
+		); err != nil {
+			s.logger.Errorf("can't scan account event values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return events, nil
+}
+
+// ChangeStatus moves the account to the status. Closure requires a zero
+// balance and no open reserves; both are checked under the row lock.
+func (s UserStorage) ChangeStatus(
+	ctx context.Context,
+	id int,
+	status model.AccountStatus,
+	reason string,
+	operator string,
+) (model.Account, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + accountColumns + " FROM users WHERE id=$1 FOR UPDATE"
+	account, err := scanAccount(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	if !account.Status.CanBecome(status) {
+		return model.Account{}, service.WithDetails(
+			fmt.Errorf("%w: %s -> %s", service.ErrInvalidStatusTransition, account.Status, status),
+			service.Details{"user_id": id, "status": account.Status},
+		)
+	}
+
+	if status == model.AccountClosed {
+		if account.Balance != 0 {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrNonZeroBalance, account.Balance),
+				service.Details{"user_id": id, "balance": account.Balance},
+			)
+		}
+
+		// transfers held from or to the account and open disputes, which may
+		// refund money to it, are open reserves too
+		query = "SELECT (SELECT count(*) FROM reserves WHERE user_id=$1 AND status=$2) + " +
+			"(SELECT count(*) FROM pending_transfers WHERE (sender_id=$1 OR receiver_id=$1) AND status=$3) + " +
+			"(SELECT count(*) FROM disputes WHERE user_id=$1 AND status=$4)"
+		var reserves int
+		if err = tx.QueryRow(ctx, query, id, "reserved", model.PendingHeld, model.DisputeOpen).Scan(&reserves); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return model.Account{}, service.ErrInternalServerError
+		}
+
+		if reserves > 0 {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrOpenReserves, reserves),
+				service.Details{"user_id": id, "reserves": reserves},
+			)
+		}
+	}
+
+	query = "UPDATE users SET status=$1 WHERE id=$2 RETURNING " + accountColumns
+	if account, err = scanAccount(tx.QueryRow(ctx, query, status, id)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	if err = s.addAccountEvent(ctx, tx, id, model.AccountEvent{
+		Status:   status,
+		Reason:   reason,
+		Operator: operator,
+	}); err != nil {
+		return model.Account{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	return account, nil
+}
+
+// SetCreditLimit changes the amount the account may go below zero. The new
+// limit must cover the current overdraft.
+func (s UserStorage) SetCreditLimit(
+	ctx context.Context,
+	id int,
+	creditLimit int,
+	reason string,
+	operator string,
+) (model.Account, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + accountColumns + " FROM users WHERE id=$1 FOR UPDATE"
+	account, err := scanAccount(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	if account.Status == model.AccountClosed {
+		return model.Account{}, service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrAccountClosed, id),
+			service.Details{"user_id": id},
+		)
+	}
+
+	if account.Balance < -creditLimit {
+		return model.Account{}, service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrCreditLimitTooLow, account.Balance),
+			service.Details{"user_id": id, "balance": account.Balance},
+		)
+	}
+
+	query = "UPDATE users SET credit_limit=$1 WHERE id=$2 RETURNING " + accountColumns
+	if account, err = scanAccount(tx.QueryRow(ctx, query, creditLimit, id)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	if err = s.addAccountEvent(ctx, tx, id, model.AccountEvent{
+		Status:      account.Status,
+		CreditLimit: &creditLimit,
+		Reason:      reason,
+		Operator:    operator,
+	}); err != nil {
+		return model.Account{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	return account, nil
+}
+
+// SetSegment moves the account to the segment limit rules are scoped by.
+func (s UserStorage) SetSegment(
+	ctx context.Context,
+	id int,
+	segment string,
+	reason string,
+	operator string,
+) (model.Account, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "UPDATE users SET segment=$1 WHERE id=$2 RETURNING " + accountColumns
+	account, err := scanAccount(tx.QueryRow(ctx, query, segment, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Account{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	if err = s.addAccountEvent(ctx, tx, id, model.AccountEvent{
+		Status:   account.Status,
+		Segment:  &segment,
+		Reason:   reason,
+		Operator: operator,
+	}); err != nil {
+		return model.Account{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Account{}, service.ErrInternalServerError
+	}
+
+	return account, nil
+}
+
+func (s UserStorage) addAccountEvent(ctx context.Context, tx pgx.Tx, id int, event model.AccountEvent) error {
+	query := "INSERT INTO account_events (user_id, status, credit_limit, segment, reason, operator) " +
+		"VALUES ($1, $2, $3, $4, $5, $6)"
+	if _, err := tx.Exec(
+		ctx,
+		query,
+		id,
+		event.Status,
+		event.CreditLimit,
+		event.Segment,
+		event.Reason,
+		event.Operator,
+	); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
@@ -0,0 +1,390 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const adjustmentColumns = "id, user_id, amount, reason_code, comment, status, requested_by, decided_by, " +
+	"transaction_id, created, decided"
+
+type AdjustmentStorage struct {
+	logger   *zap.SugaredLogger
+	db       *pgxpool.Pool
+	chainKey []byte
+}
+
+func NewAdjustmentStorage(logger *zap.SugaredLogger, db *pgxpool.Pool, chainKey []byte) AdjustmentStorage {
+	return AdjustmentStorage{
+		logger:   logger,
+		db:       db,
+		chainKey: chainKey,
+	}
+}
+
+func scanAdjustment(row pgx.Row) (model.Adjustment, error) {
+	var adjustment model.Adjustment
+	err := row.Scan(
+		&adjustment.ID,
+		&adjustment.UserID,
+		&adjustment.Amount,
+		&adjustment.ReasonCode,
+		&adjustment.Comment,
+		&adjustment.Status,
+		&adjustment.RequestedBy,
+		&adjustment.DecidedBy,
+		&adjustment.TransactionID,
+		&adjustment.Created,
+		&adjustment.Decided,
+	)
+
+	return adjustment, err
+}
+
+func adjustmentNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrAdjustmentNotFound, id),
+		service.Details{"adjustment_id": id},
+	)
+}
+
+// Create stores the adjustment requested by adjustment.RequestedBy. If apply
+// is set, the adjustment is applied to the balance in the same transaction.
+func (s AdjustmentStorage) Create(ctx context.Context, adjustment model.Adjustment, apply bool) (model.Adjustment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "INSERT INTO adjustments (user_id, amount, reason_code, comment, status, requested_by) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + adjustmentColumns
+	created, err := scanAdjustment(tx.QueryRow(
+		ctx,
+		query,
+		adjustment.UserID,
+		adjustment.Amount,
+		adjustment.ReasonCode,
+		adjustment.Comment,
+		model.AdjustmentPending,
+		adjustment.RequestedBy,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		// foreign_key_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return model.Adjustment{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, adjustment.UserID),
+				service.Details{"user_id": adjustment.UserID},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+	adjustment = created
+
+	if err = s.addEvent(ctx, tx, adjustment.ID, "requested", adjustment.RequestedBy); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	if apply {
+		if adjustment, err = s.apply(ctx, tx, adjustment, adjustment.RequestedBy); err != nil {
+			return model.Adjustment{}, err
+		}
+	}
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	return adjustment, nil
+}
+
+// Approve applies the pending adjustment on behalf of operator.
+func (s AdjustmentStorage) Approve(ctx context.Context, id int, operator string) (model.Adjustment, error) {
+	return s.decide(ctx, id, operator, model.AdjustmentApplied)
+}
+
+// Reject closes the pending adjustment without touching the balance.
+func (s AdjustmentStorage) Reject(ctx context.Context, id int, operator string) (model.Adjustment, error) {
+	return s.decide(ctx, id, operator, model.AdjustmentRejected)
+}
+
+func (s AdjustmentStorage) decide(
+	ctx context.Context,
+	id int,
+	operator string,
+	status model.AdjustmentStatus,
+) (model.Adjustment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + adjustmentColumns + " FROM adjustments WHERE id=$1 FOR UPDATE"
+	adjustment, err := scanAdjustment(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Adjustment{}, adjustmentNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	if adjustment.Status != model.AdjustmentPending {
+		return model.Adjustment{}, service.WithDetails(
+			fmt.Errorf("%w: %s", service.ErrAdjustmentNotPending, adjustment.Status),
+			service.Details{"adjustment_id": id, "status": adjustment.Status},
+		)
+	}
+
+	if status == model.AdjustmentApplied {
+		// self-approval is checked under the lock, so the check can't race
+		// with a concurrent decision
+		if adjustment.RequestedBy == operator {
+			return model.Adjustment{}, service.WithDetails(
+				service.ErrSelfApproval,
+				service.Details{"adjustment_id": id, "operator": operator},
+			)
+		}
+
+		if adjustment, err = s.apply(ctx, tx, adjustment, operator); err != nil {
+			return model.Adjustment{}, err
+		}
+	} else {
+		query = "UPDATE adjustments SET status=$1, decided_by=$2, decided=now() WHERE id=$3 " +
+			"RETURNING " + adjustmentColumns
+		if adjustment, err = scanAdjustment(tx.QueryRow(ctx, query, status, operator, id)); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return model.Adjustment{}, service.ErrInternalServerError
+		}
+
+		if err = s.addEvent(ctx, tx, id, "rejected", operator); err != nil {
+			return model.Adjustment{}, err
+		}
+	}
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	return adjustment, nil
+}
+
+// apply changes the balance, writes the adjustment journal entry and marks
+// the adjustment as applied by operator.
+func (s AdjustmentStorage) apply(
+	ctx context.Context,
+	tx pgx.Tx,
+	adjustment model.Adjustment,
+	operator string,
+) (model.Adjustment, error) {
+	if _, err := debitBalance(ctx, s.logger, tx, adjustment.UserID, -adjustment.Amount, false); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	entry, err := insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID: adjustment.UserID,
+		Amount: adjustment.Amount,
+		Type:   model.TransactionAdjustment,
+		Metadata: map[string]any{
+			"adjustment_id": adjustment.ID,
+			"reason_code":   adjustment.ReasonCode,
+			"requested_by":  adjustment.RequestedBy,
+			"approved_by":   operator,
+		},
+		Message: fmt.Sprintf("manual adjustment %d: %s", adjustment.ID, adjustment.ReasonCode),
+	})
+	if err != nil {
+		return model.Adjustment{}, err
+	}
+
+	query := "UPDATE adjustments SET status=$1, decided_by=$2, transaction_id=$3, decided=now() WHERE id=$4 " +
+		"RETURNING " + adjustmentColumns
+	if adjustment, err = scanAdjustment(tx.QueryRow(
+		ctx,
+		query,
+		model.AdjustmentApplied,
+		operator,
+		entry.ID,
+		adjustment.ID,
+	)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	if err = s.addEvent(ctx, tx, adjustment.ID, "applied", operator); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	return adjustment, nil
+}
+
+// Reverse undoes the applied adjustment with a compensating journal entry
+// and marks it as reversed in the same transaction.
+func (s AdjustmentStorage) Reverse(ctx context.Context, id int, reversal model.Reversal) (model.Adjustment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + adjustmentColumns + " FROM adjustments WHERE id=$1 FOR UPDATE"
+	adjustment, err := scanAdjustment(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Adjustment{}, adjustmentNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	if adjustment.Status != model.AdjustmentApplied || adjustment.TransactionID == nil {
+		return model.Adjustment{}, service.WithDetails(
+			fmt.Errorf("%w: %s", service.ErrAdjustmentNotApplied, adjustment.Status),
+			service.Details{"adjustment_id": id, "status": adjustment.Status},
+		)
+	}
+
+	originals, err := lockReversal(ctx, s.logger, tx, *adjustment.TransactionID)
+	if err != nil {
+		return model.Adjustment{}, err
+	}
+	if _, err = reverseEntries(ctx, s.logger, tx, originals, reversal); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	query = "UPDATE adjustments SET status=$1 WHERE id=$2 RETURNING " + adjustmentColumns
+	if adjustment, err = scanAdjustment(tx.QueryRow(ctx, query, model.AdjustmentReversed, id)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	if err = s.addEvent(ctx, tx, id, "reversed", reversal.Operator); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return model.Adjustment{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	return adjustment, nil
+}
+
+func (s AdjustmentStorage) addEvent(ctx context.Context, tx pgx.Tx, id int, action, operator string) error {
+	query := "INSERT INTO adjustment_events (adjustment_id, action, operator) VALUES ($1, $2, $3)"
+	if _, err := tx.Exec(ctx, query, id, action, operator); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
+
+// Get returns the adjustment together with its audit trail.
+func (s AdjustmentStorage) Get(ctx context.Context, id int) (model.Adjustment, error) {
+	query := "SELECT " + adjustmentColumns + " FROM adjustments WHERE id=$1"
+	adjustment, err := scanAdjustment(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Adjustment{}, adjustmentNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT action, operator, created FROM adjustment_events WHERE adjustment_id=$1 ORDER BY id"
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event model.AdjustmentEvent
+		if err = rows.Scan(&event.Action, &event.Operator, &event.Created); err != nil {
+			s.logger.Errorf("can't scan adjustment event values %q: %v", query, err)
+			return model.Adjustment{}, service.ErrInternalServerError
+		}
+		adjustment.Events = append(adjustment.Events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.Adjustment{}, service.ErrInternalServerError
+	}
+
+	return adjustment, nil
+}
+
+// List returns adjustments with the given status, oldest first.
+func (s AdjustmentStorage) List(ctx context.Context, status model.AdjustmentStatus) ([]model.Adjustment, error) {
+	query := "SELECT " + adjustmentColumns + " FROM adjustments WHERE status=$1 ORDER BY id"
+	rows, err := s.db.Query(ctx, query, status)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	adjustments := make([]model.Adjustment, 0)
+	for rows.Next() {
+		adjustment, err := scanAdjustment(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan adjustment values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return adjustments, nil
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const eventOverdraftEntered = "overdraft_entered"
+
+// accountStatusError returns the error of an account whose status doesn't
+// let it send (or receive) money: frozen accounts send only if force is set,
+// closed ones neither send nor receive.
+func accountStatusError(id int, status model.AccountStatus, sending, force bool) error {
+	switch {
+	case status == model.AccountClosed:
+		return service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrAccountClosed, id),
+			service.Details{"user_id": id},
+		)
+	case status == model.AccountFrozen && sending && !force:
+		return service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrAccountFrozen, id),
+			service.Details{"user_id": id},
+		)
+	}
+
+	return nil
+}
+
+// debitBalance takes amount from the user balance inside the transaction and
+// returns the new balance. The balance may go down to minus the credit limit
+// of the account unless force is set, in which case it isn't limited at all.
+// A negative amount credits the balance and is never limited. Crossing zero
+// records an overdraft event.
+//
+// The status of the account is checked on the updated row, which stays
+// locked until the transaction ends, so a freeze or a closure can't slip in
+// between the check and the commit.
+func debitBalance(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	id int,
+	amount int,
+	force bool,
+) (int, error) {
+	query := "UPDATE users SET balance=balance-$1 WHERE id=$2 RETURNING balance, credit_limit, status"
+	var (
+		balance, creditLimit int
+		status               model.AccountStatus
+	)
+	if err := tx.QueryRow(
+		ctx,
+		query,
+		amount,
+		id,
+	).Scan(&balance, &creditLimit, &status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return 0, service.ErrInternalServerError
+	}
+
+	if err := accountStatusError(id, status, amount > 0, force); err != nil {
+		return 0, err
+	}
+
+	previous := balance + amount
+	if amount > 0 && balance < -creditLimit && !force {
+		return 0, service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrInsufficientFunds, previous),
+			service.Details{
+				"balance":      previous,
+				"amount":       amount,
+				"credit_limit": creditLimit,
+				"available":    previous + creditLimit,
+			},
+		)
+	}
+
+	if previous >= 0 && balance < 0 {
+		if err := insertEvent(ctx, logger, tx, eventOverdraftEntered, id, map[string]any{
+			"balance":      balance,
+			"credit_limit": creditLimit,
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	return balance, nil
+}
+
+// creditBalance puts amount on the user balance inside the transaction and
+// returns the new balance. Like debitBalance, it checks the status on the
+// locked row.
+func creditBalance(ctx context.Context, logger *zap.SugaredLogger, tx pgx.Tx, id int, amount int) (int, error) {
+	query := "UPDATE users SET balance=balance+$1 WHERE id=$2 RETURNING balance, status"
+	var (
+		balance int
+		status  model.AccountStatus
+	)
+	if err := tx.QueryRow(ctx, query, amount, id).Scan(&balance, &status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return 0, service.ErrInternalServerError
+	}
+
+	if err := accountStatusError(id, status, false, false); err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// lockReceiver makes sure the account can receive money which will be put on
+// it later in the transaction or by another one, and keeps its status from
+// changing until the transaction ends.
+func lockReceiver(ctx context.Context, logger *zap.SugaredLogger, tx pgx.Tx, id int) error {
+	query := "SELECT status FROM users WHERE id=$1 FOR SHARE"
+	var status model.AccountStatus
+	if err := tx.QueryRow(ctx, query, id).Scan(&status); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return accountStatusError(id, status, false, false)
+}
+
+// insertEvent writes a domain event to the outbox inside the transaction, so
+// the outbox relay publishes the event if and only if the transaction
+// commits.
+func insertEvent(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	eventType string,
+	userID int,
+	payload map[string]any,
+) error {
+	query := "INSERT INTO outbox (type, user_id, payload) VALUES ($1, $2, $3)"
+	if _, err := tx.Exec(ctx, query, eventType, userID, payload); err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
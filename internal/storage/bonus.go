@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const (
+	bonusGrantColumns   = "id, user_id, amount, remaining, service_ids, source, reason, operator, expires, created"
+	cashbackRuleColumns = "id, service_id, rate_bps, max_amount, valid_seconds, enabled, created"
+)
+
+type BonusStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewBonusStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) BonusStorage {
+	return BonusStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanBonusGrant(row pgx.Row) (model.BonusGrant, error) {
+	var grant model.BonusGrant
+	err := row.Scan(
+		&grant.ID,
+		&grant.UserID,
+		&grant.Amount,
+		&grant.Remaining,
+		&grant.ServiceIDs,
+		&grant.Source,
+		&grant.Reason,
+		&grant.Operator,
+		&grant.Expires,
+		&grant.Created,
+	)
+
+	return grant, err
+}
+
+func scanCashbackRule(row pgx.Row) (model.CashbackRule, error) {
+	var rule model.CashbackRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.ServiceID,
+		&rule.RateBps,
+		&rule.MaxAmount,
+		&rule.ValidSeconds,
+		&rule.Enabled,
+		&rule.Created,
+	)
+
+	return rule, err
+}
+
+func cashbackRuleNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrCashbackRuleNotFound, id),
+		service.Details{"rule_id": id},
+	)
+}
+
+func (s BonusStorage) Grant(ctx context.Context, grant model.BonusGrant) (model.BonusGrant, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.BonusGrant{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	if grant, err = insertBonusGrant(ctx, s.logger, tx, grant); err != nil {
+		return model.BonusGrant{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.BonusGrant{}, service.ErrInternalServerError
+	}
+
+	return grant, nil
+}
+
+// Grants returns the bonus lots of the user that are still spendable,
+// the ones expiring first go first.
+func (s BonusStorage) Grants(ctx context.Context, userID int) ([]model.BonusGrant, error) {
+	query := "SELECT " + bonusGrantColumns + " FROM bonus_grants " +
+		"WHERE user_id=$1 AND remaining>0 AND expires>now() ORDER BY expires, id"
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	grants := make([]model.BonusGrant, 0)
+	for rows.Next() {
+		grant, err := scanBonusGrant(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan bonus grant values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		grants = append(grants, grant)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return grants, nil
+}
+
+func (s BonusStorage) CreateCashbackRule(ctx context.Context, rule model.CashbackRule) (model.CashbackRule, error) {
+	query := "INSERT INTO cashback_rules (service_id, rate_bps, max_amount, valid_seconds, enabled) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING " + cashbackRuleColumns
+	rule, err := scanCashbackRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.ServiceID,
+		rule.RateBps,
+		rule.MaxAmount,
+		rule.ValidSeconds,
+		rule.Enabled,
+	))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CashbackRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+// UpdateCashbackRule replaces all fields of the rule.
+func (s BonusStorage) UpdateCashbackRule(ctx context.Context, rule model.CashbackRule) (model.CashbackRule, error) {
+	id := rule.ID
+	query := "UPDATE cashback_rules SET service_id=$1, rate_bps=$2, max_amount=$3, valid_seconds=$4, enabled=$5 " +
+		"WHERE id=$6 RETURNING " + cashbackRuleColumns
+	rule, err := scanCashbackRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.ServiceID,
+		rule.RateBps,
+		rule.MaxAmount,
+		rule.ValidSeconds,
+		rule.Enabled,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.CashbackRule{}, cashbackRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CashbackRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s BonusStorage) DeleteCashbackRule(ctx context.Context, id int) (model.CashbackRule, error) {
+	query := "DELETE FROM cashback_rules WHERE id=$1 RETURNING " + cashbackRuleColumns
+	rule, err := scanCashbackRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.CashbackRule{}, cashbackRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CashbackRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s BonusStorage) GetCashbackRule(ctx context.Context, id int) (model.CashbackRule, error) {
+	query := "SELECT " + cashbackRuleColumns + " FROM cashback_rules WHERE id=$1"
+	rule, err := scanCashbackRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.CashbackRule{}, cashbackRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CashbackRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s BonusStorage) ListCashbackRules(ctx context.Context) ([]model.CashbackRule, error) {
+	query := "SELECT " + cashbackRuleColumns + " FROM cashback_rules ORDER BY id"
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	rules := make([]model.CashbackRule, 0)
+	for rows.Next() {
+		rule, err := scanCashbackRule(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan cashback rule values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return rules, nil
+}
+
+func insertBonusGrant(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	grant model.BonusGrant,
+) (model.BonusGrant, error) {
+	userID := grant.UserID
+	query := "INSERT INTO bonus_grants (user_id, amount, remaining, service_ids, source, reason, operator, expires) " +
+		"VALUES ($1, $2, $2, $3, $4, $5, $6, $7) RETURNING " + bonusGrantColumns
+	grant, err := scanBonusGrant(tx.QueryRow(
+		ctx,
+		query,
+		grant.UserID,
+		grant.Amount,
+		grant.ServiceIDs,
+		grant.Source,
+		grant.Reason,
+		grant.Operator,
+		grant.Expires,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		// foreign_key_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return model.BonusGrant{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, userID),
+				service.Details{"user_id": userID},
+			)
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return model.BonusGrant{}, service.ErrInternalServerError
+	}
+
+	return grant, nil
+}
+
+// bonusPortion is the part of a reserve paid from a bonus lot.
+type bonusPortion struct {
+	grantID int
+	amount  int
+}
+
+// spendBonus takes up to amount from the unexpired bonus lots of the user the
+// service may be paid with, the ones expiring first go first.
+func spendBonus(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	userID int,
+	serviceID int,
+	amount int,
+) ([]bonusPortion, int, error) {
+	query := "SELECT id, remaining FROM bonus_grants " +
+		"WHERE user_id=$1 AND remaining>0 AND expires>now() " +
+		"AND (service_ids IS NULL OR $2=ANY(service_ids)) " +
+		"ORDER BY expires, id FOR UPDATE"
+	rows, err := tx.Query(ctx, query, userID, serviceID)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return nil, 0, service.ErrInternalServerError
+	}
+
+	portions := make([]bonusPortion, 0)
+	var spent int
+	for rows.Next() {
+		var portion bonusPortion
+		if err = rows.Scan(&portion.grantID, &portion.amount); err != nil {
+			rows.Close()
+			logger.Errorf("can't scan bonus grant values %q: %v", query, err)
+			return nil, 0, service.ErrInternalServerError
+		}
+
+		if spent == amount {
+			continue
+		}
+		if portion.amount > amount-spent {
+			portion.amount = amount - spent
+		}
+
+		spent += portion.amount
+		portions = append(portions, portion)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, 0, service.ErrInternalServerError
+	}
+
+	query = "UPDATE bonus_grants SET remaining=remaining-$1 WHERE id=$2"
+	for _, portion := range portions {
+		if _, err = tx.Exec(ctx, query, portion.amount, portion.grantID); err != nil {
+			logger.Errorf("can't process query %q: %v", query, err)
+			return nil, 0, service.ErrInternalServerError
+		}
+	}
+
+	return portions, spent, nil
+}
+
+// returnBonus puts the bonus portions of a rejected reserve back to their
+// lots. Lots that have expired meanwhile stay expired.
+func returnBonus(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	source model.ReserveSource,
+	orderID, userID, serviceID int,
+) error {
+	query := "UPDATE bonus_grants g SET remaining=g.remaining+r.amount FROM reserve_bonuses r " +
+		"WHERE r.grant_id=g.id AND r.source=$1 AND r.order_id=$2 AND r.user_id=$3 AND r.service_id=$4"
+	if _, err := tx.Exec(ctx, query, source, orderID, userID, serviceID); err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
+
+// grantCashback credits bonus for the payment by the most specific enabled
+// cashback rule: a rule of the service wins over a rule without a service.
+func grantCashback(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	reason string,
+	userID, serviceID int,
+	amount int,
+) error {
+	query := "SELECT " + cashbackRuleColumns + " FROM cashback_rules " +
+		"WHERE enabled AND (service_id=$1 OR service_id IS NULL) " +
+		"ORDER BY service_id IS NULL, id LIMIT 1"
+	rule, err := scanCashbackRule(tx.QueryRow(ctx, query, serviceID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	cashback := rule.Cashback(amount)
+	if cashback <= 0 {
+		return nil
+	}
+
+	_, err = insertBonusGrant(ctx, logger, tx, model.BonusGrant{
+		UserID:  userID,
+		Amount:  cashback,
+		Source:  model.BonusCashback,
+		Reason:  reason,
+		Expires: time.Now().Add(time.Duration(rule.ValidSeconds) * time.Second),
+	})
+
+	return err
+}
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+// currentPrice selects the price of the catalog item c valid at the moment.
+const currentPrice = "(SELECT p.price FROM service_prices p WHERE p.service_id=c.id AND p.valid_from<=now() " +
+	"AND (p.valid_to IS NULL OR p.valid_to>now()) ORDER BY p.valid_from DESC, p.id DESC LIMIT 1)"
+
+const catalogItemColumns = "c.id, c.name, c.category, c.currency, c.active, " + currentPrice + ", c.created"
+
+const servicePriceColumns = "id, service_id, price, valid_from, valid_to, created"
+
+type CatalogStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewCatalogStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) CatalogStorage {
+	return CatalogStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanCatalogItem(row pgx.Row) (model.CatalogItem, error) {
+	var item model.CatalogItem
+	err := row.Scan(
+		&item.ID,
+		&item.Name,
+		&item.Category,
+		&item.Currency,
+		&item.Active,
+		&item.CurrentPrice,
+		&item.Created,
+	)
+
+	return item, err
+}
+
+func scanServicePrice(row pgx.Row) (model.ServicePrice, error) {
+	var price model.ServicePrice
+	err := row.Scan(
+		&price.ID,
+		&price.ServiceID,
+		&price.Price,
+		&price.ValidFrom,
+		&price.ValidTo,
+		&price.Created,
+	)
+
+	return price, err
+}
+
+func catalogItemNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrCatalogItemNotFound, id),
+		service.Details{"service_id": id},
+	)
+}
+
+func (s CatalogStorage) Create(ctx context.Context, item model.CatalogItem) (model.CatalogItem, error) {
+	query := "INSERT INTO catalog_items (id, name, category, currency, active) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := s.db.Exec(ctx, query, item.ID, item.Name, item.Category, item.Currency, item.Active); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return model.CatalogItem{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrCatalogItemExists, item.ID),
+				service.Details{"service_id": item.ID},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CatalogItem{}, service.ErrInternalServerError
+	}
+
+	return s.Get(ctx, item.ID)
+}
+
+// Update replaces all fields of the item except its prices.
+func (s CatalogStorage) Update(ctx context.Context, item model.CatalogItem) (model.CatalogItem, error) {
+	query := "UPDATE catalog_items SET name=$1, category=$2, currency=$3, active=$4 WHERE id=$5"
+	tag, err := s.db.Exec(ctx, query, item.Name, item.Category, item.Currency, item.Active, item.ID)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CatalogItem{}, service.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		return model.CatalogItem{}, catalogItemNotFound(item.ID)
+	}
+
+	return s.Get(ctx, item.ID)
+}
+
+// Get returns the item with all its prices, the latest starting first.
+func (s CatalogStorage) Get(ctx context.Context, id int) (model.CatalogItem, error) {
+	query := "SELECT " + catalogItemColumns + " FROM catalog_items c WHERE c.id=$1"
+	item, err := scanCatalogItem(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.CatalogItem{}, catalogItemNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CatalogItem{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT " + servicePriceColumns + " FROM service_prices WHERE service_id=$1 ORDER BY valid_from DESC, id DESC"
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.CatalogItem{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	item.Prices = make([]model.ServicePrice, 0)
+	for rows.Next() {
+		price, err := scanServicePrice(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan service price values %q: %v", query, err)
+			return model.CatalogItem{}, service.ErrInternalServerError
+		}
+
+		item.Prices = append(item.Prices, price)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.CatalogItem{}, service.ErrInternalServerError
+	}
+
+	return item, nil
+}
+
+// List returns the items of the category, or of all categories if there is
+// no category, without their price lists.
+func (s CatalogStorage) List(ctx context.Context, category *string, active *bool) ([]model.CatalogItem, error) {
+	query := "SELECT " + catalogItemColumns + " FROM catalog_items c " +
+		"WHERE ($1::text IS NULL OR c.category=$1) AND ($2::bool IS NULL OR c.active=$2) ORDER BY c.id"
+	rows, err := s.db.Query(ctx, query, category, active)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	items := make([]model.CatalogItem, 0)
+	for rows.Next() {
+		item, err := scanCatalogItem(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan catalog item values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return items, nil
+}
+
+// AddPrice adds the price to the price list of the service. The price is
+// valid from now on if there is no start.
+func (s CatalogStorage) AddPrice(
+	ctx context.Context,
+	serviceID int,
+	price int,
+	validFrom *time.Time,
+	validTo *time.Time,
+) (model.ServicePrice, error) {
+	query := "INSERT INTO service_prices (service_id, price, valid_from, valid_to) " +
+		"VALUES ($1, $2, coalesce($3, now()), $4) RETURNING " + servicePriceColumns
+	added, err := scanServicePrice(s.db.QueryRow(ctx, query, serviceID, price, validFrom, validTo))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "23503":
+				return model.ServicePrice{}, catalogItemNotFound(serviceID)
+			case "23514":
+				reason := "valid_to must be after valid_from"
+				return model.ServicePrice{}, service.WithDetails(
+					fmt.Errorf("%w: %s", service.ErrInvalidServicePrice, reason),
+					service.Details{"reason": reason},
+				)
+			}
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ServicePrice{}, service.ErrInternalServerError
+	}
+
+	return added, nil
+}
+
+func (s CatalogStorage) DeletePrice(ctx context.Context, serviceID, id int) (model.ServicePrice, error) {
+	query := "DELETE FROM service_prices WHERE id=$1 AND service_id=$2 RETURNING " + servicePriceColumns
+	price, err := scanServicePrice(s.db.QueryRow(ctx, query, id, serviceID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.ServicePrice{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrServicePriceNotFound, id),
+				service.Details{"service_id": serviceID, "price_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ServicePrice{}, service.ErrInternalServerError
+	}
+
+	return price, nil
+}
+
+// CurrentPrice returns whether the service is active and its price at the
+// moment, which is nil if no price is valid now.
+func (s CatalogStorage) CurrentPrice(ctx context.Context, serviceID int) (bool, *int, error) {
+	query := "SELECT c.active, " + currentPrice + " FROM catalog_items c WHERE c.id=$1"
+
+	var active bool
+	var price *int
+	if err := s.db.QueryRow(ctx, query, serviceID).Scan(&active, &price); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil, catalogItemNotFound(serviceID)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return false, nil, service.ErrInternalServerError
+	}
+
+	return active, price, nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+type JournalStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewJournalStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) JournalStorage {
+	return JournalStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+// WalkChain calls visit for the entries which aren't chained, then for the
+// chained ones in the order of the global chain, and returns the head of the
+// chain. The journal is read from a single snapshot, so entries committed
+// meanwhile are neither visited nor reflected in the head. Errors returned
+// by visit stop the walk and are returned as they are.
+func (s JournalStorage) WalkChain(
+	ctx context.Context,
+	visit func(link model.ChainLink) error,
+) (model.ChainHead, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.ChainHead{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT seq, hash FROM journal_chain"
+	var head model.ChainHead
+	if err = tx.QueryRow(ctx, query).Scan(&head.Seq, &head.Hash); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ChainHead{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT coalesce(seq, 0), coalesce(user_hash, ''), coalesce(global_hash, ''), " + journalColumns +
+		" FROM journal ORDER BY seq NULLS FIRST, id"
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ChainHead{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var link model.ChainLink
+		err = rows.Scan(
+			&link.Seq,
+			&link.UserHash,
+			&link.GlobalHash,
+			&link.Entry.ID,
+			&link.Entry.UserID,
+			&link.Entry.Amount,
+			&link.Entry.Type,
+			&link.Entry.CounterpartyID,
+			&link.Entry.OrderID,
+			&link.Entry.ServiceID,
+			&link.Entry.ExternalRef,
+			&link.Entry.Metadata,
+			&link.Entry.PairID,
+			&link.Entry.ReversalOf,
+			&link.Entry.ReversedBy,
+			&link.Entry.Message,
+			&link.Entry.Created,
+		)
+		if err != nil {
+			s.logger.Errorf("can't scan chain link values %q: %v", query, err)
+			return model.ChainHead{}, service.ErrInternalServerError
+		}
+
+		if err = visit(link); err != nil {
+			return model.ChainHead{}, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.ChainHead{}, service.ErrInternalServerError
+	}
+
+	return head, nil
+}
@@ -0,0 +1,379 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const disputeColumns = "id, order_id, user_id, service_id, amount, reason, status, deadline, resolved_by, comment, " +
+	"transaction_id, created, resolved"
+
+type DisputeStorage struct {
+	logger   *zap.SugaredLogger
+	db       *pgxpool.Pool
+	chainKey []byte
+}
+
+func NewDisputeStorage(logger *zap.SugaredLogger, db *pgxpool.Pool, chainKey []byte) DisputeStorage {
+	return DisputeStorage{
+		logger:   logger,
+		db:       db,
+		chainKey: chainKey,
+	}
+}
+
+func scanDispute(row pgx.Row) (model.Dispute, error) {
+	var dispute model.Dispute
+	err := row.Scan(
+		&dispute.ID,
+		&dispute.OrderID,
+		&dispute.UserID,
+		&dispute.ServiceID,
+		&dispute.Amount,
+		&dispute.Reason,
+		&dispute.Status,
+		&dispute.Deadline,
+		&dispute.ResolvedBy,
+		&dispute.Comment,
+		&dispute.TransactionID,
+		&dispute.Created,
+		&dispute.Resolved,
+	)
+
+	return dispute, err
+}
+
+func disputeNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrDisputeNotFound, id),
+		service.Details{"dispute_id": id},
+	)
+}
+
+// Open opens a dispute against the confirmed payment of the reserve. The
+// payment must have been reserved within the last windowSeconds, and only the
+// money part of it can be disputed, the bonus part can't. The amount of a
+// dispute without one is the whole money part.
+func (s DisputeStorage) Open(
+	ctx context.Context,
+	dispute model.Dispute,
+	amount *int,
+	windowSeconds int,
+	responseSeconds int,
+) (model.Dispute, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT cost-bonus, status, created > now() - $4::int * interval '1 second' FROM reserves " +
+		"WHERE source=$5 AND order_id=$1 AND user_id=$2 AND service_id=$3 FOR UPDATE"
+	var paid int
+	var status string
+	var inWindow bool
+	if err = tx.QueryRow(
+		ctx,
+		query,
+		dispute.OrderID,
+		dispute.UserID,
+		dispute.ServiceID,
+		windowSeconds,
+		model.ReserveOrder,
+	).Scan(&paid, &status, &inWindow); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Dispute{}, service.WithDetails(
+				fmt.Errorf(
+					"%w: (%d,%d,%d)",
+					service.ErrRecordNotFound,
+					dispute.OrderID,
+					dispute.UserID,
+					dispute.ServiceID,
+				),
+				reserveDetails(dispute.OrderID, dispute.UserID, dispute.ServiceID),
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	details := reserveDetails(dispute.OrderID, dispute.UserID, dispute.ServiceID)
+	if status != "confirmed" {
+		details["status"] = status
+		return model.Dispute{}, service.WithDetails(
+			fmt.Errorf("%w: %s", service.ErrNotDisputable, status),
+			details,
+		)
+	}
+	if !inWindow {
+		details["window_seconds"] = windowSeconds
+		return model.Dispute{}, service.WithDetails(
+			fmt.Errorf("%w: %d seconds", service.ErrDisputeWindowClosed, windowSeconds),
+			details,
+		)
+	}
+
+	dispute.Amount = paid
+	if amount != nil {
+		dispute.Amount = *amount
+	}
+	if dispute.Amount <= 0 || dispute.Amount > paid {
+		details["amount"] = dispute.Amount
+		details["max_amount"] = paid
+		return model.Dispute{}, service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrInvalidDisputeAmount, dispute.Amount),
+			details,
+		)
+	}
+
+	query = "INSERT INTO disputes (order_id, user_id, service_id, amount, reason, status, deadline) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, now() + $7::int * interval '1 second') RETURNING " + disputeColumns
+	if dispute, err = scanDispute(tx.QueryRow(
+		ctx,
+		query,
+		dispute.OrderID,
+		dispute.UserID,
+		dispute.ServiceID,
+		dispute.Amount,
+		dispute.Reason,
+		model.DisputeOpen,
+		responseSeconds,
+	)); err != nil {
+		var pgErr *pgconn.PgError
+		// unique_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return model.Dispute{}, service.WithDetails(
+				fmt.Errorf("%w: (%d,%d,%d)", service.ErrAlreadyDisputed, dispute.OrderID, dispute.UserID, dispute.ServiceID),
+				details,
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	return dispute, nil
+}
+
+func (s DisputeStorage) Get(ctx context.Context, id int) (model.Dispute, error) {
+	query := "SELECT " + disputeColumns + " FROM disputes WHERE id=$1"
+	dispute, err := scanDispute(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Dispute{}, disputeNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	return dispute, nil
+}
+
+// List returns the disputes of the user, newest first.
+func (s DisputeStorage) List(ctx context.Context, userID int) ([]model.Dispute, error) {
+	query := "SELECT " + disputeColumns + " FROM disputes WHERE user_id=$1 ORDER BY id DESC"
+
+	return s.list(ctx, query, userID)
+}
+
+// ListByStatus returns the disputes in the status, the closest deadline
+// first.
+func (s DisputeStorage) ListByStatus(ctx context.Context, status model.DisputeStatus) ([]model.Dispute, error) {
+	query := "SELECT " + disputeColumns + " FROM disputes WHERE status=$1 ORDER BY deadline, id"
+
+	return s.list(ctx, query, status)
+}
+
+// Overdue returns up to limit open disputes past their deadline.
+func (s DisputeStorage) Overdue(ctx context.Context, limit int) ([]model.Dispute, error) {
+	query := "SELECT " + disputeColumns + " FROM disputes WHERE status=$1 AND deadline <= now() " +
+		"ORDER BY deadline, id LIMIT $2"
+
+	return s.list(ctx, query, model.DisputeOpen, limit)
+}
+
+func (s DisputeStorage) list(ctx context.Context, query string, args ...any) ([]model.Dispute, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	disputes := make([]model.Dispute, 0)
+	for rows.Next() {
+		dispute, err := scanDispute(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan dispute values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		disputes = append(disputes, dispute)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return disputes, nil
+}
+
+// disputedFee returns the share of the platform fee taken for the disputed
+// payment that falls on the disputed amount, rounded half up.
+func disputedFee(ctx context.Context, logger *zap.SugaredLogger, tx pgx.Tx, dispute model.Dispute) (int, error) {
+	query := "SELECT -p.amount, coalesce(SUM(f.amount), 0) FROM journal p " +
+		"LEFT JOIN journal f ON f.pair_id=p.id AND f.user_id=$1 AND f.type=$2 " +
+		"WHERE p.user_id=$3 AND p.type=$4 AND p.order_id=$5 AND p.service_id=$6 GROUP BY p.id"
+	var net, fee int
+	if err := tx.QueryRow(
+		ctx,
+		query,
+		model.RevenueAccountID,
+		model.TransactionFee,
+		dispute.UserID,
+		model.TransactionPayment,
+		dispute.OrderID,
+		dispute.ServiceID,
+	).Scan(&net, &fee); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return 0, service.ErrInternalServerError
+	}
+
+	paid := net + fee
+	if fee <= 0 || paid <= 0 {
+		return 0, nil
+	}
+
+	return (fee*dispute.Amount + paid/2) / paid, nil
+}
+
+// Resolve resolves the open dispute with the outcome. A dispute resolved in
+// favor of the customer refunds the disputed amount to the balance, together
+// with its share of the platform fee.
+func (s DisputeStorage) Resolve(
+	ctx context.Context,
+	id int,
+	outcome model.DisputeOutcome,
+	operator string,
+	comment *string,
+) (model.Dispute, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + disputeColumns + " FROM disputes WHERE id=$1 FOR UPDATE"
+	dispute, err := scanDispute(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Dispute{}, disputeNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	if dispute.Status != model.DisputeOpen {
+		return model.Dispute{}, service.WithDetails(
+			fmt.Errorf("%w: %s", service.ErrDisputeNotOpen, dispute.Status),
+			service.Details{"dispute_id": id, "status": dispute.Status},
+		)
+	}
+
+	var transactionID *int
+	if outcome == model.OutcomeCustomer {
+		if _, err = creditBalance(ctx, s.logger, tx, dispute.UserID, dispute.Amount); err != nil {
+			return model.Dispute{}, err
+		}
+
+		// the refund is split like the payment was: the share of the platform
+		// fee goes back from the revenue account, the rest is the refund
+		fee, err := disputedFee(ctx, s.logger, tx, dispute)
+		if err != nil {
+			return model.Dispute{}, err
+		}
+
+		refund, err := insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+			UserID:    dispute.UserID,
+			Amount:    dispute.Amount - fee,
+			Type:      model.TransactionRefund,
+			OrderID:   &dispute.OrderID,
+			ServiceID: &dispute.ServiceID,
+			Metadata:  map[string]any{"dispute_id": dispute.ID},
+			Message:   fmt.Sprintf("chargeback for the service %d", dispute.ServiceID),
+		})
+		if err != nil {
+			return model.Dispute{}, err
+		}
+
+		if fee > 0 {
+			if err = returnFee(ctx, s.logger, tx, dispute.UserID, fee, model.Transaction{
+				OrderID:   &dispute.OrderID,
+				ServiceID: &dispute.ServiceID,
+				Metadata:  map[string]any{"dispute_id": dispute.ID},
+				PairID:    &refund.ID,
+				Message:   fmt.Sprintf("platform fee returned for the dispute %d", dispute.ID),
+			}); err != nil {
+				return model.Dispute{}, err
+			}
+		}
+
+		transactionID = &refund.ID
+	}
+
+	query = "UPDATE disputes SET status=$1, resolved_by=$2, comment=$3, transaction_id=$4, resolved=now() " +
+		"WHERE id=$5 RETURNING " + disputeColumns
+	if dispute, err = scanDispute(tx.QueryRow(
+		ctx,
+		query,
+		outcome.Status(),
+		operator,
+		comment,
+		transactionID,
+		id,
+	)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return model.Dispute{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Dispute{}, service.ErrInternalServerError
+	}
+
+	return dispute, nil
+}
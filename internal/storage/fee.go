@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const feeRuleColumns = "id, operation, service_id, kind, flat, rate_bps, tiers, min_fee, max_fee, enabled, created"
+
+// queryRower is implemented by both the pool and transactions.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type FeeStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewFeeStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) FeeStorage {
+	return FeeStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanFeeRule(row pgx.Row) (model.FeeRule, error) {
+	var rule model.FeeRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.Operation,
+		&rule.ServiceID,
+		&rule.Kind,
+		&rule.Flat,
+		&rule.RateBps,
+		&rule.Tiers,
+		&rule.MinFee,
+		&rule.MaxFee,
+		&rule.Enabled,
+		&rule.Created,
+	)
+
+	return rule, err
+}
+
+func feeRuleNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrFeeRuleNotFound, id),
+		service.Details{"rule_id": id},
+	)
+}
+
+func (s FeeStorage) Create(ctx context.Context, rule model.FeeRule) (model.FeeRule, error) {
+	if rule.Tiers == nil {
+		rule.Tiers = []model.FeeTier{}
+	}
+
+	query := "INSERT INTO fee_rules (operation, service_id, kind, flat, rate_bps, tiers, min_fee, max_fee, enabled) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING " + feeRuleColumns
+	rule, err := scanFeeRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.Operation,
+		rule.ServiceID,
+		rule.Kind,
+		rule.Flat,
+		rule.RateBps,
+		rule.Tiers,
+		rule.MinFee,
+		rule.MaxFee,
+		rule.Enabled,
+	))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.FeeRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+// Update replaces all fields of the rule.
+func (s FeeStorage) Update(ctx context.Context, rule model.FeeRule) (model.FeeRule, error) {
+	if rule.Tiers == nil {
+		rule.Tiers = []model.FeeTier{}
+	}
+
+	id := rule.ID
+	query := "UPDATE fee_rules SET operation=$1, service_id=$2, kind=$3, flat=$4, rate_bps=$5, tiers=$6, " +
+		"min_fee=$7, max_fee=$8, enabled=$9 WHERE id=$10 RETURNING " + feeRuleColumns
+	rule, err := scanFeeRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.Operation,
+		rule.ServiceID,
+		rule.Kind,
+		rule.Flat,
+		rule.RateBps,
+		rule.Tiers,
+		rule.MinFee,
+		rule.MaxFee,
+		rule.Enabled,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.FeeRule{}, feeRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.FeeRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s FeeStorage) Delete(ctx context.Context, id int) (model.FeeRule, error) {
+	query := "DELETE FROM fee_rules WHERE id=$1 RETURNING " + feeRuleColumns
+	rule, err := scanFeeRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.FeeRule{}, feeRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.FeeRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s FeeStorage) Get(ctx context.Context, id int) (model.FeeRule, error) {
+	query := "SELECT " + feeRuleColumns + " FROM fee_rules WHERE id=$1"
+	rule, err := scanFeeRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.FeeRule{}, feeRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.FeeRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s FeeStorage) List(ctx context.Context) ([]model.FeeRule, error) {
+	query := "SELECT " + feeRuleColumns + " FROM fee_rules ORDER BY id"
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	rules := make([]model.FeeRule, 0)
+	for rows.Next() {
+		rule, err := scanFeeRule(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan fee rule values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return rules, nil
+}
+
+func (s FeeStorage) Quote(
+	ctx context.Context,
+	operation model.FeeOperation,
+	serviceID *int,
+	amount int,
+) (model.FeeQuote, error) {
+	return quoteFee(ctx, s.logger, s.db, operation, serviceID, amount)
+}
+
+// quoteFee calculates the fee by the most specific enabled rule of the
+// operation: a rule of the service wins over a rule without a service.
+func quoteFee(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	q queryRower,
+	operation model.FeeOperation,
+	serviceID *int,
+	amount int,
+) (model.FeeQuote, error) {
+	query := "SELECT " + feeRuleColumns + " FROM fee_rules " +
+		"WHERE enabled AND operation=$1 AND (service_id=$2 OR service_id IS NULL) " +
+		"ORDER BY service_id IS NULL, id LIMIT 1"
+	rule, err := scanFeeRule(q.QueryRow(ctx, query, operation, serviceID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.NewFeeQuote(nil, operation, serviceID, amount), nil
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return model.FeeQuote{}, service.ErrInternalServerError
+	}
+
+	return model.NewFeeQuote(&rule, operation, serviceID, amount), nil
+}
+
+// bookFee credits the fee to the revenue account and writes the fee lines of
+// the payer and of the revenue account. The payer's balance must already be
+// debited by the caller.
+func bookFee(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	userID int,
+	quote model.FeeQuote,
+	entry model.Transaction,
+) error {
+	query := "UPDATE users SET balance=balance+$1 WHERE id=$2"
+	tag, err := tx.Exec(ctx, query, quote.Fee, model.RevenueAccountID)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Errorf("revenue account %d doesn't exist", model.RevenueAccountID)
+		return service.ErrInternalServerError
+	}
+
+	metadata := map[string]any{
+		"operation": quote.Operation,
+		"amount":    quote.Amount,
+	}
+	if quote.RuleID != nil {
+		metadata["rule_id"] = *quote.RuleID
+	}
+
+	revenueID := model.RevenueAccountID
+	entry.UserID = userID
+	entry.Amount = -quote.Fee
+	entry.Type = model.TransactionFee
+	entry.CounterpartyID = &revenueID
+	entry.Metadata = metadata
+	if _, err = insertJournalEntry(ctx, logger, tx, entry); err != nil {
+		return err
+	}
+
+	entry.UserID = model.RevenueAccountID
+	entry.Amount = quote.Fee
+	entry.CounterpartyID = &userID
+	if _, err = insertJournalEntry(ctx, logger, tx, entry); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// returnFee moves the fee back from the revenue account to the user, the
+// reverse of bookFee, e.g. when the payment it was taken for is refunded.
+func returnFee(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	userID int,
+	fee int,
+	entry model.Transaction,
+) error {
+	query := "UPDATE users SET balance=balance-$1 WHERE id=$2"
+	tag, err := tx.Exec(ctx, query, fee, model.RevenueAccountID)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+	if tag.RowsAffected() == 0 {
+		logger.Errorf("revenue account %d doesn't exist", model.RevenueAccountID)
+		return service.ErrInternalServerError
+	}
+
+	revenueID := model.RevenueAccountID
+	entry.UserID = model.RevenueAccountID
+	entry.Amount = -fee
+	entry.Type = model.TransactionFee
+	entry.CounterpartyID = &userID
+	if _, err = insertJournalEntry(ctx, logger, tx, entry); err != nil {
+		return err
+	}
+
+	entry.UserID = userID
+	entry.Amount = fee
+	entry.CounterpartyID = &revenueID
+	if _, err = insertJournalEntry(ctx, logger, tx, entry); err != nil {
+		return err
+	}
+
+	return nil
+}
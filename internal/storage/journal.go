@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const journalColumns = "id, user_id, amount, type, counterparty_id, order_id, service_id, " +
+	"external_ref, metadata, pair_id, reversal_of, reversed_by, message, created"
+
+// insertJournalEntry writes the entry to the journal inside the transaction
+// and returns it with the id and the creation time set. The external
+// reference of an outgoing transfer is unique for the sender, so a transfer
+// booked twice under one reference fails with service.ErrDuplicateReference.
+func insertJournalEntry(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	entry model.Transaction,
+) (model.Transaction, error) {
+	if entry.Metadata == nil {
+		entry.Metadata = map[string]any{}
+	}
+
+	query := "INSERT INTO journal " +
+		"(user_id, amount, type, counterparty_id, order_id, service_id, external_ref, metadata, pair_id, " +
+		"reversal_of, message) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id, created"
+
+	if err := tx.QueryRow(
+		ctx,
+		query,
+		entry.UserID,
+		entry.Amount,
+		entry.Type,
+		entry.CounterpartyID,
+		entry.OrderID,
+		entry.ServiceID,
+		entry.ExternalRef,
+		entry.Metadata,
+		entry.PairID,
+		entry.ReversalOf,
+		entry.Message,
+	).Scan(&entry.ID, &entry.Created); err != nil {
+		var pgErr *pgconn.PgError
+		// unique_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && entry.ExternalRef != nil {
+			return model.Transaction{}, service.WithDetails(
+				fmt.Errorf("%w: %s", service.ErrDuplicateReference, *entry.ExternalRef),
+				service.Details{"external_ref": *entry.ExternalRef},
+			)
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return model.Transaction{}, service.ErrInternalServerError
+	}
+
+	return entry, nil
+}
+
+// scanTransaction scans a row selected with journalColumns.
+func scanTransaction(row pgx.Row) (model.Transaction, error) {
+	var transaction model.Transaction
+	err := row.Scan(
+		&transaction.ID,
+		&transaction.UserID,
+		&transaction.Amount,
+		&transaction.Type,
+		&transaction.CounterpartyID,
+		&transaction.OrderID,
+		&transaction.ServiceID,
+		&transaction.ExternalRef,
+		&transaction.Metadata,
+		&transaction.PairID,
+		&transaction.ReversalOf,
+		&transaction.ReversedBy,
+		&transaction.Message,
+		&transaction.Created,
+	)
+
+	return transaction, err
+}
+
+// sealJournal adds the entries written by the transaction to the hash chain
+// of their users and to the global chain, keyed by key. It must be called
+// right before the commit: the head of the global chain stays locked until
+// the transaction ends, and locking anything after it could deadlock.
+//
+// The single row of journal_chain is locked by every transaction which
+// writes the journal, so all money movement is serialised on it for the
+// time between sealing and commit. The lock is taken last to keep that
+// time short, but throughput of the ledger is bound by it.
+func sealJournal(ctx context.Context, logger *zap.SugaredLogger, tx pgx.Tx, key []byte) error {
+	// only the rows inserted by this transaction are chained, so rows
+	// inserted around the service are reported by the verification
+	query := "SELECT " + journalColumns + " FROM journal WHERE seq IS NULL AND xmin=pg_current_xact_id()::xid ORDER BY id"
+	rows, err := tx.Query(ctx, query)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	entries := make([]model.Transaction, 0)
+	for rows.Next() {
+		entry, err := scanTransaction(rows)
+		if err != nil {
+			rows.Close()
+			logger.Errorf("can't scan transaction values %q: %v", query, err)
+			return service.ErrInternalServerError
+		}
+
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.Errorf("error occurred during rows scanning: %v", err)
+		return service.ErrInternalServerError
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	query = "SELECT seq, hash FROM journal_chain FOR UPDATE"
+	var head model.ChainHead
+	if err = tx.QueryRow(ctx, query).Scan(&head.Seq, &head.Hash); err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	userHeads := make(map[int]string)
+	for _, entry := range entries {
+		prev, ok := userHeads[entry.UserID]
+		if !ok {
+			query = "SELECT coalesce((SELECT user_hash FROM journal WHERE user_id=$1 AND seq IS NOT NULL " +
+				"ORDER BY seq DESC LIMIT 1), '')"
+			if err = tx.QueryRow(ctx, query, entry.UserID).Scan(&prev); err != nil {
+				logger.Errorf("can't process query %q: %v", query, err)
+				return service.ErrInternalServerError
+			}
+		}
+
+		head.Seq++
+		head.Hash = entry.ChainHash(key, head.Hash)
+		userHeads[entry.UserID] = entry.ChainHash(key, prev)
+
+		query = "UPDATE journal SET seq=$1, user_hash=$2, global_hash=$3 WHERE id=$4"
+		if _, err = tx.Exec(ctx, query, head.Seq, userHeads[entry.UserID], head.Hash, entry.ID); err != nil {
+			logger.Errorf("can't process query %q: %v", query, err)
+			return service.ErrInternalServerError
+		}
+	}
+
+	query = "UPDATE journal_chain SET seq=$1, hash=$2"
+	if _, err = tx.Exec(ctx, query, head.Seq, head.Hash); err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
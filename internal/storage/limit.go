@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const limitRuleColumns = "id, operation, segment, window_seconds, max_amount, max_count, enabled, created"
+
+type LimitStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewLimitStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) LimitStorage {
+	return LimitStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanLimitRule(row pgx.Row) (model.LimitRule, error) {
+	var rule model.LimitRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.Operation,
+		&rule.Segment,
+		&rule.WindowSeconds,
+		&rule.MaxAmount,
+		&rule.MaxCount,
+		&rule.Enabled,
+		&rule.Created,
+	)
+
+	return rule, err
+}
+
+func limitRuleNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrLimitRuleNotFound, id),
+		service.Details{"rule_id": id},
+	)
+}
+
+func (s LimitStorage) Create(ctx context.Context, rule model.LimitRule) (model.LimitRule, error) {
+	query := "INSERT INTO limit_rules (operation, segment, window_seconds, max_amount, max_count, enabled) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + limitRuleColumns
+	rule, err := scanLimitRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.Operation,
+		rule.Segment,
+		rule.WindowSeconds,
+		rule.MaxAmount,
+		rule.MaxCount,
+		rule.Enabled,
+	))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.LimitRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+// Update replaces all fields of the rule.
+func (s LimitStorage) Update(ctx context.Context, rule model.LimitRule) (model.LimitRule, error) {
+	id := rule.ID
+	query := "UPDATE limit_rules SET operation=$1, segment=$2, window_seconds=$3, max_amount=$4, max_count=$5, " +
+		"enabled=$6 WHERE id=$7 RETURNING " + limitRuleColumns
+	rule, err := scanLimitRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.Operation,
+		rule.Segment,
+		rule.WindowSeconds,
+		rule.MaxAmount,
+		rule.MaxCount,
+		rule.Enabled,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.LimitRule{}, limitRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.LimitRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s LimitStorage) Delete(ctx context.Context, id int) (model.LimitRule, error) {
+	query := "DELETE FROM limit_rules WHERE id=$1 RETURNING " + limitRuleColumns
+	rule, err := scanLimitRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.LimitRule{}, limitRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.LimitRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s LimitStorage) Get(ctx context.Context, id int) (model.LimitRule, error) {
+	query := "SELECT " + limitRuleColumns + " FROM limit_rules WHERE id=$1"
+	rule, err := scanLimitRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.LimitRule{}, limitRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.LimitRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s LimitStorage) List(ctx context.Context) ([]model.LimitRule, error) {
+	query := "SELECT " + limitRuleColumns + " FROM limit_rules ORDER BY id"
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	rules := make([]model.LimitRule, 0)
+	for rows.Next() {
+		rule, err := scanLimitRule(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan limit rule values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return rules, nil
+}
+
+// limitUsageQueries sum up and count the operations of the user within the
+// last $2 seconds.
+var limitUsageQueries = map[model.LimitOperation]string{
+	model.LimitTopUp: "SELECT coalesce(sum(amount), 0), count(*) FROM journal " +
+		"WHERE user_id=$1 AND type='topup' AND created > now() - $2::int * interval '1 second'",
+	// held transfers are counted until they are captured, when they are
+	// booked as transfer_out entries
+	model.LimitTransfer: "SELECT coalesce(sum(amount), 0), count(*) FROM (" +
+		"SELECT -amount AS amount FROM journal " +
+		"WHERE user_id=$1 AND type='transfer_out' AND created > now() - $2::int * interval '1 second' " +
+		"UNION ALL SELECT amount FROM pending_transfers " +
+		"WHERE sender_id=$1 AND status='held' AND created > now() - $2::int * interval '1 second'" +
+		") AS transfers",
+	model.LimitReserve: "SELECT coalesce(sum(cost), 0), count(*) FROM reserves " +
+		"WHERE user_id=$1 AND status<>'rejected' AND created > now() - $2::int * interval '1 second'",
+}
+
+// checkLimits evaluates the enabled limit rules of the operation against the
+// user's usage inside the transaction. The user row is locked first, so
+// concurrent operations of the same user are counted one after another.
+func checkLimits(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	userID int,
+	operation model.LimitOperation,
+	amount int,
+) error {
+	query := "SELECT segment FROM users WHERE id=$1 FOR UPDATE"
+	var segment string
+	if err := tx.QueryRow(ctx, query, userID).Scan(&segment); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, userID),
+				service.Details{"user_id": userID},
+			)
+		}
+
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	query = "SELECT " + limitRuleColumns + " FROM limit_rules " +
+		"WHERE enabled AND operation=$1 AND (segment IS NULL OR segment=$2) ORDER BY id"
+	rows, err := tx.Query(ctx, query, operation, segment)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	rules := make([]model.LimitRule, 0)
+	for rows.Next() {
+		rule, err := scanLimitRule(rows)
+		if err != nil {
+			rows.Close()
+			logger.Errorf("can't scan limit rule values %q: %v", query, err)
+			return service.ErrInternalServerError
+		}
+
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Errorf("error occurred during rows scanning: %v", err)
+		return service.ErrInternalServerError
+	}
+
+	query = limitUsageQueries[operation]
+	for _, rule := range rules {
+		var usedAmount, usedCount int
+		if err = tx.QueryRow(ctx, query, userID, rule.WindowSeconds).Scan(&usedAmount, &usedCount); err != nil {
+			logger.Errorf("can't process query %q: %v", query, err)
+			return service.ErrInternalServerError
+		}
+
+		amountExceeded := rule.MaxAmount != nil && usedAmount+amount > *rule.MaxAmount
+		countExceeded := rule.MaxCount != nil && usedCount+1 > *rule.MaxCount
+		if !amountExceeded && !countExceeded {
+			continue
+		}
+
+		details := service.Details{
+			"rule_id":        rule.ID,
+			"operation":      rule.Operation,
+			"window_seconds": rule.WindowSeconds,
+		}
+		if rule.MaxAmount != nil {
+			details["max_amount"] = *rule.MaxAmount
+			details["remaining_amount"] = remaining(*rule.MaxAmount, usedAmount)
+		}
+		if rule.MaxCount != nil {
+			details["max_count"] = *rule.MaxCount
+			details["remaining_count"] = remaining(*rule.MaxCount, usedCount)
+		}
+
+		return service.WithDetails(
+			fmt.Errorf("%w: rule %d", service.ErrLimitExceeded, rule.ID),
+			details,
+		)
+	}
+
+	return nil
+}
+
+// remaining returns the allowance left under the cap, which is never negative
+// even if the cap has been lowered below the usage.
+func remaining(limit, used int) int {
+	if used > limit {
+		return 0
+	}
+
+	return limit - used
+}
@@ -9,24 +9,49 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
 	"github.com/s02190058/billing-service/internal/service"
 	"go.uber.org/zap"
 )
 
 type OrderStorage struct {
-	logger *zap.SugaredLogger
-	db     *pgxpool.Pool
+	logger   *zap.SugaredLogger
+	db       *pgxpool.Pool
+	chainKey []byte
 }
 
-func NewOrderStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) OrderStorage {
+func NewOrderStorage(logger *zap.SugaredLogger, db *pgxpool.Pool, chainKey []byte) OrderStorage {
 	return OrderStorage{
-		logger: logger,
-		db:     db,
+		logger:   logger,
+		db:       db,
+		chainKey: chainKey,
 	}
 }
 
-func (s OrderStorage) Reserve(orderID, userID, serviceID int, cost int) error {
-	tx, err := s.db.Begin(context.Background())
+func reserveDetails(orderID, userID, serviceID int) service.Details {
+	return service.Details{
+		"order_id":   orderID,
+		"user_id":    userID,
+		"service_id": serviceID,
+	}
+}
+
+func (s OrderStorage) Reserve(ctx context.Context, orderID, userID, serviceID int, cost int) error {
+	return s.reserve(ctx, model.ReserveOrder, orderID, userID, serviceID, cost)
+}
+
+// ReserveCharge reserves the price of the subscription charge under its id.
+func (s OrderStorage) ReserveCharge(ctx context.Context, chargeID, userID, serviceID int, cost int) error {
+	return s.reserve(ctx, model.ReserveSubscription, chargeID, userID, serviceID, cost)
+}
+
+func (s OrderStorage) reserve(
+	ctx context.Context,
+	source model.ReserveSource,
+	orderID, userID, serviceID int,
+	cost int,
+) error {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		s.logger.Errorf("can't begin transaction: %v", err)
 		return service.ErrInternalServerError
@@ -37,37 +62,33 @@ func (s OrderStorage) Reserve(orderID, userID, serviceID int, cost int) error {
 		}
 	}()
 
-	query := "UPDATE users SET balance=balance-$1 WHERE id=$2 RETURNING balance"
-	var balance int
-	if err = tx.QueryRow(
-		context.Background(),
-		query,
-		cost,
-		userID,
-	).Scan(&balance); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return fmt.Errorf("%w: %d", service.ErrUserNotFound, userID)
-		}
+	if err = checkLimits(ctx, s.logger, tx, userID, model.LimitReserve, cost); err != nil {
+		return err
+	}
 
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return service.ErrInternalServerError
+	// bonus money is spent first, the rest is taken from the balance
+	portions, bonus, err := spendBonus(ctx, s.logger, tx, userID, serviceID, cost)
+	if err != nil {
+		return err
 	}
 
-	if balance < 0 {
-		return fmt.Errorf("%w: %d", service.ErrInsufficientFunds, balance+cost)
+	if _, err = debitBalance(ctx, s.logger, tx, userID, cost-bonus, false); err != nil {
+		return err
 	}
 
-	query = "INSERT INTO reserves (order_id, user_id, service_id, cost, status) " +
-		"VALUES ($1, $2, $3, $4, $5)"
+	query := "INSERT INTO reserves (source, order_id, user_id, service_id, cost, bonus, status) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7)"
 
 	status := "reserved"
 	if _, err = tx.Exec(
-		context.Background(),
+		ctx,
 		query,
+		source,
 		orderID,
 		userID,
 		serviceID,
 		cost,
+		bonus,
 		status,
 	); err != nil {
 		var pgErr *pgconn.PgError
@@ -75,11 +96,14 @@ func (s OrderStorage) Reserve(orderID, userID, serviceID int, cost int) error {
 			switch pgErr.Code {
 			// unique_violation
 			case "23505":
-				return fmt.Errorf("%w: (%d,%d,%d)",
-					service.ErrAlreadyReserved,
-					orderID,
-					userID,
-					serviceID,
+				return service.WithDetails(
+					fmt.Errorf("%w: (%d,%d,%d)",
+						service.ErrAlreadyReserved,
+						orderID,
+						userID,
+						serviceID,
+					),
+					reserveDetails(orderID, userID, serviceID),
 				)
 			}
 		}
@@ -88,7 +112,16 @@ func (s OrderStorage) Reserve(orderID, userID, serviceID int, cost int) error {
 		return service.ErrInternalServerError
 	}
 
-	if err = tx.Commit(context.Background()); err != nil {
+	query = "INSERT INTO reserve_bonuses (source, order_id, user_id, service_id, grant_id, amount) " +
+		"VALUES ($1, $2, $3, $4, $5, $6)"
+	for _, portion := range portions {
+		if _, err = tx.Exec(ctx, query, source, orderID, userID, serviceID, portion.grantID, portion.amount); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return service.ErrInternalServerError
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
 		s.logger.Errorf("can't commit transaction: %v", err)
 		return service.ErrInternalServerError
 	}
@@ -96,8 +129,22 @@ func (s OrderStorage) Reserve(orderID, userID, serviceID int, cost int) error {
 	return nil
 }
 
-func (s OrderStorage) Confirm(orderID, userID, serviceID int, cost int) error {
-	tx, err := s.db.Begin(context.Background())
+func (s OrderStorage) Confirm(ctx context.Context, orderID, userID, serviceID int, cost int) error {
+	return s.confirm(ctx, model.ReserveOrder, orderID, userID, serviceID, cost)
+}
+
+// ConfirmCharge confirms the reserve of the subscription charge.
+func (s OrderStorage) ConfirmCharge(ctx context.Context, chargeID, userID, serviceID int, cost int) error {
+	return s.confirm(ctx, model.ReserveSubscription, chargeID, userID, serviceID, cost)
+}
+
+func (s OrderStorage) confirm(
+	ctx context.Context,
+	source model.ReserveSource,
+	orderID, userID, serviceID int,
+	cost int,
+) error {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		s.logger.Errorf("can't begin transaction: %v", err)
 		return service.ErrInternalServerError
@@ -109,49 +156,108 @@ func (s OrderStorage) Confirm(orderID, userID, serviceID int, cost int) error {
 	}()
 
 	query := "UPDATE reserves SET status=$1 WHERE " +
-		"order_id=$2 AND user_id=$3 AND service_id=$4 AND cost=$5 AND status=$6"
+		"source=$2 AND order_id=$3 AND user_id=$4 AND service_id=$5 AND cost=$6 AND status=$7 RETURNING bonus"
 
 	status := "confirmed"
 	prevStatus := "reserved"
-	tag, err := tx.Exec(
-		context.Background(),
+	var bonus int
+	if err = tx.QueryRow(
+		ctx,
 		query,
 		status,
+		source,
 		orderID,
 		userID,
 		serviceID,
 		cost,
 		prevStatus,
-	)
-	if err != nil {
+	).Scan(&bonus); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return service.WithDetails(
+				fmt.Errorf(
+					"%w: (%d,%d,%d)",
+					service.ErrRecordNotFound,
+					orderID,
+					userID,
+					serviceID,
+				),
+				reserveDetails(orderID, userID, serviceID),
+			)
+		}
+
 		s.logger.Errorf("can't process query %q: %v", query, err)
 		return service.ErrInternalServerError
 	}
 
-	if tag.RowsAffected() == 0 {
-		return fmt.Errorf(
-			"%w: (%d,%d,%d)",
-			service.ErrRecordNotFound,
-			orderID,
-			userID,
-			serviceID,
-		)
+	// the platform fee is a part of the cost, so the user pays the cost in
+	// total and the fee line is split out of the payment; the journal holds
+	// money only, so the fee is limited by the part not paid with bonus
+	quote, err := quoteFee(ctx, s.logger, tx, model.FeePayment, &serviceID, cost)
+	if err != nil {
+		return err
+	}
+	paid := cost - bonus
+	if quote.Fee > paid {
+		quote.Fee = paid
+	}
+	quote.Net = paid - quote.Fee
+
+	metadata := map[string]any{}
+	if bonus > 0 {
+		metadata["bonus"] = bonus
 	}
 
-	query = "INSERT INTO journal (user_id, amount, message) VALUES ($1, $2, $3)"
-	message := fmt.Sprintf("payment for the service %d", serviceID)
-	if _, err = tx.Exec(
-		context.Background(),
-		query,
-		userID,
-		-cost,
-		message,
-	); err != nil {
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return service.ErrInternalServerError
+	// subscription charges are no orders of the client, so their entries
+	// refer to the subscription instead
+	order := &orderID
+	cashbackReason := fmt.Sprintf("cashback for the order %d", orderID)
+	if source == model.ReserveSubscription {
+		query = "SELECT subscription_id FROM subscription_charges WHERE id=$1"
+		var subscriptionID int
+		if err = tx.QueryRow(ctx, query, orderID).Scan(&subscriptionID); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return service.ErrInternalServerError
+		}
+
+		metadata["subscription_id"] = subscriptionID
+		metadata["charge_id"] = orderID
+		order = nil
+		cashbackReason = fmt.Sprintf("cashback for the subscription %d", subscriptionID)
+	}
+
+	payment, err := insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID:    userID,
+		Amount:    -quote.Net,
+		Type:      model.TransactionPayment,
+		OrderID:   order,
+		ServiceID: &serviceID,
+		Metadata:  metadata,
+		Message:   fmt.Sprintf("payment for the service %d", serviceID),
+	})
+	if err != nil {
+		return err
+	}
+
+	if quote.Fee > 0 {
+		if err = bookFee(ctx, s.logger, tx, userID, quote, model.Transaction{
+			OrderID:   order,
+			ServiceID: &serviceID,
+			PairID:    &payment.ID,
+			Message:   fmt.Sprintf("platform fee for the service %d", serviceID),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err = grantCashback(ctx, s.logger, tx, cashbackReason, userID, serviceID, paid); err != nil {
+		return err
 	}
 
-	if err = tx.Commit(context.Background()); err != nil {
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
 		s.logger.Errorf("can't commit transaction: %v", err)
 		return service.ErrInternalServerError
 	}
@@ -159,8 +265,8 @@ func (s OrderStorage) Confirm(orderID, userID, serviceID int, cost int) error {
 	return nil
 }
 
-func (s OrderStorage) Reject(orderID, userID, serviceID int, cost int) error {
-	tx, err := s.db.Begin(context.Background())
+func (s OrderStorage) Reject(ctx context.Context, orderID, userID, serviceID int, cost int) error {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		s.logger.Errorf("can't begin transaction: %v", err)
 		return service.ErrInternalServerError
@@ -172,47 +278,49 @@ func (s OrderStorage) Reject(orderID, userID, serviceID int, cost int) error {
 	}()
 
 	query := "UPDATE reserves SET status=$1 WHERE " +
-		"order_id=$2 AND user_id=$3 AND service_id=$4 AND cost=$5 AND status=$6"
+		"source=$2 AND order_id=$3 AND user_id=$4 AND service_id=$5 AND cost=$6 AND status=$7 RETURNING bonus"
 
 	status := "rejected"
 	prevStatus := "reserved"
-	tag, err := tx.Exec(
-		context.Background(),
+	var bonus int
+	if err = tx.QueryRow(
+		ctx,
 		query,
 		status,
+		model.ReserveOrder,
 		orderID,
 		userID,
 		serviceID,
 		cost,
 		prevStatus,
-	)
-	if err != nil {
+	).Scan(&bonus); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return service.WithDetails(
+				fmt.Errorf(
+					"%w: (%d,%d,%d)",
+					service.ErrRecordNotFound,
+					orderID,
+					userID,
+					serviceID,
+				),
+				reserveDetails(orderID, userID, serviceID),
+			)
+		}
+
 		s.logger.Errorf("can't process query %q: %v", query, err)
 		return service.ErrInternalServerError
 	}
 
-	if tag.RowsAffected() == 0 {
-		return fmt.Errorf(
-			"%w: (%d,%d,%d)",
-			service.ErrRecordNotFound,
-			orderID,
-			userID,
-			serviceID,
-		)
+	// each part goes back where it was taken from
+	if err = returnBonus(ctx, s.logger, tx, model.ReserveOrder, orderID, userID, serviceID); err != nil {
+		return err
 	}
 
-	query = "UPDATE users SET balance=balance+$1 WHERE id=$2"
-	if _, err = tx.Exec(
-		context.Background(),
-		query,
-		cost,
-		userID,
-	); err != nil {
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return service.ErrInternalServerError
+	if _, err = creditBalance(ctx, s.logger, tx, userID, cost-bonus); err != nil {
+		return err
 	}
 
-	if err = tx.Commit(context.Background()); err != nil {
+	if err = tx.Commit(ctx); err != nil {
 		s.logger.Errorf("can't commit transaction: %v", err)
 		return service.ErrInternalServerError
 	}
@@ -220,21 +328,48 @@ func (s OrderStorage) Reject(orderID, userID, serviceID int, cost int) error {
 	return nil
 }
 
-func (s OrderStorage) Report(year int, month time.Month) ([][]string, error) {
+func (s OrderStorage) Report(ctx context.Context, year int, month time.Month) ([][]string, error) {
 	from := time.Date(year, month, 0, 0, 0, 0, 0, time.UTC)
 	to := from.AddDate(0, 1, 0)
 
-	query := "SELECT service_id, SUM(cost) AS total_revenue FROM reserves " +
-		"WHERE status=$1 AND created>=$2 AND created<$3 " +
-		"GROUP BY service_id"
+	// platform fees are the fee lines of the revenue account; fees without a
+	// service are transfer fees and go to a separate row; subscription revenue
+	// is the part of the total paid by subscription charges; disputes count
+	// towards the month of the payment they dispute, frozen from its total
+	// while open and taken out of it as chargebacks when refunded; services
+	// are named after the catalog
+	query := "SELECT coalesce(coalesce(r.service_id, f.service_id, d.service_id)::text, 'transfers'), " +
+		"(coalesce(r.total_revenue, 0) - coalesce(d.disputed, 0) - coalesce(d.chargebacks, 0))::text, " +
+		"coalesce(f.fee, 0)::text, coalesce(r.subscription_revenue, 0)::text, " +
+		"coalesce(d.disputed, 0)::text, coalesce(d.chargebacks, 0)::text, coalesce(c.name, '') FROM " +
+		"(SELECT service_id, SUM(cost) AS total_revenue, " +
+		"coalesce(SUM(cost) FILTER (WHERE source=$8), 0) AS subscription_revenue FROM reserves " +
+		"WHERE status=$1 AND created>=$2 AND created<$3 GROUP BY service_id) r " +
+		"FULL JOIN (SELECT service_id, SUM(amount) AS fee FROM journal " +
+		"WHERE user_id=$4 AND type=$5 AND created>=$2 AND created<$3 GROUP BY service_id) f " +
+		"ON r.service_id=f.service_id " +
+		"FULL JOIN (SELECT d.service_id, " +
+		"coalesce(SUM(d.amount) FILTER (WHERE d.status=$6), 0) AS disputed, " +
+		"coalesce(SUM(d.amount) FILTER (WHERE d.status=$7), 0) AS chargebacks FROM disputes d " +
+		"JOIN reserves p ON p.source=$9 AND p.order_id=d.order_id AND p.user_id=d.user_id AND " +
+		"p.service_id=d.service_id WHERE p.created>=$2 AND p.created<$3 GROUP BY d.service_id) d " +
+		"ON d.service_id=coalesce(r.service_id, f.service_id) " +
+		"LEFT JOIN catalog_items c ON c.id=coalesce(r.service_id, f.service_id, d.service_id) " +
+		"ORDER BY coalesce(r.service_id, f.service_id, d.service_id) NULLS LAST"
 
 	status := "confirmed"
 	rows, err := s.db.Query(
-		context.Background(),
+		ctx,
 		query,
 		status,
 		from,
 		to,
+		model.RevenueAccountID,
+		model.TransactionFee,
+		model.DisputeOpen,
+		model.DisputeRefunded,
+		model.ReserveSubscription,
+		model.ReserveOrder,
 	)
 	if err != nil {
 		s.logger.Errorf("can't process query %q: %v", query, err)
@@ -245,12 +380,17 @@ func (s OrderStorage) Report(year int, month time.Month) ([][]string, error) {
 	for rows.Next() {
 		var serviceID string
 		var totalRevenue string
-		if err = rows.Scan(&serviceID, &totalRevenue); err != nil {
+		var fee string
+		var subscriptionRevenue string
+		var disputed string
+		var chargebacks string
+		var name string
+		if err = rows.Scan(&serviceID, &totalRevenue, &fee, &subscriptionRevenue, &disputed, &chargebacks, &name); err != nil {
 			s.logger.Errorf("can't scan service values")
 			return nil, service.ErrInternalServerError
 		}
 
-		services = append(services, []string{serviceID, totalRevenue})
+		services = append(services, []string{serviceID, totalRevenue, fee, subscriptionRevenue, disputed, chargebacks, name})
 	}
 	if err = rows.Err(); err != nil {
 		s.logger.Errorf("error occurred during rows scanning: %v", err)
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+type OutboxStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewOutboxStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) OutboxStorage {
+	return OutboxStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+// Unpublished returns up to limit events which haven't been published yet,
+// oldest first.
+func (s OutboxStorage) Unpublished(ctx context.Context, limit int) ([]model.Event, error) {
+	query := "SELECT id, type, user_id, payload, created FROM outbox WHERE published IS NULL ORDER BY id LIMIT $1"
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	events := make([]model.Event, 0)
+	for rows.Next() {
+		var event model.Event
+		if err = rows.Scan(&event.ID, &event.Type, &event.UserID, &event.Payload, &event.Created); err != nil {
+			s.logger.Errorf("can't scan event values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return events, nil
+}
+
+// MarkPublished records that the events have been published.
+func (s OutboxStorage) MarkPublished(ctx context.Context, ids []int) error {
+	query := "UPDATE outbox SET published=now() WHERE id=ANY($1)"
+	if _, err := s.db.Exec(ctx, query, ids); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
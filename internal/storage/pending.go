@@ -0,0 +1,377 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const pendingTransferColumns = "id, sender_id, receiver_id, amount, fee, fee_rule_id, status, external_ref, metadata, " +
+	"transaction_id, expires, created, settled"
+
+type PendingTransferStorage struct {
+	logger   *zap.SugaredLogger
+	db       *pgxpool.Pool
+	chainKey []byte
+}
+
+func NewPendingTransferStorage(logger *zap.SugaredLogger, db *pgxpool.Pool, chainKey []byte) PendingTransferStorage {
+	return PendingTransferStorage{
+		logger:   logger,
+		db:       db,
+		chainKey: chainKey,
+	}
+}
+
+func scanPendingTransfer(row pgx.Row, extra ...any) (model.PendingTransfer, error) {
+	var transfer model.PendingTransfer
+	err := row.Scan(append([]any{
+		&transfer.ID,
+		&transfer.SenderID,
+		&transfer.ReceiverID,
+		&transfer.Amount,
+		&transfer.Fee,
+		&transfer.FeeRuleID,
+		&transfer.Status,
+		&transfer.ExternalRef,
+		&transfer.Metadata,
+		&transfer.TransactionID,
+		&transfer.Expires,
+		&transfer.Created,
+		&transfer.Settled,
+	}, extra...)...)
+
+	return transfer, err
+}
+
+func pendingTransferNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrPendingTransferNotFound, id),
+		service.Details{"transfer_id": id},
+	)
+}
+
+func pendingTransferNotHeld(id int, status model.PendingTransferStatus) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %s", service.ErrPendingTransferNotHeld, status),
+		service.Details{"transfer_id": id, "status": status},
+	)
+}
+
+// Hold takes the amount and the transfer fee from the sender and keeps them
+// until the transfer is settled. Held transfers count towards the transfer
+// limits of the sender.
+func (s PendingTransferStorage) Hold(
+	ctx context.Context,
+	transfer model.PendingTransfer,
+	timeoutSeconds int,
+) (model.PendingTransfer, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	if err = checkLimits(ctx, s.logger, tx, transfer.SenderID, model.LimitTransfer, transfer.Amount); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	quote, err := quoteFee(ctx, s.logger, tx, model.FeeTransfer, nil, transfer.Amount)
+	if err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if _, err = debitBalance(ctx, s.logger, tx, transfer.SenderID, quote.Total, false); err != nil {
+		return model.PendingTransfer{}, err
+	}
+	if err = lockReceiver(ctx, s.logger, tx, transfer.ReceiverID); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	query := "INSERT INTO pending_transfers " +
+		"(sender_id, receiver_id, amount, fee, fee_rule_id, status, external_ref, metadata, expires) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, coalesce($8, '{}'::jsonb), now() + $9::int * interval '1 second') " +
+		"RETURNING " + pendingTransferColumns
+	held, err := scanPendingTransfer(tx.QueryRow(
+		ctx,
+		query,
+		transfer.SenderID,
+		transfer.ReceiverID,
+		transfer.Amount,
+		quote.Fee,
+		quote.RuleID,
+		model.PendingHeld,
+		transfer.ExternalRef,
+		transfer.Metadata,
+		timeoutSeconds,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		// foreign_key_violation, the sender is locked by now
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return model.PendingTransfer{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, transfer.ReceiverID),
+				service.Details{"user_id": transfer.ReceiverID},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	return held, nil
+}
+
+func (s PendingTransferStorage) Get(ctx context.Context, id int) (model.PendingTransfer, error) {
+	query := "SELECT " + pendingTransferColumns + " FROM pending_transfers WHERE id=$1"
+	transfer, err := scanPendingTransfer(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.PendingTransfer{}, pendingTransferNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	return transfer, nil
+}
+
+// List returns the transfers the user sent or receives, newest first,
+// optionally in the status only.
+func (s PendingTransferStorage) List(
+	ctx context.Context,
+	userID int,
+	status *model.PendingTransferStatus,
+) ([]model.PendingTransfer, error) {
+	query := "SELECT " + pendingTransferColumns + " FROM pending_transfers " +
+		"WHERE (sender_id=$1 OR receiver_id=$1) AND ($2::text IS NULL OR status=$2) ORDER BY id DESC"
+	rows, err := s.db.Query(ctx, query, userID, status)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	transfers := make([]model.PendingTransfer, 0)
+	for rows.Next() {
+		transfer, err := scanPendingTransfer(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan pending transfer values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		transfers = append(transfers, transfer)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return transfers, nil
+}
+
+// lockHeld locks the transfer and makes sure it can still be settled. A
+// transfer past its expiry is reported as expired even if it hasn't been
+// released yet.
+func (s PendingTransferStorage) lockHeld(ctx context.Context, tx pgx.Tx, id int) (model.PendingTransfer, error) {
+	query := "SELECT " + pendingTransferColumns + ", expires <= now() FROM pending_transfers WHERE id=$1 FOR UPDATE"
+	var expired bool
+	transfer, err := scanPendingTransfer(tx.QueryRow(ctx, query, id), &expired)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.PendingTransfer{}, pendingTransferNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	if transfer.Status == model.PendingHeld && expired {
+		transfer.Status = model.PendingExpired
+	}
+	if transfer.Status != model.PendingHeld {
+		return model.PendingTransfer{}, pendingTransferNotHeld(id, transfer.Status)
+	}
+
+	return transfer, nil
+}
+
+// Capture credits the held amount to the receiver, books the transfer and
+// its fee to the journal and returns the captured transfer.
+func (s PendingTransferStorage) Capture(ctx context.Context, id int) (model.PendingTransfer, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	transfer, err := s.lockHeld(ctx, tx, id)
+	if err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if _, err = creditBalance(ctx, s.logger, tx, transfer.ReceiverID, transfer.Amount); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	metadata := make(map[string]any, len(transfer.Metadata)+1)
+	for k, v := range transfer.Metadata {
+		metadata[k] = v
+	}
+	metadata["pending_transfer_id"] = transfer.ID
+
+	out, err := insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID:         transfer.SenderID,
+		Amount:         -transfer.Amount,
+		Type:           model.TransactionTransferOut,
+		CounterpartyID: &transfer.ReceiverID,
+		ExternalRef:    transfer.ExternalRef,
+		Metadata:       metadata,
+		Message:        fmt.Sprintf("transfer to the user %d", transfer.ReceiverID),
+	})
+	if err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if _, err = insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID:         transfer.ReceiverID,
+		Amount:         transfer.Amount,
+		Type:           model.TransactionTransferIn,
+		CounterpartyID: &transfer.SenderID,
+		ExternalRef:    transfer.ExternalRef,
+		Metadata:       metadata,
+		PairID:         &out.ID,
+		Message:        fmt.Sprintf("transfer from the user %d", transfer.SenderID),
+	}); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	// the fee was quoted and held together with the amount
+	if transfer.Fee > 0 {
+		if err = bookFee(ctx, s.logger, tx, transfer.SenderID, model.FeeQuote{
+			Operation: model.FeeTransfer,
+			Amount:    transfer.Amount,
+			Fee:       transfer.Fee,
+			RuleID:    transfer.FeeRuleID,
+		}, model.Transaction{
+			ExternalRef: transfer.ExternalRef,
+			PairID:      &out.ID,
+			Message:     fmt.Sprintf("fee for the transfer to the user %d", transfer.ReceiverID),
+		}); err != nil {
+			return model.PendingTransfer{}, err
+		}
+	}
+
+	if transfer, err = s.settle(ctx, tx, id, model.PendingCaptured, &out.ID); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	return transfer, nil
+}
+
+// Void returns the held amount and fee to the sender.
+func (s PendingTransferStorage) Void(ctx context.Context, id int) (model.PendingTransfer, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	transfer, err := s.lockHeld(ctx, tx, id)
+	if err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if _, err = creditBalance(ctx, s.logger, tx, transfer.SenderID, transfer.Amount+transfer.Fee); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if transfer, err = s.settle(ctx, tx, id, model.PendingVoided, nil); err != nil {
+		return model.PendingTransfer{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	return transfer, nil
+}
+
+func (s PendingTransferStorage) settle(
+	ctx context.Context,
+	tx pgx.Tx,
+	id int,
+	status model.PendingTransferStatus,
+	transactionID *int,
+) (model.PendingTransfer, error) {
+	query := "UPDATE pending_transfers SET status=$1, transaction_id=$2, settled=now() WHERE id=$3 " +
+		"RETURNING " + pendingTransferColumns
+	transfer, err := scanPendingTransfer(tx.QueryRow(ctx, query, status, transactionID, id))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.PendingTransfer{}, service.ErrInternalServerError
+	}
+
+	return transfer, nil
+}
+
+// Expire returns the money of up to limit transfers past their expiry to the
+// senders and returns the number of expired transfers. Transfers locked by a
+// capture or a void are left to them.
+func (s PendingTransferStorage) Expire(ctx context.Context, limit int) (int, error) {
+	query := "WITH expired AS (" +
+		"UPDATE pending_transfers SET status=$1, settled=now() WHERE id IN (" +
+		"SELECT id FROM pending_transfers WHERE status=$2 AND expires <= now() " +
+		"ORDER BY expires LIMIT $3 FOR UPDATE SKIP LOCKED" +
+		") RETURNING sender_id, amount+fee AS total" +
+		"), released AS (" +
+		"UPDATE users SET balance=balance+e.total " +
+		"FROM (SELECT sender_id, sum(total) AS total FROM expired GROUP BY sender_id) e " +
+		"WHERE users.id=e.sender_id" +
+		") SELECT count(*) FROM expired"
+	var expired int
+	if err := s.db.QueryRow(ctx, query, model.PendingExpired, model.PendingHeld, limit).Scan(&expired); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return 0, service.ErrInternalServerError
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+// userFigures selects the balance of the users, the money taken from it by
+// open reserves ($1) and held transfers ($2), and the sum of their journal.
+const userFigures = "SELECT u.id, u.balance, " +
+	"coalesce((SELECT SUM(cost-bonus) FROM reserves WHERE user_id=u.id AND status=$1), 0) + " +
+	"coalesce((SELECT SUM(amount+fee) FROM pending_transfers WHERE sender_id=u.id AND status=$2), 0) AS open, " +
+	"coalesce((SELECT SUM(amount) FROM journal WHERE user_id=u.id), 0) AS journal FROM users u"
+
+const reconciliationColumns = "id, requested_by, repair, users, mismatched, drift, started, finished"
+
+type ReconciliationStorage struct {
+	logger   *zap.SugaredLogger
+	db       *pgxpool.Pool
+	chainKey []byte
+}
+
+func NewReconciliationStorage(logger *zap.SugaredLogger, db *pgxpool.Pool, chainKey []byte) ReconciliationStorage {
+	return ReconciliationStorage{
+		logger:   logger,
+		db:       db,
+		chainKey: chainKey,
+	}
+}
+
+func scanReconciliation(row pgx.Row) (model.Reconciliation, error) {
+	var run model.Reconciliation
+	err := row.Scan(
+		&run.ID,
+		&run.RequestedBy,
+		&run.Repair,
+		&run.Users,
+		&run.Mismatched,
+		&run.Drift,
+		&run.Started,
+		&run.Finished,
+	)
+
+	return run, err
+}
+
+func scanDiscrepancy(row pgx.Row) (model.Discrepancy, error) {
+	var discrepancy model.Discrepancy
+	err := row.Scan(
+		&discrepancy.UserID,
+		&discrepancy.Balance,
+		&discrepancy.Open,
+		&discrepancy.Journal,
+	)
+	discrepancy.Drift = discrepancy.Balance + discrepancy.Open - discrepancy.Journal
+
+	return discrepancy, err
+}
+
+func (s ReconciliationStorage) Start(ctx context.Context, requestedBy string, repair bool) (model.Reconciliation, error) {
+	query := "INSERT INTO reconciliation_runs (requested_by, repair) VALUES ($1, $2) RETURNING " + reconciliationColumns
+	run, err := scanReconciliation(s.db.QueryRow(ctx, query, requestedBy, repair))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+
+	return run, nil
+}
+
+// Discrepancies returns the number of users and the users whose balance
+// doesn't match the journal. Both are read from a single snapshot, so
+// operations in progress don't show up as discrepancies.
+func (s ReconciliationStorage) Discrepancies(ctx context.Context) (int, []model.Discrepancy, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return 0, nil, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT count(*) FROM users"
+	var users int
+	if err = tx.QueryRow(ctx, query).Scan(&users); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return 0, nil, service.ErrInternalServerError
+	}
+
+	query = "SELECT id, balance, open, journal FROM (" + userFigures + ") f " +
+		"WHERE balance + open <> journal ORDER BY id"
+	rows, err := tx.Query(ctx, query, "reserved", model.PendingHeld)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return 0, nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	discrepancies := make([]model.Discrepancy, 0)
+	for rows.Next() {
+		discrepancy, err := scanDiscrepancy(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan discrepancy values %q: %v", query, err)
+			return 0, nil, service.ErrInternalServerError
+		}
+
+		discrepancies = append(discrepancies, discrepancy)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return 0, nil, service.ErrInternalServerError
+	}
+
+	return users, discrepancies, nil
+}
+
+// Repair aligns the journal of the user with the balance by an adjustment
+// entry of the drift. The drift is calculated again with the user locked, so
+// a discrepancy repaired meanwhile is returned without an entry.
+func (s ReconciliationStorage) Repair(ctx context.Context, runID, userID int) (model.Discrepancy, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Discrepancy{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT id FROM users WHERE id=$1 FOR UPDATE"
+	if _, err = tx.Exec(ctx, query, userID); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Discrepancy{}, service.ErrInternalServerError
+	}
+
+	query = userFigures + " WHERE u.id=$3"
+	discrepancy, err := scanDiscrepancy(tx.QueryRow(ctx, query, "reserved", model.PendingHeld, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Discrepancy{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, userID),
+				service.Details{"user_id": userID},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Discrepancy{}, service.ErrInternalServerError
+	}
+	if discrepancy.Drift == 0 {
+		return discrepancy, nil
+	}
+
+	entry, err := insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID: userID,
+		Amount: discrepancy.Drift,
+		Type:   model.TransactionAdjustment,
+		Metadata: map[string]any{
+			"reconciliation_id": runID,
+			"balance":           discrepancy.Balance,
+			"open":              discrepancy.Open,
+			"journal":           discrepancy.Journal,
+		},
+		Message: fmt.Sprintf("reconciliation %d: journal aligned with the balance", runID),
+	})
+	if err != nil {
+		return model.Discrepancy{}, err
+	}
+	discrepancy.TransactionID = &entry.ID
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return model.Discrepancy{}, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Discrepancy{}, service.ErrInternalServerError
+	}
+
+	return discrepancy, nil
+}
+
+// Finish records the discrepancies and the totals of the run.
+func (s ReconciliationStorage) Finish(ctx context.Context, run model.Reconciliation) (model.Reconciliation, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "INSERT INTO reconciliation_items (run_id, user_id, balance, open, journal, drift, transaction_id) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	for _, discrepancy := range run.Discrepancies {
+		if _, err = tx.Exec(
+			ctx,
+			query,
+			run.ID,
+			discrepancy.UserID,
+			discrepancy.Balance,
+			discrepancy.Open,
+			discrepancy.Journal,
+			discrepancy.Drift,
+			discrepancy.TransactionID,
+		); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return model.Reconciliation{}, service.ErrInternalServerError
+		}
+	}
+
+	discrepancies := run.Discrepancies
+	query = "UPDATE reconciliation_runs SET users=$1, mismatched=$2, drift=$3, finished=now() WHERE id=$4 " +
+		"RETURNING " + reconciliationColumns
+	if run, err = scanReconciliation(tx.QueryRow(
+		ctx,
+		query,
+		run.Users,
+		run.Mismatched,
+		run.Drift,
+		run.ID,
+	)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+	run.Discrepancies = discrepancies
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+
+	return run, nil
+}
+
+// Get returns the run with its discrepancies.
+func (s ReconciliationStorage) Get(ctx context.Context, id int) (model.Reconciliation, error) {
+	query := "SELECT " + reconciliationColumns + " FROM reconciliation_runs WHERE id=$1"
+	run, err := scanReconciliation(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Reconciliation{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrReconciliationNotFound, id),
+				service.Details{"reconciliation_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT user_id, balance, open, journal, drift, transaction_id FROM reconciliation_items " +
+		"WHERE run_id=$1 ORDER BY user_id"
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	run.Discrepancies = make([]model.Discrepancy, 0)
+	for rows.Next() {
+		var discrepancy model.Discrepancy
+		if err = rows.Scan(
+			&discrepancy.UserID,
+			&discrepancy.Balance,
+			&discrepancy.Open,
+			&discrepancy.Journal,
+			&discrepancy.Drift,
+			&discrepancy.TransactionID,
+		); err != nil {
+			s.logger.Errorf("can't scan discrepancy values %q: %v", query, err)
+			return model.Reconciliation{}, service.ErrInternalServerError
+		}
+
+		run.Discrepancies = append(run.Discrepancies, discrepancy)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.Reconciliation{}, service.ErrInternalServerError
+	}
+
+	return run, nil
+}
+
+// List returns the latest runs without their discrepancies.
+func (s ReconciliationStorage) List(ctx context.Context, limit int) ([]model.Reconciliation, error) {
+	query := "SELECT " + reconciliationColumns + " FROM reconciliation_runs ORDER BY id DESC LIMIT $1"
+	rows, err := s.db.Query(ctx, query, limit)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	runs := make([]model.Reconciliation, 0)
+	for rows.Next() {
+		run, err := scanReconciliation(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan reconciliation values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return runs, nil
+}
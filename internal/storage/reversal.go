@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+// Reverse writes compensating entries for the journal entry and, for
+// transfers, for the other side of the transfer and its fee lines. Balances
+// may go negative only if reversal.Force is set.
+func (s UserStorage) Reverse(ctx context.Context, id int, reversal model.Reversal) ([]model.Transaction, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	originals, err := lockReversal(ctx, s.logger, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, original := range originals {
+		// fee lines are undone only together with the operation they were
+		// taken for
+		if original.Reversible() || (original.Type == model.TransactionFee && original.ID != id) {
+			continue
+		}
+
+		return nil, service.WithDetails(
+			fmt.Errorf("%w: %s", service.ErrNotReversible, original.Type),
+			service.Details{"transaction_id": original.ID, "type": original.Type},
+		)
+	}
+
+	entries, err := reverseEntries(ctx, s.logger, tx, originals, reversal)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return entries, nil
+}
+
+// lockReversal locks the journal entry together with the entries it is
+// paired with (the other side of a transfer, fee lines) and returns them.
+// All of them are locked in the id order, so concurrent reversals of any of
+// them can't deadlock.
+func lockReversal(ctx context.Context, logger *zap.SugaredLogger, tx pgx.Tx, id int) ([]model.Transaction, error) {
+	query := "WITH root AS (SELECT coalesce(pair_id, id) AS id FROM journal WHERE id=$1) " +
+		"SELECT journal.id FROM journal, root WHERE journal.id=root.id OR journal.pair_id=root.id"
+	rows, err := tx.Query(ctx, query, id)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+
+	if len(ids) == 0 {
+		return nil, service.WithDetails(
+			fmt.Errorf("%w: %d", service.ErrTransactionNotFound, id),
+			service.Details{"transaction_id": id},
+		)
+	}
+
+	query = "SELECT " + journalColumns + " FROM journal WHERE id=ANY($1) ORDER BY id FOR UPDATE"
+	rows, err = tx.Query(ctx, query, ids)
+	if err != nil {
+		logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+
+	originals := make([]model.Transaction, 0, len(ids))
+	for rows.Next() {
+		original, err := scanTransaction(rows)
+		if err != nil {
+			rows.Close()
+			logger.Errorf("can't scan transaction values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		originals = append(originals, original)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return originals, nil
+}
+
+// reverseEntries writes a compensating entry for each of the locked entries
+// and links the entries to them.
+func reverseEntries(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	tx pgx.Tx,
+	originals []model.Transaction,
+	reversal model.Reversal,
+) ([]model.Transaction, error) {
+	for _, original := range originals {
+		if original.ReversedBy != nil {
+			return nil, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrAlreadyReversed, original.ID),
+				service.Details{"transaction_id": original.ID, "reversed_by": *original.ReversedBy},
+			)
+		}
+	}
+
+	metadata := map[string]any{
+		"reason": reversal.Reason,
+	}
+	if reversal.Operator != "" {
+		metadata["operator"] = reversal.Operator
+	}
+	if reversal.Force {
+		metadata["forced"] = true
+	}
+
+	entries := make([]model.Transaction, 0, len(originals))
+	for _, original := range originals {
+		originalID := original.ID
+		amount := -original.Amount
+
+		if _, err := debitBalance(ctx, logger, tx, original.UserID, -amount, reversal.Force); err != nil {
+			return nil, err
+		}
+
+		entry, err := insertJournalEntry(ctx, logger, tx, model.Transaction{
+			UserID:         original.UserID,
+			Amount:         amount,
+			Type:           model.TransactionReversal,
+			CounterpartyID: original.CounterpartyID,
+			ExternalRef:    original.ExternalRef,
+			Metadata:       metadata,
+			ReversalOf:     &originalID,
+			Message:        fmt.Sprintf("reversal of the transaction %d", original.ID),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		query := "UPDATE journal SET reversed_by=$1 WHERE id=$2"
+		if _, err = tx.Exec(ctx, query, entry.ID, original.ID); err != nil {
+			logger.Errorf("can't process query %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,600 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const (
+	riskRuleColumns     = "id, name, operation, condition, outcome, enabled, created"
+	riskDecisionColumns = "id, payload, outcome, rule_ids, facts, review_id, created"
+	riskReviewColumns   = "id, decision_id, payload, status, reviewer, comment, error, created, decided"
+	blockedUserColumns  = "user_id, reason, operator, created"
+)
+
+type RiskStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewRiskStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) RiskStorage {
+	return RiskStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanRiskRule(row pgx.Row) (model.RiskRule, error) {
+	var rule model.RiskRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.Operation,
+		&rule.Condition,
+		&rule.Outcome,
+		&rule.Enabled,
+		&rule.Created,
+	)
+
+	return rule, err
+}
+
+func scanRiskDecision(row pgx.Row) (model.RiskDecision, error) {
+	var decision model.RiskDecision
+	err := row.Scan(
+		&decision.ID,
+		&decision.Check,
+		&decision.Outcome,
+		&decision.RuleIDs,
+		&decision.Facts,
+		&decision.ReviewID,
+		&decision.Created,
+	)
+
+	return decision, err
+}
+
+func scanRiskReview(row pgx.Row) (model.RiskReview, error) {
+	var review model.RiskReview
+	err := row.Scan(
+		&review.ID,
+		&review.DecisionID,
+		&review.Check,
+		&review.Status,
+		&review.Reviewer,
+		&review.Comment,
+		&review.Error,
+		&review.Created,
+		&review.Decided,
+	)
+
+	return review, err
+}
+
+func scanBlockedUser(row pgx.Row) (model.BlockedUser, error) {
+	var blocked model.BlockedUser
+	err := row.Scan(
+		&blocked.UserID,
+		&blocked.Reason,
+		&blocked.Operator,
+		&blocked.Created,
+	)
+
+	return blocked, err
+}
+
+func riskRuleNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrRiskRuleNotFound, id),
+		service.Details{"rule_id": id},
+	)
+}
+
+func reviewNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrReviewNotFound, id),
+		service.Details{"review_id": id},
+	)
+}
+
+func (s RiskStorage) CreateRule(ctx context.Context, rule model.RiskRule) (model.RiskRule, error) {
+	query := "INSERT INTO risk_rules (name, operation, condition, outcome, enabled) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING " + riskRuleColumns
+	rule, err := scanRiskRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.Name,
+		rule.Operation,
+		rule.Condition,
+		rule.Outcome,
+		rule.Enabled,
+	))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+// UpdateRule replaces all fields of the rule.
+func (s RiskStorage) UpdateRule(ctx context.Context, rule model.RiskRule) (model.RiskRule, error) {
+	id := rule.ID
+	query := "UPDATE risk_rules SET name=$1, operation=$2, condition=$3, outcome=$4, enabled=$5 " +
+		"WHERE id=$6 RETURNING " + riskRuleColumns
+	rule, err := scanRiskRule(s.db.QueryRow(
+		ctx,
+		query,
+		rule.Name,
+		rule.Operation,
+		rule.Condition,
+		rule.Outcome,
+		rule.Enabled,
+		id,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.RiskRule{}, riskRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s RiskStorage) DeleteRule(ctx context.Context, id int) (model.RiskRule, error) {
+	query := "DELETE FROM risk_rules WHERE id=$1 RETURNING " + riskRuleColumns
+	rule, err := scanRiskRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.RiskRule{}, riskRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s RiskStorage) GetRule(ctx context.Context, id int) (model.RiskRule, error) {
+	query := "SELECT " + riskRuleColumns + " FROM risk_rules WHERE id=$1"
+	rule, err := scanRiskRule(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.RiskRule{}, riskRuleNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskRule{}, service.ErrInternalServerError
+	}
+
+	return rule, nil
+}
+
+func (s RiskStorage) ListRules(ctx context.Context) ([]model.RiskRule, error) {
+	query := "SELECT " + riskRuleColumns + " FROM risk_rules ORDER BY id"
+
+	return s.listRules(ctx, query)
+}
+
+// Rules returns the enabled rules of the operation, including the rules of
+// all operations.
+func (s RiskStorage) Rules(ctx context.Context, operation model.RiskOperation) ([]model.RiskRule, error) {
+	query := "SELECT " + riskRuleColumns + " FROM risk_rules " +
+		"WHERE enabled AND (operation IS NULL OR operation=$1) ORDER BY id"
+
+	return s.listRules(ctx, query, operation)
+}
+
+func (s RiskStorage) listRules(ctx context.Context, query string, args ...any) ([]model.RiskRule, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	rules := make([]model.RiskRule, 0)
+	for rows.Next() {
+		rule, err := scanRiskRule(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan risk rule values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		rules = append(rules, rule)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return rules, nil
+}
+
+// velocityQueries count and sum up the money that came to and left the
+// account of $1 within the last $2 seconds.
+var velocityQueries = map[model.RiskField]string{
+	model.RiskIncomingCount: "SELECT count(*), coalesce(sum(amount), 0) FROM journal " +
+		"WHERE user_id=$1 AND amount>0 AND type IN ('topup', 'transfer_in') " +
+		"AND created > now() - $2::int * interval '1 second'",
+	model.RiskOutgoingCount: "SELECT count(*), coalesce(sum(-amount), 0) FROM journal " +
+		"WHERE user_id=$1 AND amount<0 AND type IN ('transfer_out', 'payment', 'withdrawal') " +
+		"AND created > now() - $2::int * interval '1 second'",
+}
+
+// Facts measures the facts of the check. The facts are read outside of the
+// transaction of the operation, so they may lag behind concurrent operations.
+func (s RiskStorage) Facts(ctx context.Context, check model.RiskCheck, facts []model.RiskFact) (map[string]int, error) {
+	values := make(map[string]int, len(facts))
+	for _, fact := range facts {
+		if _, ok := values[fact.Key()]; ok {
+			continue
+		}
+
+		switch fact.Field {
+		case model.RiskAmount:
+			values[fact.Key()] = check.Amount
+		case model.RiskAccountAge, model.RiskBalanceShare:
+			query := "SELECT extract(epoch FROM now()-created)::int, balance+credit_limit FROM users WHERE id=$1"
+			var age, available int
+			if err := s.db.QueryRow(ctx, query, check.UserID).Scan(&age, &available); err != nil &&
+				!errors.Is(err, pgx.ErrNoRows) {
+				s.logger.Errorf("can't process query %q: %v", query, err)
+				return nil, service.ErrInternalServerError
+			}
+
+			// an operation with nothing available takes everything
+			share := 100
+			if available > 0 {
+				share = check.Amount * 100 / available
+			}
+
+			values[model.RiskFact{Field: model.RiskAccountAge}.Key()] = age
+			values[model.RiskFact{Field: model.RiskBalanceShare}.Key()] = share
+		case model.RiskUserBlocklisted, model.RiskCounterpartyBlocklisted:
+			id := &check.UserID
+			if fact.Field == model.RiskCounterpartyBlocklisted {
+				id = check.CounterpartyID
+			}
+
+			blocked, err := s.blocked(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+
+			values[fact.Key()] = blocked
+		case model.RiskIncomingCount, model.RiskIncomingAmount, model.RiskOutgoingCount, model.RiskOutgoingAmount:
+			countField, amountField := model.RiskIncomingCount, model.RiskIncomingAmount
+			if fact.Field == model.RiskOutgoingCount || fact.Field == model.RiskOutgoingAmount {
+				countField, amountField = model.RiskOutgoingCount, model.RiskOutgoingAmount
+			}
+
+			query := velocityQueries[countField]
+			var count, amount int
+			if err := s.db.QueryRow(ctx, query, check.UserID, fact.Window).Scan(&count, &amount); err != nil {
+				s.logger.Errorf("can't process query %q: %v", query, err)
+				return nil, service.ErrInternalServerError
+			}
+
+			values[model.RiskFact{Field: countField, Window: fact.Window}.Key()] = count
+			values[model.RiskFact{Field: amountField, Window: fact.Window}.Key()] = amount
+		}
+	}
+
+	return values, nil
+}
+
+// blocked returns 1 if the user is on the blocklist and 0 if not or if there
+// is no user.
+func (s RiskStorage) blocked(ctx context.Context, id *int) (int, error) {
+	if id == nil {
+		return 0, nil
+	}
+
+	query := "SELECT count(*) FROM risk_blocklist WHERE user_id=$1"
+	var blocked int
+	if err := s.db.QueryRow(ctx, query, *id).Scan(&blocked); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return 0, service.ErrInternalServerError
+	}
+
+	return blocked, nil
+}
+
+// RecordDecision writes the decision and, for the review outcome, puts the
+// operation into the review queue in the same transaction.
+func (s RiskStorage) RecordDecision(ctx context.Context, decision model.RiskDecision) (model.RiskDecision, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.RiskDecision{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "INSERT INTO risk_decisions (user_id, payload, outcome, rule_ids, facts) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING " + riskDecisionColumns
+	if decision, err = scanRiskDecision(tx.QueryRow(
+		ctx,
+		query,
+		decision.Check.UserID,
+		decision.Check,
+		decision.Outcome,
+		decision.RuleIDs,
+		decision.Facts,
+	)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskDecision{}, service.ErrInternalServerError
+	}
+
+	if decision.Outcome == model.OutcomeReview && !decision.Check.Unattended {
+		query = "INSERT INTO risk_reviews (decision_id, user_id, payload, status) VALUES ($1, $2, $3, $4) RETURNING id"
+		var reviewID int
+		if err = tx.QueryRow(
+			ctx,
+			query,
+			decision.ID,
+			decision.Check.UserID,
+			decision.Check,
+			model.ReviewPending,
+		).Scan(&reviewID); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return model.RiskDecision{}, service.ErrInternalServerError
+		}
+
+		query = "UPDATE risk_decisions SET review_id=$1 WHERE id=$2"
+		if _, err = tx.Exec(ctx, query, reviewID, decision.ID); err != nil {
+			s.logger.Errorf("can't process query %q: %v", query, err)
+			return model.RiskDecision{}, service.ErrInternalServerError
+		}
+
+		decision.ReviewID = &reviewID
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.RiskDecision{}, service.ErrInternalServerError
+	}
+
+	return decision, nil
+}
+
+// Decisions returns the latest decisions, of the user if there is one.
+func (s RiskStorage) Decisions(ctx context.Context, userID *int, limit int) ([]model.RiskDecision, error) {
+	query := "SELECT " + riskDecisionColumns + " FROM risk_decisions " +
+		"WHERE $1::int IS NULL OR user_id=$1 ORDER BY id DESC LIMIT $2"
+	rows, err := s.db.Query(ctx, query, userID, limit)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	decisions := make([]model.RiskDecision, 0)
+	for rows.Next() {
+		decision, err := scanRiskDecision(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan risk decision values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		decisions = append(decisions, decision)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return decisions, nil
+}
+
+// Reviews returns the reviews in the status, oldest first, so the queue is
+// worked off in order.
+func (s RiskStorage) Reviews(ctx context.Context, status model.ReviewStatus) ([]model.RiskReview, error) {
+	query := "SELECT " + riskReviewColumns + " FROM risk_reviews WHERE status=$1 ORDER BY id"
+	rows, err := s.db.Query(ctx, query, status)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	reviews := make([]model.RiskReview, 0)
+	for rows.Next() {
+		review, err := scanRiskReview(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan risk review values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		reviews = append(reviews, review)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return reviews, nil
+}
+
+func (s RiskStorage) GetReview(ctx context.Context, id int) (model.RiskReview, error) {
+	query := "SELECT " + riskReviewColumns + " FROM risk_reviews WHERE id=$1"
+	review, err := scanRiskReview(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.RiskReview{}, reviewNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskReview{}, service.ErrInternalServerError
+	}
+
+	return review, nil
+}
+
+// DecideReview moves a pending review to the status on behalf of the
+// reviewer. Only one of concurrent decisions on a review succeeds.
+func (s RiskStorage) DecideReview(
+	ctx context.Context,
+	id int,
+	status model.ReviewStatus,
+	reviewer string,
+	comment *string,
+) (model.RiskReview, error) {
+	query := "UPDATE risk_reviews SET status=$1, reviewer=$2, comment=$3, decided=now() " +
+		"WHERE id=$4 AND status=$5 RETURNING " + riskReviewColumns
+	review, err := scanRiskReview(s.db.QueryRow(ctx, query, status, reviewer, comment, id, model.ReviewPending))
+	if err == nil {
+		return review, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskReview{}, service.ErrInternalServerError
+	}
+
+	if review, err = s.GetReview(ctx, id); err != nil {
+		return model.RiskReview{}, err
+	}
+
+	return model.RiskReview{}, service.WithDetails(
+		fmt.Errorf("%w: %s", service.ErrReviewNotPending, review.Status),
+		service.Details{"review_id": id, "status": review.Status},
+	)
+}
+
+// FailReview records that the operation of the executing review couldn't be
+// made.
+func (s RiskStorage) FailReview(ctx context.Context, id int, reason string) (model.RiskReview, error) {
+	query := "UPDATE risk_reviews SET status=$1, error=$2 WHERE id=$3 RETURNING " + riskReviewColumns
+	review, err := scanRiskReview(s.db.QueryRow(ctx, query, model.ReviewFailed, reason, id))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskReview{}, service.ErrInternalServerError
+	}
+
+	return review, nil
+}
+
+// CompleteReview records that the operation of the executing review has
+// been made.
+func (s RiskStorage) CompleteReview(ctx context.Context, id int) (model.RiskReview, error) {
+	query := "UPDATE risk_reviews SET status=$1 WHERE id=$2 AND status=$3 RETURNING " + riskReviewColumns
+	review, err := scanRiskReview(s.db.QueryRow(ctx, query, model.ReviewApproved, id, model.ReviewExecuting))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s.GetReview(ctx, id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.RiskReview{}, service.ErrInternalServerError
+	}
+
+	return review, nil
+}
+
+// ReviewExecuted reports whether the operation of the review has already
+// been made, so an interrupted approval that is retried doesn't make it
+// twice. Operations carry the id of the review in their metadata, except
+// reserves, which are unique by their order.
+func (s RiskStorage) ReviewExecuted(ctx context.Context, review model.RiskReview) (bool, error) {
+	check := review.Check
+	reviewID := strconv.Itoa(review.ID)
+
+	var query string
+	var args []any
+	switch check.Operation {
+	case model.RiskReserve:
+		query = "SELECT EXISTS (SELECT 1 FROM reserves " +
+			"WHERE source=$1 AND order_id=$2 AND user_id=$3 AND service_id=$4)"
+		args = []any{model.ReserveOrder, check.OrderID, check.UserID, check.ServiceID}
+	case model.RiskHold:
+		query = "SELECT EXISTS (SELECT 1 FROM pending_transfers " +
+			"WHERE sender_id=$1 AND metadata->>'risk_review_id'=$2)"
+		args = []any{check.UserID, reviewID}
+	default:
+		query = "SELECT EXISTS (SELECT 1 FROM journal WHERE user_id=$1 AND metadata->>'risk_review_id'=$2)"
+		args = []any{check.UserID, reviewID}
+	}
+
+	var executed bool
+	if err := s.db.QueryRow(ctx, query, args...).Scan(&executed); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return false, service.ErrInternalServerError
+	}
+
+	return executed, nil
+}
+
+// Block puts the user on the blocklist or updates the reason they are on it.
+func (s RiskStorage) Block(ctx context.Context, blocked model.BlockedUser) (model.BlockedUser, error) {
+	query := "INSERT INTO risk_blocklist (user_id, reason, operator) VALUES ($1, $2, $3) " +
+		"ON CONFLICT (user_id) DO UPDATE SET reason=excluded.reason, operator=excluded.operator " +
+		"RETURNING " + blockedUserColumns
+	blocked, err := scanBlockedUser(s.db.QueryRow(ctx, query, blocked.UserID, blocked.Reason, blocked.Operator))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.BlockedUser{}, service.ErrInternalServerError
+	}
+
+	return blocked, nil
+}
+
+func (s RiskStorage) Unblock(ctx context.Context, userID int) (model.BlockedUser, error) {
+	query := "DELETE FROM risk_blocklist WHERE user_id=$1 RETURNING " + blockedUserColumns
+	blocked, err := scanBlockedUser(s.db.QueryRow(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.BlockedUser{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrNotBlocked, userID),
+				service.Details{"user_id": userID},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.BlockedUser{}, service.ErrInternalServerError
+	}
+
+	return blocked, nil
+}
+
+func (s RiskStorage) Blocklist(ctx context.Context) ([]model.BlockedUser, error) {
+	query := "SELECT " + blockedUserColumns + " FROM risk_blocklist ORDER BY user_id"
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	blocklist := make([]model.BlockedUser, 0)
+	for rows.Next() {
+		blocked, err := scanBlockedUser(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan blocked user values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		blocklist = append(blocklist, blocked)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return blocklist, nil
+}
@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const (
+	scheduledTransferColumns = "id, user_id, receiver_id, amount, period, status, next_run, created"
+	transferRunColumns       = "id, scheduled_transfer_id, scheduled_for, status, attempts, transaction_id, error, " +
+		"created, updated"
+)
+
+type ScheduledTransferStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewScheduledTransferStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) ScheduledTransferStorage {
+	return ScheduledTransferStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanScheduledTransfer(row pgx.Row) (model.ScheduledTransfer, error) {
+	var transfer model.ScheduledTransfer
+	err := row.Scan(
+		&transfer.ID,
+		&transfer.UserID,
+		&transfer.ReceiverID,
+		&transfer.Amount,
+		&transfer.Period,
+		&transfer.Status,
+		&transfer.NextRun,
+		&transfer.Created,
+	)
+
+	return transfer, err
+}
+
+func scanTransferRun(row pgx.Row) (model.TransferRun, error) {
+	var run model.TransferRun
+	err := row.Scan(
+		&run.ID,
+		&run.ScheduledTransferID,
+		&run.ScheduledFor,
+		&run.Status,
+		&run.Attempts,
+		&run.TransactionID,
+		&run.Error,
+		&run.Created,
+		&run.Updated,
+	)
+
+	return run, err
+}
+
+func scheduledTransferNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrScheduledTransferNotFound, id),
+		service.Details{"scheduled_transfer_id": id},
+	)
+}
+
+func (s ScheduledTransferStorage) Create(
+	ctx context.Context,
+	transfer model.ScheduledTransfer,
+) (model.ScheduledTransfer, error) {
+	query := "INSERT INTO scheduled_transfers (user_id, receiver_id, amount, period, status, next_run) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + scheduledTransferColumns
+	created, err := scanScheduledTransfer(s.db.QueryRow(
+		ctx,
+		query,
+		transfer.UserID,
+		transfer.ReceiverID,
+		transfer.Amount,
+		transfer.Period,
+		transfer.Status,
+		transfer.NextRun,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		// foreign_key_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			id := transfer.UserID
+			if pgErr.ConstraintName == "scheduled_transfers_receiver_id_fkey" {
+				id = transfer.ReceiverID
+			}
+
+			return model.ScheduledTransfer{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+
+	return created, nil
+}
+
+// Get returns the scheduled transfer together with its runs, newest first.
+func (s ScheduledTransferStorage) Get(ctx context.Context, id int) (model.ScheduledTransfer, error) {
+	query := "SELECT " + scheduledTransferColumns + " FROM scheduled_transfers WHERE id=$1"
+	transfer, err := scanScheduledTransfer(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.ScheduledTransfer{}, scheduledTransferNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT " + transferRunColumns + " FROM scheduled_transfer_runs " +
+		"WHERE scheduled_transfer_id=$1 ORDER BY scheduled_for DESC"
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	transfer.Runs = make([]model.TransferRun, 0)
+	for rows.Next() {
+		run, err := scanTransferRun(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan transfer run values %q: %v", query, err)
+			return model.ScheduledTransfer{}, service.ErrInternalServerError
+		}
+
+		transfer.Runs = append(transfer.Runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+
+	return transfer, nil
+}
+
+// List returns the transfers the user has scheduled.
+func (s ScheduledTransferStorage) List(ctx context.Context, userID int) ([]model.ScheduledTransfer, error) {
+	query := "SELECT " + scheduledTransferColumns + " FROM scheduled_transfers WHERE user_id=$1 ORDER BY id"
+
+	return s.list(ctx, query, userID)
+}
+
+// Due returns up to limit active transfers whose run time has come, the
+// longest waiting first.
+func (s ScheduledTransferStorage) Due(ctx context.Context, limit int) ([]model.ScheduledTransfer, error) {
+	query := "SELECT " + scheduledTransferColumns + " FROM scheduled_transfers " +
+		"WHERE status=$1 AND next_run<=now() ORDER BY next_run, id LIMIT $2"
+
+	return s.list(ctx, query, model.ScheduledActive, limit)
+}
+
+func (s ScheduledTransferStorage) list(ctx context.Context, query string, args ...any) ([]model.ScheduledTransfer, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	transfers := make([]model.ScheduledTransfer, 0)
+	for rows.Next() {
+		transfer, err := scanScheduledTransfer(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan scheduled transfer values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		transfers = append(transfers, transfer)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return transfers, nil
+}
+
+// Cancel stops an active scheduled transfer. A run already in progress still
+// completes.
+func (s ScheduledTransferStorage) Cancel(ctx context.Context, id int) (model.ScheduledTransfer, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + scheduledTransferColumns + " FROM scheduled_transfers WHERE id=$1 FOR UPDATE"
+	transfer, err := scanScheduledTransfer(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.ScheduledTransfer{}, scheduledTransferNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+
+	if transfer.Status != model.ScheduledActive {
+		return model.ScheduledTransfer{}, service.WithDetails(
+			fmt.Errorf("%w: %s", service.ErrScheduledTransferNotActive, transfer.Status),
+			service.Details{"scheduled_transfer_id": id, "status": transfer.Status},
+		)
+	}
+
+	query = "UPDATE scheduled_transfers SET status=$1 WHERE id=$2 RETURNING " + scheduledTransferColumns
+	if transfer, err = scanScheduledTransfer(tx.QueryRow(ctx, query, model.ScheduledCancelled, id)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.ScheduledTransfer{}, service.ErrInternalServerError
+	}
+
+	return transfer, nil
+}
+
+// StartRun records an attempt to run the transfer for the occurrence it is
+// due at. Repeated attempts for the same occurrence share the run.
+func (s ScheduledTransferStorage) StartRun(
+	ctx context.Context,
+	transfer model.ScheduledTransfer,
+) (model.TransferRun, error) {
+	query := "INSERT INTO scheduled_transfer_runs (scheduled_transfer_id, scheduled_for, status, attempts) " +
+		"VALUES ($1, $2, $3, 1) ON CONFLICT (scheduled_transfer_id, scheduled_for) DO UPDATE SET " +
+		"attempts=scheduled_transfer_runs.attempts+1, updated=now() " +
+		"RETURNING " + transferRunColumns
+	run, err := scanTransferRun(s.db.QueryRow(
+		ctx,
+		query,
+		transfer.ID,
+		transfer.NextRun,
+		model.RunPending,
+	))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.TransferRun{}, service.ErrInternalServerError
+	}
+
+	return run, nil
+}
+
+// FindTransfer returns the id of the outgoing transfer entry of the user
+// booked with the reference, if there is one.
+func (s ScheduledTransferStorage) FindTransfer(ctx context.Context, userID int, externalRef string) (*int, error) {
+	query := "SELECT id FROM journal WHERE user_id=$1 AND type=$2 AND external_ref=$3"
+	var id int
+	if err := s.db.QueryRow(ctx, query, userID, model.TransactionTransferOut, externalRef).Scan(&id); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return &id, nil
+}
+
+// FinishRun records the outcome of the run and moves the transfer to its
+// next occurrence; a transfer without a period ends with the outcome of its
+// only run. The transfer is only updated if it is still active and due at the
+// occurrence of the run, so a run finished twice doesn't skip an occurrence.
+func (s ScheduledTransferStorage) FinishRun(
+	ctx context.Context,
+	transfer model.ScheduledTransfer,
+	run model.TransferRun,
+) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "UPDATE scheduled_transfer_runs SET status=$1, transaction_id=$2, error=$3, updated=now() WHERE id=$4"
+	if _, err = tx.Exec(ctx, query, run.Status, run.TransactionID, run.Error, run.ID); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	status := model.ScheduledActive
+	nextRun := run.ScheduledFor
+	switch {
+	case transfer.Period != nil:
+		nextRun = transfer.Period.Next(run.ScheduledFor)
+	case run.Status == model.RunSucceeded:
+		status = model.ScheduledCompleted
+	default:
+		status = model.ScheduledFailed
+	}
+
+	query = "UPDATE scheduled_transfers SET status=$1, next_run=$2 WHERE id=$3 AND status=$4 AND next_run=$5"
+	if _, err = tx.Exec(
+		ctx,
+		query,
+		status,
+		nextRun,
+		transfer.ID,
+		model.ScheduledActive,
+		run.ScheduledFor,
+	); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
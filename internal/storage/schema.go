@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// SchemaVersion is the version of sql/init.sql the application expects.
+const SchemaVersion = 22
+
+var ErrSchemaVersionMismatch = errors.New("unexpected schema version")
+
+type SchemaStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewSchemaStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) SchemaStorage {
+	return SchemaStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (s SchemaStorage) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx)
+}
+
+func (s SchemaStorage) CheckVersion(ctx context.Context) error {
+	query := "SELECT max(version) FROM schema_version"
+	var version *int
+	if err := s.db.QueryRow(ctx, query).Scan(&version); err != nil {
+		return err
+	}
+
+	if version == nil || *version != SchemaVersion {
+		got := 0
+		if version != nil {
+			got = *version
+		}
+		return fmt.Errorf("%w: expected %d, got %d", ErrSchemaVersionMismatch, SchemaVersion, got)
+	}
+
+	return nil
+}
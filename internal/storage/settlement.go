@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const settlementColumns = "id, provider, format, checksum, imported_by, lines, amount, matched, unmatched, mismatched, created"
+
+// settledTopUpIndex is the unique index which keeps a top-up from being
+// matched by more than one settlement line.
+const settledTopUpIndex = "settlement_items_matched_transaction_idx"
+
+type SettlementStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewSettlementStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) SettlementStorage {
+	return SettlementStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanSettlement(row pgx.Row) (model.Settlement, error) {
+	var settlement model.Settlement
+	err := row.Scan(
+		&settlement.ID,
+		&settlement.Provider,
+		&settlement.Format,
+		&settlement.Checksum,
+		&settlement.ImportedBy,
+		&settlement.Lines,
+		&settlement.Amount,
+		&settlement.Matched,
+		&settlement.Unmatched,
+		&settlement.Mismatched,
+		&settlement.Created,
+	)
+
+	return settlement, err
+}
+
+// TopUps returns the top-ups booked with the references, whether they are
+// reversed and whether a settlement line has matched them.
+func (s SettlementStorage) TopUps(ctx context.Context, refs []string) ([]model.SettledTopUp, error) {
+	query := "SELECT j.id, j.user_id, j.amount, j.external_ref, j.reversed_by IS NOT NULL, " +
+		"EXISTS (SELECT 1 FROM settlement_items i WHERE i.transaction_id=j.id AND i.status=$3) " +
+		"FROM journal j WHERE j.type=$1 AND j.external_ref=ANY($2) ORDER BY j.id"
+	rows, err := s.db.Query(ctx, query, model.TransactionTopUp, refs, model.SettlementMatched)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	topUps := make([]model.SettledTopUp, 0)
+	for rows.Next() {
+		var topUp model.SettledTopUp
+		if err = rows.Scan(
+			&topUp.ID,
+			&topUp.UserID,
+			&topUp.Amount,
+			&topUp.ExternalRef,
+			&topUp.Reversed,
+			&topUp.Settled,
+		); err != nil {
+			s.logger.Errorf("can't scan top-up values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		topUps = append(topUps, topUp)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return topUps, nil
+}
+
+// Create records the settlement with its items.
+func (s SettlementStorage) Create(ctx context.Context, settlement model.Settlement) (model.Settlement, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "INSERT INTO settlements " +
+		"(provider, format, checksum, imported_by, lines, amount, matched, unmatched, mismatched) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING " + settlementColumns
+	created, err := scanSettlement(tx.QueryRow(
+		ctx,
+		query,
+		settlement.Provider,
+		settlement.Format,
+		settlement.Checksum,
+		settlement.ImportedBy,
+		settlement.Lines,
+		settlement.Amount,
+		settlement.Matched,
+		settlement.Unmatched,
+		settlement.Mismatched,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		// unique_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return model.Settlement{}, service.WithDetails(
+				fmt.Errorf("%w: %s", service.ErrSettlementExists, settlement.Checksum),
+				service.Details{"provider": settlement.Provider, "checksum": settlement.Checksum},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+
+	if _, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"settlement_items"},
+		[]string{
+			"settlement_id",
+			"line",
+			"reference",
+			"amount",
+			"currency",
+			"booked",
+			"status",
+			"transaction_id",
+			"user_id",
+			"journal_amount",
+			"reason",
+		},
+		pgx.CopyFromSlice(len(settlement.Items), func(i int) ([]any, error) {
+			item := settlement.Items[i]
+			return []any{
+				created.ID,
+				item.Line,
+				item.Reference,
+				item.Amount,
+				item.Currency,
+				item.Booked,
+				string(item.Status),
+				item.TransactionID,
+				item.UserID,
+				item.JournalAmount,
+				item.Reason,
+			}, nil
+		}),
+	); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == settledTopUpIndex {
+			return model.Settlement{}, service.ErrSettlementConflict
+		}
+
+		s.logger.Errorf("can't copy settlement items: %v", err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+
+	created.Items = settlement.Items
+	return created, nil
+}
+
+// Get returns the settlement with its items, only those with the status if
+// it isn't nil.
+func (s SettlementStorage) Get(
+	ctx context.Context,
+	id int,
+	status *model.SettlementStatus,
+) (model.Settlement, error) {
+	query := "SELECT " + settlementColumns + " FROM settlements WHERE id=$1"
+	settlement, err := scanSettlement(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Settlement{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrSettlementNotFound, id),
+				service.Details{"settlement_id": id},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT line, reference, amount, currency, booked, status, transaction_id, user_id, journal_amount, reason " +
+		"FROM settlement_items WHERE settlement_id=$1 AND ($2::text IS NULL OR status=$2) ORDER BY line"
+	rows, err := s.db.Query(ctx, query, id, status)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	settlement.Items = make([]model.SettlementItem, 0)
+	for rows.Next() {
+		var item model.SettlementItem
+		if err = rows.Scan(
+			&item.Line,
+			&item.Reference,
+			&item.Amount,
+			&item.Currency,
+			&item.Booked,
+			&item.Status,
+			&item.TransactionID,
+			&item.UserID,
+			&item.JournalAmount,
+			&item.Reason,
+		); err != nil {
+			s.logger.Errorf("can't scan settlement item values %q: %v", query, err)
+			return model.Settlement{}, service.ErrInternalServerError
+		}
+
+		settlement.Items = append(settlement.Items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.Settlement{}, service.ErrInternalServerError
+	}
+
+	return settlement, nil
+}
+
+// List returns the latest settlements of the provider, or of all providers if
+// there is no provider, without their items.
+func (s SettlementStorage) List(ctx context.Context, provider *string, limit int) ([]model.Settlement, error) {
+	query := "SELECT " + settlementColumns + " FROM settlements " +
+		"WHERE ($1::text IS NULL OR provider=$1) ORDER BY id DESC LIMIT $2"
+	rows, err := s.db.Query(ctx, query, provider, limit)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	settlements := make([]model.Settlement, 0)
+	for rows.Next() {
+		settlement, err := scanSettlement(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan settlement values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		settlements = append(settlements, settlement)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return settlements, nil
+}
@@ -0,0 +1,400 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+const (
+	subscriptionColumns       = "id, user_id, service_id, price, period, status, next_charge, retry_at, last_error, created"
+	subscriptionChargeColumns = "id, subscription_id, period_start, status, attempts, error, created, updated"
+
+	eventSubscriptionPastDue = "subscription_past_due"
+	eventSubscriptionExpired = "subscription_expired"
+)
+
+type SubscriptionStorage struct {
+	logger *zap.SugaredLogger
+	db     *pgxpool.Pool
+}
+
+func NewSubscriptionStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) SubscriptionStorage {
+	return SubscriptionStorage{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func scanSubscription(row pgx.Row) (model.Subscription, error) {
+	var subscription model.Subscription
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.UserID,
+		&subscription.ServiceID,
+		&subscription.Price,
+		&subscription.Period,
+		&subscription.Status,
+		&subscription.NextCharge,
+		&subscription.RetryAt,
+		&subscription.LastError,
+		&subscription.Created,
+	)
+
+	return subscription, err
+}
+
+func scanSubscriptionCharge(row pgx.Row) (model.SubscriptionCharge, error) {
+	var charge model.SubscriptionCharge
+	err := row.Scan(
+		&charge.ID,
+		&charge.SubscriptionID,
+		&charge.PeriodStart,
+		&charge.Status,
+		&charge.Attempts,
+		&charge.Error,
+		&charge.Created,
+		&charge.Updated,
+	)
+
+	return charge, err
+}
+
+func subscriptionNotFound(id int) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %d", service.ErrSubscriptionNotFound, id),
+		service.Details{"subscription_id": id},
+	)
+}
+
+func (s SubscriptionStorage) Create(ctx context.Context, subscription model.Subscription) (model.Subscription, error) {
+	query := "INSERT INTO subscriptions (user_id, service_id, price, period, status, next_charge) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + subscriptionColumns
+	created, err := scanSubscription(s.db.QueryRow(
+		ctx,
+		query,
+		subscription.UserID,
+		subscription.ServiceID,
+		subscription.Price,
+		subscription.Period,
+		subscription.Status,
+		subscription.NextCharge,
+	))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		// foreign_key_violation
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+			return model.Subscription{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, subscription.UserID),
+				service.Details{"user_id": subscription.UserID},
+			)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+
+	return created, nil
+}
+
+// Get returns the subscription together with its charges, newest first.
+func (s SubscriptionStorage) Get(ctx context.Context, id int) (model.Subscription, error) {
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions WHERE id=$1"
+	subscription, err := scanSubscription(s.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Subscription{}, subscriptionNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+
+	query = "SELECT " + subscriptionChargeColumns + " FROM subscription_charges " +
+		"WHERE subscription_id=$1 ORDER BY period_start DESC"
+	rows, err := s.db.Query(ctx, query, id)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	subscription.Charges = make([]model.SubscriptionCharge, 0)
+	for rows.Next() {
+		charge, err := scanSubscriptionCharge(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan subscription charge values %q: %v", query, err)
+			return model.Subscription{}, service.ErrInternalServerError
+		}
+
+		subscription.Charges = append(subscription.Charges, charge)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+
+	return subscription, nil
+}
+
+func (s SubscriptionStorage) List(ctx context.Context, userID int) ([]model.Subscription, error) {
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions WHERE user_id=$1 ORDER BY id"
+
+	return s.list(ctx, query, userID)
+}
+
+// Due returns up to limit subscriptions whose charge or retry time has come,
+// the longest waiting first.
+func (s SubscriptionStorage) Due(ctx context.Context, limit int) ([]model.Subscription, error) {
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions " +
+		"WHERE status=$1 AND next_charge<=now() OR status=$2 AND retry_at<=now() " +
+		"ORDER BY coalesce(retry_at, next_charge), id LIMIT $3"
+
+	return s.list(ctx, query, model.SubscriptionActive, model.SubscriptionPastDue, limit)
+}
+
+func (s SubscriptionStorage) list(ctx context.Context, query string, args ...any) ([]model.Subscription, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return nil, service.ErrInternalServerError
+	}
+	defer rows.Close()
+
+	subscriptions := make([]model.Subscription, 0)
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			s.logger.Errorf("can't scan subscription values %q: %v", query, err)
+			return nil, service.ErrInternalServerError
+		}
+
+		subscriptions = append(subscriptions, subscription)
+	}
+	if err = rows.Err(); err != nil {
+		s.logger.Errorf("error occurred during rows scanning: %v", err)
+		return nil, service.ErrInternalServerError
+	}
+
+	return subscriptions, nil
+}
+
+// ChangeStatus moves the subscription to the status. A resumed subscription
+// is charged for the period it was due at, or right away if that has passed.
+func (s SubscriptionStorage) ChangeStatus(
+	ctx context.Context,
+	id int,
+	status model.SubscriptionStatus,
+) (model.Subscription, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions WHERE id=$1 FOR UPDATE"
+	subscription, err := scanSubscription(tx.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Subscription{}, subscriptionNotFound(id)
+		}
+
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+
+	if !subscription.Status.CanBecome(status) {
+		return model.Subscription{}, service.WithDetails(
+			fmt.Errorf("%w: %s -> %s", service.ErrInvalidSubscriptionTransition, subscription.Status, status),
+			service.Details{"subscription_id": id, "status": subscription.Status},
+		)
+	}
+
+	query = "UPDATE subscriptions SET status=$1, retry_at=NULL, last_error=NULL, " +
+		"next_charge=CASE WHEN $1=$2 THEN greatest(next_charge, now()) ELSE next_charge END " +
+		"WHERE id=$3 RETURNING " + subscriptionColumns
+	if subscription, err = scanSubscription(tx.QueryRow(
+		ctx,
+		query,
+		status,
+		model.SubscriptionActive,
+		id,
+	)); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return model.Subscription{}, service.ErrInternalServerError
+	}
+
+	return subscription, nil
+}
+
+// StartCharge records an attempt to charge the subscription for the period
+// it is due at. Repeated attempts for the same period share the charge, and
+// so the order id of its reserve.
+func (s SubscriptionStorage) StartCharge(
+	ctx context.Context,
+	subscription model.Subscription,
+) (model.SubscriptionCharge, error) {
+	query := "INSERT INTO subscription_charges (subscription_id, period_start, status, attempts) " +
+		"VALUES ($1, $2, $3, 1) ON CONFLICT (subscription_id, period_start) DO UPDATE SET " +
+		"attempts=subscription_charges.attempts+1, updated=now(), " +
+		"status=CASE WHEN subscription_charges.status=$4 THEN subscription_charges.status ELSE $3 END " +
+		"RETURNING " + subscriptionChargeColumns
+	charge, err := scanSubscriptionCharge(s.db.QueryRow(
+		ctx,
+		query,
+		subscription.ID,
+		subscription.NextCharge,
+		model.ChargePending,
+		model.ChargePaid,
+	))
+	if err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return model.SubscriptionCharge{}, service.ErrInternalServerError
+	}
+
+	return charge, nil
+}
+
+// CompleteCharge marks the charge paid and moves the subscription to the
+// next period. The subscription is only updated if it is still due at the
+// period of the charge, so a charge completed twice doesn't skip a period.
+func (s SubscriptionStorage) CompleteCharge(
+	ctx context.Context,
+	subscription model.Subscription,
+	charge model.SubscriptionCharge,
+) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "UPDATE subscription_charges SET status=$1, error=NULL, updated=now() WHERE id=$2"
+	if _, err = tx.Exec(ctx, query, model.ChargePaid, charge.ID); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	query = "UPDATE subscriptions SET next_charge=$1, retry_at=NULL, last_error=NULL, " +
+		"status=CASE WHEN status=$2 THEN $3 ELSE status END WHERE id=$4 AND next_charge=$5"
+	if _, err = tx.Exec(
+		ctx,
+		query,
+		subscription.Period.Next(charge.PeriodStart),
+		model.SubscriptionPastDue,
+		model.SubscriptionActive,
+		subscription.ID,
+		charge.PeriodStart,
+	); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
+
+// FailCharge records the reason the charge failed. The subscription becomes
+// past due and is retried after retry, unless the grace period after the due
+// time has run out, in which case it expires.
+func (s SubscriptionStorage) FailCharge(
+	ctx context.Context,
+	subscription model.Subscription,
+	charge model.SubscriptionCharge,
+	reason string,
+	retry time.Duration,
+	grace time.Duration,
+) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.logger.Errorf("can't begin transaction: %v", err)
+		return service.ErrInternalServerError
+	}
+	defer func() {
+		if err = tx.Rollback(context.Background()); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			s.logger.Errorf("can't rollback transcation: %v", err)
+		}
+	}()
+
+	query := "UPDATE subscription_charges SET status=$1, error=$2, updated=now() WHERE id=$3"
+	if _, err = tx.Exec(ctx, query, model.ChargeFailed, reason, charge.ID); err != nil {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	query = "UPDATE subscriptions SET last_error=$1, " +
+		"status=CASE WHEN next_charge + $2::int * interval '1 second' <= now() THEN $3 ELSE $4 END, " +
+		"retry_at=CASE WHEN next_charge + $2::int * interval '1 second' <= now() THEN NULL " +
+		"ELSE now() + $5::int * interval '1 second' END " +
+		"WHERE id=$6 AND next_charge=$7 AND status IN ($8, $4) RETURNING status"
+	var status model.SubscriptionStatus
+	if err = tx.QueryRow(
+		ctx,
+		query,
+		reason,
+		int(grace.Seconds()),
+		model.SubscriptionExpired,
+		model.SubscriptionPastDue,
+		int(retry.Seconds()),
+		subscription.ID,
+		charge.PeriodStart,
+		model.SubscriptionActive,
+	).Scan(&status); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		s.logger.Errorf("can't process query %q: %v", query, err)
+		return service.ErrInternalServerError
+	}
+
+	// the user is notified once, when the subscription falls behind, and
+	// once more when it expires
+	eventType := ""
+	switch {
+	case status == model.SubscriptionExpired:
+		eventType = eventSubscriptionExpired
+	case status == model.SubscriptionPastDue && subscription.Status == model.SubscriptionActive:
+		eventType = eventSubscriptionPastDue
+	}
+	if eventType != "" {
+		if err = insertEvent(ctx, s.logger, tx, eventType, subscription.UserID, map[string]any{
+			"subscription_id": subscription.ID,
+			"service_id":      subscription.ServiceID,
+			"price":           subscription.Price,
+			"reason":          reason,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.logger.Errorf("can't commit transaction: %v", err)
+		return service.ErrInternalServerError
+	}
+
+	return nil
+}
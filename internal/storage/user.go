@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,38 +15,47 @@ import (
 )
 
 type UserStorage struct {
-	logger *zap.SugaredLogger
-	db     *pgxpool.Pool
+	logger   *zap.SugaredLogger
+	db       *pgxpool.Pool
+	chainKey []byte
 }
 
-func NewUserStorage(logger *zap.SugaredLogger, db *pgxpool.Pool) UserStorage {
+func NewUserStorage(logger *zap.SugaredLogger, db *pgxpool.Pool, chainKey []byte) UserStorage {
 	return UserStorage{
-		logger: logger,
-		db:     db,
+		logger:   logger,
+		db:       db,
+		chainKey: chainKey,
 	}
 }
 
-func (s UserStorage) GetBalance(id int) (int, error) {
-	query := "SELECT balance FROM users WHERE id=$1"
-	var balance int
+func (s UserStorage) GetBalance(ctx context.Context, id int) (model.Balance, error) {
+	query := "SELECT balance, credit_limit, " +
+		"(SELECT coalesce(sum(remaining), 0) FROM bonus_grants WHERE user_id=$1 AND expires>now()) " +
+		"FROM users WHERE id=$1"
+	var balance model.Balance
 	if err := s.db.QueryRow(
-		context.Background(),
+		ctx,
 		query,
 		id,
-	).Scan(&balance); err != nil {
+	).Scan(&balance.Balance, &balance.CreditLimit, &balance.Bonus); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, fmt.Errorf("%w: %d", service.ErrUserNotFound, id)
+			return model.Balance{}, service.WithDetails(
+				fmt.Errorf("%w: %d", service.ErrUserNotFound, id),
+				service.Details{"user_id": id},
+			)
 		}
 
 		s.logger.Errorf("can't process query %q: %v", query, err)
-		return 0, service.ErrInternalServerError
+		return model.Balance{}, service.ErrInternalServerError
 	}
 
+	balance.Available = balance.Balance + balance.CreditLimit
+
 	return balance, nil
 }
 
-func (s UserStorage) TopUpBalance(id int, amount int) (int, error) {
-	tx, err := s.db.Begin(context.Background())
+func (s UserStorage) TopUpBalance(ctx context.Context, id int, amount int, ref model.Reference) (int, error) {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		s.logger.Errorf("can't begin transaction: %v", err)
 		return 0, service.ErrInternalServerError
@@ -55,45 +66,31 @@ func (s UserStorage) TopUpBalance(id int, amount int) (int, error) {
 		}
 	}()
 
-	query := "UPDATE users SET balance=balance+$1 WHERE id=$2 RETURNING balance"
-	var balance int
-	if err = tx.QueryRow(
-		context.Background(),
-		query,
-		amount,
-		id,
-	).Scan(&balance); err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			s.logger.Errorf("can't process query %q: %v", query, err)
-			return 0, service.ErrInternalServerError
-		}
+	if err = checkLimits(ctx, s.logger, tx, id, model.LimitTopUp, amount); err != nil {
+		return 0, err
+	}
 
-		query = "INSERT INTO users (id, balance) VALUES ($1, $2) RETURNING balance"
-		if err = tx.QueryRow(
-			context.Background(),
-			query,
-			id,
-			amount,
-		).Scan(&balance); err != nil {
-			s.logger.Errorf("can't process query %q: %v", query, err)
-			return 0, service.ErrInternalServerError
-		}
+	balance, err := creditBalance(ctx, s.logger, tx, id, amount)
+	if err != nil {
+		return 0, err
 	}
 
-	query = "INSERT INTO journal (user_id, amount, message) VALUES ($1, $2, $3)"
-	message := "account replenishment"
-	if _, err = tx.Exec(
-		context.Background(),
-		query,
-		id,
-		amount,
-		message,
-	); err != nil {
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return 0, service.ErrInternalServerError
+	if _, err = insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID:      id,
+		Amount:      amount,
+		Type:        model.TransactionTopUp,
+		ExternalRef: ref.ExternalRef,
+		Metadata:    ref.Metadata,
+		Message:     "account replenishment",
+	}); err != nil {
+		return 0, err
 	}
 
-	if err = tx.Commit(context.Background()); err != nil {
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
 		s.logger.Errorf("can't commit transaction: %v", err)
 		return 0, service.ErrInternalServerError
 	}
@@ -101,8 +98,8 @@ func (s UserStorage) TopUpBalance(id int, amount int) (int, error) {
 	return balance, nil
 }
 
-func (s UserStorage) Transfer(id, receiverID int, amount int) (int, error) {
-	tx, err := s.db.Begin(context.Background())
+func (s UserStorage) Transfer(ctx context.Context, id, receiverID int, amount int, ref model.Reference) (int, error) {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		s.logger.Errorf("can't begin transaction: %v", err)
 		return 0, service.ErrInternalServerError
@@ -113,68 +110,65 @@ func (s UserStorage) Transfer(id, receiverID int, amount int) (int, error) {
 		}
 	}()
 
-	query := "UPDATE users SET balance=balance-$1 WHERE id=$2 RETURNING balance"
-	var balance int
-	if err = tx.QueryRow(
-		context.Background(),
-		query,
-		amount,
-		id,
-	).Scan(&balance); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return 0, fmt.Errorf("%w: %d", service.ErrUserNotFound, id)
-		}
+	if err = checkLimits(ctx, s.logger, tx, id, model.LimitTransfer, amount); err != nil {
+		return 0, err
+	}
 
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return 0, service.ErrInternalServerError
+	quote, err := quoteFee(ctx, s.logger, tx, model.FeeTransfer, nil, amount)
+	if err != nil {
+		return 0, err
 	}
 
-	if balance < 0 {
-		return 0, fmt.Errorf("%w: %d", service.ErrInsufficientFunds, balance+amount)
+	balance, err := debitBalance(ctx, s.logger, tx, id, quote.Total, false)
+	if err != nil {
+		return 0, err
 	}
 
-	query = "UPDATE users SET balance=balance+$1 WHERE id=$2"
-	tag, err := tx.Exec(
-		context.Background(),
-		query,
-		amount,
-		receiverID,
-	)
+	if _, err = creditBalance(ctx, s.logger, tx, receiverID, amount); err != nil {
+		return 0, err
+	}
+
+	out, err := insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID:         id,
+		Amount:         -amount,
+		Type:           model.TransactionTransferOut,
+		CounterpartyID: &receiverID,
+		ExternalRef:    ref.ExternalRef,
+		Metadata:       ref.Metadata,
+		Message:        fmt.Sprintf("transfer to the user %d", receiverID),
+	})
 	if err != nil {
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return 0, service.ErrInternalServerError
+		return 0, err
 	}
 
-	if tag.RowsAffected() == 0 {
-		return 0, fmt.Errorf("%w: %d", service.ErrUserNotFound, receiverID)
+	if _, err = insertJournalEntry(ctx, s.logger, tx, model.Transaction{
+		UserID:         receiverID,
+		Amount:         amount,
+		Type:           model.TransactionTransferIn,
+		CounterpartyID: &id,
+		ExternalRef:    ref.ExternalRef,
+		Metadata:       ref.Metadata,
+		PairID:         &out.ID,
+		Message:        fmt.Sprintf("transfer from the user %d", id),
+	}); err != nil {
+		return 0, err
 	}
 
-	query = "INSERT INTO journal (user_id, amount, message) VALUES ($1, $2, $3)"
-	message := fmt.Sprintf("transfer to the user %d", receiverID)
-	if _, err = tx.Exec(
-		context.Background(),
-		query,
-		id,
-		-amount,
-		message,
-	); err != nil {
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return 0, service.ErrInternalServerError
+	if quote.Fee > 0 {
+		if err = bookFee(ctx, s.logger, tx, id, quote, model.Transaction{
+			ExternalRef: ref.ExternalRef,
+			PairID:      &out.ID,
+			Message:     fmt.Sprintf("fee for the transfer to the user %d", receiverID),
+		}); err != nil {
+			return 0, err
+		}
 	}
 
-	message = fmt.Sprintf("transfer from the user %d", receiverID)
-	if _, err = tx.Exec(
-		context.Background(),
-		query,
-		receiverID,
-		amount,
-		message,
-	); err != nil {
-		s.logger.Errorf("can't process query %q: %v", query, err)
-		return 0, service.ErrInternalServerError
+	if err = sealJournal(ctx, s.logger, tx, s.chainKey); err != nil {
+		return 0, err
 	}
 
-	if err = tx.Commit(context.Background()); err != nil {
+	if err = tx.Commit(ctx); err != nil {
 		s.logger.Errorf("can't commit transaction: %v", err)
 		return 0, service.ErrInternalServerError
 	}
@@ -182,20 +176,78 @@ func (s UserStorage) Transfer(id, receiverID int, amount int) (int, error) {
 	return balance, nil
 }
 
-func (s UserStorage) Transactions(id int, orderField string, limit, offset int) ([]model.Transaction, error) {
-	query := "SELECT id, user_id, amount, message, created " +
+// transactionsFilter builds the WHERE clause of a transactions query.
+type transactionsFilter struct {
+	conditions []string
+	args       []any
+}
+
+func (f *transactionsFilter) add(condition string, args ...any) {
+	for _, arg := range args {
+		f.args = append(f.args, arg)
+		condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(f.args)), 1)
+	}
+
+	f.conditions = append(f.conditions, condition)
+}
+
+func (s UserStorage) Transactions(ctx context.Context, id int, q model.TransactionsQuery) ([]model.Transaction, error) {
+	filter := new(transactionsFilter)
+	filter.add("user_id=?", id)
+
+	if q.From != nil {
+		filter.add("created>=?", *q.From)
+	}
+	if q.To != nil {
+		filter.add("created<?", *q.To)
+	}
+	if q.MinAmount != nil {
+		filter.add("amount>=?", *q.MinAmount)
+	}
+	if q.MaxAmount != nil {
+		filter.add("amount<=?", *q.MaxAmount)
+	}
+
+	switch q.Direction {
+	case model.DirectionCredit:
+		filter.add("amount>0")
+	case model.DirectionDebit:
+		filter.add("amount<0")
+	}
+
+	if q.Type != "" {
+		filter.add("type=?", q.Type)
+	}
+	if q.CounterpartyID != nil {
+		filter.add("counterparty_id=?", *q.CounterpartyID)
+	}
+
+	// keyset pagination: rows are ordered by the order field and then by id,
+	// so the pair is unique and the page after the cursor is stable
+	order, comparison := "ASC", ">"
+	if q.Descending {
+		order, comparison = "DESC", "<"
+	}
+
+	if q.After != nil {
+		var value any = q.After.Created
+		if q.OrderField == "amount" {
+			value = q.After.Amount
+		}
+		filter.add("("+q.OrderField+", id)"+comparison+"(?, ?)", value, q.After.ID)
+	}
+
+	filter.args = append(filter.args, q.Limit)
+	query := "SELECT " + journalColumns + " " +
 		"FROM journal " +
-		"WHERE user_id=$1 " +
-		"ORDER BY " + orderField + " " +
-		"LIMIT $2 " +
-		"OFFSET $3"
+		"WHERE " + strings.Join(filter.conditions, " AND ") + " " +
+		"ORDER BY " + q.OrderField + " " + order + ", id " + order + " " +
+		"LIMIT $" + strconv.Itoa(len(filter.args))
 
 	rows, err := s.db.Query(
-		context.Background(),
+		ctx,
 		query,
-		id,
-		limit,
-		offset,
+		filter.args...,
 	)
 	if err != nil {
 		s.logger.Errorf("can't process query %q: %v", query, err)
@@ -204,14 +256,8 @@ func (s UserStorage) Transactions(id int, orderField string, limit, offset int)
 
 	transactions := make([]model.Transaction, 0)
 	for rows.Next() {
-		var transaction model.Transaction
-		if err = rows.Scan(
-			&transaction.ID,
-			&transaction.UserID,
-			&transaction.Amount,
-			&transaction.Message,
-			&transaction.Created,
-		); err != nil {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
 			s.logger.Errorf("can't scan transaction values %q: %v", query, err)
 			return nil, service.ErrInternalServerError
 		}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+type accountService interface {
+	ChangeStatus(
+		ctx context.Context,
+		id int,
+		status model.AccountStatus,
+		reason string,
+		operator string,
+	) (account model.Account, err error)
+	SetCreditLimit(
+		ctx context.Context,
+		id int,
+		creditLimit int,
+		reason string,
+		operator string,
+	) (account model.Account, err error)
+	SetSegment(
+		ctx context.Context,
+		id int,
+		segment string,
+		reason string,
+		operator string,
+	) (account model.Account, err error)
+}
+
+type accountHandler struct {
+	logger         *zap.SugaredLogger
+	service        accountService
+	operatorHeader string
+}
+
+func registerAccountRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service accountService,
+	operatorHeader string,
+) {
+	handler := accountHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	router.Handle("/{user_id}/freeze", handler.handleChangeStatus(model.AccountFrozen)).Methods(http.MethodPost)
+	router.Handle("/{user_id}/unfreeze", handler.handleChangeStatus(model.AccountActive)).Methods(http.MethodPost)
+	router.Handle("/{user_id}/close", handler.handleChangeStatus(model.AccountClosed)).Methods(http.MethodPost)
+	router.Handle("/{user_id}/credit-limit", handler.handleSetCreditLimit()).Methods(http.MethodPut)
+	router.Handle("/{user_id}/segment", handler.handleSetSegment()).Methods(http.MethodPut)
+}
+
+func (h *accountHandler) handleChangeStatus(status model.AccountStatus) http.Handler {
+	type input struct {
+		Reason *string `json:"reason" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		account, err := h.service.ChangeStatus(r.Context(), id, status, *data.Reason, operator)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, account)
+	})
+}
+
+func (h *accountHandler) handleSetCreditLimit() http.Handler {
+	type input struct {
+		CreditLimit *int    `json:"credit_limit" required:"true"`
+		Reason      *string `json:"reason" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		account, err := h.service.SetCreditLimit(r.Context(), id, *data.CreditLimit, *data.Reason, operator)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, account)
+	})
+}
+
+func (h *accountHandler) handleSetSegment() http.Handler {
+	type input struct {
+		Segment *string `json:"segment" required:"true"`
+		Reason  *string `json:"reason" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		account, err := h.service.SetSegment(r.Context(), id, *data.Segment, *data.Reason, operator)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, account)
+	})
+}
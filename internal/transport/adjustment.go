@@ -0,0 +1,227 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedAdjustmentID  = errors.New("missed adjustment id")
+	ErrInvalidAdjustmentID = errors.New("adjustment id must be an integer")
+)
+
+type adjustmentService interface {
+	Create(
+		ctx context.Context,
+		userID int,
+		amount int,
+		reasonCode model.ReasonCode,
+		comment string,
+		operator string,
+	) (adjustment model.Adjustment, err error)
+	Approve(ctx context.Context, id int, operator string) (adjustment model.Adjustment, err error)
+	Reject(ctx context.Context, id int, operator string) (adjustment model.Adjustment, err error)
+	Reverse(ctx context.Context, id int, reversal model.Reversal) (adjustment model.Adjustment, err error)
+	Get(ctx context.Context, id int) (adjustment model.Adjustment, err error)
+	List(ctx context.Context, status model.AdjustmentStatus) (adjustments []model.Adjustment, err error)
+}
+
+type adjustmentHandler struct {
+	logger         *zap.SugaredLogger
+	service        adjustmentService
+	operatorHeader string
+}
+
+func registerAdjustmentRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service adjustmentService,
+	operatorHeader string,
+) {
+	handler := adjustmentHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	router.Handle("", handler.handleCreate()).Methods(http.MethodPost)
+	router.Handle("", handler.handleList()).Methods(http.MethodGet)
+	router.Handle("/{adjustment_id}", handler.handleGet()).Methods(http.MethodGet)
+	router.Handle("/{adjustment_id}/approve", handler.handleApprove()).Methods(http.MethodPost)
+	router.Handle("/{adjustment_id}/reject", handler.handleReject()).Methods(http.MethodPost)
+	router.Handle("/{adjustment_id}/reverse", handler.handleReverse()).Methods(http.MethodPost)
+}
+
+func getAdjustmentID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["adjustment_id"]
+	if !ok {
+		return 0, ErrMissedAdjustmentID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidAdjustmentID
+	}
+
+	return id, nil
+}
+
+func (h *adjustmentHandler) handleCreate() http.Handler {
+	type input struct {
+		UserID     *int    `json:"user_id" required:"true"`
+		Amount     *int    `json:"amount" required:"true"`
+		ReasonCode *string `json:"reason_code" required:"true"`
+		Comment    *string `json:"comment" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		adjustment, err := h.service.Create(
+			r.Context(),
+			*data.UserID,
+			*data.Amount,
+			model.ReasonCode(*data.ReasonCode),
+			*data.Comment,
+			operator,
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		code := http.StatusCreated
+		if adjustment.Status == model.AdjustmentPending {
+			code = http.StatusAccepted
+		}
+
+		response(h.logger, w, code, adjustment)
+	})
+}
+
+func (h *adjustmentHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := model.AdjustmentStatus(r.URL.Query().Get("status"))
+		if status == "" {
+			status = model.AdjustmentPending
+		}
+
+		adjustments, err := h.service.List(r.Context(), status)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"adjustments": adjustments,
+		})
+	})
+}
+
+func (h *adjustmentHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getAdjustmentID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		adjustment, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, adjustment)
+	})
+}
+
+func (h *adjustmentHandler) handleApprove() http.Handler {
+	return h.handleDecision(h.service.Approve)
+}
+
+func (h *adjustmentHandler) handleReject() http.Handler {
+	return h.handleDecision(h.service.Reject)
+}
+
+func (h *adjustmentHandler) handleReverse() http.Handler {
+	type input struct {
+		Reason *string `json:"reason" required:"true"`
+		Force  bool    `json:"force"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getAdjustmentID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		adjustment, err := h.service.Reverse(r.Context(), id, model.Reversal{
+			Reason:   *data.Reason,
+			Force:    data.Force,
+			Operator: operator,
+		})
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, adjustment)
+	})
+}
+
+func (h *adjustmentHandler) handleDecision(
+	decide func(ctx context.Context, id int, operator string) (model.Adjustment, error),
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getAdjustmentID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		adjustment, err := decide(r.Context(), id, operator)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, adjustment)
+	})
+}
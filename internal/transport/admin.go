@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+var ErrMissedOperator = errors.New("missed operator identity")
+
+const defaultOperatorHeader = "X-Operator-ID"
+
+// getOperator returns the identity of the admin making the request. The
+// admin port is internal, so the identity is set by the gateway in front of it.
+func getOperator(r *http.Request, header string) (string, error) {
+	operator := r.Header.Get(header)
+	if operator == "" {
+		return "", ErrMissedOperator
+	}
+
+	return operator, nil
+}
+
+// ConfigureAdminRouter returns a handler for the internal endpoints which
+// must not be exposed on the public port.
+func ConfigureAdminRouter(
+	logger *zap.SugaredLogger,
+	adjustmentService adjustmentService,
+	transactionService transactionService,
+	accountService accountService,
+	limitService limitService,
+	feeService feeService,
+	bonusService bonusService,
+	riskService riskService,
+	disputeService disputeService,
+	catalogService catalogService,
+	journalService journalService,
+	reconciliationService reconciliationService,
+	settlementService settlementService,
+	operatorHeader string,
+) http.Handler {
+	if operatorHeader == "" {
+		operatorHeader = defaultOperatorHeader
+	}
+
+	router := mux.NewRouter()
+
+	router.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	adjustments := router.PathPrefix("/adjustments").Subrouter()
+	registerAdjustmentRoutes(logger, adjustments, adjustmentService, operatorHeader)
+
+	transactions := router.PathPrefix("/transactions").Subrouter()
+	registerAdminTransactionRoutes(logger, transactions, transactionService, operatorHeader)
+
+	accounts := router.PathPrefix("/accounts").Subrouter()
+	registerAccountRoutes(logger, accounts, accountService, operatorHeader)
+
+	limits := router.PathPrefix("/limits").Subrouter()
+	registerLimitRoutes(logger, limits, limitService)
+
+	fees := router.PathPrefix("/fees").Subrouter()
+	registerAdminFeeRoutes(logger, fees, feeService)
+
+	bonuses := router.PathPrefix("/bonuses").Subrouter()
+	cashback := router.PathPrefix("/cashback").Subrouter()
+	registerAdminBonusRoutes(logger, bonuses, cashback, bonusService, operatorHeader)
+
+	risk := router.PathPrefix("/risk").Subrouter()
+	registerRiskRoutes(logger, risk, riskService, operatorHeader)
+
+	disputes := router.PathPrefix("/disputes").Subrouter()
+	registerAdminDisputeRoutes(logger, disputes, disputeService, operatorHeader)
+
+	catalog := router.PathPrefix("/catalog").Subrouter()
+	registerCatalogRoutes(logger, catalog, catalogService)
+
+	journal := router.PathPrefix("/journal").Subrouter()
+	registerJournalRoutes(logger, journal, journalService)
+
+	reconciliations := router.PathPrefix("/reconciliations").Subrouter()
+	registerReconciliationRoutes(logger, reconciliations, reconciliationService, operatorHeader)
+
+	settlements := router.PathPrefix("/settlements").Subrouter()
+	registerSettlementRoutes(logger, settlements, settlementService, operatorHeader)
+
+	mw := middleware{
+		logger: logger,
+	}
+
+	for _, r := range []*mux.Router{
+		adjustments,
+		transactions,
+		accounts,
+		limits,
+		fees,
+		bonuses,
+		cashback,
+		risk,
+		disputes,
+		catalog,
+		journal,
+		reconciliations,
+		settlements,
+	} {
+		r.Use(mw.catchPanic, mw.setRequestID, mw.traceRequest, mw.logRequest)
+	}
+
+	return router
+}
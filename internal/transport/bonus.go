@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+type bonusService interface {
+	Grant(
+		ctx context.Context,
+		userID int,
+		amount int,
+		serviceIDs []int,
+		expires time.Time,
+		reason string,
+		operator string,
+	) (grant model.BonusGrant, err error)
+	Grants(ctx context.Context, userID int) (grants []model.BonusGrant, err error)
+	CreateCashbackRule(ctx context.Context, rule model.CashbackRule) (created model.CashbackRule, err error)
+	UpdateCashbackRule(ctx context.Context, rule model.CashbackRule) (updated model.CashbackRule, err error)
+	DeleteCashbackRule(ctx context.Context, id int) (rule model.CashbackRule, err error)
+	GetCashbackRule(ctx context.Context, id int) (rule model.CashbackRule, err error)
+	ListCashbackRules(ctx context.Context) (rules []model.CashbackRule, err error)
+}
+
+type bonusHandler struct {
+	logger         *zap.SugaredLogger
+	service        bonusService
+	operatorHeader string
+}
+
+func registerBonusRoutes(logger *zap.SugaredLogger, router *mux.Router, service bonusService) {
+	handler := bonusHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	router.Handle("/{user_id}/bonuses", handler.handleGrants()).Methods(http.MethodGet)
+}
+
+// registerAdminBonusRoutes registers bonus grants and the management of
+// cashback rules.
+func registerAdminBonusRoutes(
+	logger *zap.SugaredLogger,
+	bonuses *mux.Router,
+	cashback *mux.Router,
+	service bonusService,
+	operatorHeader string,
+) {
+	handler := bonusHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	bonuses.Handle("", handler.handleGrant()).Methods(http.MethodPost)
+
+	cashback.Handle("", handler.handleCreateCashbackRule()).Methods(http.MethodPost)
+	cashback.Handle("", handler.handleListCashbackRules()).Methods(http.MethodGet)
+	cashback.Handle("/{rule_id}", handler.handleGetCashbackRule()).Methods(http.MethodGet)
+	cashback.Handle("/{rule_id}", handler.handleUpdateCashbackRule()).Methods(http.MethodPut)
+	cashback.Handle("/{rule_id}", handler.handleDeleteCashbackRule()).Methods(http.MethodDelete)
+}
+
+func (h *bonusHandler) handleGrants() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		grants, err := h.service.Grants(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"bonuses": grants,
+		})
+	})
+}
+
+func (h *bonusHandler) handleGrant() http.Handler {
+	type input struct {
+		UserID     *int       `json:"user_id" required:"true"`
+		Amount     *int       `json:"amount" required:"true"`
+		ServiceIDs []int      `json:"service_ids"`
+		Expires    *time.Time `json:"expires" required:"true"`
+		Reason     *string    `json:"reason" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		grant, err := h.service.Grant(
+			r.Context(),
+			*data.UserID,
+			*data.Amount,
+			data.ServiceIDs,
+			*data.Expires,
+			*data.Reason,
+			operator,
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, grant)
+	})
+}
+
+// cashbackRuleInput is the body of the create and update requests. Rules are
+// enabled unless stated otherwise.
+type cashbackRuleInput struct {
+	ServiceID    *int  `json:"service_id"`
+	RateBps      *int  `json:"rate_bps" required:"true"`
+	MaxAmount    *int  `json:"max_amount"`
+	ValidSeconds *int  `json:"valid_seconds" required:"true"`
+	Enabled      *bool `json:"enabled"`
+}
+
+func (in cashbackRuleInput) rule() model.CashbackRule {
+	enabled := true
+	if in.Enabled != nil {
+		enabled = *in.Enabled
+	}
+
+	return model.CashbackRule{
+		ServiceID:    in.ServiceID,
+		RateBps:      *in.RateBps,
+		MaxAmount:    in.MaxAmount,
+		ValidSeconds: *in.ValidSeconds,
+		Enabled:      enabled,
+	}
+}
+
+func (h *bonusHandler) handleCreateCashbackRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(cashbackRuleInput)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.CreateCashbackRule(r.Context(), data.rule())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, rule)
+	})
+}
+
+func (h *bonusHandler) handleListCashbackRules() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules, err := h.service.ListCashbackRules(r.Context())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"rules": rules,
+		})
+	})
+}
+
+func (h *bonusHandler) handleGetCashbackRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.GetCashbackRule(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *bonusHandler) handleUpdateCashbackRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(cashbackRuleInput)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule := data.rule()
+		rule.ID = id
+		if rule, err = h.service.UpdateCashbackRule(r.Context(), rule); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *bonusHandler) handleDeleteCashbackRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.DeleteCashbackRule(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
@@ -0,0 +1,277 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedServiceID  = errors.New("missed service id")
+	ErrInvalidServiceID = errors.New("service id must be an integer")
+	ErrMissedPriceID    = errors.New("missed price id")
+	ErrInvalidPriceID   = errors.New("price id must be an integer")
+)
+
+type catalogService interface {
+	Create(ctx context.Context, item model.CatalogItem) (created model.CatalogItem, err error)
+	Update(ctx context.Context, item model.CatalogItem) (updated model.CatalogItem, err error)
+	Get(ctx context.Context, id int) (item model.CatalogItem, err error)
+	List(ctx context.Context, category *string, active *bool) (items []model.CatalogItem, err error)
+	AddPrice(
+		ctx context.Context,
+		serviceID int,
+		price int,
+		validFrom *time.Time,
+		validTo *time.Time,
+	) (added model.ServicePrice, err error)
+	DeletePrice(ctx context.Context, serviceID, id int) (price model.ServicePrice, err error)
+}
+
+type catalogHandler struct {
+	logger  *zap.SugaredLogger
+	service catalogService
+}
+
+// registerCatalogRoutes registers the management of the service catalog and
+// its price lists.
+func registerCatalogRoutes(logger *zap.SugaredLogger, router *mux.Router, service catalogService) {
+	handler := catalogHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	router.Handle("", handler.handleCreate()).Methods(http.MethodPost)
+	router.Handle("", handler.handleList()).Methods(http.MethodGet)
+	router.Handle("/{service_id}", handler.handleGet()).Methods(http.MethodGet)
+	router.Handle("/{service_id}", handler.handleUpdate()).Methods(http.MethodPut)
+	router.Handle("/{service_id}/prices", handler.handleAddPrice()).Methods(http.MethodPost)
+	router.Handle("/{service_id}/prices/{price_id}", handler.handleDeletePrice()).Methods(http.MethodDelete)
+}
+
+func getServiceID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["service_id"]
+	if !ok {
+		return 0, ErrMissedServiceID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidServiceID
+	}
+
+	return id, nil
+}
+
+func getPriceID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["price_id"]
+	if !ok {
+		return 0, ErrMissedPriceID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidPriceID
+	}
+
+	return id, nil
+}
+
+// catalogItemInput is the body of the update request; the create request
+// adds the id of the service to it. Services are active and priced in the
+// default currency unless stated otherwise.
+type catalogItemInput struct {
+	Name     *string `json:"name" required:"true"`
+	Category *string `json:"category"`
+	Currency *string `json:"currency"`
+	Active   *bool   `json:"active"`
+}
+
+func (in catalogItemInput) item() model.CatalogItem {
+	item := model.CatalogItem{
+		Name:     *in.Name,
+		Currency: model.DefaultCurrency,
+		Active:   true,
+	}
+	if in.Category != nil {
+		item.Category = *in.Category
+	}
+	if in.Currency != nil {
+		item.Currency = *in.Currency
+	}
+	if in.Active != nil {
+		item.Active = *in.Active
+	}
+
+	return item
+}
+
+func (h *catalogHandler) handleCreate() http.Handler {
+	type input struct {
+		ID       *int    `json:"id" required:"true"`
+		Name     *string `json:"name" required:"true"`
+		Category *string `json:"category"`
+		Currency *string `json:"currency"`
+		Active   *bool   `json:"active"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(input)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		item := catalogItemInput{
+			Name:     data.Name,
+			Category: data.Category,
+			Currency: data.Currency,
+			Active:   data.Active,
+		}.item()
+		item.ID = *data.ID
+		item, err := h.service.Create(r.Context(), item)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, item)
+	})
+}
+
+func (h *catalogHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		var category *string
+		if value := params.Get("category"); value != "" {
+			category = &value
+		}
+
+		var active *bool
+		if value := params.Get("active"); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errorResponse(h.logger, w, r, invalidParameter("active"))
+				return
+			}
+
+			active = &b
+		}
+
+		items, err := h.service.List(r.Context(), category, active)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"services": items,
+		})
+	})
+}
+
+func (h *catalogHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getServiceID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		item, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, item)
+	})
+}
+
+func (h *catalogHandler) handleUpdate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getServiceID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(catalogItemInput)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		item := data.item()
+		item.ID = id
+		if item, err = h.service.Update(r.Context(), item); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, item)
+	})
+}
+
+func (h *catalogHandler) handleAddPrice() http.Handler {
+	type input struct {
+		Price     *int       `json:"price" required:"true"`
+		ValidFrom *time.Time `json:"valid_from"`
+		ValidTo   *time.Time `json:"valid_to"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getServiceID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		price, err := h.service.AddPrice(r.Context(), id, *data.Price, data.ValidFrom, data.ValidTo)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, price)
+	})
+}
+
+func (h *catalogHandler) handleDeletePrice() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serviceID, err := getServiceID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getPriceID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		price, err := h.service.DeletePrice(r.Context(), serviceID, id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, price)
+	})
+}
@@ -0,0 +1,221 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedDisputeID  = errors.New("missed dispute id")
+	ErrInvalidDisputeID = errors.New("dispute id must be an integer")
+)
+
+type disputeService interface {
+	Open(
+		ctx context.Context,
+		orderID, userID, serviceID int,
+		amount *int,
+		reason string,
+	) (dispute model.Dispute, err error)
+	Get(ctx context.Context, id int) (dispute model.Dispute, err error)
+	List(ctx context.Context, userID int) (disputes []model.Dispute, err error)
+	ListByStatus(ctx context.Context, status *model.DisputeStatus) (disputes []model.Dispute, err error)
+	Resolve(
+		ctx context.Context,
+		id int,
+		outcome model.DisputeOutcome,
+		operator string,
+		comment *string,
+	) (dispute model.Dispute, err error)
+}
+
+type disputeHandler struct {
+	logger         *zap.SugaredLogger
+	service        disputeService
+	operatorHeader string
+}
+
+// registerDisputeRoutes registers the dispute resources and the disputes of
+// a user.
+func registerDisputeRoutes(
+	logger *zap.SugaredLogger,
+	disputes *mux.Router,
+	users *mux.Router,
+	service disputeService,
+) {
+	handler := disputeHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	disputes.Handle("", handler.handleOpen()).Methods(http.MethodPost)
+	disputes.Handle("/{dispute_id}", handler.handleGet()).Methods(http.MethodGet)
+
+	users.Handle("/{user_id}/disputes", handler.handleList()).Methods(http.MethodGet)
+}
+
+// registerAdminDisputeRoutes registers the dispute queue and the resolution
+// of disputes.
+func registerAdminDisputeRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service disputeService,
+	operatorHeader string,
+) {
+	handler := disputeHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	router.Handle("", handler.handleQueue()).Methods(http.MethodGet)
+	router.Handle("/{dispute_id}", handler.handleGet()).Methods(http.MethodGet)
+	router.Handle("/{dispute_id}/resolve", handler.handleResolve()).Methods(http.MethodPost)
+}
+
+func getDisputeID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["dispute_id"]
+	if !ok {
+		return 0, ErrMissedDisputeID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidDisputeID
+	}
+
+	return id, nil
+}
+
+func (h *disputeHandler) handleOpen() http.Handler {
+	type input struct {
+		OrderID   *int    `json:"order_id" required:"true"`
+		UserID    *int    `json:"user_id" required:"true"`
+		ServiceID *int    `json:"service_id" required:"true"`
+		Amount    *int    `json:"amount"`
+		Reason    *string `json:"reason" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(input)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		dispute, err := h.service.Open(
+			r.Context(),
+			*data.OrderID,
+			*data.UserID,
+			*data.ServiceID,
+			data.Amount,
+			*data.Reason,
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, dispute)
+	})
+}
+
+func (h *disputeHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getDisputeID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		dispute, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, dispute)
+	})
+}
+
+func (h *disputeHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		disputes, err := h.service.List(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"disputes": disputes,
+		})
+	})
+}
+
+func (h *disputeHandler) handleQueue() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status *model.DisputeStatus
+		if value := r.URL.Query().Get("status"); value != "" {
+			s := model.DisputeStatus(value)
+			status = &s
+		}
+
+		disputes, err := h.service.ListByStatus(r.Context(), status)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"disputes": disputes,
+		})
+	})
+}
+
+func (h *disputeHandler) handleResolve() http.Handler {
+	type input struct {
+		Outcome *model.DisputeOutcome `json:"outcome" required:"true"`
+		Comment *string               `json:"comment"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getDisputeID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		dispute, err := h.service.Resolve(r.Context(), id, *data.Outcome, operator, data.Comment)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, dispute)
+	})
+}
@@ -1,7 +1,178 @@
 package transport
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/s02190058/billing-service/internal/service"
+)
 
 var (
-	ErrBadRequest = errors.New("bad request")
+	ErrBadRequest       = errors.New("bad request")
+	ErrInvalidRequest   = errors.New("request doesn't match the api specification")
+	ErrMissedField      = errors.New("missed required field")
+	ErrInvalidParameter = errors.New("invalid query parameter")
 )
+
+type problemType struct {
+	err    error
+	status int
+	code   string
+}
+
+// problemTypes maps known errors to HTTP statuses and stable error codes.
+// Clients must rely on the code, not on the error message.
+var problemTypes = []problemType{
+	{ErrBadRequest, http.StatusBadRequest, "BAD_REQUEST"},
+	{ErrInvalidRequest, http.StatusBadRequest, "INVALID_REQUEST"},
+	{ErrMissedField, http.StatusBadRequest, "MISSED_FIELD"},
+	{ErrInvalidParameter, http.StatusBadRequest, "INVALID_PARAMETER"},
+	{ErrTooManyRequests, http.StatusTooManyRequests, "TOO_MANY_REQUESTS"},
+	{ErrMissedUserID, http.StatusBadRequest, "MISSED_USER_ID"},
+	{ErrInvalidUserID, http.StatusBadRequest, "INVALID_USER_ID"},
+	{ErrInvalidOrder, http.StatusBadRequest, "INVALID_ORDER"},
+	{ErrMissedOrderID, http.StatusBadRequest, "MISSED_ORDER_ID"},
+	{ErrInvalidOrderID, http.StatusBadRequest, "INVALID_ORDER_ID"},
+	{ErrMissedYear, http.StatusBadRequest, "MISSED_YEAR"},
+	{ErrInvalidYear, http.StatusBadRequest, "INVALID_YEAR"},
+	{ErrMissedMonth, http.StatusBadRequest, "MISSED_MONTH"},
+	{ErrInvalidMonth, http.StatusBadRequest, "INVALID_MONTH"},
+	{ErrMissedTransactionID, http.StatusBadRequest, "MISSED_TRANSACTION_ID"},
+	{ErrInvalidTransactionID, http.StatusBadRequest, "INVALID_TRANSACTION_ID"},
+	{ErrMissedOperator, http.StatusUnauthorized, "MISSED_OPERATOR"},
+	{ErrMissedAdjustmentID, http.StatusBadRequest, "MISSED_ADJUSTMENT_ID"},
+	{ErrInvalidAdjustmentID, http.StatusBadRequest, "INVALID_ADJUSTMENT_ID"},
+	{ErrMissedRuleID, http.StatusBadRequest, "MISSED_RULE_ID"},
+	{ErrInvalidRuleID, http.StatusBadRequest, "INVALID_RULE_ID"},
+	{ErrMissedSubscriptionID, http.StatusBadRequest, "MISSED_SUBSCRIPTION_ID"},
+	{ErrInvalidSubscriptionID, http.StatusBadRequest, "INVALID_SUBSCRIPTION_ID"},
+	{ErrMissedScheduledTransferID, http.StatusBadRequest, "MISSED_SCHEDULED_TRANSFER_ID"},
+	{ErrInvalidScheduledTransferID, http.StatusBadRequest, "INVALID_SCHEDULED_TRANSFER_ID"},
+	{ErrMissedTransferID, http.StatusBadRequest, "MISSED_TRANSFER_ID"},
+	{ErrInvalidTransferID, http.StatusBadRequest, "INVALID_TRANSFER_ID"},
+	{ErrMissedDisputeID, http.StatusBadRequest, "MISSED_DISPUTE_ID"},
+	{ErrInvalidDisputeID, http.StatusBadRequest, "INVALID_DISPUTE_ID"},
+	{ErrMissedServiceID, http.StatusBadRequest, "MISSED_SERVICE_ID"},
+	{ErrInvalidServiceID, http.StatusBadRequest, "INVALID_SERVICE_ID"},
+	{ErrMissedPriceID, http.StatusBadRequest, "MISSED_PRICE_ID"},
+	{ErrInvalidPriceID, http.StatusBadRequest, "INVALID_PRICE_ID"},
+	{ErrMissedReconciliationID, http.StatusBadRequest, "MISSED_RECONCILIATION_ID"},
+	{ErrInvalidReconciliationID, http.StatusBadRequest, "INVALID_RECONCILIATION_ID"},
+	{ErrMissedSettlementID, http.StatusBadRequest, "MISSED_SETTLEMENT_ID"},
+	{ErrInvalidSettlementID, http.StatusBadRequest, "INVALID_SETTLEMENT_ID"},
+	{ErrMissedReviewID, http.StatusBadRequest, "MISSED_REVIEW_ID"},
+	{ErrInvalidReviewID, http.StatusBadRequest, "INVALID_REVIEW_ID"},
+
+	{service.ErrInsufficientFunds, http.StatusUnprocessableEntity, "INSUFFICIENT_FUNDS"},
+	{service.ErrInvalidAmount, http.StatusBadRequest, "INVALID_AMOUNT"},
+	{service.ErrInvalidOrderField, http.StatusBadRequest, "INVALID_ORDER_FIELD"},
+	{service.ErrInvalidCursor, http.StatusBadRequest, "INVALID_CURSOR"},
+	{service.ErrInvalidLimit, http.StatusBadRequest, "INVALID_LIMIT"},
+	{service.ErrInvalidDirection, http.StatusBadRequest, "INVALID_DIRECTION"},
+	{service.ErrInvalidRange, http.StatusBadRequest, "INVALID_RANGE"},
+	{service.ErrInvalidTransfer, http.StatusUnprocessableEntity, "INVALID_TRANSFER"},
+	{service.ErrInvalidType, http.StatusBadRequest, "INVALID_TRANSACTION_TYPE"},
+	{service.ErrTransactionNotFound, http.StatusNotFound, "TRANSACTION_NOT_FOUND"},
+	{service.ErrNotReversible, http.StatusUnprocessableEntity, "NOT_REVERSIBLE"},
+	{service.ErrAlreadyReversed, http.StatusConflict, "ALREADY_REVERSED"},
+	{service.ErrDuplicateReference, http.StatusConflict, "DUPLICATE_REFERENCE"},
+	{service.ErrMissedReason, http.StatusBadRequest, "MISSED_REASON"},
+	{service.ErrUserNotFound, http.StatusNotFound, "USER_NOT_FOUND"},
+	{service.ErrReservedAccount, http.StatusBadRequest, "RESERVED_ACCOUNT"},
+	{service.ErrUserExists, http.StatusConflict, "USER_EXISTS"},
+	{service.ErrAccountFrozen, http.StatusUnprocessableEntity, "ACCOUNT_FROZEN"},
+	{service.ErrAccountClosed, http.StatusUnprocessableEntity, "ACCOUNT_CLOSED"},
+	{service.ErrInvalidStatusTransition, http.StatusConflict, "INVALID_STATUS_TRANSITION"},
+	{service.ErrNonZeroBalance, http.StatusConflict, "NON_ZERO_BALANCE"},
+	{service.ErrOpenReserves, http.StatusConflict, "OPEN_RESERVES"},
+	{service.ErrInvalidCreditLimit, http.StatusBadRequest, "INVALID_CREDIT_LIMIT"},
+	{service.ErrCreditLimitTooLow, http.StatusConflict, "CREDIT_LIMIT_TOO_LOW"},
+	{service.ErrMissedSegment, http.StatusBadRequest, "MISSED_SEGMENT"},
+	{service.ErrLimitExceeded, http.StatusUnprocessableEntity, "LIMIT_EXCEEDED"},
+	{service.ErrInvalidLimitOperation, http.StatusBadRequest, "INVALID_LIMIT_OPERATION"},
+	{service.ErrInvalidLimitWindow, http.StatusBadRequest, "INVALID_LIMIT_WINDOW"},
+	{service.ErrInvalidLimitRule, http.StatusBadRequest, "INVALID_LIMIT_RULE"},
+	{service.ErrLimitRuleNotFound, http.StatusNotFound, "LIMIT_RULE_NOT_FOUND"},
+	{service.ErrInvalidFeeOperation, http.StatusBadRequest, "INVALID_FEE_OPERATION"},
+	{service.ErrInvalidFeeKind, http.StatusBadRequest, "INVALID_FEE_KIND"},
+	{service.ErrInvalidFeeRule, http.StatusBadRequest, "INVALID_FEE_RULE"},
+	{service.ErrFeeRuleNotFound, http.StatusNotFound, "FEE_RULE_NOT_FOUND"},
+	{service.ErrInvalidBonus, http.StatusBadRequest, "INVALID_BONUS"},
+	{service.ErrInvalidExpiry, http.StatusBadRequest, "INVALID_EXPIRY"},
+	{service.ErrInvalidCashbackRule, http.StatusBadRequest, "INVALID_CASHBACK_RULE"},
+	{service.ErrCashbackRuleNotFound, http.StatusNotFound, "CASHBACK_RULE_NOT_FOUND"},
+	{service.ErrInvalidPrice, http.StatusBadRequest, "INVALID_PRICE"},
+	{service.ErrInvalidPeriod, http.StatusBadRequest, "INVALID_PERIOD"},
+	{service.ErrInvalidStart, http.StatusBadRequest, "INVALID_START"},
+	{service.ErrSubscriptionNotFound, http.StatusNotFound, "SUBSCRIPTION_NOT_FOUND"},
+	{service.ErrInvalidSubscriptionTransition, http.StatusConflict, "INVALID_SUBSCRIPTION_TRANSITION"},
+	{service.ErrScheduledTransferNotFound, http.StatusNotFound, "SCHEDULED_TRANSFER_NOT_FOUND"},
+	{service.ErrScheduledTransferNotActive, http.StatusConflict, "SCHEDULED_TRANSFER_NOT_ACTIVE"},
+	{service.ErrInvalidTimeout, http.StatusBadRequest, "INVALID_TIMEOUT"},
+	{service.ErrInvalidTransferStatus, http.StatusBadRequest, "INVALID_TRANSFER_STATUS"},
+	{service.ErrPendingTransferNotFound, http.StatusNotFound, "PENDING_TRANSFER_NOT_FOUND"},
+	{service.ErrPendingTransferNotHeld, http.StatusConflict, "PENDING_TRANSFER_NOT_HELD"},
+	{service.ErrNotTransferSender, http.StatusForbidden, "NOT_TRANSFER_SENDER"},
+	{service.ErrNotTransferReceiver, http.StatusForbidden, "NOT_TRANSFER_RECEIVER"},
+	{service.ErrInvalidDisputeAmount, http.StatusBadRequest, "INVALID_DISPUTE_AMOUNT"},
+	{service.ErrInvalidOutcome, http.StatusBadRequest, "INVALID_OUTCOME"},
+	{service.ErrInvalidDisputeStatus, http.StatusBadRequest, "INVALID_DISPUTE_STATUS"},
+	{service.ErrNotDisputable, http.StatusUnprocessableEntity, "NOT_DISPUTABLE"},
+	{service.ErrDisputeWindowClosed, http.StatusUnprocessableEntity, "DISPUTE_WINDOW_CLOSED"},
+	{service.ErrAlreadyDisputed, http.StatusConflict, "ALREADY_DISPUTED"},
+	{service.ErrDisputeNotFound, http.StatusNotFound, "DISPUTE_NOT_FOUND"},
+	{service.ErrDisputeNotOpen, http.StatusConflict, "DISPUTE_NOT_OPEN"},
+	{service.ErrInvalidCatalogItem, http.StatusBadRequest, "INVALID_CATALOG_ITEM"},
+	{service.ErrCatalogItemExists, http.StatusConflict, "CATALOG_ITEM_EXISTS"},
+	{service.ErrCatalogItemNotFound, http.StatusNotFound, "CATALOG_ITEM_NOT_FOUND"},
+	{service.ErrInvalidServicePrice, http.StatusBadRequest, "INVALID_SERVICE_PRICE"},
+	{service.ErrServicePriceNotFound, http.StatusNotFound, "SERVICE_PRICE_NOT_FOUND"},
+	{service.ErrServiceInactive, http.StatusUnprocessableEntity, "SERVICE_INACTIVE"},
+	{service.ErrNoServicePrice, http.StatusUnprocessableEntity, "NO_SERVICE_PRICE"},
+	{service.ErrPriceMismatch, http.StatusUnprocessableEntity, "PRICE_MISMATCH"},
+	{service.ErrReconciliationNotFound, http.StatusNotFound, "RECONCILIATION_NOT_FOUND"},
+	{service.ErrMissedProvider, http.StatusBadRequest, "MISSED_PROVIDER"},
+	{service.ErrInvalidSettlementFormat, http.StatusBadRequest, "INVALID_SETTLEMENT_FORMAT"},
+	{service.ErrInvalidSettlementFile, http.StatusUnprocessableEntity, "INVALID_SETTLEMENT_FILE"},
+	{service.ErrSettlementFileTooLarge, http.StatusRequestEntityTooLarge, "SETTLEMENT_FILE_TOO_LARGE"},
+	{service.ErrSettlementExists, http.StatusConflict, "SETTLEMENT_EXISTS"},
+	{service.ErrSettlementConflict, http.StatusConflict, "SETTLEMENT_CONFLICT"},
+	{service.ErrSettlementNotFound, http.StatusNotFound, "SETTLEMENT_NOT_FOUND"},
+	{service.ErrInvalidSettlementStatus, http.StatusBadRequest, "INVALID_SETTLEMENT_STATUS"},
+	{service.ErrRiskDenied, http.StatusUnprocessableEntity, "RISK_DENIED"},
+	{service.ErrRiskReview, http.StatusUnprocessableEntity, "RISK_REVIEW"},
+	{service.ErrInvalidRiskRule, http.StatusBadRequest, "INVALID_RISK_RULE"},
+	{service.ErrRiskRuleNotFound, http.StatusNotFound, "RISK_RULE_NOT_FOUND"},
+	{service.ErrReviewNotFound, http.StatusNotFound, "REVIEW_NOT_FOUND"},
+	{service.ErrReviewNotPending, http.StatusConflict, "REVIEW_NOT_PENDING"},
+	{service.ErrInvalidReviewStatus, http.StatusBadRequest, "INVALID_REVIEW_STATUS"},
+	{service.ErrNotBlocked, http.StatusNotFound, "NOT_BLOCKED"},
+	{service.ErrAlreadyReserved, http.StatusBadRequest, "ALREADY_RESERVED"},
+	{service.ErrInvalidCost, http.StatusBadRequest, "INVALID_COST"},
+	{service.ErrInvalidMonth, http.StatusBadRequest, "INVALID_MONTH"},
+	{service.ErrRecordNotFound, http.StatusNotFound, "RECORD_NOT_FOUND"},
+	{service.ErrInvalidAdjustment, http.StatusBadRequest, "INVALID_ADJUSTMENT"},
+	{service.ErrInvalidReasonCode, http.StatusBadRequest, "INVALID_REASON_CODE"},
+	{service.ErrMissedComment, http.StatusBadRequest, "MISSED_COMMENT"},
+	{service.ErrInvalidStatus, http.StatusBadRequest, "INVALID_STATUS"},
+	{service.ErrAdjustmentNotFound, http.StatusNotFound, "ADJUSTMENT_NOT_FOUND"},
+	{service.ErrAdjustmentNotPending, http.StatusConflict, "ADJUSTMENT_NOT_PENDING"},
+	{service.ErrAdjustmentNotApplied, http.StatusConflict, "ADJUSTMENT_NOT_APPLIED"},
+	{service.ErrSelfApproval, http.StatusForbidden, "SELF_APPROVAL"},
+}
+
+var internalProblem = problemType{
+	err:    service.ErrInternalServerError,
+	status: http.StatusInternalServerError,
+	code:   "INTERNAL_ERROR",
+}
+
+func lookupProblemType(err error) problemType {
+	for _, p := range problemTypes {
+		if errors.Is(err, p.err) {
+			return p
+		}
+	}
+
+	return internalProblem
+}
@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+type feeService interface {
+	Create(ctx context.Context, rule model.FeeRule) (created model.FeeRule, err error)
+	Update(ctx context.Context, rule model.FeeRule) (updated model.FeeRule, err error)
+	Delete(ctx context.Context, id int) (rule model.FeeRule, err error)
+	Get(ctx context.Context, id int) (rule model.FeeRule, err error)
+	List(ctx context.Context) (rules []model.FeeRule, err error)
+	Quote(ctx context.Context, operation model.FeeOperation, serviceID *int, amount int) (quote model.FeeQuote, err error)
+}
+
+type feeHandler struct {
+	logger  *zap.SugaredLogger
+	service feeService
+}
+
+func registerFeeRoutes(logger *zap.SugaredLogger, router *mux.Router, service feeService) {
+	handler := feeHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	router.Handle("/quote", handler.handleQuote()).Methods(http.MethodGet)
+}
+
+// registerAdminFeeRoutes registers the management of fee rules.
+func registerAdminFeeRoutes(logger *zap.SugaredLogger, router *mux.Router, service feeService) {
+	handler := feeHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	router.Handle("", handler.handleCreate()).Methods(http.MethodPost)
+	router.Handle("", handler.handleList()).Methods(http.MethodGet)
+	router.Handle("/{rule_id}", handler.handleGet()).Methods(http.MethodGet)
+	router.Handle("/{rule_id}", handler.handleUpdate()).Methods(http.MethodPut)
+	router.Handle("/{rule_id}", handler.handleDelete()).Methods(http.MethodDelete)
+}
+
+// feeRuleInput is the body of the create and update requests. Rules are
+// enabled unless stated otherwise.
+type feeRuleInput struct {
+	Operation *string         `json:"operation" required:"true"`
+	ServiceID *int            `json:"service_id"`
+	Kind      *string         `json:"kind" required:"true"`
+	Flat      *int            `json:"flat"`
+	RateBps   *int            `json:"rate_bps"`
+	Tiers     []model.FeeTier `json:"tiers"`
+	MinFee    *int            `json:"min_fee"`
+	MaxFee    *int            `json:"max_fee"`
+	Enabled   *bool           `json:"enabled"`
+}
+
+func (in feeRuleInput) rule() model.FeeRule {
+	rule := model.FeeRule{
+		Operation: model.FeeOperation(*in.Operation),
+		ServiceID: in.ServiceID,
+		Kind:      model.FeeKind(*in.Kind),
+		Tiers:     in.Tiers,
+		MinFee:    in.MinFee,
+		MaxFee:    in.MaxFee,
+		Enabled:   true,
+	}
+	if in.Flat != nil {
+		rule.Flat = *in.Flat
+	}
+	if in.RateBps != nil {
+		rule.RateBps = *in.RateBps
+	}
+	if in.Enabled != nil {
+		rule.Enabled = *in.Enabled
+	}
+
+	return rule
+}
+
+func (h *feeHandler) handleQuote() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		amount, err := intParam(params, "amount")
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+		if amount == nil {
+			errorResponse(h.logger, w, r, invalidParameter("amount"))
+			return
+		}
+
+		serviceID, err := intParam(params, "service_id")
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		operation := model.FeeOperation(params.Get("operation"))
+		quote, err := h.service.Quote(r.Context(), operation, serviceID, *amount)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, quote)
+	})
+}
+
+func (h *feeHandler) handleCreate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(feeRuleInput)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.Create(r.Context(), data.rule())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, rule)
+	})
+}
+
+func (h *feeHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules, err := h.service.List(r.Context())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"rules": rules,
+		})
+	})
+}
+
+func (h *feeHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *feeHandler) handleUpdate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(feeRuleInput)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule := data.rule()
+		rule.ID = id
+		if rule, err = h.service.Update(r.Context(), rule); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *feeHandler) handleDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.Delete(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
@@ -0,0 +1,177 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/s02190058/billing-service/internal/service"
+	"github.com/s02190058/billing-service/pkg/billingpb"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const requestIDMetadataKey = "x-request-id"
+
+// ConfigureGRPCServer returns a grpc server exposing the same services as
+// the http router.
+func ConfigureGRPCServer(
+	logger *zap.SugaredLogger,
+	userService userService,
+	orderService orderService,
+) *grpc.Server {
+	ic := interceptors{
+		logger: logger,
+	}
+
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		ic.catchPanic,
+		ic.setRequestID,
+		ic.logCall,
+		ic.mapError,
+	))
+
+	billingpb.RegisterUserServiceServer(server, &userGRPCHandler{service: userService})
+	billingpb.RegisterOrderServiceServer(server, &orderGRPCHandler{service: orderService})
+
+	return server
+}
+
+type interceptors struct {
+	logger *zap.SugaredLogger
+}
+
+func (ic *interceptors) catchPanic(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (resp any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			ic.logger.Errorf("panic occured in %s: %v", info.FullMethod, p)
+			err = status.Error(codes.Internal, service.ErrInternalServerError.Error())
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+func (ic *interceptors) setRequestID(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDMetadataKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadataKey, id)); err != nil {
+		ic.logger.Errorf("can't set request id header: %v", err)
+	}
+
+	return handler(context.WithValue(ctx, requestIDKey, id), req)
+}
+
+func (ic *interceptors) logCall(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	logger := ic.logger.With(
+		"request_id", requestID(ctx),
+	)
+
+	logger.Infof("started %s", info.FullMethod)
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	logger.Infof(
+		"completed with %s in %v",
+		status.Code(err),
+		time.Since(start),
+	)
+
+	return resp, err
+}
+
+// mapError converts service errors to grpc statuses. The stable error code
+// and the error details are passed as google.rpc.ErrorInfo.
+func (ic *interceptors) mapError(
+	ctx context.Context,
+	req any,
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+
+	p := lookupProblemType(err)
+
+	message := err.Error()
+	if p == internalProblem {
+		message = service.ErrInternalServerError.Error()
+	}
+
+	info := &errdetails.ErrorInfo{
+		Reason: p.code,
+		Domain: "billing-service",
+		Metadata: map[string]string{
+			"request_id": requestID(ctx),
+		},
+	}
+
+	var detailedErr *service.DetailedError
+	if errors.As(err, &detailedErr) {
+		for key, value := range detailedErr.Details {
+			info.Metadata[key] = fmt.Sprint(value)
+		}
+	}
+
+	st, detailsErr := status.New(grpcCode(p.status), message).WithDetails(info)
+	if detailsErr != nil {
+		ic.logger.Errorf("can't attach error details: %v", detailsErr)
+		return nil, status.Error(grpcCode(p.status), message)
+	}
+
+	return nil, st.Err()
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/s02190058/billing-service/pkg/billingpb"
+)
+
+type orderGRPCHandler struct {
+	billingpb.UnimplementedOrderServiceServer
+	service orderService
+}
+
+func (h *orderGRPCHandler) Reserve(
+	ctx context.Context,
+	req *billingpb.OrderRequest,
+) (*billingpb.StatusResponse, error) {
+	err := h.service.Reserve(ctx, int(req.OrderId), int(req.UserId), int(req.ServiceId), int(req.Cost))
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.StatusResponse{Status: "reserved"}, nil
+}
+
+func (h *orderGRPCHandler) Confirm(
+	ctx context.Context,
+	req *billingpb.OrderRequest,
+) (*billingpb.StatusResponse, error) {
+	err := h.service.Confirm(ctx, int(req.OrderId), int(req.UserId), int(req.ServiceId), int(req.Cost))
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.StatusResponse{Status: "confirmed"}, nil
+}
+
+func (h *orderGRPCHandler) Reject(
+	ctx context.Context,
+	req *billingpb.OrderRequest,
+) (*billingpb.StatusResponse, error) {
+	err := h.service.Reject(ctx, int(req.OrderId), int(req.UserId), int(req.ServiceId), int(req.Cost))
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.StatusResponse{Status: "rejected"}, nil
+}
+
+func (h *orderGRPCHandler) Report(
+	ctx context.Context,
+	req *billingpb.ReportRequest,
+) (*billingpb.ReportResponse, error) {
+	path, err := h.service.Report(ctx, int(req.Year), int(req.Month))
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.ReportResponse{Path: path}, nil
+}
@@ -0,0 +1,208 @@
+package transport
+
+import (
+	"context"
+
+	"github.com/s02190058/billing-service/internal/model"
+	"github.com/s02190058/billing-service/pkg/billingpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type userGRPCHandler struct {
+	billingpb.UnimplementedUserServiceServer
+	service userService
+}
+
+func (h *userGRPCHandler) CreateAccount(
+	ctx context.Context,
+	req *billingpb.CreateAccountRequest,
+) (*billingpb.Account, error) {
+	account, err := h.service.CreateAccount(ctx, int(req.UserId), req.Metadata.AsMap())
+	if err != nil {
+		return nil, err
+	}
+
+	return grpcAccount(account)
+}
+
+func (h *userGRPCHandler) GetAccount(
+	ctx context.Context,
+	req *billingpb.GetAccountRequest,
+) (*billingpb.Account, error) {
+	account, err := h.service.GetAccount(ctx, int(req.UserId))
+	if err != nil {
+		return nil, err
+	}
+
+	return grpcAccount(account)
+}
+
+func grpcAccount(a model.Account) (*billingpb.Account, error) {
+	metadata, err := structpb.NewStruct(a.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &billingpb.Account{
+		Id:          int64(a.ID),
+		Balance:     int64(a.Balance),
+		CreditLimit: int64(a.CreditLimit),
+		Available:   int64(a.Available),
+		Status:      string(a.Status),
+		Segment:     a.Segment,
+		Metadata:    metadata,
+		Created:     timestamppb.New(a.Created),
+		Events:      make([]*billingpb.AccountEvent, 0, len(a.Events)),
+	}
+	for _, e := range a.Events {
+		account.Events = append(account.Events, &billingpb.AccountEvent{
+			Status:      string(e.Status),
+			CreditLimit: optionalInt64(e.CreditLimit),
+			Segment:     e.Segment,
+			Reason:      e.Reason,
+			Operator:    e.Operator,
+			Created:     timestamppb.New(e.Created),
+		})
+	}
+
+	return account, nil
+}
+
+func (h *userGRPCHandler) GetBalance(
+	ctx context.Context,
+	req *billingpb.GetBalanceRequest,
+) (*billingpb.BalanceResponse, error) {
+	balance, err := h.service.GetBalance(ctx, int(req.UserId))
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.BalanceResponse{
+		Balance:     int64(balance.Balance),
+		CreditLimit: int64(balance.CreditLimit),
+		Available:   int64(balance.Available),
+		Bonus:       int64(balance.Bonus),
+	}, nil
+}
+
+func (h *userGRPCHandler) TopUpBalance(
+	ctx context.Context,
+	req *billingpb.TopUpBalanceRequest,
+) (*billingpb.BalanceResponse, error) {
+	balance, err := h.service.TopUpBalance(ctx, int(req.UserId), int(req.Amount), model.Reference{
+		ExternalRef: req.ExternalRef,
+		Metadata:    req.Metadata.AsMap(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.BalanceResponse{Balance: int64(balance)}, nil
+}
+
+func (h *userGRPCHandler) Transfer(
+	ctx context.Context,
+	req *billingpb.TransferRequest,
+) (*billingpb.BalanceResponse, error) {
+	balance, err := h.service.Transfer(ctx, int(req.UserId), int(req.ReceiverId), int(req.Amount), model.Reference{
+		ExternalRef: req.ExternalRef,
+		Metadata:    req.Metadata.AsMap(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.BalanceResponse{Balance: int64(balance)}, nil
+}
+
+func (h *userGRPCHandler) Transactions(
+	ctx context.Context,
+	req *billingpb.TransactionsRequest,
+) (*billingpb.TransactionsResponse, error) {
+	query := model.TransactionsQuery{
+		OrderField: req.OrderField,
+		Descending: req.Descending,
+		Limit:      int(req.Limit),
+		Direction:  req.Direction,
+		Type:       model.TransactionType(req.Type),
+	}
+	if query.OrderField == "" {
+		query.OrderField = "created"
+	}
+	if query.Limit == 0 {
+		query.Limit = 25
+	}
+	if req.From != nil {
+		from := req.From.AsTime()
+		query.From = &from
+	}
+	if req.To != nil {
+		to := req.To.AsTime()
+		query.To = &to
+	}
+	if req.MinAmount != nil {
+		minAmount := int(*req.MinAmount)
+		query.MinAmount = &minAmount
+	}
+	if req.MaxAmount != nil {
+		maxAmount := int(*req.MaxAmount)
+		query.MaxAmount = &maxAmount
+	}
+	if req.CounterpartyId != nil {
+		counterpartyID := int(*req.CounterpartyId)
+		query.CounterpartyID = &counterpartyID
+	}
+
+	page, err := h.service.Transactions(ctx, int(req.UserId), query, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &billingpb.TransactionsResponse{
+		Transactions: make([]*billingpb.Transaction, 0, len(page.Transactions)),
+		NextCursor:   page.NextCursor,
+	}
+	for _, t := range page.Transactions {
+		transaction, err := grpcTransaction(t)
+		if err != nil {
+			return nil, err
+		}
+		resp.Transactions = append(resp.Transactions, transaction)
+	}
+
+	return resp, nil
+}
+
+func grpcTransaction(t model.Transaction) (*billingpb.Transaction, error) {
+	metadata, err := structpb.NewStruct(t.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &billingpb.Transaction{
+		Id:             int64(t.ID),
+		UserId:         int64(t.UserID),
+		Amount:         int64(t.Amount),
+		Message:        t.Message,
+		Created:        timestamppb.New(t.Created),
+		Type:           string(t.Type),
+		CounterpartyId: optionalInt64(t.CounterpartyID),
+		OrderId:        optionalInt64(t.OrderID),
+		ServiceId:      optionalInt64(t.ServiceID),
+		ExternalRef:    t.ExternalRef,
+		Metadata:       metadata,
+		PairId:         optionalInt64(t.PairID),
+		ReversalOf:     optionalInt64(t.ReversalOf),
+		ReversedBy:     optionalInt64(t.ReversedBy),
+	}, nil
+}
+
+func optionalInt64(v *int) *int64 {
+	if v == nil {
+		return nil
+	}
+
+	i := int64(*v)
+	return &i
+}
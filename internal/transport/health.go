@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/pkg/health"
+	"go.uber.org/zap"
+)
+
+type healthHandler struct {
+	logger *zap.SugaredLogger
+	health *health.Health
+}
+
+func registerHealthRoutes(logger *zap.SugaredLogger, router *mux.Router, health *health.Health) {
+	handler := healthHandler{
+		logger: logger,
+		health: health,
+	}
+
+	router.Handle("/healthz", handler.handleLiveness()).Methods(http.MethodGet)
+	router.Handle("/readyz", handler.handleReadiness()).Methods(http.MethodGet)
+	router.Handle("/startupz", handler.handleStartup()).Methods(http.MethodGet)
+}
+
+func (h *healthHandler) handleLiveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusResponse(h.logger, w, http.StatusOK, "ok")
+	})
+}
+
+func (h *healthHandler) handleReadiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks, ready := h.health.Ready(r.Context())
+
+		status, code := "ok", http.StatusOK
+		switch {
+		case h.health.Draining():
+			status, code = "draining", http.StatusServiceUnavailable
+		case !h.health.Started():
+			status, code = "starting", http.StatusServiceUnavailable
+		case !ready:
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+
+		response(h.logger, w, code, map[string]any{
+			"status": status,
+			"checks": checks,
+		})
+	})
+}
+
+func (h *healthHandler) handleStartup() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.health.Started() {
+			statusResponse(h.logger, w, http.StatusServiceUnavailable, "starting")
+			return
+		}
+
+		statusResponse(h.logger, w, http.StatusOK, "ok")
+	})
+}
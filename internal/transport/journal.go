@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+type journalService interface {
+	Verify(ctx context.Context) (report model.ChainReport, err error)
+}
+
+type journalHandler struct {
+	logger  *zap.SugaredLogger
+	service journalService
+}
+
+// registerJournalRoutes registers the verification of the journal hash
+// chains.
+func registerJournalRoutes(logger *zap.SugaredLogger, router *mux.Router, service journalService) {
+	handler := journalHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	router.Handle("/verify", handler.handleVerify()).Methods(http.MethodGet)
+}
+
+func (h *journalHandler) handleVerify() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := h.service.Verify(r.Context())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, report)
+	})
+}
@@ -0,0 +1,178 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedRuleID  = errors.New("missed limit rule id")
+	ErrInvalidRuleID = errors.New("limit rule id must be an integer")
+)
+
+type limitService interface {
+	Create(ctx context.Context, rule model.LimitRule) (created model.LimitRule, err error)
+	Update(ctx context.Context, rule model.LimitRule) (updated model.LimitRule, err error)
+	Delete(ctx context.Context, id int) (rule model.LimitRule, err error)
+	Get(ctx context.Context, id int) (rule model.LimitRule, err error)
+	List(ctx context.Context) (rules []model.LimitRule, err error)
+}
+
+type limitHandler struct {
+	logger  *zap.SugaredLogger
+	service limitService
+}
+
+func registerLimitRoutes(logger *zap.SugaredLogger, router *mux.Router, service limitService) {
+	handler := limitHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	router.Handle("", handler.handleCreate()).Methods(http.MethodPost)
+	router.Handle("", handler.handleList()).Methods(http.MethodGet)
+	router.Handle("/{rule_id}", handler.handleGet()).Methods(http.MethodGet)
+	router.Handle("/{rule_id}", handler.handleUpdate()).Methods(http.MethodPut)
+	router.Handle("/{rule_id}", handler.handleDelete()).Methods(http.MethodDelete)
+}
+
+func getRuleID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["rule_id"]
+	if !ok {
+		return 0, ErrMissedRuleID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidRuleID
+	}
+
+	return id, nil
+}
+
+// limitRuleInput is the body of the create and update requests. Rules are
+// enabled unless stated otherwise.
+type limitRuleInput struct {
+	Operation     *string `json:"operation" required:"true"`
+	Segment       *string `json:"segment"`
+	WindowSeconds *int    `json:"window_seconds" required:"true"`
+	MaxAmount     *int    `json:"max_amount"`
+	MaxCount      *int    `json:"max_count"`
+	Enabled       *bool   `json:"enabled"`
+}
+
+func (in limitRuleInput) rule() model.LimitRule {
+	enabled := true
+	if in.Enabled != nil {
+		enabled = *in.Enabled
+	}
+
+	return model.LimitRule{
+		Operation:     model.LimitOperation(*in.Operation),
+		Segment:       in.Segment,
+		WindowSeconds: *in.WindowSeconds,
+		MaxAmount:     in.MaxAmount,
+		MaxCount:      in.MaxCount,
+		Enabled:       enabled,
+	}
+}
+
+func (h *limitHandler) handleCreate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(limitRuleInput)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.Create(r.Context(), data.rule())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, rule)
+	})
+}
+
+func (h *limitHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules, err := h.service.List(r.Context())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"rules": rules,
+		})
+	})
+}
+
+func (h *limitHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *limitHandler) handleUpdate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(limitRuleInput)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule := data.rule()
+		rule.ID = id
+		if rule, err = h.service.Update(r.Context(), rule); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *limitHandler) handleDelete() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.Delete(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "billing",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of handled requests.",
+	}, []string{"method", "route", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "billing",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Request handling latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+
+	rateLimitedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "billing",
+		Subsystem: "http",
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of requests rejected by the rate limiter.",
+	}, []string{"route", "scope"})
+)
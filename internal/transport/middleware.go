@@ -3,14 +3,24 @@ package transport
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/s02190058/billing-service/pkg/ratelimit"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 type middleware struct {
-	logger *zap.SugaredLogger
+	logger  *zap.SugaredLogger
+	limiter ratelimit.Limiter
+	policy  RateLimitPolicy
 }
 
 type ctxRequestIDKey int
@@ -29,6 +39,11 @@ func (m *middleware) catchPanic(next http.Handler) http.Handler {
 	})
 }
 
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
 func (m *middleware) setRequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := uuid.New().String()
@@ -37,12 +52,51 @@ func (m *middleware) setRequestID(next http.Handler) http.Handler {
 	})
 }
 
+// traceRequest starts a server span continuing the trace passed by the
+// client in the W3C traceparent header, if any.
+func (m *middleware) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		route := routeTemplate(r)
+		ctx, span := tracer.Start(
+			ctx,
+			r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(route),
+				semconv.HTTPTargetKey.String(r.RequestURI),
+				attribute.String("request_id", requestID(r.Context())),
+			),
+		)
+		defer span.End()
+
+		rw := &responseWriter{
+			ResponseWriter: w,
+			code:           http.StatusOK,
+		}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rw.code))
+		if rw.code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.code))
+		}
+	})
+}
+
 func (m *middleware) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger := m.logger.With(
 			"remote_addr", r.RemoteAddr,
 			"request_id", r.Context().Value(requestIDKey),
 		)
+		if spanCtx := trace.SpanContextFromContext(r.Context()); spanCtx.IsValid() {
+			logger = logger.With(
+				"trace_id", spanCtx.TraceID().String(),
+				"span_id", spanCtx.SpanID().String(),
+			)
+		}
 
 		logger.Infof("started %s %s", r.Method, r.RequestURI)
 
@@ -53,11 +107,18 @@ func (m *middleware) logRequest(next http.Handler) http.Handler {
 		}
 		next.ServeHTTP(rw, r)
 
+		elapsed := time.Since(start)
+
 		logger.Infof(
 			"completed with %d %s in %v",
 			rw.code,
 			http.StatusText(rw.code),
-			time.Since(start),
+			elapsed,
 		)
+
+		route := routeTemplate(r)
+		code := strconv.Itoa(rw.code)
+		httpRequests.WithLabelValues(r.Method, route, code).Inc()
+		httpRequestDuration.WithLabelValues(r.Method, route, code).Observe(elapsed.Seconds())
 	})
 }
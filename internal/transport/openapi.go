@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+type OpenAPIOptions struct {
+	Spec              []byte
+	ValidateRequests  bool
+	ValidateResponses bool
+}
+
+// openAPIValidator checks requests and responses against the api
+// specification. Invalid requests are rejected; invalid responses are only
+// logged, because they mean the specification and the code have diverged.
+type openAPIValidator struct {
+	logger  *zap.SugaredLogger
+	router  routers.Router
+	options OpenAPIOptions
+}
+
+func newOpenAPIValidator(logger *zap.SugaredLogger, options OpenAPIOptions) (*openAPIValidator, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(options.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("can't load api specification: %w", err)
+	}
+
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid api specification: %w", err)
+	}
+
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("can't build api specification router: %w", err)
+	}
+
+	return &openAPIValidator{
+		logger:  logger,
+		router:  router,
+		options: options,
+	}, nil
+}
+
+type bodyRecorder struct {
+	*responseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.responseWriter.Write(b)
+}
+
+func (v *openAPIValidator) validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := v.router.FindRoute(r)
+		if err != nil {
+			// routes missing in the specification are not validated
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				IncludeResponseStatus: true,
+			},
+		}
+
+		// the api accepts only json, but clients such as curl send bodies as
+		// form data by default
+		if mediaType := r.Header.Get("Content-Type"); r.ContentLength != 0 &&
+			(mediaType == "" || mediaType == "application/x-www-form-urlencoded") {
+			r.Header.Set("Content-Type", "application/json")
+		}
+
+		if v.options.ValidateRequests {
+			if err = openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				reason := validationReason(err)
+				errorResponse(v.logger, w, r, service.WithDetails(
+					fmt.Errorf("%w: %s", ErrInvalidRequest, reason),
+					service.Details{"reason": reason},
+				))
+				return
+			}
+		}
+
+		if !v.options.ValidateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &bodyRecorder{
+			responseWriter: &responseWriter{
+				ResponseWriter: w,
+				code:           http.StatusOK,
+			},
+		}
+		next.ServeHTTP(rw, r)
+
+		if err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 rw.code,
+			Header:                 rw.Header(),
+			Body:                   io.NopCloser(&rw.body),
+			Options:                input.Options,
+		}); err != nil {
+			v.logger.Errorf("response to %s %s doesn't match the api specification: %v", r.Method, r.URL.Path, err)
+		}
+	})
+}
+
+// validationReason returns a short description of a validation error
+// without the schema dump kin-openapi adds to its messages.
+func validationReason(err error) string {
+	var requestErr *openapi3filter.RequestError
+	if !errors.As(err, &requestErr) {
+		return err.Error()
+	}
+
+	reason := requestErr.Reason
+	var schemaErr *openapi3.SchemaError
+	if errors.As(requestErr.Err, &schemaErr) {
+		reason = schemaErr.Reason
+	} else if reason == "" && requestErr.Err != nil {
+		reason = requestErr.Err.Error()
+	}
+
+	if requestErr.Parameter != nil {
+		return fmt.Sprintf("parameter %q: %s", requestErr.Parameter.Name, reason)
+	}
+
+	return reason
+}
+
+func handleOpenAPI(spec []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = w.Write(spec)
+	})
+}
@@ -1,13 +1,12 @@
 package transport
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
-	"github.com/s02190058/billing-service/internal/service"
 	"go.uber.org/zap"
 )
 
@@ -21,10 +20,10 @@ var (
 )
 
 type orderService interface {
-	Reserve(orderID, userID, serviceID int, cost int) (err error)
-	Confirm(orderID, userID, serviceID int, cost int) (err error)
-	Reject(orderID, userID, serviceID int, cost int) (err error)
-	Report(year, month int) (path string, err error)
+	Reserve(ctx context.Context, orderID, userID, serviceID int, cost int) (err error)
+	Confirm(ctx context.Context, orderID, userID, serviceID int, cost int) (err error)
+	Reject(ctx context.Context, orderID, userID, serviceID int, cost int) (err error)
+	Report(ctx context.Context, year, month int) (path string, err error)
 }
 
 type orderHandler struct {
@@ -67,43 +66,26 @@ func statusResponse(logger *zap.SugaredLogger, w http.ResponseWriter, code int,
 
 func (h *orderHandler) HandleReserve() http.Handler {
 	type input struct {
-		UserID    int `json:"user_id"`
-		ServiceID int `json:"service_id"`
-		Cost      int `json:"cost"`
+		UserID    *int `json:"user_id" required:"true"`
+		ServiceID *int `json:"service_id" required:"true"`
+		Cost      *int `json:"cost" required:"true"`
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getOrderID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
 		data := new(input)
-		if err = json.NewDecoder(r.Body).Decode(data); err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrBadRequest)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		if err = r.Body.Close(); err != nil {
-			h.logger.Errorf("can't close request body: %v", err)
-		}
-
-		if err = h.service.Reserve(id, data.UserID, data.ServiceID, data.Cost); err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidCost):
-				code = http.StatusBadRequest
-			case errors.Is(err, service.ErrUserNotFound):
-				code = http.StatusNotFound
-			case errors.Is(err, service.ErrInsufficientFunds):
-				code = http.StatusUnprocessableEntity
-			case errors.Is(err, service.ErrAlreadyReserved):
-				code = http.StatusBadRequest
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+		if err = h.service.Reserve(r.Context(), id, *data.UserID, *data.ServiceID, *data.Cost); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
@@ -113,39 +95,26 @@ func (h *orderHandler) HandleReserve() http.Handler {
 
 func (h *orderHandler) HandleConfirm() http.Handler {
 	type input struct {
-		UserID    int `json:"user_id"`
-		ServiceID int `json:"service_id"`
-		Cost      int `json:"cost"`
+		UserID    *int `json:"user_id" required:"true"`
+		ServiceID *int `json:"service_id" required:"true"`
+		Cost      *int `json:"cost" required:"true"`
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getOrderID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
 		data := new(input)
-		if err = json.NewDecoder(r.Body).Decode(data); err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrBadRequest)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		if err = r.Body.Close(); err != nil {
-			h.logger.Errorf("can't close request body: %v", err)
-		}
-
-		if err = h.service.Confirm(id, data.UserID, data.ServiceID, data.Cost); err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidCost):
-				code = http.StatusBadRequest
-			case errors.Is(err, service.ErrRecordNotFound):
-				code = http.StatusNotFound
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+		if err = h.service.Confirm(r.Context(), id, *data.UserID, *data.ServiceID, *data.Cost); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
@@ -155,39 +124,26 @@ func (h *orderHandler) HandleConfirm() http.Handler {
 
 func (h *orderHandler) HandleReject() http.Handler {
 	type input struct {
-		UserID    int `json:"user_id"`
-		ServiceID int `json:"service_id"`
-		Cost      int `json:"cost"`
+		UserID    *int `json:"user_id" required:"true"`
+		ServiceID *int `json:"service_id" required:"true"`
+		Cost      *int `json:"cost" required:"true"`
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getOrderID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
 		data := new(input)
-		if err = json.NewDecoder(r.Body).Decode(data); err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrBadRequest)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		if err = r.Body.Close(); err != nil {
-			h.logger.Errorf("can't close request body: %v", err)
-		}
-
-		if err = h.service.Reject(id, data.UserID, data.ServiceID, data.Cost); err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidCost):
-				code = http.StatusBadRequest
-			case errors.Is(err, service.ErrRecordNotFound):
-				code = http.StatusNotFound
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+		if err = h.service.Reject(r.Context(), id, *data.UserID, *data.ServiceID, *data.Cost); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
@@ -200,35 +156,28 @@ func (h *orderHandler) handleReport() http.Handler {
 		params := r.URL.Query()
 
 		if params.Get("year") == "" {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrMissedYear)
+			errorResponse(h.logger, w, r, ErrMissedYear)
 			return
 		}
 		year, err := strconv.Atoi(params.Get("year"))
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrInvalidYear)
+			errorResponse(h.logger, w, r, ErrInvalidYear)
 			return
 		}
 
 		if params.Get("month") == "" {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrMissedMonth)
+			errorResponse(h.logger, w, r, ErrMissedMonth)
 			return
 		}
 		month, err := strconv.Atoi(params.Get("month"))
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrInvalidMonth)
+			errorResponse(h.logger, w, r, ErrInvalidMonth)
 			return
 		}
 
-		path, err := h.service.Report(year, month)
+		path, err := h.service.Report(r.Context(), year, month)
 		if err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidMonth):
-				code = http.StatusBadRequest
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
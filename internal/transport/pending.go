@@ -0,0 +1,179 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedTransferID  = errors.New("missed transfer id")
+	ErrInvalidTransferID = errors.New("transfer id must be an integer")
+)
+
+type pendingTransferService interface {
+	Hold(
+		ctx context.Context,
+		senderID int,
+		receiverID int,
+		amount int,
+		timeoutSeconds *int,
+		ref model.Reference,
+	) (transfer model.PendingTransfer, err error)
+	Get(ctx context.Context, userID, id int) (transfer model.PendingTransfer, err error)
+	List(
+		ctx context.Context,
+		userID int,
+		status *model.PendingTransferStatus,
+	) (transfers []model.PendingTransfer, err error)
+	Capture(ctx context.Context, userID, id int) (transfer model.PendingTransfer, err error)
+	Void(ctx context.Context, userID, id int) (transfer model.PendingTransfer, err error)
+}
+
+type pendingTransferHandler struct {
+	logger  *zap.SugaredLogger
+	service pendingTransferService
+}
+
+// registerPendingTransferRoutes registers the pending transfers of a user,
+// which are the transfers the user sent or receives.
+func registerPendingTransferRoutes(logger *zap.SugaredLogger, users *mux.Router, service pendingTransferService) {
+	handler := pendingTransferHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	users.Handle("/{user_id}/transfers", handler.handleHold()).Methods(http.MethodPost)
+	users.Handle("/{user_id}/transfers", handler.handleList()).Methods(http.MethodGet)
+	users.Handle("/{user_id}/transfers/{transfer_id}", handler.handleGet()).Methods(http.MethodGet)
+	users.Handle("/{user_id}/transfers/{transfer_id}/capture", handler.handleCapture()).Methods(http.MethodPost)
+	users.Handle("/{user_id}/transfers/{transfer_id}/void", handler.handleVoid()).Methods(http.MethodPost)
+}
+
+func getTransferID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["transfer_id"]
+	if !ok {
+		return 0, ErrMissedTransferID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidTransferID
+	}
+
+	return id, nil
+}
+
+func (h *pendingTransferHandler) handleHold() http.Handler {
+	type input struct {
+		ReceiverID     *int           `json:"receiver_id" required:"true"`
+		Amount         *int           `json:"amount" required:"true"`
+		TimeoutSeconds *int           `json:"timeout_seconds"`
+		ExternalRef    *string        `json:"external_ref"`
+		Metadata       map[string]any `json:"metadata"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		transfer, err := h.service.Hold(
+			r.Context(),
+			id,
+			*data.ReceiverID,
+			*data.Amount,
+			data.TimeoutSeconds,
+			model.Reference{
+				ExternalRef: data.ExternalRef,
+				Metadata:    data.Metadata,
+			},
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, transfer)
+	})
+}
+
+func (h *pendingTransferHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		var status *model.PendingTransferStatus
+		if value := r.URL.Query().Get("status"); value != "" {
+			s := model.PendingTransferStatus(value)
+			status = &s
+		}
+
+		transfers, err := h.service.List(r.Context(), id, status)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"transfers": transfers,
+		})
+	})
+}
+
+func (h *pendingTransferHandler) handleGet() http.Handler {
+	return h.handleTransfer(h.service.Get)
+}
+
+func (h *pendingTransferHandler) handleCapture() http.Handler {
+	return h.handleTransfer(h.service.Capture)
+}
+
+func (h *pendingTransferHandler) handleVoid() http.Handler {
+	return h.handleTransfer(h.service.Void)
+}
+
+// handleTransfer applies fn to the transfer of the path on behalf of the user
+// of the path.
+func (h *pendingTransferHandler) handleTransfer(
+	fn func(ctx context.Context, userID, id int) (model.PendingTransfer, error),
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getTransferID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		transfer, err := fn(r.Context(), userID, id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, transfer)
+	})
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/service"
+	"github.com/s02190058/billing-service/pkg/ratelimit"
+)
+
+var ErrTooManyRequests = errors.New("too many requests")
+
+// RateLimitPolicy describes which bucket applies to a request. Route rules
+// are keyed either by a path template ("/users/{user_id}/transactions") or by
+// a method and a path template ("GET /users/{user_id}/transactions") and are
+// applied per client. Client rules are quotas shared by all routes, keyed by
+// the address of the client.
+type RateLimitPolicy struct {
+	Default ratelimit.Rule
+	Routes  map[string]ratelimit.Rule
+	Clients map[string]ratelimit.Rule
+}
+
+func (p RateLimitPolicy) routeRule(method, route string) ratelimit.Rule {
+	if rule, ok := p.Routes[method+" "+route]; ok {
+		return rule
+	}
+	if rule, ok := p.Routes[route]; ok {
+		return rule
+	}
+
+	return p.Default
+}
+
+// clientID identifies the client by its address: headers are set by the
+// client itself, so a client could pick a fresh bucket with every request.
+func clientID(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return r.URL.Path
+}
+
+func (m *middleware) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := routeTemplate(r)
+		client := clientID(r)
+
+		// the keys of a client share a hash tag, so Redis Cluster keeps
+		// them in one slot
+		var (
+			scopes  []string
+			buckets []ratelimit.Bucket
+		)
+		if rule := m.policy.routeRule(r.Method, route); rule.Valid() {
+			scopes = append(scopes, "route")
+			buckets = append(buckets, ratelimit.Bucket{
+				Key:  "{" + client + "}|" + r.Method + " " + route,
+				Rule: rule,
+			})
+		}
+		if rule, ok := m.policy.Clients[client]; ok && rule.Valid() {
+			scopes = append(scopes, "client")
+			buckets = append(buckets, ratelimit.Bucket{
+				Key:  "{" + client + "}",
+				Rule: rule,
+			})
+		}
+		if len(buckets) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		results, err := m.limiter.Allow(r.Context(), buckets...)
+		if err != nil {
+			// the limiter must not take the service down with it
+			m.logger.Errorf("can't check rate limit for %q: %v", client, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var strictest *ratelimit.Result
+		for i := range results {
+			res := &results[i]
+			if !res.Allowed {
+				rateLimitedRequests.WithLabelValues(route, scopes[i]).Inc()
+				strictest = res
+				break
+			}
+			if strictest == nil || res.Remaining < strictest.Remaining {
+				strictest = res
+			}
+		}
+
+		if strictest != nil {
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(strictest.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(int(strictest.Reset.Seconds())))
+
+			if !strictest.Allowed {
+				h.Set("Retry-After", strconv.Itoa(int(strictest.RetryAfter.Seconds())))
+				errorResponse(m.logger, w, r, service.WithDetails(
+					ErrTooManyRequests,
+					service.Details{"retry_after": int(strictest.RetryAfter.Seconds())},
+				))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
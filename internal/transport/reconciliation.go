@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedReconciliationID  = errors.New("missed reconciliation id")
+	ErrInvalidReconciliationID = errors.New("reconciliation id must be an integer")
+)
+
+type reconciliationService interface {
+	Run(ctx context.Context, operator string, repair bool) (run model.Reconciliation, err error)
+	Get(ctx context.Context, id int) (run model.Reconciliation, err error)
+	List(ctx context.Context, limit *int) (runs []model.Reconciliation, err error)
+}
+
+type reconciliationHandler struct {
+	logger         *zap.SugaredLogger
+	service        reconciliationService
+	operatorHeader string
+}
+
+// registerReconciliationRoutes registers the reconciliation of balances with
+// the journal.
+func registerReconciliationRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service reconciliationService,
+	operatorHeader string,
+) {
+	handler := reconciliationHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	router.Handle("", handler.handleRun()).Methods(http.MethodPost)
+	router.Handle("", handler.handleList()).Methods(http.MethodGet)
+	router.Handle("/{reconciliation_id}", handler.handleGet()).Methods(http.MethodGet)
+}
+
+func getReconciliationID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["reconciliation_id"]
+	if !ok {
+		return 0, ErrMissedReconciliationID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidReconciliationID
+	}
+
+	return id, nil
+}
+
+func (h *reconciliationHandler) handleRun() http.Handler {
+	type input struct {
+		Repair *bool `json:"repair"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if r.ContentLength != 0 {
+			if err = decodeBody(h.logger, r, data); err != nil {
+				errorResponse(h.logger, w, r, err)
+				return
+			}
+		}
+
+		repair := data.Repair != nil && *data.Repair
+
+		run, err := h.service.Run(r.Context(), operator, repair)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, run)
+	})
+}
+
+func (h *reconciliationHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, err := intParam(r.URL.Query(), "limit")
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		runs, err := h.service.List(r.Context(), limit)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"reconciliations": runs,
+		})
+	})
+}
+
+func (h *reconciliationHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getReconciliationID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		run, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, run)
+	})
+}
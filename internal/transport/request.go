@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/s02190058/billing-service/internal/service"
+	"go.uber.org/zap"
+)
+
+// decodeBody strictly decodes the request body into data: unknown fields
+// are rejected, and pointer fields tagged `required:"true"` must be present.
+func decodeBody(logger *zap.SugaredLogger, r *http.Request, data any) error {
+	_, span := tracer.Start(r.Context(), "decode request body")
+	defer span.End()
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(data); err != nil {
+		span.RecordError(err)
+		return service.WithDetails(
+			fmt.Errorf("%w: %v", ErrBadRequest, err),
+			service.Details{"reason": err.Error()},
+		)
+	}
+
+	if err := r.Body.Close(); err != nil {
+		logger.Errorf("can't close request body: %v", err)
+	}
+
+	return checkRequired(data)
+}
+
+func checkRequired(data any) error {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("required") != "true" || !v.Field(i).IsNil() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		return service.WithDetails(
+			fmt.Errorf("%w: %s", ErrMissedField, name),
+			service.Details{"field": name},
+		)
+	}
+
+	return nil
+}
+
+func invalidParameter(name string) error {
+	return service.WithDetails(
+		fmt.Errorf("%w: %s", ErrInvalidParameter, name),
+		service.Details{"parameter": name},
+	)
+}
+
+// intParam parses an optional integer query parameter.
+func intParam(params url.Values, name string) (*int, error) {
+	if params.Get(name) == "" {
+		return nil, nil
+	}
+
+	value, err := strconv.Atoi(params.Get(name))
+	if err != nil {
+		return nil, invalidParameter(name)
+	}
+
+	return &value, nil
+}
+
+// timeParam parses an optional RFC 3339 query parameter.
+func timeParam(params url.Values, name string) (*time.Time, error) {
+	if params.Get(name) == "" {
+		return nil, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, params.Get(name))
+	if err != nil {
+		return nil, invalidParameter(name)
+	}
+
+	value = value.UTC()
+
+	return &value, nil
+}
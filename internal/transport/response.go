@@ -2,15 +2,51 @@ package transport
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/s02190058/billing-service/internal/service"
 	"go.uber.org/zap"
 )
 
-func errorResponse(logger *zap.SugaredLogger, w http.ResponseWriter, code int, err error) {
-	response(logger, w, code, map[string]string{
-		"message": err.Error(),
-	})
+// problem is an RFC 7807 problem details object. Error details are added
+// as extension members.
+type problem map[string]any
+
+// errorResponse writes err as problem details. The status and the code are
+// taken from problemTypes; unknown errors are reported as internal ones
+// without exposing their messages.
+func errorResponse(logger *zap.SugaredLogger, w http.ResponseWriter, r *http.Request, err error) {
+	p := lookupProblemType(err)
+
+	detail := err.Error()
+	if p == internalProblem {
+		detail = service.ErrInternalServerError.Error()
+	}
+
+	body := problem{}
+
+	var detailedErr *service.DetailedError
+	if errors.As(err, &detailedErr) {
+		for key, value := range detailedErr.Details {
+			body[key] = value
+		}
+	}
+
+	body["type"] = "urn:billing-service:problem:" + strings.ToLower(strings.ReplaceAll(p.code, "_", "-"))
+	body["title"] = p.err.Error()
+	body["status"] = p.status
+	body["detail"] = detail
+	body["instance"] = r.URL.Path
+	body["code"] = p.code
+	body["request_id"] = requestID(r.Context())
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.status)
+	if err = json.NewEncoder(w).Encode(body); err != nil {
+		logger.Errorf("can't write the server response: %v", err)
+	}
 }
 
 func response(logger *zap.SugaredLogger, w http.ResponseWriter, code int, data any) {
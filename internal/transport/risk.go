@@ -0,0 +1,386 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedReviewID  = errors.New("missed review id")
+	ErrInvalidReviewID = errors.New("review id must be an integer")
+)
+
+type riskService interface {
+	CreateRule(ctx context.Context, rule model.RiskRule) (created model.RiskRule, err error)
+	UpdateRule(ctx context.Context, rule model.RiskRule) (updated model.RiskRule, err error)
+	DeleteRule(ctx context.Context, id int) (rule model.RiskRule, err error)
+	GetRule(ctx context.Context, id int) (rule model.RiskRule, err error)
+	ListRules(ctx context.Context) (rules []model.RiskRule, err error)
+	Decisions(ctx context.Context, userID *int, limit *int) (decisions []model.RiskDecision, err error)
+	Reviews(ctx context.Context, status *model.ReviewStatus) (reviews []model.RiskReview, err error)
+	GetReview(ctx context.Context, id int) (review model.RiskReview, err error)
+	ApproveReview(ctx context.Context, id int, reviewer string, comment *string) (review model.RiskReview, err error)
+	RejectReview(ctx context.Context, id int, reviewer string, comment *string) (review model.RiskReview, err error)
+	Block(ctx context.Context, userID int, reason string, operator string) (blocked model.BlockedUser, err error)
+	Unblock(ctx context.Context, userID int) (blocked model.BlockedUser, err error)
+	Blocklist(ctx context.Context) (blocklist []model.BlockedUser, err error)
+}
+
+type riskHandler struct {
+	logger         *zap.SugaredLogger
+	service        riskService
+	operatorHeader string
+}
+
+func registerRiskRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service riskService,
+	operatorHeader string,
+) {
+	handler := riskHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	rules := router.PathPrefix("/rules").Subrouter()
+	rules.Handle("", handler.handleCreateRule()).Methods(http.MethodPost)
+	rules.Handle("", handler.handleListRules()).Methods(http.MethodGet)
+	rules.Handle("/{rule_id}", handler.handleGetRule()).Methods(http.MethodGet)
+	rules.Handle("/{rule_id}", handler.handleUpdateRule()).Methods(http.MethodPut)
+	rules.Handle("/{rule_id}", handler.handleDeleteRule()).Methods(http.MethodDelete)
+
+	reviews := router.PathPrefix("/reviews").Subrouter()
+	reviews.Handle("", handler.handleListReviews()).Methods(http.MethodGet)
+	reviews.Handle("/{review_id}", handler.handleGetReview()).Methods(http.MethodGet)
+	reviews.Handle("/{review_id}/approve", handler.handleApproveReview()).Methods(http.MethodPost)
+	reviews.Handle("/{review_id}/reject", handler.handleRejectReview()).Methods(http.MethodPost)
+
+	blocklist := router.PathPrefix("/blocklist").Subrouter()
+	blocklist.Handle("", handler.handleBlock()).Methods(http.MethodPost)
+	blocklist.Handle("", handler.handleBlocklist()).Methods(http.MethodGet)
+	blocklist.Handle("/{user_id}", handler.handleUnblock()).Methods(http.MethodDelete)
+
+	router.Handle("/decisions", handler.handleDecisions()).Methods(http.MethodGet)
+}
+
+func getReviewID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["review_id"]
+	if !ok {
+		return 0, ErrMissedReviewID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidReviewID
+	}
+
+	return id, nil
+}
+
+// riskRuleInput is the body of the create and update requests. Rules are
+// enabled unless stated otherwise.
+type riskRuleInput struct {
+	Name      *string              `json:"name" required:"true"`
+	Operation *string              `json:"operation"`
+	Condition *model.RiskCondition `json:"condition" required:"true"`
+	Outcome   *string              `json:"outcome" required:"true"`
+	Enabled   *bool                `json:"enabled"`
+}
+
+func (in riskRuleInput) rule() model.RiskRule {
+	enabled := true
+	if in.Enabled != nil {
+		enabled = *in.Enabled
+	}
+
+	var operation *model.RiskOperation
+	if in.Operation != nil {
+		op := model.RiskOperation(*in.Operation)
+		operation = &op
+	}
+
+	return model.RiskRule{
+		Name:      *in.Name,
+		Operation: operation,
+		Condition: *in.Condition,
+		Outcome:   model.RiskOutcome(*in.Outcome),
+		Enabled:   enabled,
+	}
+}
+
+func (h *riskHandler) handleCreateRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(riskRuleInput)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.CreateRule(r.Context(), data.rule())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, rule)
+	})
+}
+
+func (h *riskHandler) handleListRules() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules, err := h.service.ListRules(r.Context())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"rules": rules,
+		})
+	})
+}
+
+func (h *riskHandler) handleGetRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.GetRule(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *riskHandler) handleUpdateRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(riskRuleInput)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule := data.rule()
+		rule.ID = id
+		if rule, err = h.service.UpdateRule(r.Context(), rule); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *riskHandler) handleDeleteRule() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getRuleID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		rule, err := h.service.DeleteRule(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, rule)
+	})
+}
+
+func (h *riskHandler) handleListReviews() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status *model.ReviewStatus
+		if value := r.URL.Query().Get("status"); value != "" {
+			s := model.ReviewStatus(value)
+			status = &s
+		}
+
+		reviews, err := h.service.Reviews(r.Context(), status)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"reviews": reviews,
+		})
+	})
+}
+
+func (h *riskHandler) handleGetReview() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getReviewID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		review, err := h.service.GetReview(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, review)
+	})
+}
+
+func (h *riskHandler) handleApproveReview() http.Handler {
+	return h.handleDecision(h.service.ApproveReview)
+}
+
+func (h *riskHandler) handleRejectReview() http.Handler {
+	return h.handleDecision(h.service.RejectReview)
+}
+
+// handleDecision decides on the review. The body with the comment of the
+// reviewer is optional.
+func (h *riskHandler) handleDecision(
+	decide func(ctx context.Context, id int, reviewer string, comment *string) (model.RiskReview, error),
+) http.Handler {
+	type input struct {
+		Comment *string `json:"comment"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getReviewID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if r.ContentLength != 0 {
+			if err = decodeBody(h.logger, r, data); err != nil {
+				errorResponse(h.logger, w, r, err)
+				return
+			}
+		}
+
+		review, err := decide(r.Context(), id, operator, data.Comment)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, review)
+	})
+}
+
+func (h *riskHandler) handleBlock() http.Handler {
+	type input struct {
+		UserID *int    `json:"user_id" required:"true"`
+		Reason *string `json:"reason" required:"true"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		blocked, err := h.service.Block(r.Context(), *data.UserID, *data.Reason, operator)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, blocked)
+	})
+}
+
+func (h *riskHandler) handleBlocklist() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blocklist, err := h.service.Blocklist(r.Context())
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"blocklist": blocklist,
+		})
+	})
+}
+
+func (h *riskHandler) handleUnblock() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		blocked, err := h.service.Unblock(r.Context(), userID)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, blocked)
+	})
+}
+
+func (h *riskHandler) handleDecisions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		userID, err := intParam(params, "user_id")
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		limit, err := intParam(params, "limit")
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		decisions, err := h.service.Decisions(r.Context(), userID, limit)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"decisions": decisions,
+		})
+	})
+}
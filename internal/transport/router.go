@@ -4,6 +4,9 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/service"
+	"github.com/s02190058/billing-service/pkg/health"
+	"github.com/s02190058/billing-service/pkg/ratelimit"
 	"go.uber.org/zap"
 )
 
@@ -11,22 +14,63 @@ func ConfigureRouter(
 	logger *zap.SugaredLogger,
 	userService userService,
 	orderService orderService,
-) http.Handler {
-	router := mux.NewRouter()
+	feeService feeService,
+	bonusService bonusService,
+	subscriptionService subscriptionService,
+	scheduledTransferService scheduledTransferService,
+	pendingTransferService pendingTransferService,
+	disputeService disputeService,
+	limiter ratelimit.Limiter,
+	policy RateLimitPolicy,
+	probes *health.Health,
+	openAPI OpenAPIOptions,
+) (http.Handler, error) {
+	validator, err := newOpenAPIValidator(logger, openAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	root := mux.NewRouter()
+
+	// probes are registered outside of the api router, so they are neither
+	// logged nor rate limited
+	registerHealthRoutes(logger, root, probes)
+
+	root.Handle("/openapi.json", handleOpenAPI(openAPI.Spec)).Methods(http.MethodGet)
+
+	router := root.PathPrefix("/").Subrouter()
 
-	registerUserRoutes(logger, router.PathPrefix("/users").Subrouter(), userService)
+	users := router.PathPrefix("/users").Subrouter()
+	registerUserRoutes(logger, users, userService)
+	registerBonusRoutes(logger, users, bonusService)
+	registerPendingTransferRoutes(logger, users, pendingTransferService)
 
 	registerOrderRoutes(logger, router.PathPrefix("/orders").Subrouter(), orderService)
 
+	registerFeeRoutes(logger, router.PathPrefix("/fees").Subrouter(), feeService)
+
+	registerSubscriptionRoutes(logger, router.PathPrefix("/subscriptions").Subrouter(), users, subscriptionService)
+
+	registerScheduledTransferRoutes(
+		logger,
+		router.PathPrefix("/scheduled-transfers").Subrouter(),
+		users,
+		scheduledTransferService,
+	)
+
+	registerDisputeRoutes(logger, router.PathPrefix("/disputes").Subrouter(), users, disputeService)
+
 	router.PathPrefix("/reports/").Handler(
-		http.StripPrefix("/reports", http.FileServer(http.Dir("/reports"))),
+		http.StripPrefix("/reports", http.FileServer(http.Dir(service.ReportsDir))),
 	)
 
 	mw := middleware{
-		logger: logger,
+		logger:  logger,
+		limiter: limiter,
+		policy:  policy,
 	}
 
-	router.Use(mw.catchPanic, mw.setRequestID, mw.logRequest)
+	router.Use(mw.catchPanic, mw.setRequestID, mw.traceRequest, mw.logRequest, mw.rateLimit, validator.validate)
 
-	return router
+	return root, nil
 }
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedScheduledTransferID  = errors.New("missed scheduled transfer id")
+	ErrInvalidScheduledTransferID = errors.New("scheduled transfer id must be an integer")
+)
+
+type scheduledTransferService interface {
+	Create(
+		ctx context.Context,
+		userID int,
+		receiverID int,
+		amount int,
+		start time.Time,
+		period *model.Period,
+	) (transfer model.ScheduledTransfer, err error)
+	Get(ctx context.Context, id int) (transfer model.ScheduledTransfer, err error)
+	List(ctx context.Context, userID int) (transfers []model.ScheduledTransfer, err error)
+	Cancel(ctx context.Context, id int) (transfer model.ScheduledTransfer, err error)
+}
+
+type scheduledTransferHandler struct {
+	logger  *zap.SugaredLogger
+	service scheduledTransferService
+}
+
+// registerScheduledTransferRoutes registers the scheduled transfer resources
+// and the scheduled transfers of a user.
+func registerScheduledTransferRoutes(
+	logger *zap.SugaredLogger,
+	transfers *mux.Router,
+	users *mux.Router,
+	service scheduledTransferService,
+) {
+	handler := scheduledTransferHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	transfers.Handle("", handler.handleCreate()).Methods(http.MethodPost)
+	transfers.Handle("/{scheduled_transfer_id}", handler.handleGet()).Methods(http.MethodGet)
+	transfers.Handle("/{scheduled_transfer_id}/cancel", handler.handleCancel()).Methods(http.MethodPost)
+
+	users.Handle("/{user_id}/scheduled-transfers", handler.handleList()).Methods(http.MethodGet)
+}
+
+func getScheduledTransferID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["scheduled_transfer_id"]
+	if !ok {
+		return 0, ErrMissedScheduledTransferID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidScheduledTransferID
+	}
+
+	return id, nil
+}
+
+func (h *scheduledTransferHandler) handleCreate() http.Handler {
+	type input struct {
+		UserID     *int          `json:"user_id" required:"true"`
+		ReceiverID *int          `json:"receiver_id" required:"true"`
+		Amount     *int          `json:"amount" required:"true"`
+		Start      *time.Time    `json:"start" required:"true"`
+		Period     *model.Period `json:"period"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(input)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		transfer, err := h.service.Create(
+			r.Context(),
+			*data.UserID,
+			*data.ReceiverID,
+			*data.Amount,
+			*data.Start,
+			data.Period,
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, transfer)
+	})
+}
+
+func (h *scheduledTransferHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getScheduledTransferID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		transfer, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, transfer)
+	})
+}
+
+func (h *scheduledTransferHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		transfers, err := h.service.List(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"scheduled_transfers": transfers,
+		})
+	})
+}
+
+func (h *scheduledTransferHandler) handleCancel() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getScheduledTransferID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		transfer, err := h.service.Cancel(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, transfer)
+	})
+}
@@ -0,0 +1,193 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedSettlementID  = errors.New("missed settlement id")
+	ErrInvalidSettlementID = errors.New("settlement id must be an integer")
+)
+
+type settlementService interface {
+	Import(
+		ctx context.Context,
+		provider string,
+		format model.SettlementFormat,
+		file io.Reader,
+		operator string,
+	) (settlement model.Settlement, err error)
+	Get(ctx context.Context, id int, status *model.SettlementStatus) (settlement model.Settlement, err error)
+	List(ctx context.Context, provider *string, limit *int) (settlements []model.Settlement, err error)
+	Report(ctx context.Context, id int, status *model.SettlementStatus) (report []byte, err error)
+}
+
+type settlementHandler struct {
+	logger         *zap.SugaredLogger
+	service        settlementService
+	operatorHeader string
+}
+
+// registerSettlementRoutes registers the import of the settlement files of
+// payment providers and the results of matching them.
+func registerSettlementRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service settlementService,
+	operatorHeader string,
+) {
+	handler := settlementHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	router.Handle("", handler.handleImport()).Methods(http.MethodPost)
+	router.Handle("", handler.handleList()).Methods(http.MethodGet)
+	router.Handle("/{settlement_id}", handler.handleGet()).Methods(http.MethodGet)
+	router.Handle("/{settlement_id}/report", handler.handleReport()).Methods(http.MethodGet)
+}
+
+func getSettlementID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["settlement_id"]
+	if !ok {
+		return 0, ErrMissedSettlementID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidSettlementID
+	}
+
+	return id, nil
+}
+
+// settlementFormat returns the format of the uploaded file: the format
+// parameter if it is set, otherwise the one of the content type.
+func settlementFormat(r *http.Request) model.SettlementFormat {
+	if value := r.URL.Query().Get("format"); value != "" {
+		return model.SettlementFormat(value)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return model.SettlementCSV
+	case "application/xml", "text/xml":
+		return model.SettlementCamt053
+	}
+
+	return ""
+}
+
+func settlementStatus(r *http.Request) *model.SettlementStatus {
+	if value := r.URL.Query().Get("status"); value != "" {
+		status := model.SettlementStatus(value)
+		return &status
+	}
+
+	return nil
+}
+
+func (h *settlementHandler) handleImport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		settlement, err := h.service.Import(
+			r.Context(),
+			r.URL.Query().Get("provider"),
+			settlementFormat(r),
+			r.Body,
+			operator,
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, settlement)
+	})
+}
+
+func (h *settlementHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		var provider *string
+		if value := params.Get("provider"); value != "" {
+			provider = &value
+		}
+
+		limit, err := intParam(params, "limit")
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		settlements, err := h.service.List(r.Context(), provider, limit)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"settlements": settlements,
+		})
+	})
+}
+
+func (h *settlementHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getSettlementID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		settlement, err := h.service.Get(r.Context(), id, settlementStatus(r))
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, settlement)
+	})
+}
+
+func (h *settlementHandler) handleReport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getSettlementID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		report, err := h.service.Report(r.Context(), id, settlementStatus(r))
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"settlement-%d.csv\"", id))
+		w.WriteHeader(http.StatusOK)
+		if _, err = w.Write(report); err != nil {
+			h.logger.Errorf("can't write the server response: %v", err)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedSubscriptionID  = errors.New("missed subscription id")
+	ErrInvalidSubscriptionID = errors.New("subscription id must be an integer")
+)
+
+type subscriptionService interface {
+	Create(
+		ctx context.Context,
+		userID int,
+		serviceID int,
+		price int,
+		period model.Period,
+		start *time.Time,
+	) (subscription model.Subscription, err error)
+	Get(ctx context.Context, id int) (subscription model.Subscription, err error)
+	List(ctx context.Context, userID int) (subscriptions []model.Subscription, err error)
+	Pause(ctx context.Context, id int) (subscription model.Subscription, err error)
+	Resume(ctx context.Context, id int) (subscription model.Subscription, err error)
+	Cancel(ctx context.Context, id int) (subscription model.Subscription, err error)
+}
+
+type subscriptionHandler struct {
+	logger  *zap.SugaredLogger
+	service subscriptionService
+}
+
+// registerSubscriptionRoutes registers the subscription resources and the
+// subscriptions of a user.
+func registerSubscriptionRoutes(
+	logger *zap.SugaredLogger,
+	subscriptions *mux.Router,
+	users *mux.Router,
+	service subscriptionService,
+) {
+	handler := subscriptionHandler{
+		logger:  logger,
+		service: service,
+	}
+
+	subscriptions.Handle("", handler.handleCreate()).Methods(http.MethodPost)
+	subscriptions.Handle("/{subscription_id}", handler.handleGet()).Methods(http.MethodGet)
+	subscriptions.Handle("/{subscription_id}/pause", handler.handleChange(service.Pause)).Methods(http.MethodPost)
+	subscriptions.Handle("/{subscription_id}/resume", handler.handleChange(service.Resume)).Methods(http.MethodPost)
+	subscriptions.Handle("/{subscription_id}/cancel", handler.handleChange(service.Cancel)).Methods(http.MethodPost)
+
+	users.Handle("/{user_id}/subscriptions", handler.handleList()).Methods(http.MethodGet)
+}
+
+func getSubscriptionID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["subscription_id"]
+	if !ok {
+		return 0, ErrMissedSubscriptionID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidSubscriptionID
+	}
+
+	return id, nil
+}
+
+func (h *subscriptionHandler) handleCreate() http.Handler {
+	type input struct {
+		UserID    *int          `json:"user_id" required:"true"`
+		ServiceID *int          `json:"service_id" required:"true"`
+		Price     *int          `json:"price" required:"true"`
+		Period    *model.Period `json:"period" required:"true"`
+		Start     *time.Time    `json:"start"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(input)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		subscription, err := h.service.Create(
+			r.Context(),
+			*data.UserID,
+			*data.ServiceID,
+			*data.Price,
+			*data.Period,
+			data.Start,
+		)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, subscription)
+	})
+}
+
+func (h *subscriptionHandler) handleGet() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getSubscriptionID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		subscription, err := h.service.Get(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, subscription)
+	})
+}
+
+func (h *subscriptionHandler) handleList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		subscriptions, err := h.service.List(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, map[string]any{
+			"subscriptions": subscriptions,
+		})
+	})
+}
+
+// handleChange serves the status changes, which differ only in the service
+// method.
+func (h *subscriptionHandler) handleChange(
+	change func(ctx context.Context, id int) (model.Subscription, error),
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getSubscriptionID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		subscription, err := change(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, subscription)
+	})
+}
@@ -0,0 +1,7 @@
+package transport
+
+import (
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/s02190058/billing-service/internal/transport")
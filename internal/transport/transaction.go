@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/s02190058/billing-service/internal/model"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMissedTransactionID  = errors.New("missed transaction id")
+	ErrInvalidTransactionID = errors.New("transaction id must be an integer")
+)
+
+type transactionService interface {
+	Reverse(ctx context.Context, id int, reversal model.Reversal) (entries []model.Transaction, err error)
+}
+
+type transactionHandler struct {
+	logger         *zap.SugaredLogger
+	service        transactionService
+	operatorHeader string
+}
+
+// registerAdminTransactionRoutes registers the reversal of journal entries.
+// Reversals are made by operators only and may drive a balance negative.
+func registerAdminTransactionRoutes(
+	logger *zap.SugaredLogger,
+	router *mux.Router,
+	service transactionService,
+	operatorHeader string,
+) {
+	handler := transactionHandler{
+		logger:         logger,
+		service:        service,
+		operatorHeader: operatorHeader,
+	}
+
+	router.Handle("/{transaction_id}/reverse", handler.handleReverse()).Methods(http.MethodPost)
+}
+
+func getTransactionID(r *http.Request) (int, error) {
+	vars := mux.Vars(r)
+	idString, ok := vars["transaction_id"]
+	if !ok {
+		return 0, ErrMissedTransactionID
+	}
+
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return 0, ErrInvalidTransactionID
+	}
+
+	return id, nil
+}
+
+func (h *transactionHandler) handleReverse() http.Handler {
+	type input struct {
+		Reason *string `json:"reason" required:"true"`
+		Force  bool    `json:"force"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, err := getOperator(r, h.operatorHeader)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		id, err := getTransactionID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		data := new(input)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		entries, err := h.service.Reverse(r.Context(), id, model.Reversal{
+			Reason:   *data.Reason,
+			Force:    data.Force,
+			Operator: operator,
+		})
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, map[string]any{
+			"transactions": entries,
+		})
+	})
+}
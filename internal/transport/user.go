@@ -1,27 +1,30 @@
 package transport
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/s02190058/billing-service/internal/model"
-	"github.com/s02190058/billing-service/internal/service"
 	"go.uber.org/zap"
 )
 
 var (
 	ErrMissedUserID  = errors.New("missed user id")
 	ErrInvalidUserID = errors.New("user id must be an integer")
+	ErrInvalidOrder  = errors.New("order must be 'asc' or 'desc'")
 )
 
 type userService interface {
-	GetBalance(id int) (balance int, err error)
-	TopUpBalance(id int, amount int) (balance int, err error)
-	Transfer(id, receiverID int, amount int) (balance int, err error)
-	Transactions(id int, orderField string, limit, offset int) (transactions []model.Transaction, err error)
+	GetBalance(ctx context.Context, id int) (balance model.Balance, err error)
+	TopUpBalance(ctx context.Context, id int, amount int, ref model.Reference) (balance int, err error)
+	Transfer(ctx context.Context, id, receiverID int, amount int, ref model.Reference) (balance int, err error)
+	Transactions(ctx context.Context, id int, query model.TransactionsQuery, cursor string) (page model.TransactionsPage, err error)
+	CreateAccount(ctx context.Context, id int, metadata map[string]any) (account model.Account, err error)
+	GetAccount(ctx context.Context, id int) (account model.Account, err error)
+	transactionService
 }
 
 type userHandler struct {
@@ -36,8 +39,10 @@ func registerUserRoutes(
 		service: service,
 	}
 
+	router.Handle("", handler.handleCreateAccount()).Methods(http.MethodPost)
 	router.Handle("/{user_id}", handler.handleGetBalance()).Methods(http.MethodGet)
 	router.Handle("/{user_id}", handler.handleTopUpBalance()).Methods(http.MethodPost)
+	router.Handle("/{user_id}/account", handler.handleGetAccount()).Methods(http.MethodGet)
 	router.Handle("/{user_id}/transfer", handler.handleTransfer()).Methods(http.MethodPost)
 	router.Handle("/{user_id}/transactions", handler.handleTransactions()).Methods(http.MethodGet)
 }
@@ -63,63 +68,91 @@ func balanceResponse(logger *zap.SugaredLogger, w http.ResponseWriter, code int,
 	})
 }
 
+func (h *userHandler) handleCreateAccount() http.Handler {
+	type input struct {
+		UserID   *int           `json:"user_id" required:"true"`
+		Metadata map[string]any `json:"metadata"`
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := new(input)
+		if err := decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		account, err := h.service.CreateAccount(r.Context(), *data.UserID, data.Metadata)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusCreated, account)
+	})
+}
+
+func (h *userHandler) handleGetAccount() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := getUserID(r)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		account, err := h.service.GetAccount(r.Context(), id)
+		if err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		response(h.logger, w, http.StatusOK, account)
+	})
+}
+
 func (h *userHandler) handleGetBalance() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getUserID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		balance, err := h.service.GetBalance(id)
+		balance, err := h.service.GetBalance(r.Context(), id)
 		if err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrUserNotFound):
-				code = http.StatusNotFound
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		balanceResponse(h.logger, w, http.StatusOK, balance)
+		response(h.logger, w, http.StatusOK, balance)
 	})
 }
 
 func (h *userHandler) handleTopUpBalance() http.Handler {
 	type input struct {
-		Amount int `json:"amount"`
+		Amount      *int           `json:"amount" required:"true"`
+		ExternalRef *string        `json:"external_ref"`
+		Metadata    map[string]any `json:"metadata"`
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getUserID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
 		data := new(input)
-		if err = json.NewDecoder(r.Body).Decode(data); err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrBadRequest)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		if err = r.Body.Close(); err != nil {
-			h.logger.Errorf("can't close request body: %v", err)
-		}
-
-		balance, err := h.service.TopUpBalance(id, data.Amount)
+		balance, err := h.service.TopUpBalance(r.Context(), id, *data.Amount, model.Reference{
+			ExternalRef: data.ExternalRef,
+			Metadata:    data.Metadata,
+		})
 		if err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidAmount):
-				code = http.StatusBadRequest
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
@@ -129,43 +162,31 @@ func (h *userHandler) handleTopUpBalance() http.Handler {
 
 func (h *userHandler) handleTransfer() http.Handler {
 	type input struct {
-		ReceiverID int `json:"receiver_id"`
-		Amount     int `json:"amount"`
+		ReceiverID  *int           `json:"receiver_id" required:"true"`
+		Amount      *int           `json:"amount" required:"true"`
+		ExternalRef *string        `json:"external_ref"`
+		Metadata    map[string]any `json:"metadata"`
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getUserID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
 		data := new(input)
-		if err = json.NewDecoder(r.Body).Decode(data); err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, ErrBadRequest)
+		if err = decodeBody(h.logger, r, data); err != nil {
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		if err = r.Body.Close(); err != nil {
-			h.logger.Errorf("can't close request body: %v", err)
-		}
-
-		balance, err := h.service.Transfer(id, data.ReceiverID, data.Amount)
+		balance, err := h.service.Transfer(r.Context(), id, *data.ReceiverID, *data.Amount, model.Reference{
+			ExternalRef: data.ExternalRef,
+			Metadata:    data.Metadata,
+		})
 		if err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidAmount):
-				code = http.StatusBadRequest
-			case errors.Is(err, service.ErrUserNotFound):
-				code = http.StatusNotFound
-			case errors.Is(err, service.ErrInvalidTransfer):
-				code = http.StatusUnprocessableEntity
-			case errors.Is(err, service.ErrInsufficientFunds):
-				code = http.StatusUnprocessableEntity
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
@@ -177,40 +198,65 @@ func (h *userHandler) handleTransactions() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := getUserID(r)
 		if err != nil {
-			errorResponse(h.logger, w, http.StatusBadRequest, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
 		params := r.URL.Query()
 
-		orderField := params.Get("order_field")
-		if orderField == "" {
-			orderField = "created"
+		query := model.TransactionsQuery{
+			OrderField: params.Get("order_field"),
+			Direction:  params.Get("direction"),
+			Type:       model.TransactionType(params.Get("type")),
+			Limit:      25,
+		}
+		if query.OrderField == "" {
+			query.OrderField = "created"
 		}
 
-		limit, err := strconv.Atoi(params.Get("limit"))
-		if err != nil {
-			limit = 25
+		switch params.Get("order") {
+		case "", "asc":
+		case "desc":
+			query.Descending = true
+		default:
+			errorResponse(h.logger, w, r, ErrInvalidOrder)
+			return
 		}
 
-		offset, err := strconv.Atoi(params.Get("offset"))
-		if err != nil {
-			offset = 0
+		if params.Get("limit") != "" {
+			if query.Limit, err = strconv.Atoi(params.Get("limit")); err != nil {
+				errorResponse(h.logger, w, r, invalidParameter("limit"))
+				return
+			}
 		}
 
-		transactions, err := h.service.Transactions(id, orderField, limit, offset)
+		if query.From, err = timeParam(params, "from"); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+		if query.To, err = timeParam(params, "to"); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+		if query.MinAmount, err = intParam(params, "min_amount"); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+		if query.MaxAmount, err = intParam(params, "max_amount"); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+		if query.CounterpartyID, err = intParam(params, "counterparty_id"); err != nil {
+			errorResponse(h.logger, w, r, err)
+			return
+		}
+
+		page, err := h.service.Transactions(r.Context(), id, query, params.Get("cursor"))
 		if err != nil {
-			var code int
-			switch {
-			case errors.Is(err, service.ErrInvalidOrderField):
-				code = http.StatusBadRequest
-			default:
-				code = http.StatusInternalServerError
-			}
-			errorResponse(h.logger, w, code, err)
+			errorResponse(h.logger, w, r, err)
 			return
 		}
 
-		response(h.logger, w, http.StatusOK, transactions)
+		response(h.logger, w, http.StatusOK, page)
 	})
 }
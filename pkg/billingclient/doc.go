@@ -0,0 +1,5 @@
+// Package billingclient is a Go client for the http api of the billing
+// service generated from api/openapi.json.
+package billingclient
+
+//go:generate oapi-codegen -generate types,client -package billingclient -o client.gen.go ../../api/openapi.json
@@ -0,0 +1,64 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type Config struct {
+	Port            string
+	ShutdownTimeout time.Duration
+}
+
+type Server struct {
+	server          *grpc.Server
+	addr            string
+	notify          chan error
+	shutdownTimeout time.Duration
+}
+
+func New(server *grpc.Server, cfg Config) *Server {
+	return &Server{
+		server:          server,
+		addr:            ":" + cfg.Port,
+		notify:          make(chan error, 1),
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+}
+
+func (s *Server) Start() {
+	go func() {
+		listener, err := net.Listen("tcp", s.addr)
+		if err != nil {
+			s.notify <- err
+			return
+		}
+
+		s.notify <- s.server.Serve(listener)
+	}()
+}
+
+func (s *Server) Notify() chan error {
+	return s.notify
+}
+
+// Shutdown waits for pending RPCs to finish and forcibly stops the server
+// once the shutdown timeout is exceeded.
+func (s *Server) Shutdown() error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(s.shutdownTimeout):
+		s.server.Stop()
+		return context.DeadlineExceeded
+	}
+}
@@ -0,0 +1,103 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Health tracks the application lifecycle for liveness, readiness and
+// startup probes.
+type Health struct {
+	mu      sync.RWMutex
+	checks  []namedCheck
+	timeout time.Duration
+
+	started  atomic.Bool
+	draining atomic.Bool
+}
+
+func New(timeout time.Duration) *Health {
+	return &Health{
+		timeout: timeout,
+	}
+}
+
+// AddReadinessCheck registers a dependency check evaluated by Ready.
+func (h *Health) AddReadinessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks = append(h.checks, namedCheck{
+		name:  name,
+		check: check,
+	})
+}
+
+// MarkStarted is called once the application has finished its startup.
+func (h *Health) MarkStarted() {
+	h.started.Store(true)
+}
+
+func (h *Health) Started() bool {
+	return h.started.Load()
+}
+
+// Drain makes the application unready, so load balancers stop routing
+// traffic to it before it exits.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Ready runs all readiness checks concurrently and returns their results
+// keyed by check name ("ok" or an error message).
+func (h *Health) Ready(ctx context.Context) (map[string]string, bool) {
+	h.mu.RLock()
+	checks := make([]namedCheck, len(h.checks))
+	copy(checks, h.checks)
+	h.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make(map[string]string, len(checks))
+	ready := h.Started() && !h.Draining()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+
+			result := "ok"
+			err := c.check(ctx)
+			if err != nil {
+				result = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[c.name] = result
+			if err != nil {
+				ready = false
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return results, ready
+}
@@ -11,12 +11,15 @@ type Config struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
+	DrainDelay      time.Duration
 }
 
 type Server struct {
 	server          *http.Server
 	notify          chan error
 	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+	onShutdown      []func()
 }
 
 func New(handler http.Handler, cfg Config) *Server {
@@ -29,6 +32,7 @@ func New(handler http.Handler, cfg Config) *Server {
 		},
 		notify:          make(chan error, 1),
 		shutdownTimeout: cfg.ShutdownTimeout,
+		drainDelay:      cfg.DrainDelay,
 	}
 }
 
@@ -42,7 +46,22 @@ func (s *Server) Notify() chan error {
 	return s.notify
 }
 
+// RegisterOnShutdown registers a function called at the beginning of
+// Shutdown, while the server is still accepting connections.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// Shutdown runs the shutdown hooks, keeps serving for the drain delay so
+// load balancers notice the server is going away, and then gracefully stops
+// the server.
 func (s *Server) Shutdown() error {
+	for _, f := range s.onShutdown {
+		f()
+	}
+
+	time.Sleep(s.drainDelay)
+
 	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
 
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LockSession is the connection the advisory locks of a replica are held on.
+// It is opened apart from the pool, so the leaders of any number of jobs take
+// a single connection of the database and leave the pool to the requests.
+type LockSession struct {
+	config *pgx.ConnConfig
+
+	mu   sync.Mutex
+	conn *pgx.Conn
+	// epoch counts the connections opened, since the locks held on a
+	// connection are gone with it
+	epoch int
+}
+
+// NewLockSession returns the session connecting with the config of the pool.
+// It connects on the first election.
+func NewLockSession(pool *pgxpool.Pool) *LockSession {
+	return &LockSession{
+		config: pool.Config().ConnConfig,
+	}
+}
+
+// connect checks the connection and opens a new one if it is broken. It must
+// be called with mu held.
+func (s *LockSession) connect(ctx context.Context) error {
+	if s.conn != nil {
+		err := s.conn.Ping(ctx)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		s.drop()
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, s.config)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+	s.epoch++
+
+	return nil
+}
+
+// drop closes the connection, which releases all the locks held on it. It
+// must be called with mu held.
+func (s *LockSession) drop() {
+	if s.conn == nil {
+		return
+	}
+
+	_ = s.conn.Close(context.Background())
+	s.conn = nil
+}
+
+// Close releases all the locks of the session.
+func (s *LockSession) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drop()
+}
+
+// AdvisoryLock elects a leader among the replicas sharing the database. The
+// leader holds a session advisory lock on the lock session of the replica, so
+// the lock is released when the leader resigns or the connection breaks.
+type AdvisoryLock struct {
+	session *LockSession
+	key     int64
+
+	// held and epoch are guarded by the mutex of the session
+	held  bool
+	epoch int
+}
+
+// NewAdvisoryLock returns the lock named name. Replicas using the same name
+// compete for the same lock.
+func NewAdvisoryLock(session *LockSession, name string) *AdvisoryLock {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(name))
+
+	return &AdvisoryLock{
+		session: session,
+		key:     int64(hash.Sum64()),
+	}
+}
+
+// Elect reports whether this replica holds the lock, taking it if it is free.
+// The connection of the session is checked first, since the lock is gone
+// once the connection is.
+func (l *AdvisoryLock) Elect(ctx context.Context) (bool, error) {
+	s := l.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.connect(ctx); err != nil {
+		return false, err
+	}
+	if l.held && l.epoch == s.epoch {
+		return true, nil
+	}
+
+	// session locks stack, so the lock is only taken when it isn't held
+	var locked bool
+	if err := s.conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&locked); err != nil {
+		if ctx.Err() == nil {
+			s.drop()
+		}
+		return false, err
+	}
+
+	l.held, l.epoch = locked, s.epoch
+
+	return locked, nil
+}
+
+// Resign releases the lock, so another replica can take it.
+func (l *AdvisoryLock) Resign() {
+	s := l.session
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !l.held || l.epoch != s.epoch || s.conn == nil {
+		l.held = false
+		return
+	}
+
+	if _, err := s.conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		s.drop()
+	}
+	l.held = false
+}
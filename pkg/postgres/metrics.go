@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatsCollector exports pgxpool statistics as prometheus metrics.
+type StatsCollector struct {
+	pool *pgxpool.Pool
+
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	acquiredConns        *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	idleConns            *prometheus.Desc
+	maxConns             *prometheus.Desc
+	totalConns           *prometheus.Desc
+}
+
+func NewStatsCollector(pool *pgxpool.Pool) *StatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("billing", "pgxpool", name), help, nil, nil)
+	}
+
+	return &StatsCollector{
+		pool:                 pool,
+		acquireCount:         desc("acquire_count_total", "Cumulative count of successful acquires from the pool."),
+		acquireDuration:      desc("acquire_duration_seconds_total", "Total duration of all successful acquires from the pool."),
+		acquiredConns:        desc("acquired_conns", "Number of currently acquired connections in the pool."),
+		canceledAcquireCount: desc("canceled_acquire_count_total", "Cumulative count of acquires canceled by a context."),
+		constructingConns:    desc("constructing_conns", "Number of connections with construction in progress."),
+		emptyAcquireCount:    desc("empty_acquire_count_total", "Cumulative count of acquires that waited for a connection."),
+		idleConns:            desc("idle_conns", "Number of currently idle connections in the pool."),
+		maxConns:             desc("max_conns", "Maximum size of the pool."),
+		totalConns:           desc("total_conns", "Total number of connections currently in the pool."),
+	}
+}
+
+func (c *StatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *StatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+}
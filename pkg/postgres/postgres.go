@@ -47,6 +47,7 @@ func New(logger *zap.SugaredLogger, cfg Config) (*pgxpool.Pool, error) {
 	}
 
 	poolCfg.MaxConns = int32(cfg.MaxPoolSize)
+	poolCfg.ConnConfig.Tracer = newQueryTracer()
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	refill  time.Duration
+}
+
+// MemoryLimiter keeps buckets in the process memory, so limits are applied
+// per replica.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, buckets ...Bucket) ([]Result, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	states := make([]*bucket, len(buckets))
+	allowed := true
+	for i, bb := range buckets {
+		capacity := bb.Rule.capacity()
+
+		b, ok := l.buckets[bb.Key]
+		if !ok {
+			b = &bucket{
+				tokens:  capacity,
+				updated: now,
+				refill:  time.Duration(capacity / bb.Rule.rate() * float64(time.Second)),
+			}
+			l.buckets[bb.Key] = b
+		}
+
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*bb.Rule.rate())
+		b.updated = now
+
+		states[i] = b
+		allowed = allowed && b.tokens >= 1
+	}
+
+	results := make([]Result, len(buckets))
+	for i, b := range states {
+		hasToken := b.tokens >= 1
+		if allowed {
+			b.tokens--
+		}
+		results[i] = result(buckets[i].Rule, hasToken, b.tokens)
+	}
+
+	return results, nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again:
+// such buckets are indistinguishable from missing ones.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) > b.refill {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
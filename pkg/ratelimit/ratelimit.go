@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Rule describes a token bucket: Burst tokens at most, refilled with
+// Limit tokens every Period.
+type Rule struct {
+	Limit  int           `yaml:"limit"`
+	Period time.Duration `yaml:"period"`
+	Burst  int           `yaml:"burst"`
+}
+
+// Result is the state of a bucket after a request has been taken into account.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Bucket is a token bucket identified by its key.
+type Bucket struct {
+	Key  string
+	Rule Rule
+}
+
+// Limiter takes a token from each of the buckets only if all of them have
+// one, so a request rejected by one bucket doesn't drain the others. The
+// results are in the order of the buckets; Allowed of a result reports
+// whether its bucket had a token.
+type Limiter interface {
+	Allow(ctx context.Context, buckets ...Bucket) ([]Result, error)
+}
+
+func (r Rule) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+
+	return float64(r.Limit)
+}
+
+// rate returns the number of tokens added to the bucket per second.
+func (r Rule) rate() float64 {
+	if r.Period <= 0 {
+		return 0
+	}
+
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// Valid reports whether the rule limits anything at all.
+func (r Rule) Valid() bool {
+	return r.Limit > 0 && r.Period > 0
+}
+
+func result(rule Rule, allowed bool, tokens float64) Result {
+	capacity := rule.capacity()
+	rate := rule.rate()
+
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((capacity - tokens) / rate),
+	}
+
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(s)) * time.Second
+}
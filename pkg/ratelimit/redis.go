@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript refills the buckets stored in hashes and takes a token
+// from each of them if all of them have one, atomically. ARGV holds the
+// capacity and the rate of each key followed by the current time. It returns
+// for each bucket whether it had a token and the number of tokens left (as a
+// string, because Lua numbers are truncated to integers).
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[#ARGV])
+
+local tokens = {}
+local allowed = true
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i - 1])
+	local rate = tonumber(ARGV[2 * i])
+
+	local state = redis.call("HMGET", key, "tokens", "updated")
+	local left = tonumber(state[1]) or capacity
+	local updated = tonumber(state[2]) or now
+
+	tokens[i] = math.min(capacity, left + math.max(0, now - updated) * rate)
+	if tokens[i] < 1 then
+		allowed = false
+	end
+end
+
+local results = {}
+for i, key in ipairs(KEYS) do
+	local capacity = tonumber(ARGV[2 * i - 1])
+	local rate = tonumber(ARGV[2 * i])
+
+	local hasToken = 0
+	if tokens[i] >= 1 then
+		hasToken = 1
+	end
+	if allowed then
+		tokens[i] = tokens[i] - 1
+	end
+
+	redis.call("HSET", key, "tokens", tostring(tokens[i]), "updated", tostring(now))
+	redis.call("PEXPIRE", key, math.ceil(capacity / rate * 1000))
+
+	results[2 * i - 1] = hasToken
+	results[2 * i] = tostring(tokens[i])
+end
+
+return results
+`)
+
+// RedisLimiter keeps buckets in Redis (or any server speaking its protocol
+// and supporting Lua scripts), so limits are shared between replicas.
+type RedisLimiter struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisLimiter(client redis.UniversalClient, prefix string) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Allow runs the buckets in a single script, so with Redis Cluster their keys
+// must hash to the same slot.
+func (l *RedisLimiter) Allow(ctx context.Context, buckets ...Bucket) ([]Result, error) {
+	now := float64(time.Now().UnixMicro()) / 1e6
+
+	keys := make([]string, 0, len(buckets))
+	args := make([]any, 0, 2*len(buckets)+1)
+	for _, b := range buckets {
+		keys = append(keys, l.prefix+b.Key)
+		args = append(args, b.Rule.capacity(), b.Rule.rate())
+	}
+	args = append(args, now)
+
+	values, err := tokenBucketScript.Run(ctx, l.client, keys, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(buckets))
+	for i, b := range buckets {
+		allowed, _ := values[2*i].(int64)
+		tokensString, _ := values[2*i+1].(string)
+		tokens, err := strconv.ParseFloat(tokensString, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		results[i] = result(b.Rule, allowed == 1, tokens)
+	}
+
+	return results, nil
+}
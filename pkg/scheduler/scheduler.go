@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrShutdownTimeout = errors.New("scheduler didn't stop in time")
+	ErrInvalidInterval = errors.New("scheduler interval must be positive")
+)
+
+// Job is a unit of periodic work. It must stop when ctx is done.
+type Job func(ctx context.Context) error
+
+// Elector decides which of the replicas runs the job.
+type Elector interface {
+	// Elect reports whether this replica is the leader.
+	Elect(ctx context.Context) (bool, error)
+	// Resign gives the leadership up.
+	Resign()
+}
+
+// Config configures a scheduler. Without an elector the job runs on every
+// replica.
+type Config struct {
+	Name            string
+	Interval        time.Duration
+	ShutdownTimeout time.Duration
+	Elector         Elector
+}
+
+// Scheduler runs a job on a fixed interval until it is shut down. Runs never
+// overlap: the next run starts an interval after the previous one finishes.
+type Scheduler struct {
+	logger          *zap.SugaredLogger
+	job             Job
+	name            string
+	interval        time.Duration
+	shutdownTimeout time.Duration
+	elector         Elector
+	cancel          context.CancelFunc
+	done            chan struct{}
+}
+
+// New fails if the interval isn't positive: the job would run in a busy loop.
+func New(logger *zap.SugaredLogger, job Job, cfg Config) (*Scheduler, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("%w: %s: %s", ErrInvalidInterval, cfg.Name, cfg.Interval)
+	}
+
+	return &Scheduler{
+		logger:          logger,
+		job:             job,
+		name:            cfg.Name,
+		interval:        cfg.Interval,
+		shutdownTimeout: cfg.ShutdownTimeout,
+		elector:         cfg.Elector,
+		done:            make(chan struct{}),
+	}, nil
+}
+
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	go func() {
+		defer close(s.done)
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			s.run(ctx)
+
+			timer.Reset(s.interval)
+		}
+	}()
+}
+
+// run runs the job once if this replica is the leader.
+func (s *Scheduler) run(ctx context.Context) {
+	if s.elector != nil {
+		leader, err := s.elector.Elect(ctx)
+		if err != nil {
+			s.logger.Errorf("can't elect %s leader: %v", s.name, err)
+			return
+		}
+		if !leader {
+			return
+		}
+	}
+
+	if err := s.job(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		s.logger.Errorf("error occurred during %s run: %v", s.name, err)
+	}
+}
+
+// Shutdown cancels the current run, waits for it to return and gives the
+// leadership up.
+func (s *Scheduler) Shutdown() error {
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+
+	select {
+	case <-s.done:
+	case <-time.After(s.shutdownTimeout):
+		return ErrShutdownTimeout
+	}
+
+	if s.elector != nil {
+		s.elector.Resign()
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client posts JSON payloads to a single URL. The receiver deduplicates
+// redelivered payloads by the Idempotency-Key header.
+type Client struct {
+	client *http.Client
+	url    string
+}
+
+func New(url string, timeout time.Duration) *Client {
+	return &Client{
+		client: &http.Client{Timeout: timeout},
+		url:    url,
+	}
+}
+
+// Send posts the payload and fails unless the receiver answers with a 2xx
+// status.
+func (c *Client) Send(ctx context.Context, key string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// drain the body, so the connection is reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -2,34 +2,531 @@
 DROP TABLE IF EXISTS users;
 CREATE TABLE users
 (
-    id      INT PRIMARY KEY,
-    balance INT NOT NULL
+    id           INT PRIMARY KEY,
+    balance      INT       NOT NULL,
+    credit_limit INT       NOT NULL DEFAULT 0 CHECK (credit_limit >= 0),
+    status       TEXT      NOT NULL DEFAULT 'active',
+    segment      TEXT      NOT NULL DEFAULT 'default',
+    metadata     JSONB     NOT NULL DEFAULT '{}',
+    created      TIMESTAMP NOT NULL DEFAULT now()
 );
 
--- reserves table store reserves for services
+-- the platform revenue account fees are booked to
+INSERT INTO users (id, balance, metadata)
+VALUES (0, 0, '{"name": "platform revenue"}');
+
+-- account_events table is the audit trail of account status changes
+DROP TABLE IF EXISTS account_events;
+CREATE TABLE account_events
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INT       NOT NULL REFERENCES users (id),
+    status       TEXT      NOT NULL,
+    credit_limit INT,
+    segment      TEXT,
+    reason       TEXT      NOT NULL,
+    operator     TEXT      NOT NULL DEFAULT '',
+    created      TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON account_events (user_id);
+
+-- reserves table store reserves for services; source tells the orders of the
+-- clients from subscription charges, whose order id is the charge id
 DROP TABLE IF EXISTS reserves;
 CREATE TABLE reserves
 (
+    source     TEXT      NOT NULL DEFAULT 'order',
     order_id   INT,
     user_id    INT REFERENCES users (id),
     service_id INT,
     cost       INT       NOT NULL,
+    bonus      INT       NOT NULL DEFAULT 0,
     status     TEXT      NOT NULL,
     created    TIMESTAMP NOT NULL DEFAULT now(),
-    PRIMARY KEY (order_id, user_id, service_id)
+    PRIMARY KEY (source, order_id, user_id, service_id)
 );
 
 CREATE INDEX ON reserves (service_id);
+CREATE INDEX ON reserves (user_id, created);
+
+-- bonus_grants table stores lots of bonus money kept apart from the balance;
+-- a lot without services can be spent on any service
+DROP TABLE IF EXISTS reserve_bonuses;
+DROP TABLE IF EXISTS bonus_grants;
+CREATE TABLE bonus_grants
+(
+    id          SERIAL PRIMARY KEY,
+    user_id     INT       NOT NULL REFERENCES users (id),
+    amount      INT       NOT NULL CHECK (amount > 0),
+    remaining   INT       NOT NULL CHECK (remaining >= 0),
+    service_ids INT[],
+    source      TEXT      NOT NULL,
+    reason      TEXT      NOT NULL,
+    operator    TEXT      NOT NULL DEFAULT '',
+    expires     TIMESTAMP NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON bonus_grants (user_id, expires) WHERE remaining > 0;
+
+-- reserve_bonuses table stores the bonus lots a reserve was paid with, so a
+-- rejected reserve returns every part to its lot
+CREATE TABLE reserve_bonuses
+(
+    source     TEXT,
+    order_id   INT,
+    user_id    INT,
+    service_id INT,
+    grant_id   INT REFERENCES bonus_grants (id),
+    amount     INT NOT NULL,
+    PRIMARY KEY (source, order_id, user_id, service_id, grant_id),
+    FOREIGN KEY (source, order_id, user_id, service_id) REFERENCES reserves (source, order_id, user_id, service_id)
+);
+
+-- cashback_rules table stores the share of confirmed payments granted back as
+-- bonus; rates are in basis points
+DROP TABLE IF EXISTS cashback_rules;
+CREATE TABLE cashback_rules
+(
+    id            SERIAL PRIMARY KEY,
+    service_id    INT,
+    rate_bps      INT       NOT NULL CHECK (rate_bps > 0),
+    max_amount    INT,
+    valid_seconds INT       NOT NULL CHECK (valid_seconds > 0),
+    enabled       BOOLEAN   NOT NULL DEFAULT true,
+    created       TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- subscriptions table stores recurring service payments; a subscription is
+-- charged at next_charge and, while past due, retried at retry_at
+DROP TABLE IF EXISTS subscription_charges;
+DROP TABLE IF EXISTS subscriptions;
+CREATE TABLE subscriptions
+(
+    id          SERIAL PRIMARY KEY,
+    user_id     INT       NOT NULL REFERENCES users (id),
+    service_id  INT       NOT NULL,
+    price       INT       NOT NULL CHECK (price > 0),
+    period      TEXT      NOT NULL,
+    status      TEXT      NOT NULL,
+    next_charge TIMESTAMP NOT NULL,
+    retry_at    TIMESTAMP,
+    last_error  TEXT,
+    created     TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON subscriptions (user_id);
+CREATE INDEX ON subscriptions (status, next_charge);
+
+-- subscription_charges table stores the charge of each period; the charge is
+-- paid with a subscription reserve whose order id is the charge id
+CREATE TABLE subscription_charges
+(
+    id              SERIAL PRIMARY KEY,
+    subscription_id INT       NOT NULL REFERENCES subscriptions (id),
+    period_start    TIMESTAMP NOT NULL,
+    status          TEXT      NOT NULL,
+    attempts        INT       NOT NULL DEFAULT 0,
+    error           TEXT,
+    created         TIMESTAMP NOT NULL DEFAULT now(),
+    updated         TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE (subscription_id, period_start)
+);
+
+-- scheduled_transfers table stores transfers made at next_run and, if there
+-- is a period, every period after that
+DROP TABLE IF EXISTS scheduled_transfer_runs;
+DROP TABLE IF EXISTS scheduled_transfers;
+CREATE TABLE scheduled_transfers
+(
+    id          SERIAL PRIMARY KEY,
+    user_id     INT       NOT NULL REFERENCES users (id),
+    receiver_id INT       NOT NULL REFERENCES users (id),
+    amount      INT       NOT NULL CHECK (amount > 0),
+    period      TEXT,
+    status      TEXT      NOT NULL,
+    next_run    TIMESTAMP NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON scheduled_transfers (user_id);
+CREATE INDEX ON scheduled_transfers (status, next_run);
+
+-- scheduled_transfer_runs table stores the run of each occurrence; the
+-- transfer of a run is booked with the external reference of the run
+CREATE TABLE scheduled_transfer_runs
+(
+    id                    SERIAL PRIMARY KEY,
+    scheduled_transfer_id INT       NOT NULL REFERENCES scheduled_transfers (id),
+    scheduled_for         TIMESTAMP NOT NULL,
+    status                TEXT      NOT NULL,
+    attempts              INT       NOT NULL DEFAULT 0,
+    transaction_id        INT,
+    error                 TEXT,
+    created               TIMESTAMP NOT NULL DEFAULT now(),
+    updated               TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE (scheduled_transfer_id, scheduled_for)
+);
+
+-- pending_transfers table stores two-phase transfers; the amount and the
+-- fee are taken from the sender when the transfer is held and booked to the
+-- journal when it is captured
+DROP TABLE IF EXISTS pending_transfers;
+CREATE TABLE pending_transfers
+(
+    id             SERIAL PRIMARY KEY,
+    sender_id      INT       NOT NULL REFERENCES users (id),
+    receiver_id    INT       NOT NULL REFERENCES users (id),
+    amount         INT       NOT NULL CHECK (amount > 0),
+    fee            INT       NOT NULL DEFAULT 0,
+    fee_rule_id    INT,
+    status         TEXT      NOT NULL,
+    external_ref   TEXT,
+    metadata       JSONB     NOT NULL DEFAULT '{}',
+    transaction_id INT,
+    expires        TIMESTAMP NOT NULL,
+    created        TIMESTAMP NOT NULL DEFAULT now(),
+    settled        TIMESTAMP
+);
+
+CREATE INDEX ON pending_transfers (sender_id, status);
+CREATE INDEX ON pending_transfers (receiver_id, status);
+CREATE INDEX ON pending_transfers (expires) WHERE status = 'held';
+
+-- catalog_items table stores the services users pay for; the id is the
+-- service id of the orders
+DROP TABLE IF EXISTS service_prices;
+DROP TABLE IF EXISTS catalog_items;
+CREATE TABLE catalog_items
+(
+    id       INT PRIMARY KEY,
+    name     TEXT      NOT NULL,
+    category TEXT      NOT NULL DEFAULT '',
+    currency TEXT      NOT NULL,
+    active   BOOLEAN   NOT NULL DEFAULT true,
+    created  TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON catalog_items (category);
+
+-- service_prices table stores the price lists of the catalog; of the prices
+-- valid at a moment the one starting last applies
+CREATE TABLE service_prices
+(
+    id         SERIAL PRIMARY KEY,
+    service_id INT       NOT NULL REFERENCES catalog_items (id),
+    price      INT       NOT NULL CHECK (price >= 0),
+    valid_from TIMESTAMP NOT NULL,
+    valid_to   TIMESTAMP CHECK (valid_to > valid_from),
+    created    TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON service_prices (service_id, valid_from);
+
+-- disputes table stores disputes against confirmed service payments; the
+-- disputed amount is frozen from the revenue of the service while the
+-- dispute is open and refunded to the user if it is resolved in their favor
+DROP TABLE IF EXISTS disputes;
+CREATE TABLE disputes
+(
+    id             SERIAL PRIMARY KEY,
+    order_id       INT       NOT NULL,
+    user_id        INT       NOT NULL REFERENCES users (id),
+    service_id     INT       NOT NULL,
+    amount         INT       NOT NULL CHECK (amount > 0),
+    reason         TEXT      NOT NULL,
+    status         TEXT      NOT NULL,
+    deadline       TIMESTAMP NOT NULL,
+    resolved_by    TEXT,
+    comment        TEXT,
+    transaction_id INT,
+    created        TIMESTAMP NOT NULL DEFAULT now(),
+    resolved       TIMESTAMP,
+    UNIQUE (order_id, user_id, service_id)
+);
+
+CREATE INDEX ON disputes (status, deadline);
+CREATE INDEX ON disputes (user_id);
+CREATE INDEX ON disputes (service_id, created);
 
 -- journal table stores all transactions
 DROP TABLE IF EXISTS journal;
 CREATE TABLE journal
 (
-    id      SERIAL PRIMARY KEY,
-    user_id INT       NOT NULL REFERENCES users (id),
-    amount  INT       NOT NULL,
-    message TEXT      NOT NULL,
-    created TIMESTAMP NOT NULL DEFAULT now()
+    id              SERIAL PRIMARY KEY,
+    user_id         INT       NOT NULL REFERENCES users (id),
+    amount          INT       NOT NULL,
+    type            TEXT      NOT NULL,
+    counterparty_id INT REFERENCES users (id),
+    order_id        INT,
+    service_id      INT,
+    external_ref    TEXT,
+    metadata        JSONB     NOT NULL DEFAULT '{}',
+    pair_id         INT REFERENCES journal (id),
+    reversal_of     INT REFERENCES journal (id),
+    reversed_by     INT REFERENCES journal (id),
+    message         TEXT      NOT NULL,
+    created         TIMESTAMP NOT NULL DEFAULT now(),
+    seq             BIGINT UNIQUE,
+    user_hash       TEXT,
+    global_hash     TEXT
+);
+
+CREATE INDEX ON journal (user_id, created, id);
+CREATE INDEX ON journal (user_id, amount, id);
+CREATE INDEX ON journal (user_id, type);
+CREATE INDEX ON journal (counterparty_id);
+CREATE INDEX ON journal (pair_id);
+CREATE INDEX ON journal (user_id, seq);
+CREATE INDEX ON journal (id) WHERE seq IS NULL;
+CREATE INDEX ON journal (external_ref);
+-- an outgoing transfer is booked at most once under a reference, so a
+-- scheduled run executed twice can't move the money twice
+CREATE UNIQUE INDEX ON journal (user_id, type, external_ref) WHERE type = 'transfer_out';
+
+-- journal_chain table stores the head of the global hash chain of the
+-- journal; entries are chained right before their transaction commits
+DROP TABLE IF EXISTS journal_chain;
+CREATE TABLE journal_chain
+(
+    id   BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    seq  BIGINT NOT NULL,
+    hash TEXT   NOT NULL
+);
+
+INSERT INTO journal_chain (seq, hash)
+VALUES (0, '');
+
+-- limit_rules table stores caps on the amount and number of operations a
+-- user may make within a rolling window; a rule without a segment applies to
+-- all users
+DROP TABLE IF EXISTS limit_rules;
+CREATE TABLE limit_rules
+(
+    id             SERIAL PRIMARY KEY,
+    operation      TEXT      NOT NULL,
+    segment        TEXT,
+    window_seconds INT       NOT NULL CHECK (window_seconds > 0),
+    max_amount     INT CHECK (max_amount > 0),
+    max_count      INT CHECK (max_count > 0),
+    enabled        BOOLEAN   NOT NULL DEFAULT true,
+    created        TIMESTAMP NOT NULL DEFAULT now(),
+    CHECK (max_amount IS NOT NULL OR max_count IS NOT NULL)
+);
+
+CREATE INDEX ON limit_rules (operation);
+
+-- fee_rules table stores fees charged on transfers and service payments;
+-- rates are in basis points
+DROP TABLE IF EXISTS fee_rules;
+CREATE TABLE fee_rules
+(
+    id         SERIAL PRIMARY KEY,
+    operation  TEXT      NOT NULL,
+    service_id INT,
+    kind       TEXT      NOT NULL,
+    flat       INT       NOT NULL DEFAULT 0,
+    rate_bps   INT       NOT NULL DEFAULT 0,
+    tiers      JSONB     NOT NULL DEFAULT '[]',
+    min_fee    INT,
+    max_fee    INT,
+    enabled    BOOLEAN   NOT NULL DEFAULT true,
+    created    TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON fee_rules (operation, service_id);
+
+-- risk_rules table stores the rules money movements are checked against
+-- before they are made; a rule without an operation applies to all of them
+DROP TABLE IF EXISTS risk_rules;
+CREATE TABLE risk_rules
+(
+    id        SERIAL PRIMARY KEY,
+    name      TEXT      NOT NULL,
+    operation TEXT,
+    condition JSONB     NOT NULL,
+    outcome   TEXT      NOT NULL,
+    enabled   BOOLEAN   NOT NULL DEFAULT true,
+    created   TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON risk_rules (operation);
+
+-- risk_blocklist table stores users whose operations risk rules may deny
+DROP TABLE IF EXISTS risk_blocklist;
+CREATE TABLE risk_blocklist
+(
+    user_id  INT PRIMARY KEY REFERENCES users (id),
+    reason   TEXT      NOT NULL,
+    operator TEXT      NOT NULL,
+    created  TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- risk_decisions table is the audit trail of the checks some rule matched;
+-- payload holds the checked operation
+DROP TABLE IF EXISTS risk_reviews;
+DROP TABLE IF EXISTS risk_decisions;
+CREATE TABLE risk_decisions
+(
+    id        SERIAL PRIMARY KEY,
+    user_id   INT       NOT NULL,
+    payload   JSONB     NOT NULL,
+    outcome   TEXT      NOT NULL,
+    rule_ids  INT[]     NOT NULL,
+    facts     JSONB     NOT NULL DEFAULT '{}',
+    review_id INT,
+    created   TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON risk_decisions (user_id, id);
+
+-- risk_reviews table stores the operations held for an operator to decide on
+CREATE TABLE risk_reviews
+(
+    id          SERIAL PRIMARY KEY,
+    decision_id INT       NOT NULL REFERENCES risk_decisions (id),
+    user_id     INT       NOT NULL,
+    payload     JSONB     NOT NULL,
+    status      TEXT      NOT NULL,
+    reviewer    TEXT,
+    comment     TEXT,
+    error       TEXT,
+    created     TIMESTAMP NOT NULL DEFAULT now(),
+    decided     TIMESTAMP
+);
+
+CREATE INDEX ON risk_reviews (status, id);
+
+-- adjustments table stores manual balance corrections made by operators
+DROP TABLE IF EXISTS adjustment_events;
+DROP TABLE IF EXISTS adjustments;
+CREATE TABLE adjustments
+(
+    id             SERIAL PRIMARY KEY,
+    user_id        INT       NOT NULL REFERENCES users (id),
+    amount         INT       NOT NULL,
+    reason_code    TEXT      NOT NULL,
+    comment        TEXT      NOT NULL,
+    status         TEXT      NOT NULL,
+    requested_by   TEXT      NOT NULL,
+    decided_by     TEXT,
+    transaction_id INT REFERENCES journal (id),
+    created        TIMESTAMP NOT NULL DEFAULT now(),
+    decided        TIMESTAMP
+);
+
+CREATE INDEX ON adjustments (status, id);
+
+-- adjustment_events table is the audit trail of actions on adjustments
+CREATE TABLE adjustment_events
+(
+    id            SERIAL PRIMARY KEY,
+    adjustment_id INT       NOT NULL REFERENCES adjustments (id),
+    action        TEXT      NOT NULL,
+    operator      TEXT      NOT NULL,
+    created       TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ON adjustment_events (adjustment_id);
+
+-- reconciliation_runs table stores the runs of the comparison of balances
+-- with the journal
+DROP TABLE IF EXISTS reconciliation_items;
+DROP TABLE IF EXISTS reconciliation_runs;
+CREATE TABLE reconciliation_runs
+(
+    id           SERIAL PRIMARY KEY,
+    requested_by TEXT      NOT NULL,
+    repair       BOOLEAN   NOT NULL DEFAULT false,
+    users        INT       NOT NULL DEFAULT 0,
+    mismatched   INT       NOT NULL DEFAULT 0,
+    drift        INT       NOT NULL DEFAULT 0,
+    started      TIMESTAMP NOT NULL DEFAULT now(),
+    finished     TIMESTAMP
+);
+
+-- reconciliation_items table stores the users whose balance didn't match the
+-- journal and the adjustment entries which repaired them
+CREATE TABLE reconciliation_items
+(
+    run_id         INT NOT NULL REFERENCES reconciliation_runs (id),
+    user_id        INT NOT NULL REFERENCES users (id),
+    balance        INT NOT NULL,
+    open           INT NOT NULL,
+    journal        INT NOT NULL,
+    drift          INT NOT NULL,
+    transaction_id INT REFERENCES journal (id),
+    PRIMARY KEY (run_id, user_id)
+);
+
+-- settlements table stores the settlement files of payment providers; a file
+-- is identified by the checksum of its content
+DROP TABLE IF EXISTS settlement_items;
+DROP TABLE IF EXISTS settlements;
+CREATE TABLE settlements
+(
+    id          SERIAL PRIMARY KEY,
+    provider    TEXT      NOT NULL,
+    format      TEXT      NOT NULL,
+    checksum    TEXT      NOT NULL,
+    imported_by TEXT      NOT NULL,
+    lines       INT       NOT NULL,
+    amount      BIGINT    NOT NULL,
+    matched     INT       NOT NULL,
+    unmatched   INT       NOT NULL,
+    mismatched  INT       NOT NULL,
+    created     TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE (provider, checksum)
+);
+
+CREATE INDEX ON settlements (provider, id);
+
+-- settlement_items table stores the lines of settlement files and the
+-- top-ups they were matched against
+CREATE TABLE settlement_items
+(
+    settlement_id  INT  NOT NULL REFERENCES settlements (id),
+    line           INT  NOT NULL,
+    reference      TEXT NOT NULL,
+    amount         INT  NOT NULL,
+    currency       TEXT NOT NULL DEFAULT '',
+    booked         TIMESTAMP,
+    status         TEXT NOT NULL,
+    transaction_id INT REFERENCES journal (id),
+    user_id        INT,
+    journal_amount INT,
+    reason         TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (settlement_id, line)
+);
+
+-- a top-up is settled by at most one line
+CREATE UNIQUE INDEX settlement_items_matched_transaction_idx ON settlement_items (transaction_id)
+    WHERE status = 'matched';
+CREATE INDEX ON settlement_items (transaction_id);
+
+-- outbox table stores domain events (e.g. overdraft_entered) written in the
+-- same transaction as the change they describe
+DROP TABLE IF EXISTS outbox;
+CREATE TABLE outbox
+(
+    id        SERIAL PRIMARY KEY,
+    type      TEXT      NOT NULL,
+    user_id   INT       NOT NULL,
+    payload   JSONB     NOT NULL DEFAULT '{}',
+    created   TIMESTAMP NOT NULL DEFAULT now(),
+    published TIMESTAMP
+);
+
+CREATE INDEX ON outbox (id) WHERE published IS NULL;
+
+-- schema_version table stores the version of the database schema expected
+-- by the application
+DROP TABLE IF EXISTS schema_version;
+CREATE TABLE schema_version
+(
+    version INT NOT NULL
 );
 
-CREATE INDEX ON journal (user_id);
\ No newline at end of file
+INSERT INTO schema_version (version)
+VALUES (22);